@@ -0,0 +1,294 @@
+// audit.go — Piste d'audit reglementaire des evenements d'ordres.
+//
+// Chaque Event du Gateway (recu, valide, rejete, pose, modifie, execute,
+// annule...) devient un AuditRecord horodate a la nanoseconde. Les records
+// sont chaines par hash : Hash = SHA-256(PrevHash || record). Modifier,
+// inserer ou retirer un record casse la chaine a partir de ce point.
+//
+// Branchement typique :
+//
+//	f, _ := os.Create("audit.jsonl")
+//	trail := NewAuditTrail(f)
+//	gw.Subscribe(trail.OnEvent)
+//
+// Export : go run ./phase2-order-engine/ audit-export -in audit.jsonl -from 2026-10-01 -to 2026-10-31
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAuditTampered signale une chaine de hash rompue.
+var ErrAuditTampered = errors.New("chaine d'audit alteree")
+
+// auditGenesis est le PrevHash du premier record.
+var auditGenesis = strings.Repeat("0", sha256.Size*2)
+
+// AuditRecord est un evenement scelle dans la chaine.
+type AuditRecord struct {
+	Seq uint64 `json:"seq"`
+	Event
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// digest calcule le hash du record a partir de PrevHash, Seq et Event.
+func (r AuditRecord) digest() string {
+	body, _ := json.Marshal(struct {
+		Seq uint64 `json:"seq"`
+		Event
+	}{r.Seq, r.Event})
+	h := sha256.New()
+	h.Write([]byte(r.PrevHash))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ---------------------------------------------------------------------------
+// AuditTrail — journal append-only, chaine par hash
+// ---------------------------------------------------------------------------
+
+// AuditTrail scelle les evenements du Gateway. Si un io.Writer est fourni,
+// chaque record y est ecrit en JSON (une ligne par record) des sa creation.
+type AuditTrail struct {
+	mu      sync.Mutex
+	w       io.Writer // nil : memoire uniquement
+	records []AuditRecord
+	head    string // Hash du dernier record
+	err     error  // Premiere erreur d'ecriture
+}
+
+// NewAuditTrail cree une piste d'audit. w peut etre nil.
+func NewAuditTrail(w io.Writer) *AuditTrail {
+	return &AuditTrail{w: w, head: auditGenesis}
+}
+
+// OnEvent scelle un evenement. Signature compatible EventHandler.
+func (at *AuditTrail) OnEvent(e Event) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	e.ChildIDs = append([]uint64(nil), e.ChildIDs...)
+	r := AuditRecord{Seq: uint64(len(at.records)) + 1, Event: e, PrevHash: at.head}
+	r.Hash = r.digest()
+	at.records = append(at.records, r)
+	at.head = r.Hash
+
+	if at.w != nil && at.err == nil {
+		line, _ := json.Marshal(r)
+		if _, err := at.w.Write(append(line, '\n')); err != nil {
+			at.err = err
+		}
+	}
+}
+
+// Records retourne une copie des records, dans l'ordre de la chaine.
+func (at *AuditTrail) Records() []AuditRecord {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return append([]AuditRecord(nil), at.records...)
+}
+
+// Head retourne le hash du dernier record. Le conserver hors du journal
+// (ticket, base separee) permet de detecter aussi une troncature de la fin.
+func (at *AuditTrail) Head() string {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return at.head
+}
+
+// Err retourne la premiere erreur d'ecriture ; les records suivants ne sont
+// plus ecrits (ils restent en memoire).
+func (at *AuditTrail) Err() error {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return at.err
+}
+
+// VerifyAuditChain verifie la continuite de Seq, le chainage PrevHash et
+// chaque Hash. Retourne une erreur ErrAuditTampered situant le premier
+// record fautif.
+func VerifyAuditChain(records []AuditRecord) error {
+	prev := auditGenesis
+	for i, r := range records {
+		switch {
+		case r.Seq != uint64(i)+1:
+			return fmt.Errorf("record %d: seq %d attendu, %d trouve: %w", i+1, i+1, r.Seq, ErrAuditTampered)
+		case r.PrevHash != prev:
+			return fmt.Errorf("record #%d: prev_hash ne correspond pas au record precedent: %w", r.Seq, ErrAuditTampered)
+		case r.Hash != r.digest():
+			return fmt.Errorf("record #%d: contenu modifie: %w", r.Seq, ErrAuditTampered)
+		}
+		prev = r.Hash
+	}
+	return nil
+}
+
+// ReadAuditLog relit un journal JSONL ecrit par AuditTrail.
+func ReadAuditLog(r io.Reader) ([]AuditRecord, error) {
+	var records []AuditRecord
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return records, fmt.Errorf("ligne %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
+
+// ---------------------------------------------------------------------------
+// Export CAT
+// ---------------------------------------------------------------------------
+
+// catEventCodes donne le code CAT (Consolidated Audit Trail) des evenements
+// qui en ont un equivalent. Les autres portent un code maison X***.
+var catEventCodes = map[EventType]string{
+	EventReceived:       "MENO", // New Order
+	EventAccepted:       "MEOA", // Order Accepted
+	EventAmended:        "MEOM", // Order Modified
+	EventCancelled:      "MEOC", // Order Cancelled
+	EventFilled:         "MEOT", // Trade
+	EventRejected:       "XREJ",
+	EventRested:         "XRST",
+	EventTriggered:      "XTRG",
+	EventTradeBusted:    "XBST",
+	EventTradeCorrected: "XCOR",
+}
+
+// catTimestamp est le format d'horodatage CAT : date, heure, nanosecondes (UTC).
+const catTimestamp = "20060102 150405.000000000"
+
+// ExportCAT ecrit au format CSV les records dont le timestamp est dans
+// [from, to). Retourne le nombre de lignes exportees (hors en-tete).
+func ExportCAT(w io.Writer, records []AuditRecord, from, to time.Time) (int, error) {
+	cw := csv.NewWriter(w)
+	header := []string{
+		"recordSeq", "eventTimestamp", "catEventType", "eventType",
+		"orderID", "clOrdID", "parentOrderID", "childOrderIDs", "groupID", "contraOrderID", "tradeID",
+		"account", "symbol", "side", "orderType", "price", "quantity", "reason", "hash",
+	}
+	if err := cw.Write(header); err != nil {
+		return 0, err
+	}
+	id := func(v uint64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatUint(v, 10)
+	}
+	lo, hi := from.UnixNano(), to.UnixNano()
+	n := 0
+	for _, r := range records {
+		if r.Timestamp < lo || r.Timestamp >= hi {
+			continue
+		}
+		children := make([]string, len(r.ChildIDs))
+		for i, c := range r.ChildIDs {
+			children[i] = id(c)
+		}
+		row := []string{
+			strconv.FormatUint(r.Seq, 10),
+			time.Unix(0, r.Timestamp).UTC().Format(catTimestamp),
+			catEventCodes[r.Type],
+			string(r.Type),
+			id(r.OrderID),
+			r.ClOrdID,
+			id(r.ParentID),
+			strings.Join(children, "|"),
+			id(r.GroupID),
+			id(r.ContraID),
+			id(r.TradeID),
+			r.Account,
+			r.Symbol,
+			string(r.Side),
+			string(r.OrderType),
+			strconv.FormatFloat(r.Price, 'f', -1, 64),
+			strconv.FormatInt(r.Quantity, 10),
+			r.Reason,
+			r.Hash,
+		}
+		if err := cw.Write(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// runAuditExport est la sous-commande audit-export : verifie la chaine
+// d'un journal puis exporte une plage de dates (bornes incluses, UTC) en CSV.
+func runAuditExport(args []string) error {
+	fs := flag.NewFlagSet("audit-export", flag.ContinueOnError)
+	in := fs.String("in", "audit.jsonl", "journal d'audit JSONL")
+	out := fs.String("out", "", "fichier CSV (defaut : sortie standard)")
+	fromDay := fs.String("from", "", "premier jour exporte (AAAA-MM-JJ)")
+	toDay := fs.String("to", "", "dernier jour exporte (AAAA-MM-JJ, defaut : -from)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *fromDay == "" {
+		return fmt.Errorf("audit-export: -from obligatoire")
+	}
+	if *toDay == "" {
+		*toDay = *fromDay
+	}
+	from, err := time.Parse("2006-01-02", *fromDay)
+	if err != nil {
+		return fmt.Errorf("audit-export: -from: %w", err)
+	}
+	to, err := time.Parse("2006-01-02", *toDay)
+	if err != nil {
+		return fmt.Errorf("audit-export: -to: %w", err)
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := ReadAuditLog(f)
+	if err != nil {
+		return fmt.Errorf("audit-export: %s: %w", *in, err)
+	}
+	if err := VerifyAuditChain(records); err != nil {
+		return fmt.Errorf("audit-export: %s: %w", *in, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		o, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer o.Close()
+		w = o
+	}
+	n, err := ExportCAT(w, records, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "audit-export: %d records verifies, %d exportes (%s -> %s)\n",
+		len(records), n, *fromDay, *toDay)
+	return nil
+}
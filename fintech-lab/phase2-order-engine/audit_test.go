@@ -0,0 +1,207 @@
+// audit_test.go — Tests de la piste d'audit, du chainage et de l'amend.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Audit|Amend' -v
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newAuditedGateway() (*Gateway, *AuditTrail) {
+	gw, _ := newTestGateway()
+	trail := NewAuditTrail(nil)
+	gw.Subscribe(trail.OnEvent)
+	return gw, trail
+}
+
+func auditTypes(records []AuditRecord, orderID uint64) []EventType {
+	var out []EventType
+	for _, r := range records {
+		if r.OrderID == orderID {
+			out = append(out, r.Type)
+		}
+	}
+	return out
+}
+
+// TestAuditOrderLifecycle verifie la sequence complete des evenements d'un ordre.
+func TestAuditOrderLifecycle(t *testing.T) {
+	gw, trail := newAuditedGateway()
+
+	rest := NewLimitOrder("AAPL", Sell, 190.00, 100)
+	mustSubmit(t, gw, rest)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190.00, 30))
+	if _, err := gw.Amend("AAPL", rest.ID, 191.00, 0); err != nil {
+		t.Fatalf("Amend: %v", err)
+	}
+	if err := gw.Cancel("AAPL", rest.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	bad := NewLimitOrder("AAPL", Buy, -1, 10)
+	if _, err := gw.Submit(bad); err == nil {
+		t.Fatal("prix negatif: erreur attendue")
+	}
+
+	records := trail.Records()
+	want := []EventType{EventReceived, EventAccepted, EventRested, EventFilled, EventAmended, EventRested, EventCancelled}
+	if got := auditTypes(records, rest.ID); !reflect.DeepEqual(got, want) {
+		t.Errorf("ordre au repos: attendu %v, obtenu %v", want, got)
+	}
+	if got := auditTypes(records, bad.ID); !reflect.DeepEqual(got, []EventType{EventReceived, EventRejected}) {
+		t.Errorf("ordre rejete: obtenu %v", got)
+	}
+	last := records[len(records)-1]
+	if last.Reason == "" {
+		t.Error("rejet sans motif")
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].Timestamp < records[i-1].Timestamp {
+			t.Errorf("record #%d anterieur au precedent", records[i].Seq)
+		}
+	}
+	if err := VerifyAuditChain(records); err != nil {
+		t.Errorf("chaine intacte attendue: %v", err)
+	}
+}
+
+// TestAuditLinkedIDs verifie les liens parent/enfant d'un bracket.
+func TestAuditLinkedIDs(t *testing.T) {
+	gw, trail := newAuditedGateway()
+
+	entry := NewLimitOrder("AAPL", Buy, 190.00, 100)
+	takeProfit := NewLimitOrder("AAPL", Sell, 195.00, 100)
+	stopLoss := NewStopOrder("AAPL", Sell, 185.00, 100)
+	id, _, err := gw.SubmitBracket(entry, takeProfit, stopLoss)
+	if err != nil {
+		t.Fatalf("SubmitBracket: %v", err)
+	}
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 40))
+
+	var sawEntry, sawLeg bool
+	for _, r := range trail.Records() {
+		switch r.OrderID {
+		case entry.ID:
+			if r.Type == EventAccepted {
+				sawEntry = true
+				if r.GroupID != id || !reflect.DeepEqual(r.ChildIDs, []uint64{takeProfit.ID, stopLoss.ID}) {
+					t.Errorf("entree: groupe/enfants inattendus: %+v", r.Event)
+				}
+			}
+		case takeProfit.ID:
+			if r.Type == EventRested {
+				sawLeg = true
+				if r.ParentID != entry.ID || r.Quantity != 40 {
+					t.Errorf("take-profit: parent/quantite inattendus: %+v", r.Event)
+				}
+			}
+		}
+	}
+	if !sawEntry || !sawLeg {
+		t.Errorf("evenements manquants: entree=%v take-profit pose=%v", sawEntry, sawLeg)
+	}
+}
+
+// TestAuditTamperDetected verifie que toute alteration casse la chaine.
+func TestAuditTamperDetected(t *testing.T) {
+	gw, trail := newAuditedGateway()
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190.00, 100))
+
+	edited := trail.Records()
+	edited[3].Price = 1.00
+	if err := VerifyAuditChain(edited); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("record modifie: ErrAuditTampered attendu, obtenu %v", err)
+	}
+
+	removed := trail.Records()
+	removed = append(removed[:2], removed[3:]...)
+	if err := VerifyAuditChain(removed); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("record retire: ErrAuditTampered attendu, obtenu %v", err)
+	}
+}
+
+// TestAuditExportCAT verifie la relecture du journal et le filtre par date.
+func TestAuditExportCAT(t *testing.T) {
+	var buf bytes.Buffer
+	gw, _ := newTestGateway()
+	trail := NewAuditTrail(&buf)
+	gw.Subscribe(trail.OnEvent)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190.00, 100))
+
+	records, err := ReadAuditLog(&buf)
+	if err != nil {
+		t.Fatalf("ReadAuditLog: %v", err)
+	}
+	if err := VerifyAuditChain(records); err != nil {
+		t.Fatalf("chaine relue: %v", err)
+	}
+	if records[len(records)-1].Hash != trail.Head() {
+		t.Error("le dernier hash relu doit egaler Head()")
+	}
+
+	var out bytes.Buffer
+	day := time.Unix(0, records[0].Timestamp).UTC().Truncate(24 * time.Hour)
+	n, err := ExportCAT(&out, records, day, day.AddDate(0, 0, 1))
+	if err != nil || n != len(records) {
+		t.Fatalf("ExportCAT: %d lignes, err=%v (attendu %d)", n, err, len(records))
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV illisible: %v", err)
+	}
+	if rows[1][2] != "MENO" || rows[1][3] != string(EventReceived) {
+		t.Errorf("premiere ligne inattendue: %v", rows[1])
+	}
+
+	if n, _ := ExportCAT(&bytes.Buffer{}, records, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)); n != 0 {
+		t.Errorf("jour suivant: attendu 0 ligne, obtenu %d", n)
+	}
+}
+
+// TestAmendPriority verifie la perte de priorite et le matching apres amend.
+func TestAmendPriority(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	first := NewLimitOrder("AAPL", Buy, 189.00, 100)
+	second := NewLimitOrder("AAPL", Buy, 189.00, 100)
+	mustSubmit(t, gw, first)
+	mustSubmit(t, gw, second)
+
+	// Baisse de quantite : first garde la tete de file.
+	if _, err := gw.Amend("AAPL", first.ID, 0, 50); err != nil {
+		t.Fatalf("Amend: %v", err)
+	}
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 189.00, 10))
+	if trades[0].BuyOrderID != first.ID {
+		t.Errorf("baisse de quantite: first devait garder la priorite, trade %v", trades[0])
+	}
+
+	// Hausse de quantite : first passe derriere second.
+	if _, err := gw.Amend("AAPL", first.ID, 0, 200); err != nil {
+		t.Fatalf("Amend: %v", err)
+	}
+	trades = mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 189.00, 10))
+	if trades[0].BuyOrderID != second.ID {
+		t.Errorf("hausse de quantite: second devait passer devant, trade %v", trades[0])
+	}
+
+	// Nouveau prix qui croise le carnet : execution immediate.
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 40))
+	trades, err := gw.Amend("AAPL", second.ID, 190.00, 0)
+	if err != nil {
+		t.Fatalf("Amend: %v", err)
+	}
+	if len(trades) != 1 || trades[0].Quantity != 40 || trades[0].Price != 190.00 {
+		t.Errorf("amend croisant: attendu 1 trade x40 @190, obtenu %v", trades)
+	}
+
+	if _, err := gw.Amend("AAPL", first.ID, 0, first.Filled); err == nil {
+		t.Error("quantite <= deja execute: erreur attendue")
+	}
+}
@@ -0,0 +1,737 @@
+// backtest.go — Backtest de strategies de trading sur le moteur.
+//
+// Une Strategy recoit les mises a jour du carnet, les trades du marche et
+// ses propres executions, et place ou annule des ordres en reponse. Le
+// runner rejoue un flux (fichier historique au format de replay.go, ou
+// flux synthetique) dans un Gateway neuf pilote par une SimClock : deux
+// runs sur les memes donnees donnent le meme rapport.
+//
+// BOUCLE : chaque ligne du flux est soumise au Gateway. Les evenements
+// produits sont comptabilises tout de suite (position, cash) mais les
+// callbacks de la strategie sont differes : le handler tourne sous gw.mu et
+// ne peut pas rappeler le Gateway. Ils sont distribues une fois l'appel
+// termine ; les ordres de la strategie produisent a leur tour des
+// evenements, distribues dans la meme boucle jusqu'a epuisement, avant la
+// ligne suivante. Pas de latence simulee : la strategie reagit toujours
+// avant le marche.
+//
+// RAPPORT : P&L (cash + position valorisee au mid), taux d'execution,
+// slippage contre le VWAP du marche, drawdown max et inventaire au fil du
+// temps. Les busts et corrections de trades ne sont pas rejoues.
+//
+// Lancer : go run ./phase2-order-engine/ backtest -strategy mm -synthetic 20000
+//          go run ./phase2-order-engine/ backtest -strategy momentum -in flux.csv -symbol AAPL
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Strategy — Interface implementee par les strategies testees
+// ---------------------------------------------------------------------------
+
+// Strategy reagit aux evenements du marche. Les callbacks sont appeles
+// hors verrou : ils peuvent passer et annuler des ordres via c.
+type Strategy interface {
+	// OnBook recoit le meilleur niveau de chaque cote quand il change,
+	// HORS ordres de la strategie : un market maker ne se cote pas sur ses
+	// propres prix. Un Level zero signale un cote vide.
+	OnBook(c *StrategyContext, symbol string, bid, ask Level)
+	// OnTrade recoit chaque trade du marche, ceux de la strategie compris.
+	OnTrade(c *StrategyContext, t Trade)
+	// OnFill recoit une execution d'un ordre de la strategie. o est l'ordre
+	// a jour (Filled, Status) ; la position en tient deja compte.
+	OnFill(c *StrategyContext, o *Order, f Fill)
+}
+
+// defaultBacktestAccount est le compte des ordres de la strategie.
+const defaultBacktestAccount = "BACKTEST"
+
+// maxBacktestDispatch borne les callbacks distribues pour une seule ligne :
+// au-dela, la strategie reagit a ses propres ordres sans fin.
+const maxBacktestDispatch = 100_000
+
+// BacktestOptions regle un backtest. Les zeros prennent les defauts.
+type BacktestOptions struct {
+	Account     string        // Compte de la strategie (defaut : BACKTEST)
+	Symbols     []string      // Symboles du Gateway (defaut : ceux du flux)
+	Sample      time.Duration // Pas d'echantillonnage de l'inventaire, en temps simule (defaut : 1s)
+	Instruments []Instrument  // Devises des symboles (absent : USD)
+	Currency    string        // Devise de reporting du P&L (defaut : USD)
+	FX          *FXRates      // Taux vers Currency (nil : toutes les positions dans Currency)
+}
+
+// ---------------------------------------------------------------------------
+// Rapport
+// ---------------------------------------------------------------------------
+
+// BacktestPosition est le resultat de la strategie sur un symbole.
+// Montants dans la devise du symbole.
+type BacktestPosition struct {
+	Symbol       string
+	Currency     string
+	Position     int64 // Positive : long
+	Bought       int64
+	Sold         int64
+	BuyNotional  float64
+	SellNotional float64
+	Mark         float64 // Prix de valorisation final (mid, ou dernier trade)
+	PnL          float64 // Cash + position valorisee a Mark
+	VWAP         float64 // VWAP du marche sur le backtest
+	SlippageBps  float64 // Cout des executions contre le VWAP (negatif : mieux que le VWAP)
+}
+
+// BacktestSample est un point de la courbe de P&L et d'inventaire.
+type BacktestSample struct {
+	TS        int64
+	PnL       float64
+	Inventory []int64 // Positions, dans l'ordre de BacktestReport.Positions
+}
+
+// BacktestReport resume un backtest.
+type BacktestReport struct {
+	Records      int // Lignes du flux rejouees
+	MarketErrors int // Lignes du flux refusees par le Gateway
+	Trades       int // Trades du marche, ceux de la strategie compris
+
+	Orders    int   // Ordres soumis par la strategie
+	Rejects   int   // ... dont refuses
+	Cancels   int   // Annulations abouties
+	Fills     int   // Executions de la strategie
+	Submitted int64 // Quantite soumise
+	Filled    int64 // Quantite executee
+
+	Currency    string  // Devise de reporting de PnL, MaxDrawdown et Samples
+	PnL         float64 // Somme des P&L des positions, converties aux taux courants
+	MaxDrawdown float64 // Plus forte baisse du P&L depuis un sommet
+	Positions   []BacktestPosition
+	Samples     []BacktestSample
+	Span        time.Duration // Temps simule couvert
+}
+
+// FillRate retourne la part de la quantite soumise qui a ete executee.
+func (r *BacktestReport) FillRate() float64 {
+	if r.Submitted == 0 {
+		return 0
+	}
+	return float64(r.Filled) / float64(r.Submitted)
+}
+
+// ---------------------------------------------------------------------------
+// StrategyContext — Acces de la strategie au Gateway simule
+// ---------------------------------------------------------------------------
+
+// StrategyContext est passe a chaque callback. Il n'est pas thread-safe :
+// le backtest est mono-goroutine.
+type StrategyContext struct {
+	gw       *Gateway
+	account  string
+	strategy Strategy
+	symbols  []string
+	report   *BacktestReport
+	fx       *FXRates
+	fxErr    error // Premiere conversion impossible (taux retire a chaud)
+
+	pos     map[string]*BacktestPosition // symbol -> position (dans report.Positions)
+	own     map[uint64]*Order            // ordres de la strategie, par ID
+	open    map[uint64]*Order            // ... encore au carnet (lazy deletion)
+	pending []Event                      // fills en attente de distribution
+	touched map[string]bool              // symboles dont le carnet a pu changer
+	tops    map[string][2]Level          // dernier top envoye a OnBook
+
+	peak       float64
+	nextSample int64
+	sample     time.Duration
+}
+
+// Now retourne l'heure simulee.
+func (c *StrategyContext) Now() int64 {
+	return c.gw.Clock().Now()
+}
+
+// Submit soumet un ordre de la strategie, sous son compte. Les executions
+// immediates sont deja dans la position au retour ; OnFill suivra.
+func (c *StrategyContext) Submit(o *Order) error {
+	o.Account = c.account
+	c.report.Orders++
+	if _, err := c.gw.Submit(o); err != nil {
+		c.report.Rejects++
+		return err
+	}
+	c.report.Submitted += o.Quantity
+	c.own[o.ID] = o
+	if o.IsActive() {
+		c.open[o.ID] = o
+	}
+	return nil
+}
+
+// Buy soumet un achat a cours limite.
+func (c *StrategyContext) Buy(symbol string, price float64, qty int64) (*Order, error) {
+	o := NewLimitOrder(symbol, Buy, price, qty)
+	return o, c.Submit(o)
+}
+
+// Sell soumet une vente a cours limite.
+func (c *StrategyContext) Sell(symbol string, price float64, qty int64) (*Order, error) {
+	o := NewLimitOrder(symbol, Sell, price, qty)
+	return o, c.Submit(o)
+}
+
+// Cancel annule un ordre de la strategie.
+func (c *StrategyContext) Cancel(o *Order) error {
+	if err := c.gw.Cancel(o.Symbol, o.ID); err != nil {
+		return err
+	}
+	c.report.Cancels++
+	delete(c.open, o.ID)
+	return nil
+}
+
+// CancelAll annule les ordres ouverts de la strategie sur symbol.
+func (c *StrategyContext) CancelAll(symbol string) {
+	for _, o := range c.Orders(symbol) {
+		c.Cancel(o)
+	}
+}
+
+// Orders retourne les ordres ouverts de la strategie sur symbol, par ID.
+func (c *StrategyContext) Orders(symbol string) []*Order {
+	var out []*Order
+	for id, o := range c.open {
+		if !o.IsActive() {
+			delete(c.open, id)
+			continue
+		}
+		if o.Symbol == symbol {
+			out = append(out, o)
+		}
+	}
+	slices.SortFunc(out, func(a, b *Order) int { return compareID(a.ID, b.ID) })
+	return out
+}
+
+// Own indique si un ordre appartient a la strategie (pour filtrer ses
+// propres trades dans OnTrade).
+func (c *StrategyContext) Own(orderID uint64) bool {
+	_, ok := c.own[orderID]
+	return ok
+}
+
+// Position retourne la position de la strategie sur symbol.
+func (c *StrategyContext) Position(symbol string) int64 {
+	if p, ok := c.pos[symbol]; ok {
+		return p.Position
+	}
+	return 0
+}
+
+// Top retourne le meilleur niveau de chaque cote hors ordres de la
+// strategie, comme OnBook.
+func (c *StrategyContext) Top(symbol string) (bid, ask Level) {
+	ob, ok := c.gw.Book(symbol)
+	if !ok {
+		return Level{}, Level{}
+	}
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	bid = bestLevel(*ob.bids, c.own, func(a, b float64) bool { return a > b })
+	ask = bestLevel(*ob.asks, c.own, func(a, b float64) bool { return a < b })
+	return bid, ask
+}
+
+// bestLevel agrege le meilleur prix des ordres actifs hors skip. Une passe
+// sans tri ni allocation : Levels trie tout le carnet, trop cher a chaque
+// ligne du backtest.
+func bestLevel(orders []*Order, skip map[uint64]*Order, better func(a, b float64) bool) Level {
+	var l Level
+	for _, o := range orders {
+		if _, ok := skip[o.ID]; ok || !o.IsActive() {
+			continue
+		}
+		switch {
+		case l.Orders == 0 || better(o.Price, l.Price):
+			l = Level{Price: o.Price, Quantity: o.Remaining(), Orders: 1}
+		case o.Price == l.Price:
+			l.Quantity += o.Remaining()
+			l.Orders++
+		}
+	}
+	return l
+}
+
+// compareID ordonne deux IDs croissants.
+func compareID(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ---------------------------------------------------------------------------
+// Boucle d'evenements
+// ---------------------------------------------------------------------------
+
+// onEvent est l'abonne du Gateway. Appele avec gw.mu tenu : il comptabilise
+// et met en file, sans jamais rappeler le Gateway.
+func (c *StrategyContext) onEvent(e Event) {
+	switch e.Type {
+	case EventFilled:
+		if e.Account == c.account {
+			p := c.pos[e.Symbol]
+			notional := e.Price * float64(e.Quantity)
+			if e.Side == Buy {
+				p.Position += e.Quantity
+				p.Bought += e.Quantity
+				p.BuyNotional += notional
+			} else {
+				p.Position -= e.Quantity
+				p.Sold += e.Quantity
+				p.SellNotional += notional
+			}
+			c.report.Fills++
+			c.report.Filled += e.Quantity
+		}
+		c.pending = append(c.pending, e)
+		c.touched[e.Symbol] = true
+	case EventRested, EventAmended, EventCancelled, EventTriggered:
+		c.touched[e.Symbol] = true
+	}
+}
+
+// dispatch distribue les evenements en file puis les changements de top
+// of book, jusqu'a ce que la strategie ne produise plus rien.
+func (c *StrategyContext) dispatch(line int) error {
+	for n := 0; ; n++ {
+		if n == maxBacktestDispatch {
+			return fmt.Errorf("backtest: ligne %d: plus de %d callbacks, la strategie reagit sans fin a ses propres ordres", line, n)
+		}
+		if len(c.pending) > 0 {
+			e := c.pending[0]
+			c.pending = c.pending[1:]
+			if e.Account == c.account {
+				c.strategy.OnFill(c, c.own[e.OrderID], Fill{
+					TradeID:   e.TradeID,
+					ContraID:  e.ContraID,
+					Price:     e.Price,
+					Quantity:  e.Quantity,
+					Timestamp: e.Timestamp,
+				})
+			}
+			if e.Side == Buy { // Un seul OnTrade par trade : le cote acheteur
+				c.strategy.OnTrade(c, Trade{
+					ID:          e.TradeID,
+					Symbol:      e.Symbol,
+					BuyOrderID:  e.OrderID,
+					SellOrderID: e.ContraID,
+					Price:       e.Price,
+					Quantity:    e.Quantity,
+					Currency:    c.pos[e.Symbol].Currency,
+					Timestamp:   e.Timestamp,
+					Status:      TradeActive,
+				})
+			}
+			continue
+		}
+		if symbol, bid, ask, ok := c.nextTop(); ok {
+			c.strategy.OnBook(c, symbol, bid, ask)
+			continue
+		}
+		return nil
+	}
+}
+
+// nextTop retourne le premier symbole touche dont le top of book a change
+// depuis le dernier OnBook.
+func (c *StrategyContext) nextTop() (string, Level, Level, bool) {
+	for _, symbol := range c.symbols {
+		if !c.touched[symbol] {
+			continue
+		}
+		delete(c.touched, symbol)
+		bid, ask := c.Top(symbol)
+		if top := [2]Level{bid, ask}; top != c.tops[symbol] {
+			c.tops[symbol] = top
+			return symbol, bid, ask, true
+		}
+	}
+	return "", Level{}, Level{}, false
+}
+
+// mark retourne le prix de valorisation d'un symbole : mid du carnet
+// (ordres de la strategie compris), sinon dernier trade.
+func (c *StrategyContext) mark(symbol string) float64 {
+	if ob, ok := c.gw.Book(symbol); ok {
+		bid, hasBid := ob.BestBid()
+		ask, hasAsk := ob.BestAsk()
+		if hasBid && hasAsk {
+			return (bid + ask) / 2
+		}
+	}
+	tk, _ := c.gw.log.Ticker(symbol)
+	return tk.Last
+}
+
+// step met a jour le P&L, le drawdown et les echantillons apres une ligne.
+func (c *StrategyContext) step(ts int64, force bool) {
+	pnl := 0.0
+	for i := range c.report.Positions {
+		p := &c.report.Positions[i]
+		p.PnL = p.SellNotional - p.BuyNotional
+		if p.Position != 0 {
+			p.Mark = c.mark(p.Symbol)
+			p.PnL += float64(p.Position) * p.Mark
+		}
+		v, err := c.fx.Convert(p.PnL, p.Currency, c.report.Currency)
+		if err != nil && c.fxErr == nil {
+			c.fxErr = fmt.Errorf("backtest: P&L %s: %w", p.Symbol, err)
+		}
+		pnl += v
+	}
+	c.report.PnL = pnl
+	c.peak = max(c.peak, pnl)
+	c.report.MaxDrawdown = max(c.report.MaxDrawdown, c.peak-pnl)
+
+	if ts < c.nextSample && !force {
+		return
+	}
+	if n := len(c.report.Samples); n > 0 && c.report.Samples[n-1].TS == ts {
+		return // Fin de flux juste apres un echantillon
+	}
+	s := BacktestSample{TS: ts, PnL: pnl, Inventory: make([]int64, len(c.report.Positions))}
+	for i, p := range c.report.Positions {
+		s.Inventory[i] = p.Position
+	}
+	c.report.Samples = append(c.report.Samples, s)
+	c.nextSample = ts + int64(c.sample)
+}
+
+// ---------------------------------------------------------------------------
+// Runner
+// ---------------------------------------------------------------------------
+
+// Backtest rejoue records dans un Gateway neuf et y fait trader s. Annule
+// par ctx, il s'arrete a la ligne en cours et retourne le rapport partiel
+// avec ctx.Err().
+func Backtest(ctx context.Context, records []ReplayRecord, s Strategy, opts BacktestOptions) (*BacktestReport, error) {
+	if opts.Account == "" {
+		opts.Account = defaultBacktestAccount
+	}
+	if opts.Sample <= 0 {
+		opts.Sample = time.Second
+	}
+	if opts.Currency == "" {
+		opts.Currency = DefaultCurrency
+	}
+	symbols := opts.Symbols
+	if len(symbols) == 0 {
+		symbols = replaySymbols(records)
+	}
+	instruments := usdInstruments(symbols)
+	for i := range instruments {
+		for _, inst := range opts.Instruments {
+			if inst.Symbol == instruments[i].Symbol {
+				instruments[i].Currency = inst.Currency
+			}
+		}
+		if _, err := opts.FX.Rate(instruments[i].Currency, opts.Currency); err != nil {
+			return nil, &ValidationError{Field: "fx", Message: fmt.Sprintf("%s en %s: %v", instruments[i].Symbol, instruments[i].Currency, err)}
+		}
+	}
+	var first, last int64
+	if len(records) > 0 {
+		first = records[0].TS
+	}
+	clock := NewSimClock(time.Unix(0, first), time.Nanosecond) // Voir Replay
+	gw := NewGatewayWithInstruments(instruments, NewTradeLog(), clock)
+
+	rep := &BacktestReport{Currency: opts.Currency, Positions: make([]BacktestPosition, len(symbols))}
+	c := &StrategyContext{
+		gw:       gw,
+		account:  opts.Account,
+		strategy: s,
+		symbols:  symbols,
+		report:   rep,
+		fx:       opts.FX,
+		pos:      make(map[string]*BacktestPosition, len(symbols)),
+		own:      make(map[uint64]*Order),
+		open:     make(map[uint64]*Order),
+		touched:  make(map[string]bool),
+		tops:     make(map[string][2]Level),
+		sample:   opts.Sample,
+	}
+	for i, inst := range instruments {
+		rep.Positions[i].Symbol, rep.Positions[i].Currency = inst.Symbol, inst.Currency
+		c.pos[inst.Symbol] = &rep.Positions[i]
+	}
+	gw.Subscribe(c.onEvent)
+
+	var err error
+	for _, r := range records {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		clock.Set(r.TS)
+		if applyReplay(gw, r) != nil {
+			rep.MarketErrors++
+		}
+		rep.Records++
+		last = r.TS
+		if err = c.dispatch(r.Line); err != nil {
+			break
+		}
+		c.step(r.TS, false)
+	}
+	c.step(last, true)
+
+	rep.Trades = gw.log.Count()
+	rep.Span = time.Duration(last - first)
+	for i := range rep.Positions {
+		p := &rep.Positions[i]
+		tk, _ := gw.log.Ticker(p.Symbol)
+		p.VWAP = tk.VWAP()
+		if traded := p.Bought + p.Sold; traded > 0 && p.VWAP > 0 {
+			cost := (p.BuyNotional - float64(p.Bought)*p.VWAP) + (float64(p.Sold)*p.VWAP - p.SellNotional)
+			p.SlippageBps = cost / (float64(traded) * p.VWAP) * 1e4
+		}
+	}
+	if err == nil {
+		err = c.fxErr
+	}
+	return rep, err
+}
+
+// ---------------------------------------------------------------------------
+// Flux synthetique
+// ---------------------------------------------------------------------------
+
+// SyntheticOptions regle le flux synthetique. Les zeros prennent les
+// defauts.
+type SyntheticOptions struct {
+	Symbols  []string      // defaut : SYN
+	Records  int           // defaut : 10000
+	Price    float64       // Mid initial (defaut : 100)
+	Tick     float64       // defaut : 0.01
+	Vol      float64       // Ecart-type du pas du mid a chaque ligne, en ticks (defaut : 1)
+	Interval time.Duration // Ecart moyen entre deux lignes (defaut : 10ms)
+	Start    time.Time     // defaut : 2025-01-02 14:30 UTC
+	Seed     uint64
+}
+
+// SyntheticFlow genere un flux autour d'un mid en marche aleatoire :
+// 60% d'ordres passifs a 1-5 ticks du mid, 20% d'ordres agressifs qui
+// traversent le mid de 2 ticks, 20% d'annulations d'ordres passifs
+// anterieurs (refusees si l'ordre a deja ete execute). Deterministe pour
+// une graine donnee.
+func SyntheticFlow(opts SyntheticOptions) []ReplayRecord {
+	if len(opts.Symbols) == 0 {
+		opts.Symbols = []string{"SYN"}
+	}
+	if opts.Records <= 0 {
+		opts.Records = 10_000
+	}
+	if opts.Price <= 0 {
+		opts.Price = 100
+	}
+	if opts.Tick <= 0 {
+		opts.Tick = 0.01
+	}
+	if opts.Vol <= 0 {
+		opts.Vol = 1
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Millisecond
+	}
+	if opts.Start.IsZero() {
+		opts.Start = time.Date(2025, 1, 2, 14, 30, 0, 0, time.UTC)
+	}
+
+	rng := rand.New(rand.NewPCG(opts.Seed, 0x5eed))
+	mids := make([]float64, len(opts.Symbols))
+	for i := range mids {
+		mids[i] = opts.Price
+	}
+	var passive []ReplayRecord // Ordres passifs, candidats a l'annulation
+	ts := opts.Start.UnixNano()
+	out := make([]ReplayRecord, 0, opts.Records)
+	for n := range opts.Records {
+		ts += 1 + int64(rng.ExpFloat64()*float64(opts.Interval))
+		i := rng.IntN(len(mids))
+		mids[i] = max(mids[i]+rng.NormFloat64()*opts.Vol*opts.Tick, 10*opts.Tick)
+		r := ReplayRecord{
+			Line:    n + 1,
+			TS:      ts,
+			Action:  ReplayNew,
+			Account: "SYN",
+			ClOrdID: "syn-" + strconv.Itoa(n+1),
+			Symbol:  opts.Symbols[i],
+			Side:    Buy,
+			Type:    Limit,
+		}
+		sign := -1.0 // Passif : achat sous le mid, vente au-dessus
+		if rng.IntN(2) == 1 {
+			r.Side, sign = Sell, 1
+		}
+		switch u := rng.Float64(); {
+		case u < 0.6 || (u >= 0.8 && len(passive) == 0):
+			r.Price = roundTick(mids[i]+sign*float64(1+rng.IntN(5))*opts.Tick, opts.Tick)
+			r.Quantity = int64(10 * (1 + rng.IntN(10)))
+			passive = append(passive, r)
+		case u < 0.8:
+			r.Price = roundTick(mids[i]-sign*2*opts.Tick, opts.Tick)
+			r.Quantity = int64(10 * (1 + rng.IntN(5)))
+		default:
+			k := rng.IntN(len(passive))
+			target := passive[k]
+			passive[k] = passive[len(passive)-1]
+			passive = passive[:len(passive)-1]
+			r = ReplayRecord{Line: n + 1, TS: ts, Action: ReplayCancel, Account: "SYN", OrigClOrdID: target.ClOrdID}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// roundTick arrondit un prix au tick le plus proche.
+func roundTick(price, tick float64) float64 {
+	return math.Round(math.Round(price/tick)*tick*1e8) / 1e8
+}
+
+// ---------------------------------------------------------------------------
+// Sorties
+// ---------------------------------------------------------------------------
+
+// writeBacktestReport ecrit le resume lisible d'un rapport.
+func writeBacktestReport(w io.Writer, name string, rep *BacktestReport) {
+	fmt.Fprintf(w, "backtest %s : %d lignes (%d refusees), %d trades sur %v simulees\n",
+		name, rep.Records, rep.MarketErrors, rep.Trades, rep.Span.Round(time.Millisecond))
+	fmt.Fprintf(w, "  ordres    : %d soumis, %d refuses, %d annules\n", rep.Orders, rep.Rejects, rep.Cancels)
+	fmt.Fprintf(w, "  execution : %d fills, %d/%d (fill rate %.1f%%)\n",
+		rep.Fills, rep.Filled, rep.Submitted, 100*rep.FillRate())
+	fmt.Fprintf(w, "  P&L       : %+.2f %s (drawdown max %.2f)\n", rep.PnL, rep.Currency, rep.MaxDrawdown)
+	for _, p := range rep.Positions {
+		if p.Bought+p.Sold == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %-5s pos=%+d achete=%d vendu=%d P&L=%+.2f %s mark=%.2f VWAP=%.4f slippage=%+.2f bps\n",
+			p.Symbol, p.Position, p.Bought, p.Sold, p.PnL, p.Currency, p.Mark, p.VWAP, p.SlippageBps)
+	}
+}
+
+// writeBacktestSamples ecrit la courbe de P&L et d'inventaire en CSV :
+// ts, pnl, puis une colonne de position par symbole.
+func writeBacktestSamples(w io.Writer, rep *BacktestReport) error {
+	cw := csv.NewWriter(w)
+	header := []string{"ts", "pnl"}
+	for _, p := range rep.Positions {
+		header = append(header, p.Symbol)
+	}
+	cw.Write(header)
+	for _, s := range rep.Samples {
+		row := []string{strconv.FormatInt(s.TS, 10), strconv.FormatFloat(s.PnL, 'f', 2, 64)}
+		for _, q := range s.Inventory {
+			row = append(row, strconv.FormatInt(q, 10))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ---------------------------------------------------------------------------
+// CLI
+// ---------------------------------------------------------------------------
+
+// runBacktest est le point d'entree de la commande backtest.
+func runBacktest(args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	in := fs.String("in", "", "fichier d'ordres historique (format replay) ; vide : flux synthetique")
+	synthetic := fs.Int("synthetic", 10_000, "lignes du flux synthetique")
+	seed := fs.Uint64("seed", 1, "graine du flux synthetique")
+	name := fs.String("strategy", "mm", "strategie : mm (market making) ou momentum")
+	symbol := fs.String("symbol", "", "symbole trade (defaut : premier symbole du flux)")
+	qty := fs.Int64("qty", 100, "taille des ordres de la strategie")
+	maxPos := fs.Int64("max-position", 1000, "position maximale, en valeur absolue")
+	samplesPath := fs.String("samples", "", "fichier CSV de la courbe P&L / inventaire (optionnel)")
+	currencies := fs.String("currencies", "", "devises des symboles, SYM:DEVISE separes par des virgules (defaut USD)")
+	currency := fs.String("currency", DefaultCurrency, "devise de reporting du P&L")
+	fxPath := fs.String("fx", "", "fichier de taux de change vers la devise de reporting")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts := BacktestOptions{Currency: *currency}
+	if *currencies != "" {
+		var err error
+		if opts.Instruments, err = ParseInstruments(*currencies); err != nil {
+			return fmt.Errorf("backtest: %w", err)
+		}
+	}
+	if *fxPath != "" {
+		var err error
+		if opts.FX, err = LoadFXRates(*fxPath); err != nil {
+			return fmt.Errorf("backtest: %w", err)
+		}
+	}
+
+	var records []ReplayRecord
+	if *in != "" {
+		var err error
+		if records, err = ReadReplayFile(*in); err != nil {
+			return fmt.Errorf("backtest: %w", err)
+		}
+	} else {
+		records = SyntheticFlow(SyntheticOptions{Records: *synthetic, Seed: *seed})
+	}
+	if *symbol == "" {
+		if symbols := replaySymbols(records); len(symbols) > 0 {
+			*symbol = symbols[0]
+		}
+	}
+
+	var s Strategy
+	switch strings.ToLower(*name) {
+	case "mm":
+		s = &MarketMaker{Symbol: *symbol, Quantity: *qty, MaxPosition: *maxPos}
+	case "momentum":
+		s = &Momentum{Symbol: *symbol, Quantity: *qty, MaxPosition: *maxPos}
+	default:
+		return fmt.Errorf("backtest: strategie inconnue %q (mm, momentum)", *name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	start := time.Now()
+	rep, err := Backtest(ctx, records, s, opts)
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backtest: interrompu apres %d lignes sur %d\n", rep.Records, len(records))
+	}
+	writeBacktestReport(os.Stdout, *name+" "+*symbol, rep)
+	fmt.Printf("  duree     : %v\n", time.Since(start).Round(time.Millisecond))
+	if *samplesPath != "" {
+		if err := writeFile(*samplesPath, func(w io.Writer) error { return writeBacktestSamples(w, rep) }); err != nil {
+			return err
+		}
+		fmt.Printf("backtest: %d echantillons -> %s\n", len(rep.Samples), *samplesPath)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// backtest_test.go — Tests du backtest et des strategies d'exemple.
+// Lancer avec : go test ./phase2-order-engine/ -run Backtest -v
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptStrategy delegue OnBook a une fonction et enregistre le reste.
+type scriptStrategy struct {
+	onBook  func(c *StrategyContext, symbol string, bid, ask Level)
+	onTrade func(c *StrategyContext, t Trade)
+	books   [][2]Level
+	trades  []Trade
+	fills   []Fill
+}
+
+func (s *scriptStrategy) OnBook(c *StrategyContext, symbol string, bid, ask Level) {
+	s.books = append(s.books, [2]Level{bid, ask})
+	if s.onBook != nil {
+		s.onBook(c, symbol, bid, ask)
+	}
+}
+
+func (s *scriptStrategy) OnTrade(c *StrategyContext, t Trade) {
+	s.trades = append(s.trades, t)
+	if s.onTrade != nil {
+		s.onTrade(c, t)
+	}
+}
+
+func (s *scriptStrategy) OnFill(c *StrategyContext, o *Order, f Fill) {
+	s.fills = append(s.fills, f)
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+// TestBacktestAccounting suit un scenario calcule a la main : la strategie
+// achete 100 @ 10.00 au marche, revend 40 @ 10.20 en passif, et finit
+// longue de 60 valorisee au mid 10.15.
+func TestBacktestAccounting(t *testing.T) {
+	records, err := ReadReplayCSV(strings.NewReader(`ts,account,symbol,side,price,quantity
+1000000000,M,AAPL,SELL,10.00,100
+2000000000,M,AAPL,BUY,9.90,100
+3000000000,M,AAPL,BUY,10.20,40
+4000000000,M,AAPL,SELL,10.40,10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &scriptStrategy{}
+	s.onBook = func(c *StrategyContext, symbol string, bid, ask Level) {
+		if bid.Quantity == 0 || ask.Quantity == 0 || len(c.own) > 0 {
+			return
+		}
+		if _, err := c.Buy(symbol, ask.Price, 100); err != nil {
+			t.Error(err)
+		}
+		if c.Position(symbol) != 100 {
+			t.Errorf("position apres un achat execute: %d, attendu 100 des le retour", c.Position(symbol))
+		}
+		c.Sell(symbol, 10.20, 40)
+	}
+	rep, err := Backtest(context.Background(), records, s, BacktestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apres l'achat, le seul ask est celui de la strategie : invisible.
+	wantBooks := [][2]Level{
+		{{}, {Price: 10, Quantity: 100, Orders: 1}},
+		{{Price: 9.9, Quantity: 100, Orders: 1}, {Price: 10, Quantity: 100, Orders: 1}},
+		{{Price: 9.9, Quantity: 100, Orders: 1}, {}},
+		{{Price: 9.9, Quantity: 100, Orders: 1}, {Price: 10.4, Quantity: 10, Orders: 1}},
+	}
+	if !reflect.DeepEqual(s.books, wantBooks) {
+		t.Errorf("OnBook: %+v, attendu %+v", s.books, wantBooks)
+	}
+	if len(s.fills) != 2 || len(s.trades) != 2 || s.fills[1].Price != 10.2 || s.fills[1].Quantity != 40 {
+		t.Errorf("fills %+v, trades %+v", s.fills, s.trades)
+	}
+
+	if rep.Records != 4 || rep.Trades != 2 || rep.Orders != 2 || rep.Fills != 2 || rep.Submitted != 140 || rep.FillRate() != 1 {
+		t.Errorf("rapport: %+v", rep)
+	}
+	p := rep.Positions[0]
+	if p.Symbol != "AAPL" || p.Position != 60 || p.Bought != 100 || p.Sold != 40 || p.Mark != 10.15 {
+		t.Errorf("position: %+v", p)
+	}
+	// Cash 408 - 1000, plus 60 x 10.15.
+	if !near(rep.PnL, 17) || !near(p.PnL, 17) {
+		t.Errorf("P&L = %v, attendu 17", rep.PnL)
+	}
+	// VWAP 1408/140 ; cout (1000 - 100 VWAP) + (40 VWAP - 408) sur 1408.
+	if vwap := 1408.0 / 140; !near(p.VWAP, vwap) || !near(p.SlippageBps, (592-60*vwap)/1408*1e4) {
+		t.Errorf("VWAP %v, slippage %v bps", p.VWAP, p.SlippageBps)
+	}
+	// P&L : 0, 5 (mid 10.05), 20 (dernier trade 10.20), 17.
+	if !near(rep.MaxDrawdown, 3) {
+		t.Errorf("drawdown max = %v, attendu 3", rep.MaxDrawdown)
+	}
+	var inventory []int64
+	for _, s := range rep.Samples {
+		inventory = append(inventory, s.Inventory[0])
+	}
+	if want := []int64{0, 100, 60, 60}; !reflect.DeepEqual(inventory, want) {
+		t.Errorf("inventaire: %v, attendu %v", inventory, want)
+	}
+}
+
+// TestBacktestCurrency trade SAP en EUR et lit le P&L en USD : la strategie
+// achete 100 @ 10.00 et finit valorisee au mid 10.20, soit +20 EUR.
+func TestBacktestCurrency(t *testing.T) {
+	records, err := ReadReplayCSV(strings.NewReader(`ts,account,symbol,side,price,quantity
+1000000000,M,SAP,SELL,10.00,100
+2000000000,M,SAP,BUY,9.90,10
+3000000000,M,SAP,SELL,10.50,10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &scriptStrategy{}
+	s.onBook = func(c *StrategyContext, symbol string, bid, ask Level) {
+		if ask.Quantity > 0 && len(c.own) == 0 {
+			c.Buy(symbol, ask.Price, 100)
+		}
+	}
+	opts := BacktestOptions{Instruments: []Instrument{{"SAP", "EUR"}}, FX: NewFXRates()}
+	var ve *ValidationError
+	if _, err := Backtest(context.Background(), records, s, opts); !errors.As(err, &ve) || ve.Field != "fx" {
+		t.Fatalf("sans taux EUR/USD: %v", err)
+	}
+	opts.FX.Set("EUR", "USD", 1.1)
+	rep, err := Backtest(context.Background(), records, s, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := rep.Positions[0]
+	if p.Currency != "EUR" || !near(p.PnL, 20) || rep.Currency != "USD" || !near(rep.PnL, 22) {
+		t.Errorf("P&L: position %+v, rapport %v %s", p, rep.PnL, rep.Currency)
+	}
+	if len(s.trades) != 1 || s.trades[0].Currency != "EUR" {
+		t.Errorf("trades: %v", s.trades)
+	}
+}
+
+// TestBacktestStrategies fait tourner les strategies d'exemple sur un flux
+// synthetique : limites de position, coherence du rapport, determinisme.
+func TestBacktestStrategies(t *testing.T) {
+	records := SyntheticFlow(SyntheticOptions{Records: 5000, Seed: 7})
+	if len(records) != 5000 || records[0].Symbol != "SYN" {
+		t.Fatalf("flux synthetique: %d lignes, premier %+v", len(records), records[0])
+	}
+	strategies := map[string]func() Strategy{
+		"mm":       func() Strategy { return &MarketMaker{Symbol: "SYN", Quantity: 50, MaxPosition: 200} },
+		"momentum": func() Strategy { return &Momentum{Symbol: "SYN", Quantity: 50, MaxPosition: 200} },
+	}
+	for name, newStrategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			rep, err := Backtest(context.Background(), records, newStrategy(), BacktestOptions{Sample: 10 * time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Fills == 0 || rep.Filled == 0 || rep.FillRate() > 1 {
+				t.Errorf("executions: %d fills, %d/%d", rep.Fills, rep.Filled, rep.Submitted)
+			}
+			p := rep.Positions[0]
+			if p.Bought-p.Sold != p.Position || !near(p.PnL, rep.PnL) {
+				t.Errorf("position incoherente: %+v (P&L total %v)", p, rep.PnL)
+			}
+			if rep.MaxDrawdown < 0 || len(rep.Samples) < 2 {
+				t.Errorf("drawdown %v, %d echantillons", rep.MaxDrawdown, len(rep.Samples))
+			}
+			for i, s := range rep.Samples {
+				if s.Inventory[0] > 250 || s.Inventory[0] < -250 { // MaxPosition + un ordre
+					t.Errorf("echantillon %d: position %d hors limite", i, s.Inventory[0])
+				}
+				if i > 0 && s.TS-rep.Samples[i-1].TS < int64(10*time.Second) && i < len(rep.Samples)-1 {
+					t.Errorf("echantillons %d et %d trop proches", i-1, i)
+				}
+			}
+
+			again, _ := Backtest(context.Background(), records, newStrategy(), BacktestOptions{Sample: 10 * time.Second})
+			if !reflect.DeepEqual(rep, again) {
+				t.Error("deux runs sur le meme flux different")
+			}
+		})
+	}
+}
+
+// TestBacktestRunaway verifie l'arret d'une strategie qui se traite
+// elle-meme sans fin.
+func TestBacktestRunaway(t *testing.T) {
+	records, _ := ReadReplayCSV(strings.NewReader("ts,symbol,side,price,quantity\n1,AAPL,SELL,10,1\n2,AAPL,BUY,10,1\n"))
+	s := &scriptStrategy{}
+	s.onTrade = func(c *StrategyContext, t Trade) {
+		c.Sell("AAPL", 10, 1)
+		c.Buy("AAPL", 10, 1)
+	}
+	rep, err := Backtest(context.Background(), records, s, BacktestOptions{})
+	if err == nil || !strings.Contains(err.Error(), "ligne 3") {
+		t.Fatalf("attendu une erreur a la ligne 3, obtenu %v", err)
+	}
+	if rep.Records != 2 {
+		t.Errorf("Records = %d, attendu 2", rep.Records)
+	}
+}
@@ -0,0 +1,410 @@
+// bookbuilder.go — Reconstruction des carnets cote client, a partir du flux
+// ITCH (voir itch.go, itchreceiver.go).
+//
+// Le BookBuilder part d'un snapshot (ou du message 1) et applique les
+// messages dans l'ordre strict des numeros :
+//   - AddOrder pose l'ordre en fin de file a son prix ;
+//   - OrderExecuted et OrderCancel baissent sa quantite (0 : retire) ;
+//   - OrderDelete le retire ;
+//   - BookChecksum compare le carnet local au checksum du moteur.
+//
+// Un trou dans les numeros, un ordre inconnu ou un checksum different
+// rendent le carnet local faux : Apply retourne ErrSequenceGap ou
+// ErrBookDiverged et le client repart d'un snapshot.
+//
+//	r := NewITCHReceiver(udpConn, "localhost:30002")
+//	snap, _ := r.Snapshot()
+//	b := NewBookBuilder()
+//	b.LoadSnapshot(snap)
+//	for {
+//		seq, msg, _ := r.Next()
+//		if err := b.Apply(seq, msg); err != nil { ... }
+//		book, _ := b.Book("AAPL")
+//		bid, ask := book.BBO()
+//	}
+//
+// CHECKSUM : CRC32 (IEEE) des checksumDepth meilleurs niveaux de chaque cote
+// (prix en 1/10000, quantite, nombre d'ordres). L'ordre des ordres dans la
+// file d'un niveau n'est pas couvert : le flux publie une hausse de
+// quantite sans perte de priorite (sorties de bracket) en Delete + Add.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"maps"
+	"slices"
+	"sort"
+)
+
+// checksumDepth est le nombre de niveaux par cote couverts par le checksum.
+const checksumDepth = 10
+
+var (
+	// ErrSequenceGap : un message manque entre le dernier applique et celui
+	// recu.
+	ErrSequenceGap = errors.New("trou dans la sequence")
+
+	// ErrBookDiverged : le carnet local ne correspond plus a celui du moteur.
+	ErrBookDiverged = errors.New("carnet local divergent")
+)
+
+// ITCHSnapshot est l'etat de tous les carnets juste avant le message Seq :
+// les ordres au carnet, par symbole puis par cote, dans l'ordre de priorite.
+type ITCHSnapshot struct {
+	Session string
+	Seq     uint64 // Premier message a appliquer apres le snapshot
+	Orders  []ITCHAddOrder
+}
+
+// ---------------------------------------------------------------------------
+// BookBuilder
+// ---------------------------------------------------------------------------
+
+// BookBuilder tient un carnet L3 par symbole. Non sur pour un usage
+// concurrent.
+type BookBuilder struct {
+	Checked int // Checksums verifies sans ecart
+
+	books  map[string]*LocalBook
+	orders map[uint64]*LocalOrder // OrderRef -> ordre au carnet
+	next   uint64
+}
+
+// NewBookBuilder cree un builder vide qui attend le message 1.
+func NewBookBuilder() *BookBuilder {
+	return &BookBuilder{
+		books:  make(map[string]*LocalBook),
+		orders: make(map[uint64]*LocalOrder),
+		next:   1,
+	}
+}
+
+// LoadSnapshot remplace tous les carnets par le snapshot. Apply reprend a
+// s.Seq ; les messages anterieurs sont ignores.
+func (b *BookBuilder) LoadSnapshot(s *ITCHSnapshot) error {
+	clear(b.books)
+	clear(b.orders)
+	b.next = s.Seq
+	for _, o := range s.Orders {
+		if err := b.add(o); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// NextSeq retourne le numero du prochain message attendu.
+func (b *BookBuilder) NextSeq() uint64 {
+	return b.next
+}
+
+// Apply applique le message seq. Un message deja integre (anterieur au
+// snapshot) est ignore. Apres ErrBookDiverged le message est consomme
+// mais le carnet concerne est faux jusqu'au prochain snapshot.
+func (b *BookBuilder) Apply(seq uint64, msg any) error {
+	switch {
+	case seq < b.next:
+		return nil
+	case seq > b.next:
+		return fmt.Errorf("%w: message %d, attendu %d", ErrSequenceGap, seq, b.next)
+	}
+	b.next++
+	if err := b.apply(msg); err != nil {
+		return fmt.Errorf("message %d: %w", seq, err)
+	}
+	return nil
+}
+
+// apply applique un message sans controle de sequence.
+func (b *BookBuilder) apply(msg any) error {
+	switch m := msg.(type) {
+	case ITCHAddOrder:
+		return b.add(m)
+	case ITCHOrderExecuted:
+		return b.reduce(m.OrderRef, m.Shares)
+	case ITCHOrderCancel:
+		return b.reduce(m.OrderRef, m.Shares)
+	case ITCHOrderDelete:
+		o, ok := b.orders[m.OrderRef]
+		if !ok {
+			return fmt.Errorf("%w: Delete de l'ordre inconnu %d", ErrBookDiverged, m.OrderRef)
+		}
+		b.remove(o)
+	case ITCHBookChecksum:
+		got := emptyBookChecksum
+		if book, ok := b.books[m.Symbol]; ok {
+			got = book.Checksum()
+		}
+		if got != m.Checksum {
+			return fmt.Errorf("%w: %s checksum %08x, moteur %08x", ErrBookDiverged, m.Symbol, got, m.Checksum)
+		}
+		b.Checked++
+	}
+	// Trade et BrokenTrade ne touchent pas au carnet.
+	return nil
+}
+
+// add pose un ordre en fin de file a son prix.
+func (b *BookBuilder) add(m ITCHAddOrder) error {
+	if _, dup := b.orders[m.OrderRef]; dup {
+		return fmt.Errorf("%w: AddOrder de l'ordre %d deja au carnet", ErrBookDiverged, m.OrderRef)
+	}
+	if m.Shares <= 0 || (m.Side != Buy && m.Side != Sell) {
+		return fmt.Errorf("%w: AddOrder invalide %+v", ErrBookDiverged, m)
+	}
+	book, ok := b.books[m.Symbol]
+	if !ok {
+		book = &LocalBook{Symbol: m.Symbol}
+		b.books[m.Symbol] = book
+	}
+	o := &LocalOrder{Ref: m.OrderRef, Symbol: m.Symbol, Side: m.Side, Price: m.Price, Shares: m.Shares, Timestamp: m.Timestamp}
+	book.insert(o)
+	b.orders[o.Ref] = o
+	return nil
+}
+
+// reduce baisse la quantite d'un ordre et le retire a 0.
+func (b *BookBuilder) reduce(ref uint64, shares int64) error {
+	o, ok := b.orders[ref]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: ordre inconnu %d", ErrBookDiverged, ref)
+	case shares > o.Shares:
+		return fmt.Errorf("%w: ordre %d reduit de %d, reste %d", ErrBookDiverged, ref, shares, o.Shares)
+	}
+	o.Shares -= shares
+	b.books[o.Symbol].levelOf(o).quantity -= shares
+	if o.Shares == 0 {
+		b.remove(o)
+	}
+	return nil
+}
+
+func (b *BookBuilder) remove(o *LocalOrder) {
+	delete(b.orders, o.Ref)
+	b.books[o.Symbol].delete(o)
+}
+
+// Book retourne le carnet local d'un symbole.
+func (b *BookBuilder) Book(symbol string) (*LocalBook, bool) {
+	book, ok := b.books[symbol]
+	return book, ok
+}
+
+// Symbols retourne les symboles vus, tries.
+func (b *BookBuilder) Symbols() []string {
+	return slices.Sorted(maps.Keys(b.books))
+}
+
+// snapshot retourne les ordres au carnet, symboles tries, bids puis asks,
+// par priorite (voir ITCHSnapshot).
+func (b *BookBuilder) snapshot() []ITCHAddOrder {
+	out := make([]ITCHAddOrder, 0, len(b.orders))
+	for _, symbol := range b.Symbols() {
+		book := b.books[symbol]
+		for _, side := range [][]*localLevel{book.bids, book.asks} {
+			for _, l := range side {
+				for _, o := range l.orders {
+					out = append(out, ITCHAddOrder{o.Timestamp, o.Ref, o.Side, o.Shares, o.Symbol, o.Price})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// LocalBook — carnet L3 d'un symbole
+// ---------------------------------------------------------------------------
+
+// LocalOrder est un ordre du carnet local.
+type LocalOrder struct {
+	Ref       uint64
+	Symbol    string
+	Side      Side
+	Price     float64
+	Shares    int64 // Quantite restante
+	Timestamp int64 // Heure du AddOrder
+}
+
+// localLevel est une file d'ordres a un prix.
+type localLevel struct {
+	price    float64
+	quantity int64
+	orders   []*LocalOrder
+}
+
+// LocalBook est le carnet d'un symbole, meilleur prix en tete de chaque
+// cote. Lecture seule pour l'appelant : seul le BookBuilder le modifie.
+type LocalBook struct {
+	Symbol string
+	bids   []*localLevel
+	asks   []*localLevel
+}
+
+// side retourne les niveaux d'un cote et leur ordre de tri.
+func (lb *LocalBook) side(s Side) (*[]*localLevel, func(a, b float64) bool) {
+	if s == Buy {
+		return &lb.bids, func(a, b float64) bool { return a > b }
+	}
+	return &lb.asks, func(a, b float64) bool { return a < b }
+}
+
+// insert ajoute o en fin de file a son prix, en creant le niveau si besoin.
+func (lb *LocalBook) insert(o *LocalOrder) {
+	levels, better := lb.side(o.Side)
+	i := sort.Search(len(*levels), func(i int) bool { return !better((*levels)[i].price, o.Price) })
+	if i == len(*levels) || (*levels)[i].price != o.Price {
+		*levels = slices.Insert(*levels, i, &localLevel{price: o.Price})
+	}
+	l := (*levels)[i]
+	l.orders = append(l.orders, o)
+	l.quantity += o.Shares
+}
+
+// levelOf retourne le niveau d'un ordre du carnet.
+func (lb *LocalBook) levelOf(o *LocalOrder) *localLevel {
+	levels, better := lb.side(o.Side)
+	i := sort.Search(len(*levels), func(i int) bool { return !better((*levels)[i].price, o.Price) })
+	return (*levels)[i]
+}
+
+// delete retire o de sa file, et le niveau s'il devient vide.
+func (lb *LocalBook) delete(o *LocalOrder) {
+	levels, better := lb.side(o.Side)
+	i := sort.Search(len(*levels), func(i int) bool { return !better((*levels)[i].price, o.Price) })
+	l := (*levels)[i]
+	l.orders = slices.DeleteFunc(l.orders, func(x *LocalOrder) bool { return x == o })
+	l.quantity -= o.Shares
+	if len(l.orders) == 0 {
+		*levels = slices.Delete(*levels, i, i+1)
+	}
+}
+
+// BestBid retourne le meilleur prix d'achat.
+func (lb *LocalBook) BestBid() (float64, bool) {
+	if len(lb.bids) == 0 {
+		return 0, false
+	}
+	return lb.bids[0].price, true
+}
+
+// BestAsk retourne le meilleur prix de vente.
+func (lb *LocalBook) BestAsk() (float64, bool) {
+	if len(lb.asks) == 0 {
+		return 0, false
+	}
+	return lb.asks[0].price, true
+}
+
+// BBO retourne le meilleur niveau de chaque cote (Level vide si le cote
+// est vide).
+func (lb *LocalBook) BBO() (bid, ask Level) {
+	if len(lb.bids) > 0 {
+		bid = lb.bids[0].level()
+	}
+	if len(lb.asks) > 0 {
+		ask = lb.asks[0].level()
+	}
+	return bid, ask
+}
+
+// Spread retourne l'ecart entre meilleure offre et meilleure demande.
+func (lb *LocalBook) Spread() (float64, bool) {
+	bid, okBid := lb.BestBid()
+	ask, okAsk := lb.BestAsk()
+	if !okBid || !okAsk {
+		return 0, false
+	}
+	return ask - bid, true
+}
+
+// Depth retourne le nombre d'ordres de chaque cote.
+func (lb *LocalBook) Depth() (bidCount, askCount int) {
+	for _, l := range lb.bids {
+		bidCount += len(l.orders)
+	}
+	for _, l := range lb.asks {
+		askCount += len(l.orders)
+	}
+	return
+}
+
+// Levels retourne les depth meilleurs niveaux de chaque cote (L2).
+// depth <= 0 : tous les niveaux.
+func (lb *LocalBook) Levels(depth int) (bids, asks []Level) {
+	return localLevels(lb.bids, depth), localLevels(lb.asks, depth)
+}
+
+func localLevels(levels []*localLevel, depth int) []Level {
+	if depth > 0 && len(levels) > depth {
+		levels = levels[:depth]
+	}
+	out := make([]Level, len(levels))
+	for i, l := range levels {
+		out[i] = l.level()
+	}
+	return out
+}
+
+func (l *localLevel) level() Level {
+	return Level{Price: l.price, Quantity: l.quantity, Orders: len(l.orders)}
+}
+
+// Orders retourne les ordres d'un cote par priorite (L3) : meilleur prix
+// d'abord, puis ordre d'arrivee. Ce sont des copies.
+func (lb *LocalBook) Orders(side Side) []LocalOrder {
+	levels, _ := lb.side(side)
+	var out []LocalOrder
+	for _, l := range *levels {
+		for _, o := range l.orders {
+			out = append(out, *o)
+		}
+	}
+	return out
+}
+
+// Checksum retourne le checksum du carnet local (voir bookChecksum).
+func (lb *LocalBook) Checksum() uint32 {
+	bids, asks := lb.Levels(checksumDepth)
+	return bookChecksum(bids, asks)
+}
+
+// ---------------------------------------------------------------------------
+// Checksum
+// ---------------------------------------------------------------------------
+
+// emptyBookChecksum est le checksum d'un carnet vide.
+var emptyBookChecksum = bookChecksum(nil, nil)
+
+// bookChecksum calcule le CRC32 des niveaux : pour chaque cote, un octet
+// 'B' ou 'S' puis, par niveau, [prix u64 en 1/10000][quantite u64][ordres u32].
+// Les niveaux sont passes meilleur prix en tete, tronques a checksumDepth.
+func bookChecksum(bids, asks []Level) uint32 {
+	buf := make([]byte, 0, 2+(len(bids)+len(asks))*20)
+	for i, side := range [][]Level{bids, asks} {
+		buf = append(buf, "BS"[i])
+		for _, l := range side[:min(len(side), checksumDepth)] {
+			buf = binary.BigEndian.AppendUint64(buf, ouchPrice(l.Price))
+			buf = binary.BigEndian.AppendUint64(buf, uint64(l.Quantity))
+			buf = binary.BigEndian.AppendUint32(buf, uint32(l.Orders))
+		}
+	}
+	return crc32.ChecksumIEEE(buf)
+}
+
+// bookChecksums appelle fn avec le checksum de chaque carnet du moteur,
+// symboles tries. Le tout sous gw.mu : les checksums correspondent
+// exactement aux Events deja publies. fn ne doit pas rappeler le Gateway.
+func (gw *Gateway) bookChecksums(fn func(symbol string, sum uint32)) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	for _, symbol := range slices.Sorted(maps.Keys(gw.books)) {
+		bids, asks := gw.books[symbol].Levels(checksumDepth)
+		fn(symbol, bookChecksum(bids, asks))
+	}
+}
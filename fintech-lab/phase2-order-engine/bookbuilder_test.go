@@ -0,0 +1,242 @@
+// bookbuilder_test.go — Tests de la reconstruction des carnets cote client.
+// Lancer avec : go test ./phase2-order-engine/ -run BookBuilder -v
+
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+// TestBookBuilder verifie l'application des messages, les requetes et les
+// controles de sequence et de checksum.
+func TestBookBuilder(t *testing.T) {
+	b := NewBookBuilder()
+	msgs := []any{
+		ITCHAddOrder{OrderRef: 1, Side: Buy, Shares: 100, Symbol: "AAPL", Price: 99.5},
+		ITCHAddOrder{OrderRef: 2, Side: Buy, Shares: 50, Symbol: "AAPL", Price: 100},
+		ITCHAddOrder{OrderRef: 3, Side: Buy, Shares: 70, Symbol: "AAPL", Price: 99.5},
+		ITCHAddOrder{OrderRef: 4, Side: Sell, Shares: 30, Symbol: "AAPL", Price: 101},
+		ITCHAddOrder{OrderRef: 5, Side: Sell, Shares: 10, Symbol: "MSFT", Price: 400},
+		ITCHOrderExecuted{OrderRef: 2, Shares: 50, MatchNumber: 1}, // Niveau 100 vide
+		ITCHOrderCancel{OrderRef: 1, Shares: 40},
+		ITCHOrderDelete{OrderRef: 5},
+		ITCHTrade{Symbol: "AAPL", Shares: 50, Price: 100, MatchNumber: 1},
+	}
+	for i, m := range msgs {
+		if err := b.Apply(uint64(i+1), m); err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+
+	book, ok := b.Book("AAPL")
+	if !ok {
+		t.Fatal("carnet AAPL absent")
+	}
+	bid, ask := book.BBO()
+	if want := (Level{Price: 99.5, Quantity: 130, Orders: 2}); bid != want {
+		t.Errorf("bid: %+v, attendu %+v", bid, want)
+	}
+	if want := (Level{Price: 101, Quantity: 30, Orders: 1}); ask != want {
+		t.Errorf("ask: %+v, attendu %+v", ask, want)
+	}
+	if spread, ok := book.Spread(); !ok || spread != 1.5 {
+		t.Errorf("spread: %v %v, attendu 1.5", spread, ok)
+	}
+	if bids, asks := book.Depth(); bids != 2 || asks != 1 {
+		t.Errorf("depth: %d/%d, attendu 2/1", bids, asks)
+	}
+	var refs []uint64
+	for _, o := range book.Orders(Buy) {
+		refs = append(refs, o.Ref)
+	}
+	if want := []uint64{1, 3}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("file des bids: %v, attendu %v (priorite gardee apres Cancel)", refs, want)
+	}
+	if msft, _ := b.Book("MSFT"); msft == nil || len(msft.Orders(Sell)) != 0 {
+		t.Errorf("MSFT: attendu carnet vide")
+	}
+
+	// Checksum juste puis faux.
+	next := uint64(len(msgs) + 1)
+	sum := book.Checksum()
+	if err := b.Apply(next, ITCHBookChecksum{Symbol: "AAPL", Checksum: sum}); err != nil || b.Checked != 1 {
+		t.Fatalf("checksum: %v (Checked = %d)", err, b.Checked)
+	}
+	next++
+	if err := b.Apply(next, ITCHBookChecksum{Symbol: "TSLA", Checksum: emptyBookChecksum}); err != nil {
+		t.Errorf("checksum d'un carnet jamais vu: %v", err)
+	}
+	next++
+	if err := b.Apply(next, ITCHBookChecksum{Symbol: "AAPL", Checksum: sum + 1}); !errors.Is(err, ErrBookDiverged) {
+		t.Errorf("checksum faux: attendu ErrBookDiverged, obtenu %v", err)
+	}
+	next++
+
+	// Sequence : doublon ignore, trou refuse.
+	if err := b.Apply(1, msgs[0]); err != nil {
+		t.Errorf("doublon: %v", err)
+	}
+	if err := b.Apply(next+1, ITCHOrderDelete{OrderRef: 3}); !errors.Is(err, ErrSequenceGap) {
+		t.Errorf("trou: attendu ErrSequenceGap, obtenu %v", err)
+	}
+	if err := b.Apply(next, ITCHOrderDelete{OrderRef: 42}); !errors.Is(err, ErrBookDiverged) {
+		t.Errorf("ordre inconnu: attendu ErrBookDiverged, obtenu %v", err)
+	}
+	if err := b.Apply(next+1, ITCHOrderExecuted{OrderRef: 4, Shares: 31}); !errors.Is(err, ErrBookDiverged) {
+		t.Errorf("execution au-dela du reste: attendu ErrBookDiverged, obtenu %v", err)
+	}
+}
+
+// randomFlow soumet n operations pseudo-aleatoires (limites, marches,
+// modifications, annulations) sur AAPL et MSFT. Retourne les ordres
+// soumis.
+func randomFlow(t *testing.T, gw *Gateway, rng *rand.Rand, n int, orders []*Order) []*Order {
+	t.Helper()
+	symbols := []string{"AAPL", "MSFT"}
+	for range n {
+		symbol := symbols[rng.IntN(len(symbols))]
+		side := Buy
+		if rng.IntN(2) == 1 {
+			side = Sell
+		}
+		price := 100 + float64(rng.IntN(11)-5)*0.25
+		qty := int64(1 + rng.IntN(20)*10)
+		switch r := rng.IntN(10); {
+		case r < 6 || len(orders) == 0:
+			o := NewLimitOrder(symbol, side, price, qty)
+			gw.Submit(o)
+			orders = append(orders, o)
+		case r == 6:
+			gw.Submit(NewMarketOrder(symbol, side, qty))
+		case r == 7:
+			o := orders[rng.IntN(len(orders))]
+			gw.Amend(o.Symbol, o.ID, price, 0) // Erreur attendue si l'ordre n'est plus au carnet
+		case r == 8:
+			o := orders[rng.IntN(len(orders))]
+			gw.Amend(o.Symbol, o.ID, 0, o.Filled+qty/2+1)
+		default:
+			o := orders[rng.IntN(len(orders))]
+			gw.Cancel(o.Symbol, o.ID)
+		}
+	}
+	return orders
+}
+
+// applyFeed lit le flux jusqu'a la fin de session et l'applique a b.
+func applyFeed(t *testing.T, r *ITCHReceiver, b *BookBuilder) {
+	t.Helper()
+	for _, m := range readFeed(t, r) {
+		if err := b.Apply(m.seq, m.msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type feedMessage struct {
+	seq uint64
+	msg any
+}
+
+// readFeed lit les messages jusqu'a la fin de session.
+func readFeed(t *testing.T, r *ITCHReceiver) []feedMessage {
+	t.Helper()
+	var out []feedMessage
+	for {
+		seq, msg, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		out = append(out, feedMessage{seq, msg})
+	}
+}
+
+// checkBooks compare les carnets reconstruits a ceux du moteur.
+func checkBooks(t *testing.T, gw *Gateway, b *BookBuilder) {
+	t.Helper()
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		ob, _ := gw.Book(symbol)
+		wantBids, wantAsks := ob.Levels(0)
+		var gotBids, gotAsks []Level
+		if lb, ok := b.Book(symbol); ok {
+			gotBids, gotAsks = lb.Levels(0)
+		}
+		if !reflect.DeepEqual(gotBids, wantBids) && len(gotBids)+len(wantBids) > 0 {
+			t.Errorf("%s bids: %+v, moteur %+v", symbol, gotBids, wantBids)
+		}
+		if !reflect.DeepEqual(gotAsks, wantAsks) && len(gotAsks)+len(wantAsks) > 0 {
+			t.Errorf("%s asks: %+v, moteur %+v", symbol, gotAsks, wantAsks)
+		}
+	}
+}
+
+// TestBookBuilderFeed reconstruit les carnets d'un flux aleatoire et
+// verifie chaque checksum publie par le moteur.
+func TestBookBuilderFeed(t *testing.T) {
+	gw, _ := newTestGateway()
+	pub, r := startITCHFeed(t, gw, ITCHOptions{Checksum: -1})
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	var orders []*Order
+	for range 20 {
+		orders = randomFlow(t, gw, rng, 50, orders)
+		pub.publishChecksums()
+	}
+	pub.EndSession()
+
+	b := NewBookBuilder()
+	applyFeed(t, r, b)
+	if b.Checked != 40 {
+		t.Errorf("Checked = %d, attendu 40 (20 tours x 2 symboles)", b.Checked)
+	}
+	if pub.diverged != 0 {
+		t.Errorf("le publisher a vu %d divergences", pub.diverged)
+	}
+	checkBooks(t, gw, b)
+}
+
+// TestBookBuilderSnapshot verifie l'arrivee en cours de session : snapshot,
+// puis flux incremental a partir du message suivant.
+func TestBookBuilderSnapshot(t *testing.T) {
+	gw, _ := newTestGateway()
+	pub, r := startITCHFeed(t, gw, ITCHOptions{Checksum: -1})
+	rng := rand.New(rand.NewPCG(3, 4))
+
+	orders := randomFlow(t, gw, rng, 300, nil)
+	snap, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Session != "TEST" || snap.Seq < 2 || len(snap.Orders) == 0 {
+		t.Fatalf("snapshot: session %q, seq %d, %d ordres", snap.Session, snap.Seq, len(snap.Orders))
+	}
+	b := NewBookBuilder()
+	if err := b.LoadSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	checkBooks(t, gw, b)
+
+	randomFlow(t, gw, rng, 300, orders)
+	pub.publishChecksums()
+	pub.EndSession()
+
+	msgs := readFeed(t, r)
+	if len(msgs) == 0 || msgs[0].seq != snap.Seq {
+		t.Fatalf("premier message apres le snapshot: %+v, attendu seq %d", msgs[:min(len(msgs), 1)], snap.Seq)
+	}
+	for _, m := range msgs {
+		if err := b.Apply(m.seq, m.msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Checked != 2 {
+		t.Errorf("Checked = %d, attendu 2", b.Checked)
+	}
+	checkBooks(t, gw, b)
+}
@@ -0,0 +1,282 @@
+// clearing.go — Clearing de fin de journee : netting des trades par compte et
+// par symbole en une obligation de reglement unique, et fichier pour le back office.
+//
+// Convention de signe (point de vue du compte) :
+//   NetQty  > 0 : titres a recevoir     NetQty  < 0 : titres a livrer
+//   NetCash > 0 : cash a recevoir       NetCash < 0 : cash a payer
+//
+// Le cash est dans la devise du symbole : les totaux entre symboles sont
+// donnes par devise, jamais additionnes d'une devise a l'autre.
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// ErrUnbalanced signale un clearing ou achats et ventes ne s'equilibrent pas.
+var ErrUnbalanced = errors.New("clearing desequilibre")
+
+// FeeSchedule definit les frais factures a CHAQUE cote d'un trade.
+type FeeSchedule struct {
+	PerShare float64 // Par action executee
+	BPS      float64 // Points de base du notionnel (1 bp = 0.01%)
+	Min      float64 // Minimum par trade et par cote
+}
+
+// Fee retourne les frais d'un cote du trade.
+func (f FeeSchedule) Fee(t Trade) float64 {
+	fee := f.PerShare*float64(t.Quantity) + f.BPS/10_000*t.Notional()
+	return math.Max(fee, f.Min)
+}
+
+// Obligation est la position nette de reglement d'un compte sur un symbole.
+type Obligation struct {
+	Account   string
+	Symbol    string
+	Currency  string // Devise du symbole : celle de GrossCash, Fees et NetCash
+	Bought    int64
+	Sold      int64
+	NetQty    int64   // Bought - Sold
+	GrossCash float64 // Notionnel vendu - notionnel achete
+	Fees      float64
+	NetCash   float64 // GrossCash - Fees
+	Trades    int
+}
+
+// SymbolBalance reconcilie un symbole : les executions du TradeLog contre
+// les quantites executees que portent les ordres eux-memes (Filled), deux
+// sources tenues separement par le moteur. Chaque action achetee doit aussi
+// avoir ete vendue, et le cash echange s'annuler.
+type SymbolBalance struct {
+	Symbol     string
+	Bought     int64
+	Sold       int64
+	Cash       float64 // Somme des GrossCash : doit etre ~0
+	Executed   int64   // Executions au TradeLog, busts compris, corrections exclues
+	BuyFilled  int64   // Filled des ordres d'achat, ordres liberes (Release) compris
+	SellFilled int64   // Idem a la vente
+	Balanced   bool
+}
+
+// ClearingReport est le resultat du clearing d'une session.
+type ClearingReport struct {
+	Timestamp   int64        // Unix nanoseconds du clearing
+	Obligations []Obligation // Triees par compte puis symbole
+	Balances    []SymbolBalance
+	Fees        map[string]float64 // Frais totaux (deux cotes) par devise
+}
+
+// Balanced indique si tous les symboles s'equilibrent.
+func (r *ClearingReport) Balanced() bool {
+	for _, b := range r.Balances {
+		if !b.Balanced {
+			return false
+		}
+	}
+	return true
+}
+
+// cashEpsilon absorbe les erreurs d'arrondi float64 (voir data_structures.txt).
+const cashEpsilon = 1e-6
+
+// Clear nette tous les trades vivants du TradeLog (busts exclus, corrections
+// incluses). Le compte de chaque cote vient de l'index d'ordres du Gateway.
+// Retourne le rapport, et ErrUnbalanced (wrappe) si un symbole ne s'equilibre
+// pas : executions du TradeLog et Filled des ordres en desaccord (trade sans
+// ordre, fill perdu...), voir SymbolBalance.
+func (gw *Gateway) Clear(fees FeeSchedule) (*ClearingReport, error) {
+	if gw.log == nil {
+		return nil, fmt.Errorf("clearing impossible: Gateway sans TradeLog")
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	type key struct{ account, symbol string }
+	positions := make(map[key]*Obligation)
+	position := func(account string, t Trade) *Obligation {
+		k := key{account, t.Symbol}
+		ob, ok := positions[k]
+		if !ok {
+			ob = &Obligation{Account: account, Symbol: t.Symbol, Currency: t.Currency}
+			positions[k] = ob
+		}
+		return ob
+	}
+	balances := make(map[string]*SymbolBalance)
+	balance := func(symbol string) *SymbolBalance {
+		b, ok := balances[symbol]
+		if !ok {
+			b = &SymbolBalance{Symbol: symbol}
+			balances[symbol] = b
+		}
+		return b
+	}
+
+	// Cote ordres : un bust ne rend pas la quantite aux ordres, une
+	// correction n'execute rien (voir invariantViolations).
+	for symbol, r := range gw.retired {
+		b := balance(symbol)
+		b.BuyFilled, b.SellFilled = r[0], r[1]
+	}
+	for _, o := range gw.orders {
+		if o.Filled == 0 {
+			continue
+		}
+		b := balance(o.Symbol)
+		if o.Side == Buy {
+			b.BuyFilled += o.Filled
+		} else {
+			b.SellFilled += o.Filled
+		}
+	}
+
+	report := &ClearingReport{Timestamp: gw.clock.Now(), Fees: make(map[string]float64)}
+	for _, t := range gw.log.Trades() {
+		if t.Corrects == 0 {
+			balance(t.Symbol).Executed += t.Quantity
+		}
+		if !t.IsLive() {
+			continue
+		}
+		fee := fees.Fee(t)
+
+		buyer := position(gw.accountOf(t.BuyOrderID), t)
+		buyer.Bought += t.Quantity
+		buyer.GrossCash -= t.Notional()
+		buyer.Fees += fee
+		buyer.Trades++
+
+		seller := position(gw.accountOf(t.SellOrderID), t)
+		seller.Sold += t.Quantity
+		seller.GrossCash += t.Notional()
+		seller.Fees += fee
+		seller.Trades++
+
+		report.Fees[t.Currency] += 2 * fee
+	}
+
+	for _, ob := range positions {
+		ob.NetQty = ob.Bought - ob.Sold
+		ob.NetCash = ob.GrossCash - ob.Fees
+		report.Obligations = append(report.Obligations, *ob)
+
+		b := balance(ob.Symbol)
+		b.Bought += ob.Bought
+		b.Sold += ob.Sold
+		b.Cash += ob.GrossCash
+	}
+	sort.Slice(report.Obligations, func(i, j int) bool {
+		a, b := report.Obligations[i], report.Obligations[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.Symbol < b.Symbol
+	})
+
+	var unbalanced []string
+	for _, b := range balances {
+		b.Balanced = b.Bought == b.Sold && math.Abs(b.Cash) < cashEpsilon &&
+			b.BuyFilled == b.Executed && b.SellFilled == b.Executed
+		if !b.Balanced {
+			unbalanced = append(unbalanced, b.Symbol)
+		}
+		report.Balances = append(report.Balances, *b)
+	}
+	sort.Slice(report.Balances, func(i, j int) bool { return report.Balances[i].Symbol < report.Balances[j].Symbol })
+
+	if len(unbalanced) > 0 {
+		sort.Strings(unbalanced)
+		return report, fmt.Errorf("%w: %v", ErrUnbalanced, unbalanced)
+	}
+	return report, nil
+}
+
+// accountOf retourne le compte d'un ordre indexe ou oublie par Release.
+// Appele avec gw.mu tenu.
+func (gw *Gateway) accountOf(orderID uint64) string {
+	if o, ok := gw.orders[orderID]; ok {
+		return o.Account
+	}
+	return gw.owners[orderID]
+}
+
+// NetCash retourne le cash net d'un compte, tous symboles confondus, par
+// devise.
+func (r *ClearingReport) NetCash(account string) map[string]float64 {
+	out := make(map[string]float64)
+	for _, ob := range r.Obligations {
+		if ob.Account == account {
+			out[ob.Currency] += ob.NetCash
+		}
+	}
+	return out
+}
+
+// WriteCSV ecrit le fichier de reglement : une ligne par obligation.
+func (r *ClearingReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"account", "symbol", "currency", "bought", "sold", "net_qty", "gross_cash", "fees", "net_cash", "trades"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	for _, ob := range r.Obligations {
+		row := []string{
+			ob.Account,
+			ob.Symbol,
+			ob.Currency,
+			strconv.FormatInt(ob.Bought, 10),
+			strconv.FormatInt(ob.Sold, 10),
+			strconv.FormatInt(ob.NetQty, 10),
+			money(ob.GrossCash),
+			money(ob.Fees),
+			money(ob.NetCash),
+			strconv.Itoa(ob.Trades),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// PrintReport affiche le clearing de la session.
+func (r *ClearingReport) PrintReport() {
+	fmt.Println("=== CLEARING / NETTING ===")
+	var accounts []string
+	for _, ob := range r.Obligations {
+		fmt.Printf("  %-10s %-5s net=%+6d cash=%+12.2f fees=%8.2f %s\n",
+			ob.Account, ob.Symbol, ob.NetQty, ob.NetCash, ob.Fees, ob.Currency)
+		if len(accounts) == 0 || accounts[len(accounts)-1] != ob.Account {
+			accounts = append(accounts, ob.Account) // Obligations triees par compte
+		}
+	}
+	for _, account := range accounts {
+		cash := r.NetCash(account)
+		for _, ccy := range slices.Sorted(maps.Keys(cash)) {
+			fmt.Printf("  %-10s cash net : %+.2f %s\n", account, cash[ccy], ccy)
+		}
+	}
+	for _, b := range r.Balances {
+		status := "OK"
+		if !b.Balanced {
+			status = "DESEQUILIBRE"
+		}
+		fmt.Printf("  %-5s achats=%d ventes=%d executes=%d (ordres %d/%d) : %s\n",
+			b.Symbol, b.Bought, b.Sold, b.Executed, b.BuyFilled, b.SellFilled, status)
+	}
+	for _, ccy := range slices.Sorted(maps.Keys(r.Fees)) {
+		fmt.Printf("  Frais totaux : %.2f %s\n", r.Fees[ccy], ccy)
+	}
+}
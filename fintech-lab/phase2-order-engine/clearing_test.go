@@ -0,0 +1,107 @@
+// clearing_test.go — Tests du clearing et du netting de fin de journee.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"math"
+	"strings"
+	"testing"
+)
+
+// TestClearingNetting verifie le netting par compte/symbole et l'equilibre.
+func TestClearingNetting(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	mustSubmit(t, gw, newAccountOrder("ACC-A", "AAPL", Sell, 190.00, 100))
+	mustSubmit(t, gw, newAccountOrder("ACC-B", "AAPL", Buy, 190.00, 100))
+	mustSubmit(t, gw, newAccountOrder("ACC-B", "AAPL", Sell, 191.00, 40))
+	mustSubmit(t, gw, newAccountOrder("ACC-A", "AAPL", Buy, 191.00, 40))
+	mustSubmit(t, gw, newAccountOrder("ACC-B", "MSFT", Buy, 400.00, 10))
+	msft := mustSubmit(t, gw, newAccountOrder("ACC-A", "MSFT", Sell, 400.00, 10))
+	if err := gw.BustTrade(msft[0].ID, "ops", "erreur de saisie"); err != nil {
+		t.Fatalf("BustTrade: %v", err)
+	}
+
+	report, err := gw.Clear(FeeSchedule{PerShare: 0.01})
+	if err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if !report.Balanced() {
+		t.Fatal("rapport desequilibre")
+	}
+	if len(report.Obligations) != 2 {
+		t.Fatalf("attendu 2 obligations (MSFT busted), obtenu %+v", report.Obligations)
+	}
+
+	a := report.Obligations[0]
+	if a.Account != "ACC-A" || a.NetQty != -60 {
+		t.Errorf("ACC-A: attendu net -60, obtenu %+v", a)
+	}
+	// Vend 100 @ 190 (+19000), achete 40 @ 191 (-7640), frais 140 x 0.01
+	if a.GrossCash != 11360 || a.Fees != 1.40 || a.NetCash != 11358.60 {
+		t.Errorf("ACC-A cash inattendu: %+v", a)
+	}
+	b := report.Obligations[1]
+	if b.NetQty != 60 || b.GrossCash != -11360 {
+		t.Errorf("ACC-B inattendu: %+v", b)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[1] != "ACC-A,AAPL,USD,40,100,-60,11360.00,1.40,11358.60,2" {
+		t.Errorf("fichier de reglement inattendu:\n%s", buf.String())
+	}
+}
+
+// TestClearingUnbalanced ajoute au TradeLog un trade qu'aucun ordre n'a
+// execute : la reconciliation avec les Filled des ordres le detecte.
+func TestClearingUnbalanced(t *testing.T) {
+	gw, log := newTestGateway()
+	sell := newAccountOrder("ACC-A", "AAPL", Sell, 190.00, 100)
+	buy := newAccountOrder("ACC-B", "AAPL", Buy, 190.00, 100)
+	mustSubmit(t, gw, sell)
+	mustSubmit(t, gw, buy)
+	if _, err := gw.Clear(FeeSchedule{}); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	log.Add(Trade{ID: 999, Symbol: "AAPL", BuyOrderID: buy.ID, SellOrderID: sell.ID, Price: 190, Quantity: 5, Status: TradeActive})
+	report, err := gw.Clear(FeeSchedule{})
+	if !errors.Is(err, ErrUnbalanced) || report.Balanced() {
+		t.Fatalf("trade fantome non detecte: %v", err)
+	}
+	if b := report.Balances[0]; b.Executed != 105 || b.BuyFilled != 100 || b.SellFilled != 100 || b.Bought != b.Sold {
+		t.Errorf("balance: %+v", b)
+	}
+}
+
+// TestClearingFeesByCurrency verifie que les frais ne melangent pas les
+// devises.
+func TestClearingFeesByCurrency(t *testing.T) {
+	gw := NewGatewayWithInstruments([]Instrument{{"AAPL", "USD"}, {"SAP", "EUR"}}, NewTradeLog(), SystemClock{})
+	for _, symbol := range []string{"AAPL", "SAP"} {
+		mustSubmit(t, gw, newAccountOrder("ACC-A", symbol, Sell, 100.00, 10))
+		mustSubmit(t, gw, newAccountOrder("ACC-B", symbol, Buy, 100.00, 10))
+	}
+	report, err := gw.Clear(FeeSchedule{PerShare: 0.01})
+	if err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if want := map[string]float64{"USD": 0.2, "EUR": 0.2}; !maps.Equal(report.Fees, want) {
+		t.Errorf("frais: %v, attendu %v", report.Fees, want)
+	}
+	if sap := report.Obligations[1]; sap.Symbol != "SAP" || sap.Currency != "EUR" {
+		t.Errorf("obligation SAP: %+v", sap)
+	}
+	if want := map[string]float64{"USD": 999.9, "EUR": 999.9}; !maps.EqualFunc(report.NetCash("ACC-A"), want, func(a, b float64) bool {
+		return math.Abs(a-b) < cashEpsilon
+	}) {
+		t.Errorf("cash net ACC-A: %v, attendu %v", report.NetCash("ACC-A"), want)
+	}
+}
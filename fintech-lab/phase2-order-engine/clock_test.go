@@ -0,0 +1,114 @@
+// clock_test.go — Tests de l'horloge injectable et des IDs par moteur.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Clock|Sequencer' -v
+
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+var simStart = time.Date(2026, 1, 5, 14, 30, 0, 0, time.UTC)
+
+// simSession joue une petite session sur un Gateway pilote par une SimClock.
+func simSession(t *testing.T) (*Gateway, *SimClock, []Trade) {
+	t.Helper()
+	clock := NewSimClock(simStart, 0)
+	gw := NewGatewayWithClock([]string{"AAPL"}, NewTradeLog(), clock)
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	clock.Advance(time.Second)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.50, 100))
+	clock.Advance(time.Minute)
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 191.00, 150))
+	return gw, clock, trades
+}
+
+// TestSequencerPerGateway verifie que deux moteurs ont des IDs independants.
+func TestSequencerPerGateway(t *testing.T) {
+	a, _ := newTestGateway()
+	b, _ := newTestGateway()
+
+	oa := NewLimitOrder("AAPL", Buy, 189.00, 10)
+	mustSubmit(t, a, oa)
+	mustSubmit(t, a, NewLimitOrder("AAPL", Buy, 189.00, 10))
+	ob := NewLimitOrder("AAPL", Buy, 189.00, 10)
+	mustSubmit(t, b, ob)
+
+	if oa.ID != 1 || ob.ID != 1 {
+		t.Errorf("chaque moteur doit numeroter depuis 1: obtenu #%d et #%d", oa.ID, ob.ID)
+	}
+}
+
+// TestSimClockDeterministic verifie qu'une session rejouee est identique.
+func TestSimClockDeterministic(t *testing.T) {
+	_, _, first := simSession(t)
+	_, _, second := simSession(t)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("sessions differentes:\n%v\n%v", first, second)
+	}
+	if first[0].ID != 1 || first[1].ID != 2 {
+		t.Errorf("IDs de trades attendus 1 et 2, obtenu %d et %d", first[0].ID, first[1].ID)
+	}
+}
+
+// TestTradeTimestampIsMatchTime verifie qu'un trade porte l'heure du match,
+// pas celle de la reception de l'ordre : un stop recu tot s'execute tard.
+func TestTradeTimestampIsMatchTime(t *testing.T) {
+	gw, clock := newSimGateway()
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 188.00, 100))
+	stop := NewStopOrder("AAPL", Sell, 189.00, 100)
+	mustSubmit(t, gw, stop)
+
+	clock.Advance(5 * time.Minute)
+	match := clock.Now()
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 189.00, 10))
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 189.00, 10))
+
+	if len(trades) != 2 || trades[1].SellOrderID != stop.ID {
+		t.Fatalf("attendu declencheur + stop, obtenu %v", trades)
+	}
+	if trades[1].Timestamp != match {
+		t.Errorf("trade du stop: attendu %d (match), obtenu %d (reception du stop: %d)",
+			match, trades[1].Timestamp, stop.Timestamp)
+	}
+}
+
+func newSimGateway() (*Gateway, *SimClock) {
+	clock := NewSimClock(simStart, 0)
+	return NewGatewayWithClock([]string{"AAPL", "MSFT"}, NewTradeLog(), clock), clock
+}
+
+// TestFIFOWithFrozenClock verifie la priorite temps quand l'horloge ne
+// bouge pas (SimClock a pas nul) : meme Timestamp pour tous, les ordres
+// restent servis dans leur ordre d'arrivee, amend compris.
+func TestFIFOWithFrozenClock(t *testing.T) {
+	gw, _ := newSimGateway()
+	var bids []*Order
+	for i := 0; i < 8; i++ {
+		o := NewLimitOrder("AAPL", Buy, 100, 10)
+		mustSubmit(t, gw, o)
+		bids = append(bids, o)
+	}
+	// Hausse de quantite : le premier perd sa priorite et passe en dernier.
+	if _, err := gw.Amend("AAPL", bids[0].ID, 100, 20); err != nil {
+		t.Fatal(err)
+	}
+	want := append(slices.Clone(bids[1:]), bids[0])
+
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 100, 90))
+	if len(trades) != len(want) {
+		t.Fatalf("%d trades, attendu %d", len(trades), len(want))
+	}
+	for i, tr := range trades {
+		if tr.Timestamp != simStart.UnixNano() {
+			t.Fatalf("horloge pas figee: %d", tr.Timestamp)
+		}
+		if tr.BuyOrderID != want[i].ID {
+			t.Errorf("fill %d contre #%d, attendu #%d", i, tr.BuyOrderID, want[i].ID)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// clordid.go — Identifiants client (ClOrdID) et consultation des ordres.
+//
+// Le client nomme ses ordres avec son propre ClOrdID, unique par compte :
+// il peut ensuite consulter ou annuler sans connaitre l'ID interne ni le
+// symbole. Un ClOrdID deja utilise par le compte est rejete ; celui d'un
+// ordre rejete est libere (le client peut corriger et resoumettre).
+
+package main
+
+import (
+	"fmt"
+)
+
+// maxClOrdIDLen borne la taille d'un ClOrdID (FIX : champ texte court).
+const maxClOrdIDLen = 64
+
+// clOrdKey identifie un ClOrdID dans l'espace de son compte.
+type clOrdKey struct {
+	account string
+	clOrdID string
+}
+
+// Fill est une execution d'un ordre.
+type Fill struct {
+	TradeID   uint64  `json:"trade_id"`
+	ContraID  uint64  `json:"contra_id"` // Ordre de l'autre cote
+	Price     float64 `json:"price"`
+	Quantity  int64   `json:"quantity"`
+	Timestamp int64   `json:"ts"` // Unix nanoseconds
+}
+
+// OrderState est une photo d'un ordre : etat courant, executions et
+// historique complet de ses evenements. L'Order est une copie.
+type OrderState struct {
+	Order   Order
+	Fills   []Fill
+	History []Event
+}
+
+// ---------------------------------------------------------------------------
+// API publique du Gateway
+// ---------------------------------------------------------------------------
+
+// Order retourne l'etat d'un ordre par ID interne (rejetes compris).
+func (gw *Gateway) Order(id uint64) (OrderState, bool) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.orderState(id)
+}
+
+// OrderByClOrdID retourne l'etat d'un ordre par ClOrdID client.
+func (gw *Gateway) OrderByClOrdID(account, clOrdID string) (OrderState, bool) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	id, ok := gw.clOrd[clOrdKey{account, clOrdID}]
+	if !ok {
+		return OrderState{}, false
+	}
+	return gw.orderState(id)
+}
+
+// CancelByClOrdID annule un ordre par ClOrdID client, sans symbole.
+// Comme pour Cancel, un ordre lie entraine son groupe.
+func (gw *Gateway) CancelByClOrdID(account, clOrdID string) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	id, ok := gw.clOrd[clOrdKey{account, clOrdID}]
+	if !ok {
+		return fmt.Errorf("%w: ClOrdID %q du compte %q", ErrOrderNotFound, clOrdID, account)
+	}
+	return gw.cancel(id, "client")
+}
+
+// ReplaceByClOrdID modifie un ordre designe par son ClOrdID (voir Amend)
+// et le renomme clOrdID : les evenements suivants portent le nouveau
+// ClOrdID, l'ancien reste reserve. C'est le cancel/replace de FIX.
+func (gw *Gateway) ReplaceByClOrdID(account, origClOrdID, clOrdID string, price float64, qty int64) ([]Trade, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	// Un ordre libere (Release) garde son ClOrdID reserve mais n'est plus indexe.
+	o, ok := gw.orders[gw.clOrd[clOrdKey{account, origClOrdID}]]
+	if !ok || !o.IsActive() {
+		return nil, fmt.Errorf("%w: ClOrdID %q du compte %q", ErrOrderNotFound, origClOrdID, account)
+	}
+	if clOrdID == "" {
+		return nil, &ValidationError{Field: "cl_ord_id", Message: "nouveau ClOrdID requis"}
+	}
+	return gw.amend(o, price, qty, clOrdID)
+}
+
+// ---------------------------------------------------------------------------
+// Mecanique interne (toujours avec gw.mu tenu)
+// ---------------------------------------------------------------------------
+
+func (gw *Gateway) orderState(id uint64) (OrderState, bool) {
+	o, ok := gw.orders[id]
+	if !ok {
+		return OrderState{}, false
+	}
+	st := OrderState{
+		Order:   *o,
+		History: append([]Event(nil), gw.history[id]...),
+	}
+	for _, e := range st.History {
+		if e.Type == EventFilled {
+			st.Fills = append(st.Fills, Fill{
+				TradeID:   e.TradeID,
+				ContraID:  e.ContraID,
+				Price:     e.Price,
+				Quantity:  e.Quantity,
+				Timestamp: e.Timestamp,
+			})
+		}
+	}
+	return st, true
+}
+
+// reserveClOrdID reserve le ClOrdID d'un ordre pour son compte.
+func (gw *Gateway) reserveClOrdID(o *Order) error {
+	if o.ClOrdID == "" {
+		return nil
+	}
+	if err := gw.checkClOrdID(o.Account, o.ClOrdID); err != nil {
+		return fmt.Errorf("ordre #%d rejete: %w", o.ID, err)
+	}
+	gw.clOrd[clOrdKey{o.Account, o.ClOrdID}] = o.ID
+	return nil
+}
+
+// checkClOrdID verifie qu'un ClOrdID est utilisable par le compte.
+func (gw *Gateway) checkClOrdID(account, clOrdID string) error {
+	if len(clOrdID) > maxClOrdIDLen {
+		return &ValidationError{Field: "cl_ord_id", Message: fmt.Sprintf("plus de %d caracteres", maxClOrdIDLen)}
+	}
+	if prev, dup := gw.clOrd[clOrdKey{account, clOrdID}]; dup {
+		return &ValidationError{Field: "cl_ord_id", Message: fmt.Sprintf("%q deja utilise par le compte %q (ordre #%d)", clOrdID, account, prev)}
+	}
+	return nil
+}
+
+// releaseClOrdID libere le ClOrdID d'un ordre rejete, s'il le detient.
+func (gw *Gateway) releaseClOrdID(o *Order) {
+	k := clOrdKey{o.Account, o.ClOrdID}
+	if o.ClOrdID != "" && gw.clOrd[k] == o.ID {
+		delete(gw.clOrd, k)
+	}
+}
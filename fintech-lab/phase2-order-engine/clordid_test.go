@@ -0,0 +1,126 @@
+// clordid_test.go — Tests des ClOrdID et de la consultation des ordres.
+// Lancer avec : go test ./phase2-order-engine/ -run ClOrdID -v
+
+package main
+
+import (
+	"testing"
+)
+
+func newClientOrder(account, clOrdID string, side Side, price float64, qty int64) *Order {
+	o := newAccountOrder(account, "AAPL", side, price, qty)
+	o.ClOrdID = clOrdID
+	return o
+}
+
+// TestClOrdIDDuplicateRejected verifie l'unicite par compte.
+func TestClOrdIDDuplicateRejected(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	mustSubmit(t, gw, newClientOrder("ACC1", "c-1", Buy, 189.00, 100))
+	dup := newClientOrder("ACC1", "c-1", Buy, 188.00, 100)
+	if _, err := gw.Submit(dup); err == nil || dup.Status != StatusRejected {
+		t.Fatalf("doublon: rejet attendu, obtenu err=%v status=%s", err, dup.Status)
+	}
+	// Meme ClOrdID, autre compte : accepte.
+	mustSubmit(t, gw, newClientOrder("ACC2", "c-1", Buy, 188.00, 100))
+
+	// Un ordre rejete libere son ClOrdID.
+	bad := newClientOrder("ACC1", "c-2", Buy, -1, 100)
+	if _, err := gw.Submit(bad); err == nil {
+		t.Fatal("prix negatif: erreur attendue")
+	}
+	mustSubmit(t, gw, newClientOrder("ACC1", "c-2", Buy, 187.00, 100))
+
+	if st, ok := gw.OrderByClOrdID("ACC1", "c-1"); !ok || st.Order.Price != 189.00 {
+		t.Errorf("c-1 doit designer le premier ordre, obtenu %+v", st.Order)
+	}
+}
+
+// TestOrderByClOrdIDFillsAndHistory verifie l'etat, les fills et l'historique.
+func TestOrderByClOrdIDFillsAndHistory(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	mustSubmit(t, gw, newClientOrder("ACC1", "sell-1", Sell, 190.00, 100))
+	buy := newClientOrder("ACC2", "buy-1", Buy, 190.00, 30)
+	mustSubmit(t, gw, buy)
+	mustSubmit(t, gw, newClientOrder("ACC2", "buy-2", Buy, 190.00, 20))
+
+	st, ok := gw.OrderByClOrdID("ACC1", "sell-1")
+	if !ok {
+		t.Fatal("sell-1 introuvable")
+	}
+	if st.Order.Status != StatusPartial || st.Order.Filled != 50 {
+		t.Errorf("attendu PARTIAL 50 executes, obtenu %s %d", st.Order.Status, st.Order.Filled)
+	}
+	if len(st.Fills) != 2 || st.Fills[0].ContraID != buy.ID || st.Fills[0].Quantity != 30 {
+		t.Errorf("fills inattendus: %+v", st.Fills)
+	}
+	var types []EventType
+	for _, e := range st.History {
+		types = append(types, e.Type)
+	}
+	want := []EventType{EventReceived, EventAccepted, EventRested, EventFilled, EventFilled}
+	if len(types) != len(want) {
+		t.Fatalf("historique: attendu %v, obtenu %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("historique[%d]: attendu %s, obtenu %s", i, want[i], types[i])
+		}
+	}
+
+	if byID, ok := gw.Order(st.Order.ID); !ok || byID.Order.ClOrdID != "sell-1" {
+		t.Errorf("Order(%d) doit retrouver sell-1", st.Order.ID)
+	}
+}
+
+// TestCancelByClOrdID verifie l'annulation sans symbole.
+func TestCancelByClOrdID(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	o := newClientOrder("ACC1", "c-1", Buy, 189.00, 100)
+	mustSubmit(t, gw, o)
+	if err := gw.CancelByClOrdID("ACC2", "c-1"); err == nil {
+		t.Error("autre compte: erreur attendue")
+	}
+	if err := gw.CancelByClOrdID("ACC1", "c-1"); err != nil {
+		t.Fatalf("CancelByClOrdID: %v", err)
+	}
+	if o.Status != StatusCancelled {
+		t.Errorf("attendu CANCELLED, obtenu %s", o.Status)
+	}
+	if err := gw.CancelByClOrdID("ACC1", "c-1"); err == nil {
+		t.Error("deuxieme annulation: erreur attendue")
+	}
+}
+
+// TestReplaceByClOrdID verifie le renommage et l'unicite du nouveau ClOrdID.
+func TestReplaceByClOrdID(t *testing.T) {
+	gw, _ := newTestGateway()
+	mustSubmit(t, gw, newClientOrder("ACC1", "r-1", Sell, 191.00, 100))
+	mustSubmit(t, gw, newClientOrder("ACC1", "r-9", Sell, 195.00, 10))
+
+	if _, err := gw.ReplaceByClOrdID("ACC1", "r-1", "r-9", 192.00, 100); err == nil {
+		t.Fatal("nouveau ClOrdID deja utilise: erreur attendue")
+	}
+	if _, err := gw.ReplaceByClOrdID("ACC1", "r-1", "r-2", 192.00, 80); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	st, ok := gw.OrderByClOrdID("ACC1", "r-2")
+	if !ok || st.Order.ClOrdID != "r-2" || st.Order.Price != 192.00 || st.Order.Quantity != 80 {
+		t.Fatalf("r-2 doit designer l'ordre modifie, obtenu %+v", st.Order)
+	}
+	for _, e := range st.History {
+		if e.Type == EventAmended && e.ClOrdID != "r-2" {
+			t.Errorf("EventAmended doit porter le nouveau ClOrdID, obtenu %+v", e)
+		}
+	}
+	// L'ancien ClOrdID designe toujours l'ordre et reste reserve.
+	if old, ok := gw.OrderByClOrdID("ACC1", "r-1"); !ok || old.Order.ID != st.Order.ID {
+		t.Errorf("r-1 doit rester associe a l'ordre #%d", st.Order.ID)
+	}
+	if _, err := gw.Submit(newClientOrder("ACC1", "r-1", Buy, 180.00, 10)); err == nil {
+		t.Error("r-1 reutilise: rejet attendu")
+	}
+}
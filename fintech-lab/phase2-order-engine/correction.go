@@ -0,0 +1,309 @@
+// correction.go — Bust et correction de trades apres coup, avec piste d'audit.
+//
+// Un trade n'est jamais modifie en place ni supprime :
+//   - BUST       : l'original passe en BUSTED et sort des totaux.
+//   - CORRECTION : l'original passe en CORRECTED, un nouveau trade (nouvel ID,
+//                  Corrects = ID original) porte le prix/quantite corriges.
+// Chaque operation est tracee dans le TradeAudit : qui, quand, pourquoi.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// TradeAudit — journal append-only des operations post-trade
+// ---------------------------------------------------------------------------
+
+// TradeAuditEntry trace une operation sur un trade. Valeurs copiees : une
+// entree ne change plus apres son enregistrement.
+type TradeAuditEntry struct {
+	Seq       uint64
+	Timestamp int64       // Unix nanoseconds de l'operation
+	Action    TradeStatus // TradeBusted ou TradeCorrected
+	Operator  string
+	Reason    string
+	Original  Trade // Etat du trade avant l'operation
+	Corrected Trade // Trade de correction (zero pour un bust)
+}
+
+// TradeAudit est le journal immuable des busts et corrections.
+// Aucune API ne permet de modifier ou retirer une entree.
+type TradeAudit struct {
+	mu      sync.Mutex
+	entries []TradeAuditEntry
+}
+
+func (a *TradeAudit) record(e TradeAuditEntry) TradeAuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e.Seq = uint64(len(a.entries)) + 1
+	a.entries = append(a.entries, e)
+	return e
+}
+
+// Entries retourne une copie du journal, dans l'ordre chronologique.
+func (a *TradeAudit) Entries() []TradeAuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]TradeAuditEntry(nil), a.entries...)
+}
+
+// ForTrade retourne les entrees touchant un trade (original ou correction).
+func (a *TradeAudit) ForTrade(tradeID uint64) []TradeAuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []TradeAuditEntry
+	for _, e := range a.entries {
+		if e.Original.ID == tradeID || e.Corrected.ID == tradeID {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// TradeLog — consultation et mutation des statuts
+// ---------------------------------------------------------------------------
+
+// Trade retourne un trade par ID, quel que soit son statut.
+func (tl *TradeLog) Trade(id uint64) (Trade, bool) {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	i, ok := tl.byID[id]
+	if !ok {
+		return Trade{}, false
+	}
+	return tl.trades[i], true
+}
+
+// History retourne la chaine complete d'un trade : l'original puis chaque
+// correction successive, a partir de n'importe quel maillon. Un lien vers
+// un trade inconnu (trade ajoute par Add, store tronque) arrete la chaine.
+func (tl *TradeLog) History(id uint64) []Trade {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+
+	i, ok := tl.byID[id]
+	if !ok {
+		return nil
+	}
+	i = tl.origin(i)
+	chain := []Trade{tl.trades[i]}
+	for next := tl.trades[i].CorrectedBy; next != 0 && len(chain) <= len(tl.trades); {
+		j, ok := tl.byID[next]
+		if !ok {
+			break
+		}
+		chain = append(chain, tl.trades[j])
+		next = tl.trades[j].CorrectedBy
+	}
+	return chain
+}
+
+// live retourne l'index d'un trade vivant. Appele avec tl.mu tenu.
+func (tl *TradeLog) live(id uint64) (int, error) {
+	i, ok := tl.byID[id]
+	if !ok {
+		return 0, fmt.Errorf("trade #%d inconnu", id)
+	}
+	if !tl.trades[i].IsLive() {
+		return 0, fmt.Errorf("trade #%d deja %s", id, tl.trades[i].Status)
+	}
+	return i, nil
+}
+
+// bust passe un trade en BUSTED. Retourne son etat avant l'operation.
+func (tl *TradeLog) bust(id uint64) (Trade, error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	i, err := tl.live(id)
+	if err != nil {
+		return Trade{}, err
+	}
+	orig := tl.trades[i]
+	tl.trades[i].Status = TradeBusted
+	tl.rebuildTicker(orig.Symbol)
+	tl.notify(tl.trades[i])
+	tl.sinkAppend(tl.trades[i])
+	return orig, nil
+}
+
+// correct remplace un trade par une correction. Retourne l'etat de
+// l'original avant l'operation et le nouveau trade.
+// newID est l'ID du trade de correction, fourni par le Sequencer du moteur.
+func (tl *TradeLog) correct(id, newID uint64, price float64, qty int64) (Trade, Trade, error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	i, err := tl.live(id)
+	if err != nil {
+		return Trade{}, Trade{}, err
+	}
+	orig := tl.trades[i]
+
+	// L'execution a eu lieu a l'heure d'origine : la correction garde ce timestamp.
+	fixed := newTrade(newID, orig.Symbol, orig.Currency, orig.BuyOrderID, orig.SellOrderID, price, qty, orig.Timestamp)
+	fixed.Corrects = orig.ID
+	tl.trades[i].Status = TradeCorrected
+	tl.trades[i].CorrectedBy = fixed.ID
+
+	tl.notify(tl.trades[i])
+	tl.add(fixed)
+	tl.rebuildTicker(orig.Symbol)
+	tl.sinkAppend(tl.trades[i], fixed)
+	return orig, fixed, nil
+}
+
+// sinkAppend transmet au sink les trades changes par un bust ou une
+// correction (voir TradeSink). Appele avec tl.mu tenu.
+func (tl *TradeLog) sinkAppend(trades ...Trade) {
+	if tl.sink != nil {
+		if err := tl.sink.Append(trades); err != nil && tl.sinkErr == nil {
+			tl.sinkErr = err
+		}
+	}
+}
+
+// rebuildTicker recalcule le ticker d'un symbole depuis ses trades vivants
+// (High/Low ne se "decrementent" pas), dans l'ordre d'execution : par
+// Timestamp, puis par position de l'original. Une correction, ajoutee en
+// fin de log, prend ainsi la place du trade qu'elle corrige (Open, Last).
+// Appele avec tl.mu tenu.
+func (tl *TradeLog) rebuildTicker(symbol string) {
+	type slot struct {
+		pos int // Index de l'original de la chaine
+		t   Trade
+	}
+	var live []slot
+	for i, t := range tl.trades {
+		if t.Symbol == symbol && t.IsLive() {
+			live = append(live, slot{tl.origin(i), t})
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].t.Timestamp != live[j].t.Timestamp {
+			return live[i].t.Timestamp < live[j].t.Timestamp
+		}
+		return live[i].pos < live[j].pos
+	})
+
+	tk := &Ticker{Symbol: symbol, Currency: tl.tickers[symbol].Currency}
+	for _, s := range live {
+		tk.add(s.t)
+	}
+	tl.tickers[symbol] = tk
+}
+
+// origin retourne l'index de l'original de la chaine de corrections de
+// trades[i]. Appele avec tl.mu tenu.
+func (tl *TradeLog) origin(i int) int {
+	for n := 0; tl.trades[i].Corrects != 0 && n < len(tl.trades); n++ {
+		j, ok := tl.byID[tl.trades[i].Corrects]
+		if !ok {
+			break // Lien vers un trade inconnu : la chaine s'arrete la
+		}
+		i = j
+	}
+	return i
+}
+
+// ---------------------------------------------------------------------------
+// Gateway — API operations
+// ---------------------------------------------------------------------------
+
+// BustTrade annule un trade apres coup. operator et reason sont obligatoires.
+// Les ordres ne sont pas remis dans le book : un bust corrige l'historique,
+// pas le carnet.
+func (gw *Gateway) BustTrade(tradeID uint64, operator, reason string) error {
+	if err := gw.checkPostTrade(operator, reason); err != nil {
+		return err
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	orig, err := gw.log.bust(tradeID)
+	if err != nil {
+		return fmt.Errorf("bust refuse: %w", err)
+	}
+	e := gw.audit.record(TradeAuditEntry{
+		Timestamp: gw.clock.Now(),
+		Action:    TradeBusted,
+		Operator:  operator,
+		Reason:    reason,
+		Original:  orig,
+	})
+	gw.emit(Event{
+		Type:      EventTradeBusted,
+		TradeID:   orig.ID,
+		Symbol:    orig.Symbol,
+		Price:     orig.Price,
+		Quantity:  orig.Quantity,
+		Reason:    reason,
+		Timestamp: e.Timestamp,
+	})
+	return nil
+}
+
+// CorrectTrade remplace le prix et la quantite d'un trade. Retourne le trade
+// de correction ; l'original reste consultable (TradeLog.History).
+func (gw *Gateway) CorrectTrade(tradeID uint64, price float64, qty int64, operator, reason string) (Trade, error) {
+	if err := gw.checkPostTrade(operator, reason); err != nil {
+		return Trade{}, err
+	}
+	if price <= 0 {
+		return Trade{}, &ValidationError{Field: "price", Message: fmt.Sprintf("prix doit etre > 0, recu: %.2f", price)}
+	}
+	if qty <= 0 {
+		return Trade{}, &ValidationError{Field: "quantity", Message: fmt.Sprintf("quantite doit etre > 0, recu: %d", qty)}
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	orig, fixed, err := gw.log.correct(tradeID, gw.ids.NextTradeID(), price, qty)
+	if err != nil {
+		return Trade{}, fmt.Errorf("correction refusee: %w", err)
+	}
+	e := gw.audit.record(TradeAuditEntry{
+		Timestamp: gw.clock.Now(),
+		Action:    TradeCorrected,
+		Operator:  operator,
+		Reason:    reason,
+		Original:  orig,
+		Corrected: fixed,
+	})
+	gw.emit(Event{
+		Type:      EventTradeCorrected,
+		TradeID:   orig.ID,
+		Symbol:    orig.Symbol,
+		Price:     fixed.Price,
+		Quantity:  fixed.Quantity,
+		Reason:    reason,
+		Timestamp: e.Timestamp,
+	})
+	return fixed, nil
+}
+
+// TradeAudit retourne le journal des busts et corrections.
+func (gw *Gateway) TradeAudit() *TradeAudit {
+	return gw.audit
+}
+
+func (gw *Gateway) checkPostTrade(operator, reason string) error {
+	if gw.log == nil {
+		return fmt.Errorf("operation post-trade impossible: Gateway sans TradeLog")
+	}
+	if operator == "" {
+		return &ValidationError{Field: "operator", Message: "operateur obligatoire"}
+	}
+	if reason == "" {
+		return &ValidationError{Field: "reason", Message: "motif obligatoire"}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// correction_test.go — Tests des busts et corrections de trades.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBustTrade verifie qu'un bust sort le trade des totaux et est audite.
+func TestBustTrade(t *testing.T) {
+	gw, log := newTestGateway()
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 195.00, 100))
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 195.00, 200))
+
+	var events []Event
+	gw.Subscribe(func(e Event) { events = append(events, e) })
+
+	if err := gw.BustTrade(trades[1].ID, "ops-jdoe", "prix aberrant"); err != nil {
+		t.Fatalf("BustTrade: %v", err)
+	}
+	if log.Count() != 1 || log.TotalVolume() != 100 {
+		t.Errorf("totaux apres bust: attendu 1 trade/100, obtenu %d/%d", log.Count(), log.TotalVolume())
+	}
+	if tk, _ := log.Ticker("AAPL"); tk.High != 190.00 || tk.Last != 190.00 {
+		t.Errorf("ticker non recalcule: %v", tk)
+	}
+	if got, _ := log.Trade(trades[1].ID); got.Status != TradeBusted {
+		t.Errorf("statut: attendu BUSTED, obtenu %s", got.Status)
+	}
+	if len(events) != 1 || events[0].Type != EventTradeBusted || events[0].TradeID != trades[1].ID {
+		t.Errorf("evenements inattendus: %+v", events)
+	}
+
+	audit := gw.TradeAudit().ForTrade(trades[1].ID)
+	if len(audit) != 1 || audit[0].Operator != "ops-jdoe" || audit[0].Original.Status != TradeActive {
+		t.Errorf("audit inattendu: %+v", audit)
+	}
+
+	if err := gw.BustTrade(trades[1].ID, "ops-jdoe", "doublon"); err == nil {
+		t.Error("second bust: erreur attendue")
+	}
+}
+
+// TestCorrectTrade verifie la correction et la consultation des deux versions.
+func TestCorrectTrade(t *testing.T) {
+	gw, log := newTestGateway()
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190.00, 100))
+	orig := trades[0]
+
+	if _, err := gw.CorrectTrade(orig.ID, 189.00, 100, "", "fat finger"); err == nil {
+		t.Error("correction sans operateur: erreur attendue")
+	}
+
+	fixed, err := gw.CorrectTrade(orig.ID, 189.00, 80, "ops-jdoe", "fat finger")
+	if err != nil {
+		t.Fatalf("CorrectTrade: %v", err)
+	}
+	if fixed.Corrects != orig.ID || fixed.ID == orig.ID || fixed.Timestamp != orig.Timestamp {
+		t.Errorf("trade de correction inattendu: %+v", fixed)
+	}
+	if log.Count() != 1 || log.TotalNotional() != 189.00*80 {
+		t.Errorf("totaux: attendu 1 trade/%.2f, obtenu %d/%.2f", 189.00*80, log.Count(), log.TotalNotional())
+	}
+
+	hist := log.History(fixed.ID)
+	if len(hist) != 2 || hist[0].ID != orig.ID || hist[1].ID != fixed.ID {
+		t.Fatalf("historique inattendu: %v", hist)
+	}
+	if hist[0].Status != TradeCorrected || hist[0].CorrectedBy != fixed.ID || hist[0].Price != 190.00 {
+		t.Errorf("original mal conserve: %+v", hist[0])
+	}
+
+	entries := gw.TradeAudit().Entries()
+	if len(entries) != 1 || entries[0].Action != TradeCorrected || entries[0].Corrected.ID != fixed.ID {
+		t.Errorf("audit inattendu: %+v", entries)
+	}
+}
+
+// TestCorrectFirstTrade corrige le premier trade d'une session : la
+// correction, ajoutee en fin de log, garde sa place dans le ticker.
+func TestCorrectFirstTrade(t *testing.T) {
+	gw, log := newTestGateway()
+	var trades []Trade
+	for _, price := range []float64{100, 101, 102} {
+		mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, price, 10))
+		trades = append(trades, mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, price, 10))...)
+	}
+
+	if _, err := gw.CorrectTrade(trades[0].ID, 99, 10, "ops-jdoe", "prix errone"); err != nil {
+		t.Fatalf("CorrectTrade: %v", err)
+	}
+	tk, _ := log.Ticker("AAPL")
+	if tk.Open != 99 || tk.Last != 102 || tk.Low != 99 || tk.High != 102 || tk.Trades != 3 {
+		t.Errorf("ticker apres correction du premier trade: %v", tk)
+	}
+
+	if err := gw.BustTrade(trades[2].ID, "ops-jdoe", "doublon"); err != nil {
+		t.Fatalf("BustTrade: %v", err)
+	}
+	if tk, _ := log.Ticker("AAPL"); tk.Open != 99 || tk.Last != 101 {
+		t.Errorf("ticker apres bust du dernier trade: %v", tk)
+	}
+}
+
+// TestHistoryBrokenLinks verifie qu'un lien Corrects/CorrectedBy vers un
+// trade inconnu arrete la chaine au lieu de suivre un autre trade.
+func TestHistoryBrokenLinks(t *testing.T) {
+	log := NewTradeLog()
+	log.Add(Trade{ID: 1, Symbol: "AAPL", Price: 100, Quantity: 10})
+	log.Add(Trade{ID: 5, Symbol: "AAPL", Price: 100, Quantity: 10, Corrects: 42})
+	log.Add(Trade{ID: 6, Symbol: "AAPL", Price: 100, Quantity: 10, Status: TradeCorrected, CorrectedBy: 43})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if h := log.History(5); len(h) != 1 || h[0].ID != 5 {
+			t.Errorf("History(5): %v", h)
+		}
+		if h := log.History(6); len(h) != 1 || h[0].ID != 6 {
+			t.Errorf("History(6): %v", h)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("History ne rend pas la main")
+	}
+}
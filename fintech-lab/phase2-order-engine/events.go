@@ -0,0 +1,119 @@
+// events.go — Evenements publies par le Gateway.
+// Pattern observer synchrone : chaque abonne recoit les evenements dans
+// l'ordre exact ou le moteur les produit.
+
+package main
+
+// EventType identifie la nature d'un evenement.
+type EventType string
+
+const (
+	EventReceived       EventType = "RECEIVED"        // Ordre recu, avant toute validation
+	EventAccepted       EventType = "ACCEPTED"        // Ordre valide, pris en charge par le Gateway
+	EventRejected       EventType = "REJECTED"        // Ordre refuse (validation, symbole, kill switch) ; Reason porte le motif
+	EventRested         EventType = "RESTED"          // Ordre pose au carnet (ou stop mis en attente)
+	EventTriggered      EventType = "TRIGGERED"       // Stop franchi, envoye au book en Market
+	EventAmended        EventType = "AMENDED"         // Prix ou quantite modifies (client, ou jambe liee redimensionnee)
+	EventFilled         EventType = "FILLED"          // Execution (partielle ou totale) d'un cote d'un trade
+	EventCancelled      EventType = "CANCELLED"       // Ordre retire (client, OCO, IOC/Market non execute, mass cancel, kill switch)
+	EventTradeBusted    EventType = "TRADE_BUSTED"    // Trade annule apres coup
+	EventTradeCorrected EventType = "TRADE_CORRECTED" // Trade remplace par une correction
+)
+
+// Event decrit un changement d'etat d'un ordre ou d'un trade.
+// Les champs sont copies : l'Order continue d'evoluer apres la publication.
+type Event struct {
+	Type      EventType `json:"type"`
+	OrderID   uint64    `json:"order_id,omitempty"`
+	TradeID   uint64    `json:"trade_id,omitempty"` // Fill : trade produit. Correction : trade original
+	Account   string    `json:"account,omitempty"`
+	ClOrdID   string    `json:"cl_ord_id,omitempty"`
+	Symbol    string    `json:"symbol"`
+	Side      Side      `json:"side,omitempty"`
+	OrderType OrderType `json:"order_type,omitempty"`
+	Price     float64   `json:"price,omitempty"`    // Accepte : limite. Fill : prix d'execution. Correction : nouveau prix
+	Quantity  int64     `json:"quantity,omitempty"` // Accepte : quantite. Fill : quantite executee. Correction : nouvelle quantite
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp int64     `json:"ts"` // Unix nanoseconds
+
+	// Liens entre ordres
+	ContraID uint64   `json:"contra_id,omitempty"` // Fill : ordre de l'autre cote du trade
+	GroupID  uint64   `json:"group_id,omitempty"`  // Groupe OCO / bracket de l'ordre
+	ParentID uint64   `json:"parent_id,omitempty"` // Jambe de sortie : entree du bracket
+	ChildIDs []uint64 `json:"child_ids,omitempty"` // Entree de bracket : ses jambes de sortie
+}
+
+// EventHandler recoit les evenements du Gateway.
+// Appele avec le verrou du Gateway tenu : il doit etre rapide et ne jamais
+// rappeler le Gateway (deadlock). Pour un traitement lent, copier l'Event
+// dans un channel et le consommer dans une autre goroutine.
+type EventHandler func(Event)
+
+// Subscribe enregistre un abonne aux evenements du Gateway.
+func (gw *Gateway) Subscribe(h EventHandler) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.subs = append(gw.subs, h)
+}
+
+// emit horodate (si besoin) et publie un evenement a tous les abonnes.
+// Appele avec gw.mu tenu.
+func (gw *Gateway) emit(e Event) {
+	if e.Timestamp == 0 {
+		e.Timestamp = gw.clock.Now()
+	}
+	e = gw.link(e)
+	if e.OrderID != 0 {
+		gw.history[e.OrderID] = append(gw.history[e.OrderID], e)
+	}
+	for _, h := range gw.subs {
+		h(e)
+	}
+}
+
+// orderEvent construit un Event a partir de l'etat courant d'un ordre.
+func orderEvent(t EventType, o *Order, reason string) Event {
+	return Event{
+		Type:      t,
+		OrderID:   o.ID,
+		Account:   o.Account,
+		ClOrdID:   o.ClOrdID,
+		Symbol:    o.Symbol,
+		Side:      o.Side,
+		OrderType: o.Type,
+		Price:     o.Price,
+		Quantity:  o.Quantity,
+		Reason:    reason,
+	}
+}
+
+// fillEvent construit l'EventFilled d'un cote d'un trade.
+func fillEvent(o *Order, t Trade) Event {
+	e := orderEvent(EventFilled, o, "")
+	e.TradeID = t.ID
+	e.Price = t.Price
+	e.Quantity = t.Quantity
+	e.ContraID = t.BuyOrderID
+	if o.ID == t.BuyOrderID {
+		e.ContraID = t.SellOrderID
+	}
+	return e
+}
+
+// link renseigne les liens parent/enfant d'un evenement d'ordre lie.
+// Appele avec gw.mu tenu.
+func (gw *Gateway) link(e Event) Event {
+	g, ok := gw.legOf[e.OrderID]
+	if !ok {
+		return e
+	}
+	e.GroupID = g.id
+	switch {
+	case g.entry == nil:
+	case g.entry.ID == e.OrderID:
+		e.ChildIDs = []uint64{g.legs[0].ID, g.legs[1].ID}
+	default:
+		e.ParentID = g.entry.ID
+	}
+	return e
+}
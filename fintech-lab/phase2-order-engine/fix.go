@@ -0,0 +1,292 @@
+// fix.go — Codec FIX 4.4 : messages tag=valeur separes par SOH (0x01).
+//
+//	8=FIX.4.4|9=<longueur du corps>|35=<MsgType>|...|10=<checksum>|
+//
+// BodyLength (9) compte les octets de 35= jusqu'au SOH precedant 10=.
+// CheckSum (10) = somme des octets avant 10=, modulo 256, sur 3 chiffres.
+// Le codec ne connait pas la semantique des messages : voir fixsession.go.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fixBeginString est la seule version acceptee.
+const fixBeginString = "FIX.4.4"
+
+// fixSOH separe les champs.
+const fixSOH = 0x01
+
+// maxFIXBody borne la taille d'un message (BodyLength).
+const maxFIXBody = 64 << 10
+
+// fixTimeFormat est le format UTCTimestamp (ms).
+const fixTimeFormat = "20060102-15:04:05.000"
+
+// Tags utilises par le moteur.
+const (
+	tagAccount         = 1
+	tagAvgPx           = 6
+	tagBeginSeqNo      = 7
+	tagBeginString     = 8
+	tagBodyLength      = 9
+	tagCheckSum        = 10
+	tagClOrdID         = 11
+	tagCumQty          = 14
+	tagEndSeqNo        = 16
+	tagExecID          = 17
+	tagLastPx          = 31
+	tagLastQty         = 32
+	tagMsgSeqNum       = 34
+	tagMsgType         = 35
+	tagNewSeqNo        = 36
+	tagOrderID         = 37
+	tagOrderQty        = 38
+	tagOrdStatus       = 39
+	tagOrdType         = 40
+	tagOrigClOrdID     = 41
+	tagPossDupFlag     = 43
+	tagPrice           = 44
+	tagRefSeqNum       = 45
+	tagSenderCompID    = 49
+	tagSendingTime     = 52
+	tagSide            = 54
+	tagSymbol          = 55
+	tagTargetCompID    = 56
+	tagText            = 58
+	tagTimeInForce     = 59
+	tagTransactTime    = 60
+	tagEncryptMethod   = 98
+	tagStopPx          = 99
+	tagCxlRejReason    = 102
+	tagOrdRejReason    = 103
+	tagHeartBtInt      = 108
+	tagTestReqID       = 112
+	tagOrigSendingTime = 122
+	tagGapFillFlag     = 123
+	tagResetSeqNumFlag = 141
+	tagExecType        = 150
+	tagLeavesQty       = 151
+	tagRefTagID        = 371
+	tagRefMsgType      = 372
+	tagRejectReason    = 373
+	tagCxlRejRespTo    = 434
+	tagTrdMatchID      = 880
+)
+
+// MsgType (35).
+const (
+	msgHeartbeat          = "0"
+	msgTestRequest        = "1"
+	msgResendRequest      = "2"
+	msgReject             = "3"
+	msgSequenceReset      = "4"
+	msgLogout             = "5"
+	msgExecutionReport    = "8"
+	msgOrderCancelReject  = "9"
+	msgLogon              = "A"
+	msgNewOrderSingle     = "D"
+	msgOrderCancelRequest = "F"
+	msgOrderCancelReplace = "G"
+)
+
+// isAdminMsg indique un message de la couche session.
+func isAdminMsg(msgType string) bool {
+	switch msgType {
+	case msgHeartbeat, msgTestRequest, msgResendRequest, msgReject,
+		msgSequenceReset, msgLogout, msgLogon:
+		return true
+	}
+	return false
+}
+
+// ErrFIXGarbled signale un message illisible (checksum, champ mal forme) :
+// la session l'ignore sans consommer de numero de sequence.
+var ErrFIXGarbled = errors.New("fix: message illisible")
+
+// ---------------------------------------------------------------------------
+// FIXMessage
+// ---------------------------------------------------------------------------
+
+// FIXField est un couple tag=valeur.
+type FIXField struct {
+	Tag   int
+	Value string
+}
+
+// FIXMessage est une liste ordonnee de champs. L'en-tete (8, 9) et le
+// trailer (10) sont calcules par Encode et ne sont pas stockes.
+type FIXMessage struct {
+	Fields []FIXField
+}
+
+// NewFIXMessage cree un message vide de type msgType.
+func NewFIXMessage(msgType string) *FIXMessage {
+	return &FIXMessage{Fields: []FIXField{{tagMsgType, msgType}}}
+}
+
+// MsgType retourne le champ 35.
+func (m *FIXMessage) MsgType() string {
+	return m.Get(tagMsgType)
+}
+
+// Get retourne la valeur d'un tag ("" si absent).
+func (m *FIXMessage) Get(tag int) string {
+	v, _ := m.Lookup(tag)
+	return v
+}
+
+// Lookup retourne la valeur d'un tag et sa presence.
+func (m *FIXMessage) Lookup(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int retourne la valeur entiere d'un tag.
+func (m *FIXMessage) Int(tag int) (int64, error) {
+	return strconv.ParseInt(m.Get(tag), 10, 64)
+}
+
+// Set remplace la valeur d'un tag, ou l'ajoute en fin de message.
+func (m *FIXMessage) Set(tag int, value string) *FIXMessage {
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = value
+			return m
+		}
+	}
+	m.Fields = append(m.Fields, FIXField{tag, value})
+	return m
+}
+
+// SetInt et SetFloat sont des raccourcis de Set.
+func (m *FIXMessage) SetInt(tag int, v int64) *FIXMessage {
+	return m.Set(tag, strconv.FormatInt(v, 10))
+}
+
+func (m *FIXMessage) SetFloat(tag int, v float64) *FIXMessage {
+	return m.Set(tag, strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+// Encode produit le message sur le fil, BodyLength et CheckSum compris.
+// 35 est toujours le premier champ du corps.
+func (m *FIXMessage) Encode() []byte {
+	var body bytes.Buffer
+	writeField := func(tag int, value string) {
+		body.WriteString(strconv.Itoa(tag))
+		body.WriteByte('=')
+		body.WriteString(value)
+		body.WriteByte(fixSOH)
+	}
+	writeField(tagMsgType, m.MsgType())
+	for _, f := range m.Fields {
+		switch f.Tag {
+		case tagBeginString, tagBodyLength, tagCheckSum, tagMsgType:
+			continue
+		}
+		writeField(f.Tag, f.Value)
+	}
+
+	var out bytes.Buffer
+	out.Grow(body.Len() + 32)
+	fmt.Fprintf(&out, "8=%s\x019=%d\x01", fixBeginString, body.Len())
+	out.Write(body.Bytes())
+	fmt.Fprintf(&out, "10=%03d\x01", fixChecksum(out.Bytes()))
+	return out.Bytes()
+}
+
+// String affiche le message avec '|' a la place de SOH (logs, tests).
+func (m *FIXMessage) String() string {
+	return strings.ReplaceAll(string(m.Encode()), "\x01", "|")
+}
+
+func fixChecksum(b []byte) int {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// ---------------------------------------------------------------------------
+// Lecture
+// ---------------------------------------------------------------------------
+
+// ReadFIXMessage lit un message complet. Une erreur wrappant ErrFIXGarbled
+// laisse le flux synchronise (message suivant lisible) ; toute autre erreur
+// (en-tete invalide, I/O) impose de fermer la connexion.
+func ReadFIXMessage(br *bufio.Reader) (*FIXMessage, error) {
+	begin, err := readFIXField(br)
+	if err != nil {
+		return nil, err
+	}
+	if begin != "8="+fixBeginString {
+		return nil, fmt.Errorf("fix: BeginString invalide %q", begin)
+	}
+	lenField, err := readFIXField(br)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(lenField, "9="))
+	if !strings.HasPrefix(lenField, "9=") || err != nil || n <= 0 || n > maxFIXBody {
+		return nil, fmt.Errorf("fix: BodyLength invalide %q", lenField)
+	}
+
+	// Corps + "10=XXX\x01"
+	buf := make([]byte, n+7)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, err
+	}
+	body, trailer := buf[:n], buf[n:]
+	if !bytes.HasPrefix(trailer, []byte("10=")) || trailer[6] != fixSOH || body[n-1] != fixSOH {
+		return nil, fmt.Errorf("fix: BodyLength %d ne tombe pas sur CheckSum", n)
+	}
+	want, err := strconv.Atoi(string(trailer[3:6]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: CheckSum %q", ErrFIXGarbled, trailer[3:6])
+	}
+	got := (fixChecksum([]byte(begin)) + fixChecksum([]byte(lenField)) + 2*fixSOH + fixChecksum(body)) % 256
+	if got != want {
+		return nil, fmt.Errorf("%w: CheckSum %03d, attendu %03d", ErrFIXGarbled, want, got)
+	}
+
+	m := &FIXMessage{}
+	for _, raw := range bytes.Split(body[:n-1], []byte{fixSOH}) {
+		tagStr, value, ok := bytes.Cut(raw, []byte{'='})
+		tag, err := strconv.Atoi(string(tagStr))
+		if !ok || err != nil || tag <= 0 {
+			return nil, fmt.Errorf("%w: champ %q", ErrFIXGarbled, raw)
+		}
+		m.Fields = append(m.Fields, FIXField{tag, string(value)})
+	}
+	if len(m.Fields) == 0 || m.Fields[0].Tag != tagMsgType {
+		return nil, fmt.Errorf("%w: MsgType absent ou mal place", ErrFIXGarbled)
+	}
+	return m, nil
+}
+
+// readFIXField lit un champ jusqu'au SOH (exclu).
+func readFIXField(br *bufio.Reader) (string, error) {
+	line, err := br.ReadSlice(fixSOH)
+	if err != nil {
+		return "", err
+	}
+	return string(line[:len(line)-1]), nil
+}
+
+// fixTime formate un horodatage Unix nanosecondes en UTCTimestamp.
+func fixTime(ns int64) string {
+	return time.Unix(0, ns).UTC().Format(fixTimeFormat)
+}
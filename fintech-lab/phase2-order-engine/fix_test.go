@@ -0,0 +1,364 @@
+// fix_test.go — Tests de l'acceptor FIX contre un petit initiator local.
+// Lancer avec : go test ./phase2-order-engine/ -run FIX -v
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Initiator de test
+// ---------------------------------------------------------------------------
+
+// fixInitiator est un client FIX minimal : il numerote ses messages et lit
+// ceux de l'acceptor, sans logique de session automatique.
+type fixInitiator struct {
+	t      *testing.T
+	nc     net.Conn
+	br     *bufio.Reader
+	sender string
+	target string
+	seq    int // Prochain MsgSeqNum emis
+}
+
+func dialFIX(t *testing.T, addr, sender, target string) *fixInitiator {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	return &fixInitiator{t: t, nc: nc, br: bufio.NewReader(nc), sender: sender, target: target, seq: 1}
+}
+
+// send numerote et envoie m.
+func (c *fixInitiator) send(m *FIXMessage) {
+	c.t.Helper()
+	c.sendSeq(m, c.seq)
+	c.seq++
+}
+
+// sendSeq envoie m avec un MsgSeqNum impose.
+func (c *fixInitiator) sendSeq(m *FIXMessage, seq int) {
+	c.t.Helper()
+	out := NewFIXMessage(m.MsgType()).
+		Set(tagSenderCompID, c.sender).
+		Set(tagTargetCompID, c.target).
+		SetInt(tagMsgSeqNum, int64(seq)).
+		Set(tagSendingTime, time.Now().UTC().Format(fixTimeFormat))
+	out.Fields = append(out.Fields, m.Fields[1:]...)
+	if _, err := c.nc.Write(out.Encode()); err != nil {
+		c.t.Fatalf("envoi %s: %v", out, err)
+	}
+}
+
+// read lit le prochain message.
+func (c *fixInitiator) read() (*FIXMessage, error) {
+	c.nc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return ReadFIXMessage(c.br)
+}
+
+// expect lit jusqu'au prochain message de type msgType (heartbeats ignores).
+func (c *fixInitiator) expect(msgType string) *FIXMessage {
+	c.t.Helper()
+	for {
+		m, err := c.read()
+		if err != nil {
+			c.t.Fatalf("attendu 35=%s: %v", msgType, err)
+		}
+		if m.MsgType() == msgType {
+			return m
+		}
+		if m.MsgType() != msgHeartbeat {
+			c.t.Fatalf("attendu 35=%s, obtenu %s", msgType, m)
+		}
+	}
+}
+
+// logon ouvre la session et retourne le Logon de l'acceptor.
+func (c *fixInitiator) logon(heartBtInt int64, reset bool) *FIXMessage {
+	c.t.Helper()
+	m := NewFIXMessage(msgLogon).Set(tagEncryptMethod, "0").SetInt(tagHeartBtInt, heartBtInt)
+	if reset {
+		m.Set(tagResetSeqNumFlag, "Y")
+	}
+	c.send(m)
+	return c.expect(msgLogon)
+}
+
+func newOrderSingle(clOrdID, side string, price float64, qty int64) *FIXMessage {
+	return NewFIXMessage(msgNewOrderSingle).
+		Set(tagClOrdID, clOrdID).
+		Set(tagSymbol, "AAPL").
+		Set(tagSide, side).
+		SetInt(tagOrderQty, qty).
+		Set(tagOrdType, "2").
+		SetFloat(tagPrice, price)
+}
+
+// startFIX demarre un acceptor sur un port local pour ACC1 et ACC2.
+func startFIX(t *testing.T, gw *Gateway, opts FIXOptions, stores ...FIXStore) (*FIXAcceptor, string) {
+	t.Helper()
+	cfgs := []FIXSessionConfig{
+		{SenderCompID: "GME", TargetCompID: "BROKER1", Account: "ACC1"},
+		{SenderCompID: "GME", TargetCompID: "BROKER2", Account: "ACC2"},
+	}
+	for i, s := range stores {
+		cfgs[i].Store = s
+	}
+	acc, err := NewFIXAcceptor(gw, cfgs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go acc.Serve(l)
+	t.Cleanup(func() { acc.Close() })
+	return acc, l.Addr().String()
+}
+
+// ---------------------------------------------------------------------------
+// Codec
+// ---------------------------------------------------------------------------
+
+// TestFIXCodec verifie BodyLength, CheckSum et la relecture.
+func TestFIXCodec(t *testing.T) {
+	m := NewFIXMessage(msgHeartbeat).Set(tagSenderCompID, "A").Set(tagTargetCompID, "B").SetInt(tagMsgSeqNum, 7)
+	raw := m.Encode()
+	if want := "8=FIX.4.4\x019=20\x0135=0\x0149=A\x0156=B\x0134=7\x01"; !strings.HasPrefix(string(raw), want) {
+		t.Fatalf("encodage: %q", raw)
+	}
+	if !strings.HasSuffix(string(raw), "10="+string(raw[len(raw)-4:len(raw)-1])+"\x01") {
+		t.Fatalf("trailer: %q", raw)
+	}
+
+	got, err := ReadFIXMessage(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil || got.MsgType() != msgHeartbeat || got.Get(tagMsgSeqNum) != "7" {
+		t.Fatalf("relecture: %v %v", got, err)
+	}
+
+	// Checksum faux : message ignore, flux toujours synchronise.
+	bad := append([]byte(nil), raw...)
+	bad[len(bad)-2]++
+	br := bufio.NewReader(bytes.NewReader(append(bad, raw...)))
+	if _, err := ReadFIXMessage(br); !errors.Is(err, ErrFIXGarbled) {
+		t.Errorf("checksum faux: attendu ErrFIXGarbled, obtenu %v", err)
+	}
+	if _, err := ReadFIXMessage(br); err != nil {
+		t.Errorf("message suivant illisible: %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Application
+// ---------------------------------------------------------------------------
+
+// TestFIXOrderLifecycle couvre NewOrderSingle, executions, replace, cancel
+// et OrderCancelReject.
+func TestFIXOrderLifecycle(t *testing.T) {
+	gw, _ := newTestGateway()
+	_, addr := startFIX(t, gw, FIXOptions{})
+	c1 := dialFIX(t, addr, "BROKER1", "GME")
+	c2 := dialFIX(t, addr, "BROKER2", "GME")
+	if lo := c1.logon(30, true); lo.Get(tagHeartBtInt) != "30" || lo.Get(tagMsgSeqNum) != "1" {
+		t.Fatalf("Logon: %s", lo)
+	}
+	c2.logon(30, true)
+
+	c1.send(newOrderSingle("s-1", "2", 190.00, 100))
+	er := c1.expect(msgExecutionReport)
+	if er.Get(tagExecType) != "0" || er.Get(tagOrdStatus) != "0" || er.Get(tagClOrdID) != "s-1" || er.Get(tagAccount) != "ACC1" {
+		t.Fatalf("attendu New pour s-1, obtenu %s", er)
+	}
+	orderID := er.Get(tagOrderID)
+
+	c2.send(newOrderSingle("b-1", "1", 190.00, 40))
+	c2.expect(msgExecutionReport) // New
+	if er := c2.expect(msgExecutionReport); er.Get(tagExecType) != "F" || er.Get(tagOrdStatus) != "2" || er.Get(tagLastQty) != "40" {
+		t.Errorf("acheteur: attendu Trade/Filled x40, obtenu %s", er)
+	}
+	er = c1.expect(msgExecutionReport)
+	if er.Get(tagExecType) != "F" || er.Get(tagOrdStatus) != "1" || er.Get(tagCumQty) != "40" ||
+		er.Get(tagLeavesQty) != "60" || er.Get(tagAvgPx) != "190" || er.Get(tagOrderID) != orderID {
+		t.Errorf("vendeur: attendu Trade/Partial 40/60 @190, obtenu %s", er)
+	}
+
+	replace := NewFIXMessage(msgOrderCancelReplace).
+		Set(tagOrigClOrdID, "s-1").Set(tagClOrdID, "s-2").Set(tagSymbol, "AAPL").Set(tagSide, "2").
+		SetInt(tagOrderQty, 100).Set(tagOrdType, "2").SetFloat(tagPrice, 191.00)
+	c1.send(replace)
+	er = c1.expect(msgExecutionReport)
+	if er.Get(tagExecType) != "5" || er.Get(tagClOrdID) != "s-2" || er.Get(tagOrigClOrdID) != "s-1" ||
+		er.Get(tagPrice) != "191" || er.Get(tagLeavesQty) != "60" {
+		t.Errorf("attendu Replaced s-1 -> s-2 @191, obtenu %s", er)
+	}
+
+	cancel := NewFIXMessage(msgOrderCancelRequest).
+		Set(tagOrigClOrdID, "s-2").Set(tagClOrdID, "c-1").Set(tagSymbol, "AAPL").Set(tagSide, "2")
+	c1.send(cancel)
+	er = c1.expect(msgExecutionReport)
+	if er.Get(tagExecType) != "4" || er.Get(tagClOrdID) != "c-1" || er.Get(tagOrigClOrdID) != "s-2" || er.Get(tagLeavesQty) != "0" {
+		t.Errorf("attendu Canceled c-1 (orig s-2), obtenu %s", er)
+	}
+
+	// Deja annule : trop tard. Inconnu : ordre inconnu.
+	c1.send(cancel.Set(tagClOrdID, "c-2"))
+	if rj := c1.expect(msgOrderCancelReject); rj.Get(tagCxlRejReason) != "0" || rj.Get(tagOrdStatus) != "4" || rj.Get(tagOrderID) != orderID {
+		t.Errorf("attendu OrderCancelReject too late, obtenu %s", rj)
+	}
+	c1.send(cancel.Set(tagClOrdID, "c-3").Set(tagOrigClOrdID, "inconnu"))
+	if rj := c1.expect(msgOrderCancelReject); rj.Get(tagCxlRejReason) != "1" || rj.Get(tagCxlRejRespTo) != "1" {
+		t.Errorf("attendu OrderCancelReject unknown order, obtenu %s", rj)
+	}
+}
+
+// TestFIXRejects verifie les refus : validation du moteur (ExecutionReport),
+// champ absent (Reject de session), compte d'une autre session.
+func TestFIXRejects(t *testing.T) {
+	gw, _ := newTestGateway()
+	_, addr := startFIX(t, gw, FIXOptions{})
+	c := dialFIX(t, addr, "BROKER1", "GME")
+	c.logon(30, true)
+
+	c.send(newOrderSingle("x-1", "1", -5, 10))
+	if er := c.expect(msgExecutionReport); er.Get(tagExecType) != "8" || !strings.Contains(er.Get(tagText), "price") {
+		t.Errorf("prix invalide: attendu Rejected, obtenu %s", er)
+	}
+	c.send(NewFIXMessage(msgNewOrderSingle).Set(tagClOrdID, "x-2").Set(tagSymbol, "AAPL").Set(tagSide, "1"))
+	if rj := c.expect(msgReject); rj.Get(tagRefTagID) != "38" || rj.Get(tagRejectReason) != "1" {
+		t.Errorf("OrderQty absent: attendu Reject 371=38, obtenu %s", rj)
+	}
+	c.send(newOrderSingle("x-3", "1", 190, 10).Set(tagAccount, "ACC2"))
+	if er := c.expect(msgExecutionReport); er.Get(tagExecType) != "8" || er.Get(tagOrderID) != "NONE" {
+		t.Errorf("compte etranger: attendu Rejected sans OrderID, obtenu %s", er)
+	}
+	if st, ok := gw.OrderByClOrdID("ACC2", "x-3"); ok {
+		t.Errorf("ordre x-3 ne devait pas atteindre le Gateway: %+v", st.Order)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Session
+// ---------------------------------------------------------------------------
+
+// TestFIXSequenceGap verifie ResendRequest sur trou et SequenceReset-GapFill.
+func TestFIXSequenceGap(t *testing.T) {
+	gw, _ := newTestGateway()
+	_, addr := startFIX(t, gw, FIXOptions{})
+	c := dialFIX(t, addr, "BROKER1", "GME")
+	c.logon(30, true) // seq 1
+
+	c.sendSeq(NewFIXMessage(msgTestRequest).Set(tagTestReqID, "perdu"), 5)
+	rr := c.expect(msgResendRequest)
+	if rr.Get(tagBeginSeqNo) != "2" || rr.Get(tagEndSeqNo) != "0" {
+		t.Fatalf("attendu ResendRequest 2..0, obtenu %s", rr)
+	}
+	c.sendSeq(NewFIXMessage(msgSequenceReset).Set(tagGapFillFlag, "Y").SetInt(tagNewSeqNo, 6).Set(tagPossDupFlag, "Y"), 2)
+	c.seq = 6
+	c.send(NewFIXMessage(msgTestRequest).Set(tagTestReqID, "ok"))
+	if hb := c.expect(msgHeartbeat); hb.Get(tagTestReqID) != "ok" {
+		t.Errorf("attendu Heartbeat 112=ok apres GapFill, obtenu %s", hb)
+	}
+
+	// MsgSeqNum trop bas sans PossDup : Logout et deconnexion.
+	c.sendSeq(NewFIXMessage(msgHeartbeat), 3)
+	if lo := c.expect(msgLogout); !strings.Contains(lo.Get(tagText), "trop bas") {
+		t.Errorf("attendu Logout MsgSeqNum trop bas, obtenu %s", lo)
+	}
+}
+
+// TestFIXHeartbeat verifie Heartbeat, TestRequest puis deconnexion d'un
+// client muet.
+func TestFIXHeartbeat(t *testing.T) {
+	gw, _ := newTestGateway()
+	_, addr := startFIX(t, gw, FIXOptions{heartbeatUnit: 50 * time.Millisecond})
+	c := dialFIX(t, addr, "BROKER1", "GME")
+	c.logon(1, true)
+
+	var sawHeartbeat, sawTestRequest bool
+	for {
+		m, err := c.read()
+		if err != nil {
+			break // connexion coupee par l'acceptor
+		}
+		switch m.MsgType() {
+		case msgHeartbeat:
+			sawHeartbeat = true
+		case msgTestRequest:
+			sawTestRequest = m.Get(tagTestReqID) != ""
+		}
+	}
+	if !sawHeartbeat || !sawTestRequest {
+		t.Errorf("attendu Heartbeat et TestRequest avant coupure: %v %v", sawHeartbeat, sawTestRequest)
+	}
+}
+
+// TestFIXRecovery verifie la reprise d'une session apres redemarrage de
+// l'acceptor : sequences persistees, et renvoi des ExecutionReport emis
+// pendant la coupure.
+func TestFIXRecovery(t *testing.T) {
+	dir := t.TempDir()
+	gw, _ := newTestGateway()
+
+	store, err := OpenFileFIXStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, addr := startFIX(t, gw, FIXOptions{}, store)
+	c := dialFIX(t, addr, "BROKER1", "GME")
+	c.logon(30, true)                               // seq 1, recu 1
+	c.send(newOrderSingle("r-1", "2", 190.00, 100)) // seq 2
+	c.expect(msgExecutionReport)                    // recu 2
+	c.send(NewFIXMessage(msgLogout))                // seq 3
+	c.expect(msgLogout)                             // recu 3
+
+	// Execution pendant la coupure : l'ExecutionReport est numerote 4 et
+	// persiste, mais personne n'est connecte.
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190.00, 30))
+	if err := acc.Close(); err != nil { // ferme aussi le store
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileFIXStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.NextSenderSeq() != 5 || store.NextTargetSeq() != 4 {
+		t.Fatalf("sequences relues: emis %d, attendu %d", store.NextSenderSeq(), store.NextTargetSeq())
+	}
+	_, addr = startFIX(t, gw, FIXOptions{}, store)
+
+	// Logon avec une sequence deja consommee : refuse.
+	stale := dialFIX(t, addr, "BROKER1", "GME")
+	stale.seq = 2
+	stale.send(NewFIXMessage(msgLogon).Set(tagEncryptMethod, "0").SetInt(tagHeartBtInt, 30))
+	stale.expect(msgLogout)
+	if _, err := stale.read(); err == nil {
+		t.Fatal("Logon trop bas: connexion attendue fermee")
+	}
+
+	// Le refus a consomme le numero 5 : le vrai client se reconnecte en 4.
+	c = dialFIX(t, addr, "BROKER1", "GME")
+	c.seq = 4
+	lo := c.logon(30, false)
+	if lo.Get(tagMsgSeqNum) != "6" {
+		t.Fatalf("Logon de reprise: attendu 34=6, obtenu %s", lo)
+	}
+	c.send(NewFIXMessage(msgResendRequest).SetInt(tagBeginSeqNo, 4).SetInt(tagEndSeqNo, 0))
+	er := c.expect(msgExecutionReport)
+	if er.Get(tagMsgSeqNum) != "4" || er.Get(tagPossDupFlag) != "Y" || er.Get(tagExecType) != "F" || er.Get(tagLastQty) != "30" {
+		t.Errorf("attendu le fill x30 renvoye en 34=4 avec 43=Y, obtenu %s", er)
+	}
+	if gap := c.expect(msgSequenceReset); gap.Get(tagGapFillFlag) != "Y" || gap.Get(tagNewSeqNo) != "7" {
+		t.Errorf("attendu GapFill jusqu'a 7 (Logout et Logon), obtenu %s", gap)
+	}
+}
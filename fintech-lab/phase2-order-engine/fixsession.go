@@ -0,0 +1,1138 @@
+// fixsession.go — Acceptor FIX 4.4 devant le Gateway.
+//
+// COUCHE SESSION
+//   - Logon (A) : premier message obligatoire. CompIDs connus, HeartBtInt > 0.
+//     ResetSeqNumFlag (141=Y) remet les deux sequences a 1.
+//   - Chaque message recu doit porter le MsgSeqNum attendu :
+//     trop haut -> ResendRequest (2) puis on ignore jusqu'au comblement ;
+//     trop bas  -> Logout, sauf PossDupFlag (43=Y) : doublon ignore.
+//   - SequenceReset (4) : GapFill (123=Y) saute les messages admin du pair,
+//     Reset force la sequence attendue.
+//   - ResendRequest recu : messages applicatifs renvoyes avec 43=Y, messages
+//     admin remplaces par un SequenceReset-GapFill.
+//   - Heartbeat (0) apres HeartBtInt sans emission ; TestRequest (1) apres
+//     HeartBtInt (+20%) sans reception ; deconnexion si toujours muet.
+//   - Logout (5) dans les deux sens.
+//   Sequences et messages emis sont persistes (FIXStore) : une session
+//   reprend apres un redemarrage de l'acceptor.
+//
+// APPLICATION
+//   NewOrderSingle (D), OrderCancelRequest (F), OrderCancelReplaceRequest (G)
+//   -> Submit, CancelByClOrdID, ReplaceByClOrdID. Le compte vient de la
+//   session, jamais du message. Les ExecutionReport (8) sont produits a
+//   partir des Events du Gateway ; un refus d'annulation ou de remplacement
+//   donne un OrderCancelReject (9). Seuls les ordres avec ClOrdID sont
+//   rapportes.
+//
+// Lancer : go run ./phase2-order-engine/ fix -addr :9878 -sessions GME:BROKER1=ACC1
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Valeurs par defaut des options FIX.
+const (
+	defaultFIXLogonTimeout = 10 * time.Second
+	defaultFIXSendQueue    = 1024
+)
+
+// FIXSessionConfig decrit une session autorisee.
+type FIXSessionConfig struct {
+	SenderCompID string   // Notre CompID (49 de nos messages)
+	TargetCompID string   // CompID du client
+	Account      string   // Compte des ordres de la session
+	Store        FIXStore // nil : MemoryFIXStore
+}
+
+// FIXOptions regle l'acceptor. Les zeros prennent les defauts.
+type FIXOptions struct {
+	LogonTimeout time.Duration // Delai pour recevoir le Logon
+	SendQueue    int           // Messages en attente d'ecriture avant deconnexion
+	Log          io.Writer     // Journal des sessions (nil : aucun)
+
+	heartbeatUnit time.Duration // Duree d'une unite de HeartBtInt (1s, raccourcie en test)
+}
+
+// fixSessionID identifie une session par ses CompIDs (vus de l'acceptor).
+type fixSessionID struct {
+	sender string
+	target string
+}
+
+// ---------------------------------------------------------------------------
+// FIXAcceptor
+// ---------------------------------------------------------------------------
+
+// FIXAcceptor accepte les connexions FIX et les route vers le Gateway.
+type FIXAcceptor struct {
+	gw        *Gateway
+	opts      FIXOptions
+	sessions  map[fixSessionID]*fixSession
+	byAccount map[string]*fixSession
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewFIXAcceptor cree un acceptor pour les sessions donnees (un compte par
+// session) et s'abonne aux Events du Gateway.
+func NewFIXAcceptor(gw *Gateway, sessions []FIXSessionConfig, opts FIXOptions) (*FIXAcceptor, error) {
+	if opts.LogonTimeout <= 0 {
+		opts.LogonTimeout = defaultFIXLogonTimeout
+	}
+	if opts.SendQueue <= 0 {
+		opts.SendQueue = defaultFIXSendQueue
+	}
+	if opts.heartbeatUnit <= 0 {
+		opts.heartbeatUnit = time.Second
+	}
+	a := &FIXAcceptor{
+		gw:        gw,
+		opts:      opts,
+		sessions:  make(map[fixSessionID]*fixSession),
+		byAccount: make(map[string]*fixSession),
+	}
+	for _, cfg := range sessions {
+		if cfg.SenderCompID == "" || cfg.TargetCompID == "" || cfg.Account == "" {
+			return nil, fmt.Errorf("fix: session incomplete %+v", cfg)
+		}
+		id := fixSessionID{cfg.SenderCompID, cfg.TargetCompID}
+		if _, dup := a.sessions[id]; dup {
+			return nil, fmt.Errorf("fix: session %s->%s declaree deux fois", id.sender, id.target)
+		}
+		if _, dup := a.byAccount[cfg.Account]; dup {
+			return nil, fmt.Errorf("fix: compte %q deja attribue a une session", cfg.Account)
+		}
+		if cfg.Store == nil {
+			cfg.Store = NewMemoryFIXStore()
+		}
+		s := &fixSession{
+			cfg:      cfg,
+			acc:      a,
+			orders:   make(map[uint64]*fixOrder),
+			cancels:  make(map[string]string),
+			replaces: make(map[string]string),
+		}
+		a.sessions[id] = s
+		a.byAccount[cfg.Account] = s
+	}
+	gw.Subscribe(a.onEvent)
+	return a, nil
+}
+
+// Serve accepte les connexions de l, jusqu'a Close.
+func (a *FIXAcceptor) Serve(l net.Listener) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return net.ErrClosed
+	}
+	a.listeners = append(a.listeners, l)
+	a.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			a.mu.Lock()
+			closed := a.closed
+			a.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.handle(nc)
+		}()
+	}
+}
+
+// Close deconnecte proprement les sessions (Logout), arrete l'ecoute et
+// ferme les stores.
+func (a *FIXAcceptor) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	for _, l := range a.listeners {
+		l.Close()
+	}
+	a.mu.Unlock()
+
+	for _, s := range a.sessions {
+		s.mu.Lock()
+		if s.conn != nil {
+			s.logoutLocked(s.conn, "arret de l'acceptor")
+		}
+		s.mu.Unlock()
+	}
+	a.wg.Wait()
+
+	var errs []error
+	for _, s := range a.sessions {
+		errs = append(errs, s.cfg.Store.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (a *FIXAcceptor) logf(format string, args ...any) {
+	if a.opts.Log != nil {
+		fmt.Fprintf(a.opts.Log, "[FIX] "+format+"\n", args...)
+	}
+}
+
+// handle attend le Logon puis lit la session jusqu'a la deconnexion.
+func (a *FIXAcceptor) handle(nc net.Conn) {
+	br := bufio.NewReader(nc)
+	nc.SetReadDeadline(time.Now().Add(a.opts.LogonTimeout))
+	m, err := ReadFIXMessage(br)
+	if err != nil || m.MsgType() != msgLogon {
+		a.logf("%s: Logon attendu (%v)", nc.RemoteAddr(), err)
+		nc.Close()
+		return
+	}
+	s, ok := a.sessions[fixSessionID{m.Get(tagTargetCompID), m.Get(tagSenderCompID)}]
+	if !ok {
+		a.logf("%s: session inconnue %s->%s", nc.RemoteAddr(), m.Get(tagSenderCompID), m.Get(tagTargetCompID))
+		nc.Close()
+		return
+	}
+	a.mu.Lock()
+	closed := a.closed
+	a.mu.Unlock()
+	if closed {
+		nc.Close()
+		return
+	}
+	nc.SetReadDeadline(time.Time{})
+
+	c, err := s.logon(nc, m)
+	if c == nil {
+		a.logf("%s: %v", s.name(), err)
+		nc.Close()
+		return
+	}
+	if err == nil {
+		a.logf("%s: connecte (HeartBtInt %v)", s.name(), c.heartBt)
+		s.readLoop(c, br)
+	} else {
+		a.logf("%s: %v", s.name(), err)
+	}
+	s.disconnect(c)
+	a.logf("%s: deconnecte", s.name())
+}
+
+// onEvent transforme un Event en ExecutionReport pour la session du compte.
+// Appele avec gw.mu tenu.
+func (a *FIXAcceptor) onEvent(e Event) {
+	s, ok := a.byAccount[e.Account]
+	if !ok || e.ClOrdID == "" || e.OrderID == 0 {
+		return
+	}
+	a.mu.Lock()
+	closed := a.closed
+	a.mu.Unlock()
+	if closed { // Le Gateway n'a pas de desabonnement
+		return
+	}
+	s.report(e)
+}
+
+// ---------------------------------------------------------------------------
+// fixConn — une connexion TCP authentifiee
+// ---------------------------------------------------------------------------
+
+type fixConn struct {
+	nc         net.Conn
+	heartBt    time.Duration
+	out        chan []byte // nil : fin d'ecriture (hangup)
+	done       chan struct{}
+	writerDone chan struct{}
+	killOnce   sync.Once
+	hangOnce   sync.Once
+
+	lastSent  atomic.Int64
+	lastRecv  atomic.Int64
+	testReqAt atomic.Int64 // TestRequest en attente de reponse (0 : aucun)
+
+	// Proteges par fixSession.mu
+	logoutSent  bool
+	resendUntil int // Comblement de trou en cours jusqu'a ce MsgSeqNum
+}
+
+func newFIXConn(nc net.Conn, heartBt time.Duration, queue int) *fixConn {
+	c := &fixConn{
+		nc:         nc,
+		heartBt:    heartBt,
+		out:        make(chan []byte, queue),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	now := time.Now().UnixNano()
+	c.lastSent.Store(now)
+	c.lastRecv.Store(now)
+	return c
+}
+
+// enqueue met un message en file sans bloquer. File pleine : le client ne
+// lit plus, la connexion est coupee (les messages restent dans le store).
+func (c *fixConn) enqueue(raw []byte) {
+	select {
+	case c.out <- raw:
+	case <-c.done:
+	default:
+		c.kill()
+	}
+}
+
+// hangup ferme la connexion apres envoi des messages deja en file.
+func (c *fixConn) hangup() {
+	c.hangOnce.Do(func() { c.enqueue(nil) })
+}
+
+// kill ferme la connexion immediatement.
+func (c *fixConn) kill() {
+	c.killOnce.Do(func() {
+		close(c.done)
+		c.nc.Close()
+	})
+}
+
+// ---------------------------------------------------------------------------
+// fixSession
+// ---------------------------------------------------------------------------
+
+// fixOrder suit un ordre de la session pour remplir CumQty, LeavesQty, AvgPx.
+type fixOrder struct {
+	price    float64
+	qty      int64
+	cum      int64
+	notional float64
+	execs    int // Numero du prochain ExecID de l'ordre
+}
+
+type fixSession struct {
+	cfg FIXSessionConfig
+	acc *FIXAcceptor
+
+	mu       sync.Mutex
+	conn     *fixConn // nil hors connexion
+	orders   map[uint64]*fixOrder
+	cancels  map[string]string // ClOrdID vise -> ClOrdID de l'OrderCancelRequest en cours
+	replaces map[string]string // Nouveau ClOrdID -> OrigClOrdID du replace en cours
+}
+
+func (s *fixSession) name() string {
+	return s.cfg.SenderCompID + "->" + s.cfg.TargetCompID
+}
+
+// logon valide le Logon recu et y repond. c nil : connexion refusee sans
+// reponse ; c non nil avec err : Logout envoye, a deconnecter.
+func (s *fixSession) logon(nc net.Conn, m *FIXMessage) (*fixConn, error) {
+	hb, err := m.Int(tagHeartBtInt)
+	if err != nil || hb <= 0 {
+		return nil, fmt.Errorf("HeartBtInt invalide %q", m.Get(tagHeartBtInt))
+	}
+	seq, err := m.Int(tagMsgSeqNum)
+	if err != nil {
+		return nil, fmt.Errorf("MsgSeqNum invalide %q", m.Get(tagMsgSeqNum))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return nil, fmt.Errorf("session deja connectee")
+	}
+	c := newFIXConn(nc, time.Duration(hb)*s.acc.opts.heartbeatUnit, s.acc.opts.SendQueue)
+	s.conn = c
+	go s.writeLoop(c)
+
+	reset := m.Get(tagResetSeqNumFlag) == "Y"
+	if reset {
+		if err := s.cfg.Store.Reset(); err != nil {
+			return c, err
+		}
+	}
+	expected := s.cfg.Store.NextTargetSeq()
+	if int(seq) < expected {
+		text := fmt.Sprintf("MsgSeqNum trop bas: %d, attendu %d", seq, expected)
+		s.logoutLocked(c, text)
+		return c, errors.New(text)
+	}
+
+	reply := NewFIXMessage(msgLogon).Set(tagEncryptMethod, "0").SetInt(tagHeartBtInt, hb)
+	if reset {
+		reply.Set(tagResetSeqNumFlag, "Y")
+	}
+	s.sendLocked(reply)
+	if int(seq) > expected {
+		s.requestResendLocked(c, expected, int(seq))
+	} else {
+		s.cfg.Store.SetNextTargetSeq(int(seq) + 1)
+	}
+	return c, nil
+}
+
+// disconnect attend la fin de l'ecriture et detache la connexion.
+func (s *fixSession) disconnect(c *fixConn) {
+	c.hangup()
+	select {
+	case <-c.writerDone:
+	case <-time.After(c.heartBt + time.Second):
+		c.kill()
+		<-c.writerDone
+	}
+	s.mu.Lock()
+	if s.conn == c {
+		s.conn = nil
+	}
+	s.mu.Unlock()
+}
+
+// writeLoop ecrit les messages en file et gere heartbeats et TestRequest.
+// C'est lui qui ferme la socket.
+func (s *fixSession) writeLoop(c *fixConn) {
+	defer close(c.writerDone)
+	defer c.nc.Close()
+
+	tick := time.NewTicker(max(c.heartBt/10, time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case raw := <-c.out:
+			if raw == nil {
+				return
+			}
+			c.nc.SetWriteDeadline(time.Now().Add(c.heartBt))
+			if _, err := c.nc.Write(raw); err != nil {
+				return
+			}
+			c.lastSent.Store(time.Now().UnixNano())
+		case <-tick.C:
+			if !s.heartbeat(c) {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// heartbeat envoie Heartbeat et TestRequest selon les silences des deux
+// cotes. false : le client ne repond plus.
+func (s *fixSession) heartbeat(c *fixConn) bool {
+	now := time.Now().UnixNano()
+	hb := int64(c.heartBt)
+	silence := now - c.lastRecv.Load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case c.testReqAt.Load() != 0 && silence > 2*hb+hb/5:
+		s.acc.logf("%s: pas de reponse au TestRequest", s.name())
+		return false
+	case c.testReqAt.Load() == 0 && silence > hb+hb/5:
+		c.testReqAt.Store(now)
+		s.sendLocked(NewFIXMessage(msgTestRequest).Set(tagTestReqID, strconv.FormatInt(now, 10)))
+	case now-c.lastSent.Load() >= hb:
+		s.sendLocked(NewFIXMessage(msgHeartbeat))
+	}
+	return true
+}
+
+// readLoop traite les messages du client jusqu'au Logout ou a la coupure.
+func (s *fixSession) readLoop(c *fixConn, br *bufio.Reader) {
+	for {
+		m, err := ReadFIXMessage(br)
+		if errors.Is(err, ErrFIXGarbled) {
+			s.acc.logf("%s: %v", s.name(), err)
+			continue
+		}
+		if err != nil {
+			return
+		}
+		c.lastRecv.Store(time.Now().UnixNano())
+		c.testReqAt.Store(0)
+		if !s.process(c, m) {
+			return
+		}
+	}
+}
+
+// process applique les regles de sequence puis route le message.
+// false : fin de session.
+func (s *fixSession) process(c *fixConn, m *FIXMessage) bool {
+	s.mu.Lock()
+	if m.Get(tagSenderCompID) != s.cfg.TargetCompID || m.Get(tagTargetCompID) != s.cfg.SenderCompID {
+		s.rejectLocked(m, tagSenderCompID, 9, "CompID incorrect")
+		s.logoutLocked(c, "CompID incorrect")
+		s.mu.Unlock()
+		return false
+	}
+	seq64, err := m.Int(tagMsgSeqNum)
+	if err != nil {
+		s.logoutLocked(c, "MsgSeqNum absent ou invalide")
+		s.mu.Unlock()
+		return false
+	}
+	seq, msgType := int(seq64), m.MsgType()
+	expected := s.cfg.Store.NextTargetSeq()
+
+	// SequenceReset-Reset : ignore MsgSeqNum.
+	if msgType == msgSequenceReset && m.Get(tagGapFillFlag) != "Y" {
+		s.sequenceResetLocked(m, expected)
+		s.mu.Unlock()
+		return true
+	}
+	switch {
+	case seq > expected:
+		if msgType == msgLogout {
+			s.logoutLocked(c, "")
+			s.mu.Unlock()
+			return false
+		}
+		if c.resendUntil < expected {
+			s.requestResendLocked(c, expected, seq)
+		}
+		s.mu.Unlock()
+		return true
+	case seq < expected:
+		if m.Get(tagPossDupFlag) == "Y" {
+			s.mu.Unlock()
+			return true
+		}
+		s.logoutLocked(c, fmt.Sprintf("MsgSeqNum trop bas: %d, attendu %d", seq, expected))
+		s.mu.Unlock()
+		return false
+	}
+
+	if msgType == msgSequenceReset { // GapFill
+		s.sequenceResetLocked(m, expected)
+		s.mu.Unlock()
+		return true
+	}
+	s.cfg.Store.SetNextTargetSeq(seq + 1)
+
+	switch msgType {
+	case msgHeartbeat, msgReject:
+	case msgTestRequest:
+		s.sendLocked(NewFIXMessage(msgHeartbeat).Set(tagTestReqID, m.Get(tagTestReqID)))
+	case msgResendRequest:
+		s.resendLocked(c, m)
+	case msgLogout:
+		s.logoutLocked(c, "")
+		s.mu.Unlock()
+		return false
+	case msgLogon:
+		s.rejectLocked(m, tagMsgType, 5, "session deja ouverte")
+	case msgNewOrderSingle, msgOrderCancelRequest, msgOrderCancelReplace:
+		// Le Gateway emet des Events traites sous s.mu : le relacher.
+		s.mu.Unlock()
+		s.application(m)
+		return true
+	default:
+		s.rejectLocked(m, tagMsgType, 11, fmt.Sprintf("MsgType %q non supporte", msgType))
+	}
+	s.mu.Unlock()
+	return true
+}
+
+// sequenceResetLocked applique un SequenceReset (GapFill ou Reset).
+func (s *fixSession) sequenceResetLocked(m *FIXMessage, expected int) {
+	newSeq, err := m.Int(tagNewSeqNo)
+	switch {
+	case err != nil:
+		s.rejectLocked(m, tagNewSeqNo, 1, "NewSeqNo absent ou invalide")
+	case int(newSeq) < expected:
+		s.rejectLocked(m, tagNewSeqNo, 5, fmt.Sprintf("NewSeqNo %d inferieur au MsgSeqNum attendu %d", newSeq, expected))
+	default:
+		s.cfg.Store.SetNextTargetSeq(int(newSeq))
+	}
+}
+
+// requestResendLocked demande au client les messages a partir de begin.
+func (s *fixSession) requestResendLocked(c *fixConn, begin, received int) {
+	c.resendUntil = received
+	s.sendLocked(NewFIXMessage(msgResendRequest).SetInt(tagBeginSeqNo, int64(begin)).SetInt(tagEndSeqNo, 0))
+}
+
+// resendLocked repond a un ResendRequest.
+func (s *fixSession) resendLocked(c *fixConn, m *FIXMessage) {
+	begin, err1 := m.Int(tagBeginSeqNo)
+	end, err2 := m.Int(tagEndSeqNo)
+	if err1 != nil || err2 != nil || begin < 1 {
+		s.rejectLocked(m, tagBeginSeqNo, 5, "BeginSeqNo/EndSeqNo invalides")
+		return
+	}
+	last := s.cfg.Store.NextSenderSeq() - 1
+	if end == 0 || int(end) > last {
+		end = int64(last)
+	}
+	stored, err := s.cfg.Store.Messages(int(begin), int(end))
+	if err != nil {
+		s.acc.logf("%s: resend impossible: %v", s.name(), err)
+		return
+	}
+
+	gapStart := 0
+	flushGap := func(next int) {
+		if gapStart != 0 {
+			gap := NewFIXMessage(msgSequenceReset).Set(tagGapFillFlag, "Y").SetInt(tagNewSeqNo, int64(next))
+			c.enqueue(s.frame(gap, gapStart, true, "").Encode())
+			gapStart = 0
+		}
+	}
+	for seq := int(begin); seq <= int(end); seq++ {
+		var orig *FIXMessage
+		if raw, ok := stored[seq]; ok {
+			orig, _ = ReadFIXMessage(bufio.NewReader(bytes.NewReader(raw)))
+		}
+		if orig == nil || isAdminMsg(orig.MsgType()) {
+			if gapStart == 0 {
+				gapStart = seq
+			}
+			continue
+		}
+		flushGap(seq)
+		c.enqueue(s.frame(orig, seq, true, orig.Get(tagSendingTime)).Encode())
+	}
+	flushGap(int(end) + 1)
+}
+
+// frame construit un message avec l'en-tete de la session. possDup : renvoi
+// (PossDupFlag, et OrigSendingTime si origSending n'est pas vide).
+func (s *fixSession) frame(m *FIXMessage, seq int, possDup bool, origSending string) *FIXMessage {
+	out := NewFIXMessage(m.MsgType())
+	out.Set(tagSenderCompID, s.cfg.SenderCompID)
+	out.Set(tagTargetCompID, s.cfg.TargetCompID)
+	out.SetInt(tagMsgSeqNum, int64(seq))
+	out.Set(tagSendingTime, time.Now().UTC().Format(fixTimeFormat))
+	if possDup {
+		out.Set(tagPossDupFlag, "Y")
+	}
+	if origSending != "" {
+		out.Set(tagOrigSendingTime, origSending)
+	}
+	for _, f := range m.Fields {
+		switch f.Tag {
+		case tagMsgType, tagSenderCompID, tagTargetCompID, tagMsgSeqNum, tagSendingTime,
+			tagPossDupFlag, tagOrigSendingTime:
+			continue
+		}
+		out.Fields = append(out.Fields, f)
+	}
+	return out
+}
+
+// sendLocked numerote, persiste et envoie un message. Hors connexion, le
+// message est seulement persiste : il sera renvoye sur ResendRequest.
+func (s *fixSession) sendLocked(m *FIXMessage) {
+	seq := s.cfg.Store.NextSenderSeq()
+	raw := s.frame(m, seq, false, "").Encode()
+	if err := s.cfg.Store.SaveMessage(seq, raw); err != nil {
+		s.acc.logf("%s: persistance du message %d: %v", s.name(), seq, err)
+	}
+	s.cfg.Store.SetNextSenderSeq(seq + 1)
+	if s.conn != nil {
+		s.conn.enqueue(raw)
+	}
+}
+
+func (s *fixSession) send(m *FIXMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked(m)
+}
+
+// rejectLocked envoie un Reject de session (35=3).
+func (s *fixSession) rejectLocked(m *FIXMessage, tag, reason int, text string) {
+	r := NewFIXMessage(msgReject).Set(tagRefSeqNum, m.Get(tagMsgSeqNum)).Set(tagRefMsgType, m.MsgType())
+	if tag > 0 {
+		r.SetInt(tagRefTagID, int64(tag))
+	}
+	r.SetInt(tagRejectReason, int64(reason)).Set(tagText, text)
+	s.sendLocked(r)
+}
+
+// logoutLocked envoie un Logout (sauf si deja envoye : le Logout recu est
+// alors la reponse au notre) puis raccroche.
+func (s *fixSession) logoutLocked(c *fixConn, text string) {
+	if !c.logoutSent {
+		c.logoutSent = true
+		lo := NewFIXMessage(msgLogout)
+		if text != "" {
+			lo.Set(tagText, text)
+		}
+		s.sendLocked(lo)
+	}
+	c.hangup()
+}
+
+// ---------------------------------------------------------------------------
+// Application : ordres entrants
+// ---------------------------------------------------------------------------
+
+// missingTag retourne le premier tag requis absent (0 si complet).
+func missingTag(m *FIXMessage, tags ...int) int {
+	for _, t := range tags {
+		if v, ok := m.Lookup(t); !ok || v == "" {
+			return t
+		}
+	}
+	return 0
+}
+
+// application route un message applicatif vers le Gateway. Appele sans
+// s.mu : les Events produits reviennent par report.
+func (s *fixSession) application(m *FIXMessage) {
+	switch m.MsgType() {
+	case msgNewOrderSingle:
+		s.newOrder(m)
+	case msgOrderCancelRequest:
+		s.cancelOrder(m)
+	case msgOrderCancelReplace:
+		s.replaceOrder(m)
+	}
+}
+
+// reject envoie un Reject de session hors verrou.
+func (s *fixSession) reject(m *FIXMessage, tag, reason int, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectLocked(m, tag, reason, text)
+}
+
+func (s *fixSession) newOrder(m *FIXMessage) {
+	if tag := missingTag(m, tagClOrdID, tagSymbol, tagSide, tagOrderQty, tagOrdType); tag != 0 {
+		s.reject(m, tag, 1, "champ requis absent")
+		return
+	}
+	side, ok := fixSideIn(m.Get(tagSide))
+	if !ok {
+		s.reject(m, tagSide, 5, fmt.Sprintf("Side %q non supporte", m.Get(tagSide)))
+		return
+	}
+	qty, err := m.Int(tagOrderQty)
+	if err != nil {
+		s.reject(m, tagOrderQty, 6, "OrderQty invalide")
+		return
+	}
+	o := &Order{
+		Account:  s.cfg.Account,
+		ClOrdID:  m.Get(tagClOrdID),
+		Symbol:   m.Get(tagSymbol),
+		Side:     side,
+		Status:   StatusOpen,
+		Quantity: qty,
+	}
+
+	var priceTag int
+	switch m.Get(tagOrdType) {
+	case "1":
+		o.Type = Market
+	case "2":
+		o.Type, priceTag = Limit, tagPrice
+	case "3":
+		o.Type, priceTag = Stop, tagStopPx
+	default:
+		s.reject(m, tagOrdType, 5, fmt.Sprintf("OrdType %q non supporte", m.Get(tagOrdType)))
+		return
+	}
+	if priceTag != 0 {
+		if missingTag(m, priceTag) != 0 {
+			s.reject(m, priceTag, 1, "champ requis absent")
+			return
+		}
+		px, err := strconv.ParseFloat(m.Get(priceTag), 64)
+		if err != nil {
+			s.reject(m, priceTag, 6, "prix invalide")
+			return
+		}
+		if o.Type == Stop {
+			o.StopPrice = px
+		} else {
+			o.Price = px
+		}
+	}
+	switch m.Get(tagTimeInForce) {
+	case "", "0", "1": // Day, GTC : le carnet ne gere pas d'expiration
+	case "3":
+		if o.Type == Limit {
+			o.Type = IOC
+		}
+	default:
+		s.reject(m, tagTimeInForce, 5, fmt.Sprintf("TimeInForce %q non supporte", m.Get(tagTimeInForce)))
+		return
+	}
+	if acct, ok := m.Lookup(tagAccount); ok && acct != s.cfg.Account {
+		s.send(s.execReject(m, fmt.Sprintf("compte %q non autorise pour la session", acct)))
+		return
+	}
+
+	// Un refus du Gateway revient en Event (EventRejected) : rien a faire ici.
+	s.acc.gw.Submit(o)
+}
+
+// execReject construit un ExecutionReport de refus pour un ordre qui n'a
+// pas atteint le Gateway.
+func (s *fixSession) execReject(m *FIXMessage, text string) *FIXMessage {
+	return NewFIXMessage(msgExecutionReport).
+		Set(tagOrderID, "NONE").
+		Set(tagClOrdID, m.Get(tagClOrdID)).
+		Set(tagExecID, "NONE").
+		Set(tagExecType, "8").
+		Set(tagOrdStatus, "8").
+		Set(tagSymbol, m.Get(tagSymbol)).
+		Set(tagSide, m.Get(tagSide)).
+		Set(tagOrderQty, m.Get(tagOrderQty)).
+		SetInt(tagCumQty, 0).
+		SetInt(tagLeavesQty, 0).
+		SetInt(tagAvgPx, 0).
+		Set(tagOrdRejReason, "99").
+		Set(tagText, text)
+}
+
+func (s *fixSession) cancelOrder(m *FIXMessage) {
+	if tag := missingTag(m, tagOrigClOrdID, tagClOrdID, tagSymbol, tagSide); tag != 0 {
+		s.reject(m, tag, 1, "champ requis absent")
+		return
+	}
+	orig := m.Get(tagOrigClOrdID)
+	s.mu.Lock()
+	s.cancels[orig] = m.Get(tagClOrdID)
+	s.mu.Unlock()
+
+	err := s.acc.gw.CancelByClOrdID(s.cfg.Account, orig)
+
+	s.mu.Lock()
+	delete(s.cancels, orig)
+	s.mu.Unlock()
+	if err != nil {
+		s.cancelReject(m, "1", err)
+	}
+}
+
+func (s *fixSession) replaceOrder(m *FIXMessage) {
+	if tag := missingTag(m, tagOrigClOrdID, tagClOrdID, tagSymbol, tagSide, tagOrderQty, tagOrdType); tag != 0 {
+		s.reject(m, tag, 1, "champ requis absent")
+		return
+	}
+	if m.Get(tagOrdType) != "2" {
+		s.cancelReject(m, "2", fmt.Errorf("seul un ordre limite est modifiable (OrdType %q)", m.Get(tagOrdType)))
+		return
+	}
+	qty, err := m.Int(tagOrderQty)
+	if err != nil {
+		s.reject(m, tagOrderQty, 6, "OrderQty invalide")
+		return
+	}
+	var price float64
+	if v, ok := m.Lookup(tagPrice); ok {
+		if price, err = strconv.ParseFloat(v, 64); err != nil {
+			s.reject(m, tagPrice, 6, "prix invalide")
+			return
+		}
+	}
+
+	clOrdID := m.Get(tagClOrdID)
+	s.mu.Lock()
+	s.replaces[clOrdID] = m.Get(tagOrigClOrdID)
+	s.mu.Unlock()
+
+	_, err = s.acc.gw.ReplaceByClOrdID(s.cfg.Account, m.Get(tagOrigClOrdID), clOrdID, price, qty)
+
+	s.mu.Lock()
+	delete(s.replaces, clOrdID)
+	s.mu.Unlock()
+	if err != nil {
+		s.cancelReject(m, "2", err)
+	}
+}
+
+// cancelReject envoie un OrderCancelReject. respTo : 1 = annulation,
+// 2 = remplacement.
+func (s *fixSession) cancelReject(m *FIXMessage, respTo string, err error) {
+	r := NewFIXMessage(msgOrderCancelReject).
+		Set(tagOrderID, "NONE").
+		Set(tagClOrdID, m.Get(tagClOrdID)).
+		Set(tagOrigClOrdID, m.Get(tagOrigClOrdID)).
+		Set(tagOrdStatus, "8").
+		Set(tagCxlRejRespTo, respTo).
+		Set(tagCxlRejReason, "99").
+		Set(tagText, err.Error())
+	if st, ok := s.acc.gw.OrderByClOrdID(s.cfg.Account, m.Get(tagOrigClOrdID)); ok {
+		r.SetInt(tagOrderID, int64(st.Order.ID))
+		r.Set(tagOrdStatus, fixOrdStatus(st.Order.Status))
+		if errors.Is(err, ErrOrderNotFound) {
+			r.Set(tagCxlRejReason, "0") // Too late to cancel
+		}
+	} else {
+		r.Set(tagCxlRejReason, "1") // Unknown order
+	}
+	s.send(r)
+}
+
+// ---------------------------------------------------------------------------
+// Application : ExecutionReport
+// ---------------------------------------------------------------------------
+
+// report traduit un Event en ExecutionReport. Appele avec gw.mu tenu.
+func (s *fixSession) report(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.orders[e.OrderID]
+	clOrdID, origClOrdID := e.ClOrdID, ""
+	var execType, status string
+	switch e.Type {
+	case EventAccepted:
+		o = &fixOrder{price: e.Price, qty: e.Quantity}
+		s.orders[e.OrderID] = o
+		execType = "0"
+	case EventRejected:
+		if o == nil {
+			o = &fixOrder{price: e.Price, qty: e.Quantity}
+		}
+		execType, status = "8", "8"
+	case EventFilled:
+		if o == nil {
+			return
+		}
+		o.cum += e.Quantity
+		o.notional += e.Price * float64(e.Quantity)
+		execType = "F"
+	case EventCancelled:
+		if o == nil {
+			return
+		}
+		if id, ok := s.cancels[e.ClOrdID]; ok {
+			clOrdID, origClOrdID = id, e.ClOrdID
+		}
+		execType, status = "4", "4"
+	case EventAmended:
+		if o == nil {
+			return
+		}
+		o.price, o.qty = e.Price, e.Quantity
+		origClOrdID = s.replaces[e.ClOrdID]
+		execType = "5"
+	case EventTriggered:
+		if o == nil {
+			return
+		}
+		execType = "L"
+	default:
+		return
+	}
+	if status == "" {
+		status = "0"
+		switch {
+		case o.cum >= o.qty:
+			status = "2"
+		case o.cum > 0:
+			status = "1"
+		}
+	}
+	leaves := o.qty - o.cum
+	if status == "2" || status == "4" || status == "8" {
+		leaves = 0
+		delete(s.orders, e.OrderID)
+	}
+	o.execs++
+
+	r := NewFIXMessage(msgExecutionReport).
+		SetInt(tagOrderID, int64(e.OrderID)).
+		Set(tagClOrdID, clOrdID)
+	if origClOrdID != "" {
+		r.Set(tagOrigClOrdID, origClOrdID)
+	}
+	r.Set(tagExecID, fmt.Sprintf("%d-%d", e.OrderID, o.execs)).
+		Set(tagExecType, execType).
+		Set(tagOrdStatus, status).
+		Set(tagAccount, e.Account).
+		Set(tagSymbol, e.Symbol).
+		Set(tagSide, fixSideOut(e.Side))
+	ordType, tif := fixOrdTypeOut(e.OrderType)
+	r.Set(tagOrdType, ordType)
+	if tif != "" {
+		r.Set(tagTimeInForce, tif)
+	}
+	if o.price > 0 {
+		r.SetFloat(tagPrice, o.price)
+	}
+	r.SetInt(tagOrderQty, o.qty).
+		SetInt(tagCumQty, o.cum).
+		SetInt(tagLeavesQty, leaves)
+	avg := 0.0
+	if o.cum > 0 {
+		avg = o.notional / float64(o.cum)
+	}
+	r.SetFloat(tagAvgPx, avg)
+	if e.Type == EventFilled {
+		r.SetFloat(tagLastPx, e.Price).
+			SetInt(tagLastQty, e.Quantity).
+			SetInt(tagTrdMatchID, int64(e.TradeID))
+	}
+	if e.Type == EventRejected {
+		r.Set(tagOrdRejReason, "99")
+	}
+	if e.Reason != "" {
+		r.Set(tagText, e.Reason)
+	}
+	r.Set(tagTransactTime, fixTime(e.Timestamp))
+	s.sendLocked(r)
+}
+
+// ---------------------------------------------------------------------------
+// Correspondances FIX <-> moteur
+// ---------------------------------------------------------------------------
+
+func fixSideIn(v string) (Side, bool) {
+	switch v {
+	case "1":
+		return Buy, true
+	case "2":
+		return Sell, true
+	}
+	return "", false
+}
+
+func fixSideOut(s Side) string {
+	if s == Buy {
+		return "1"
+	}
+	return "2"
+}
+
+// fixOrdTypeOut retourne OrdType (40) et TimeInForce (59, "" si defaut).
+func fixOrdTypeOut(t OrderType) (string, string) {
+	switch t {
+	case Market:
+		return "1", ""
+	case IOC:
+		return "2", "3"
+	case Stop:
+		return "3", ""
+	}
+	return "2", ""
+}
+
+func fixOrdStatus(st OrderStatus) string {
+	switch st {
+	case StatusPartial:
+		return "1"
+	case StatusFilled:
+		return "2"
+	case StatusCancelled:
+		return "4"
+	case StatusRejected:
+		return "8"
+	}
+	return "0"
+}
+
+// ---------------------------------------------------------------------------
+// Sous-commande fix
+// ---------------------------------------------------------------------------
+
+// runFIX demarre l'acceptor FIX sur un Gateway neuf, jusqu'a Ctrl+C.
+func runFIX(args []string) error {
+	fs := flag.NewFlagSet("fix", flag.ContinueOnError)
+	addr := fs.String("addr", ":9878", "adresse d'ecoute")
+	symbols := fs.String("symbols", "AAPL,MSFT,TSLA", "symboles routes, separes par des virgules (SYM:DEVISE, defaut USD)")
+	sessions := fs.String("sessions", "", "NOUS:CLIENT=compte, separes par des virgules")
+	storeDir := fs.String("store", "", "repertoire de persistance des sessions (vide : memoire)")
+	itchFeed := fs.String("itch", "", "adresse UDP du flux ITCH (multicast ou unicast, vide : pas de flux)")
+	itchRetrans := fs.String("itch-retrans", "", "adresse TCP de retransmission ITCH")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfgs, err := parseFIXSessions(*sessions)
+	if err != nil {
+		return fmt.Errorf("fix: %w", err)
+	}
+	for i := range cfgs {
+		if *storeDir == "" {
+			continue
+		}
+		store, err := OpenFileFIXStore(filepath.Join(*storeDir, cfgs[i].SenderCompID+"-"+cfgs[i].TargetCompID))
+		if err != nil {
+			return err
+		}
+		cfgs[i].Store = store
+	}
+
+	instruments, err := ParseInstruments(*symbols)
+	if err != nil {
+		return fmt.Errorf("fix: %w", err)
+	}
+	gw := NewGatewayWithInstruments(instruments, NewTradeLog(), SystemClock{})
+	if pub, err := startITCH(gw, *itchFeed, *itchRetrans, os.Stdout); err != nil {
+		return err
+	} else if pub != nil {
+		defer pub.Close()
+	}
+	acc, err := NewFIXAcceptor(gw, cfgs, FIXOptions{Log: os.Stdout})
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		acc.Close()
+	}()
+
+	fmt.Printf("GME FIX 4.4 sur %s (%d sessions, Ctrl+C pour arreter)\n", *addr, len(cfgs))
+	if err := acc.Serve(l); err != nil {
+		return err
+	}
+	return acc.Close()
+}
+
+// parseFIXSessions lit "NOUS:CLIENT=compte,...".
+func parseFIXSessions(spec string) ([]FIXSessionConfig, error) {
+	var cfgs []FIXSessionConfig
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		ids, account, ok := strings.Cut(entry, "=")
+		sender, target, ok2 := strings.Cut(ids, ":")
+		if !ok || !ok2 || sender == "" || target == "" || account == "" {
+			return nil, fmt.Errorf("session invalide %q (attendu NOUS:CLIENT=compte)", entry)
+		}
+		cfgs = append(cfgs, FIXSessionConfig{SenderCompID: sender, TargetCompID: target, Account: account})
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("au moins une session requise (-sessions GME:BROKER1=ACC1)")
+	}
+	return cfgs, nil
+}
@@ -0,0 +1,252 @@
+// fixstore.go — Persistance d'une session FIX : numeros de sequence et
+// messages emis (pour repondre aux ResendRequest).
+//
+// Un repertoire par session :
+//
+//	seqnums   "<prochain emis> <prochain attendu>\n", reecrit a chaque changement
+//	messages  journal append-only : [seq u32][len u32][message FIX brut]
+//
+// Apres un redemarrage, la session reprend ses numeros : le client n'a pas
+// a resynchroniser, et les messages perdus pendant la coupure lui sont
+// renvoyes sur ResendRequest.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FIXStore conserve l'etat persistant d'une session FIX.
+type FIXStore interface {
+	NextSenderSeq() int // Prochain MsgSeqNum emis
+	NextTargetSeq() int // Prochain MsgSeqNum attendu du client
+	SetNextSenderSeq(seq int) error
+	SetNextTargetSeq(seq int) error
+	SaveMessage(seq int, raw []byte) error           // Message emis, pour renvoi
+	Messages(begin, end int) (map[int][]byte, error) // Messages emis dans [begin, end]
+	Reset() error                                    // Remet les deux sequences a 1 et vide les messages
+	Close() error
+}
+
+// ---------------------------------------------------------------------------
+// MemoryFIXStore
+// ---------------------------------------------------------------------------
+
+// MemoryFIXStore est un FIXStore non persistant (tests, sessions jetables).
+type MemoryFIXStore struct {
+	mu       sync.Mutex
+	sender   int
+	target   int
+	messages map[int][]byte
+}
+
+// NewMemoryFIXStore cree un store vide, sequences a 1.
+func NewMemoryFIXStore() *MemoryFIXStore {
+	return &MemoryFIXStore{sender: 1, target: 1, messages: make(map[int][]byte)}
+}
+
+func (s *MemoryFIXStore) NextSenderSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sender
+}
+
+func (s *MemoryFIXStore) NextTargetSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.target
+}
+
+func (s *MemoryFIXStore) SetNextSenderSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sender = seq
+	return nil
+}
+
+func (s *MemoryFIXStore) SetNextTargetSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.target = seq
+	return nil
+}
+
+func (s *MemoryFIXStore) SaveMessage(seq int, raw []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[seq] = raw
+	return nil
+}
+
+func (s *MemoryFIXStore) Messages(begin, end int) (map[int][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return selectMessages(s.messages, begin, end), nil
+}
+
+func (s *MemoryFIXStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sender, s.target = 1, 1
+	s.messages = make(map[int][]byte)
+	return nil
+}
+
+func (s *MemoryFIXStore) Close() error { return nil }
+
+func selectMessages(all map[int][]byte, begin, end int) map[int][]byte {
+	out := make(map[int][]byte)
+	for seq, raw := range all {
+		if seq >= begin && seq <= end {
+			out[seq] = raw
+		}
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// FileFIXStore
+// ---------------------------------------------------------------------------
+
+// FileFIXStore persiste une session dans un repertoire. Les messages emis
+// sont gardes en memoire et rejoues depuis le journal a l'ouverture.
+type FileFIXStore struct {
+	mu       sync.Mutex
+	seqFile  *os.File
+	msgFile  *os.File
+	sender   int
+	target   int
+	messages map[int][]byte
+}
+
+// OpenFileFIXStore ouvre (ou cree) le store d'une session dans dir.
+func OpenFileFIXStore(dir string) (*FileFIXStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileFIXStore{sender: 1, target: 1, messages: make(map[int][]byte)}
+
+	var err error
+	if s.seqFile, err = os.OpenFile(filepath.Join(dir, "seqnums"), os.O_CREATE|os.O_RDWR, 0o644); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fscan(s.seqFile, &s.sender, &s.target); err != nil && !errors.Is(err, io.EOF) {
+		s.seqFile.Close()
+		return nil, fmt.Errorf("fix store %s: seqnums illisible: %w", dir, err)
+	}
+	if s.msgFile, err = os.OpenFile(filepath.Join(dir, "messages"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644); err != nil {
+		s.seqFile.Close()
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("fix store %s: %w", dir, err)
+	}
+	return s, nil
+}
+
+// load relit le journal des messages. Un record tronque par un crash est
+// coupe.
+func (s *FileFIXStore) load() error {
+	br := bufio.NewReader(s.msgFile)
+	var valid int64
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			break
+		}
+		seq := int(binary.LittleEndian.Uint32(hdr[0:4]))
+		raw := make([]byte, binary.LittleEndian.Uint32(hdr[4:8]))
+		if _, err := io.ReadFull(br, raw); err != nil {
+			break
+		}
+		s.messages[seq] = raw
+		valid += int64(len(hdr) + len(raw))
+	}
+	return s.msgFile.Truncate(valid)
+}
+
+func (s *FileFIXStore) NextSenderSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sender
+}
+
+func (s *FileFIXStore) NextTargetSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.target
+}
+
+func (s *FileFIXStore) SetNextSenderSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sender = seq
+	return s.writeSeqs()
+}
+
+func (s *FileFIXStore) SetNextTargetSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.target = seq
+	return s.writeSeqs()
+}
+
+// writeSeqs reecrit le fichier seqnums. Largeur fixe : la nouvelle valeur
+// recouvre toujours entierement l'ancienne. Appele avec s.mu tenu.
+func (s *FileFIXStore) writeSeqs() error {
+	_, err := s.seqFile.WriteAt([]byte(fmt.Sprintf("%010d %010d\n", s.sender, s.target)), 0)
+	return err
+}
+
+func (s *FileFIXStore) SaveMessage(seq int, raw []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := make([]byte, 8+len(raw))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(seq))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(len(raw)))
+	copy(rec[8:], raw)
+	if _, err := s.msgFile.Write(rec); err != nil {
+		return err
+	}
+	s.messages[seq] = raw
+	return nil
+}
+
+func (s *FileFIXStore) Messages(begin, end int) (map[int][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return selectMessages(s.messages, begin, end), nil
+}
+
+func (s *FileFIXStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.msgFile.Truncate(0); err != nil {
+		return err
+	}
+	s.sender, s.target = 1, 1
+	s.messages = make(map[int][]byte)
+	return s.writeSeqs()
+}
+
+// Close rend les sequences durables et ferme les fichiers.
+func (s *FileFIXStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	if s.msgFile != nil {
+		errs = append(errs, s.msgFile.Sync(), s.msgFile.Close())
+	}
+	if s.seqFile != nil {
+		errs = append(errs, s.seqFile.Sync(), s.seqFile.Close())
+	}
+	return errors.Join(errs...)
+}
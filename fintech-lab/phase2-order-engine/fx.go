@@ -0,0 +1,299 @@
+// fx.go — Devises des instruments et table de change.
+//
+// Chaque symbole est cote dans une devise (Instrument) : ses prix, ses
+// trades et leur notionnel sont dans cette devise. Les agregats qui
+// melangent plusieurs symboles (TradeLog, P&L du backtest) passent par une
+// table FXRates pour etre ramenes a une devise de reporting.
+//
+// FICHIER DE TAUX, une paire par ligne (# : commentaire) :
+//
+//   # BASE/QUOTE taux  ->  1 BASE = taux QUOTE
+//   EUR/USD 1.0850
+//   USD/JPY 151.20
+//
+// Rate accepte la paire directe, son inverse, ou un pivot par une devise
+// commune (EUR -> JPY via EUR/USD puis USD/JPY).
+//
+// MISE A JOUR A CHAUD : Set change un taux, Load et Reload remplacent toute
+// la table d'un coup (un fichier invalide laisse l'ancienne en place), Watch
+// recharge le fichier quand il change. Les conversions utilisent les taux
+// courants au moment de l'appel.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCurrency est la devise d'un symbole declare sans devise.
+const DefaultCurrency = "USD"
+
+// ErrNoRate est retourne (wrappe) quand aucun taux ne relie deux devises.
+var ErrNoRate = errors.New("taux de change introuvable")
+
+// ---------------------------------------------------------------------------
+// Instrument
+// ---------------------------------------------------------------------------
+
+// Instrument est un symbole et sa devise de cotation.
+type Instrument struct {
+	Symbol   string
+	Currency string // Code ISO 4217 (defaut : USD)
+}
+
+// ParseInstruments lit une liste "AAPL,MSFT,SAP:EUR" : symboles separes par
+// des virgules, devise optionnelle apres ':'.
+func ParseInstruments(spec string) ([]Instrument, error) {
+	var out []Instrument
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		symbol, ccy, hasCcy := strings.Cut(item, ":")
+		if !hasCcy {
+			ccy = DefaultCurrency
+		}
+		if symbol == "" {
+			return nil, &ValidationError{Field: "symbol", Message: fmt.Sprintf("symbole vide dans %q", item)}
+		}
+		if err := checkCurrency(ccy); err != nil {
+			return nil, err
+		}
+		out = append(out, Instrument{Symbol: symbol, Currency: ccy})
+	}
+	if len(out) == 0 {
+		return nil, &ValidationError{Field: "symbol", Message: "aucun symbole"}
+	}
+	return out, nil
+}
+
+// usdInstruments declare des symboles dans la devise par defaut.
+func usdInstruments(symbols []string) []Instrument {
+	out := make([]Instrument, len(symbols))
+	for i, s := range symbols {
+		out[i] = Instrument{Symbol: s, Currency: DefaultCurrency}
+	}
+	return out
+}
+
+// checkCurrency verifie un code devise : trois lettres majuscules.
+func checkCurrency(ccy string) error {
+	if len(ccy) != 3 || strings.ToUpper(ccy) != ccy || strings.Trim(ccy, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return &ValidationError{Field: "currency", Message: fmt.Sprintf("devise invalide %q (attendu un code ISO comme EUR)", ccy)}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// FXRates — table de change
+// ---------------------------------------------------------------------------
+
+// fxPair est une paire BASE/QUOTE.
+type fxPair struct{ base, quote string }
+
+// FXRates est une table de taux de change. Thread-safe : les conversions
+// lisent pendant qu'un rechargement remplace la table.
+type FXRates struct {
+	mu    sync.RWMutex
+	rates map[fxPair]float64 // 1 base = rate quote
+	path  string             // Fichier source (LoadFXRates), relu par Reload
+	mod   time.Time          // Date de modification du fichier au dernier chargement
+}
+
+// NewFXRates cree une table vide, a remplir par Set ou Load.
+func NewFXRates() *FXRates {
+	return &FXRates{rates: make(map[fxPair]float64)}
+}
+
+// LoadFXRates charge une table depuis un fichier, que Reload et Watch
+// reliront.
+func LoadFXRates(path string) (*FXRates, error) {
+	fx := NewFXRates()
+	fx.path = path
+	if err := fx.Reload(); err != nil {
+		return nil, err
+	}
+	return fx, nil
+}
+
+// Set fixe un taux : 1 base = rate quote.
+func (fx *FXRates) Set(base, quote string, rate float64) error {
+	if err := checkRate(base, quote, rate); err != nil {
+		return err
+	}
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	fx.rates[fxPair{base, quote}] = rate
+	return nil
+}
+
+// Load remplace toute la table par les taux lus dans r. En cas d'erreur,
+// la table precedente reste en place.
+func (fx *FXRates) Load(r io.Reader) error {
+	rates := make(map[fxPair]float64)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("fx: ligne %d: attendu \"BASE/QUOTE taux\"", line)
+		}
+		base, quote, ok := strings.Cut(fields[0], "/")
+		if !ok {
+			return fmt.Errorf("fx: ligne %d: paire invalide %q", line, fields[0])
+		}
+		rate, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return fmt.Errorf("fx: ligne %d: taux invalide %q", line, fields[1])
+		}
+		if err := checkRate(base, quote, rate); err != nil {
+			return fmt.Errorf("fx: ligne %d: %w", line, err)
+		}
+		rates[fxPair{base, quote}] = rate
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("fx: %w", err)
+	}
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	fx.rates = rates
+	return nil
+}
+
+// Reload relit le fichier de la table (LoadFXRates).
+func (fx *FXRates) Reload() error {
+	if fx.path == "" {
+		return errors.New("fx: table sans fichier")
+	}
+	f, err := os.Open(fx.path)
+	if err != nil {
+		return fmt.Errorf("fx: %w", err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("fx: %w", err)
+	}
+	if err := fx.Load(f); err != nil {
+		return fmt.Errorf("%w (%s)", err, fx.path)
+	}
+	fx.mu.Lock()
+	fx.mod = st.ModTime()
+	fx.mu.Unlock()
+	return nil
+}
+
+// Watch verifie le fichier toutes les interval et le recharge quand sa date
+// de modification change, jusqu'a l'annulation de ctx. Les erreurs de
+// rechargement sont ecrites dans log (nil : ignorees) : la table garde ses
+// derniers taux valides.
+func (fx *FXRates) Watch(ctx context.Context, interval time.Duration, log io.Writer) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		st, err := os.Stat(fx.path)
+		fx.mu.RLock()
+		changed := err == nil && !st.ModTime().Equal(fx.mod)
+		fx.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := fx.Reload(); err != nil && log != nil {
+			fmt.Fprintf(log, "%v\n", err)
+		}
+	}
+}
+
+// checkRate valide une paire et son taux.
+func checkRate(base, quote string, rate float64) error {
+	if err := checkCurrency(base); err != nil {
+		return err
+	}
+	if err := checkCurrency(quote); err != nil {
+		return err
+	}
+	if base == quote {
+		return &ValidationError{Field: "currency", Message: fmt.Sprintf("paire %s/%s", base, quote)}
+	}
+	if !(rate > 0) || rate > 1e12 { // Rejette aussi NaN
+		return &ValidationError{Field: "rate", Message: fmt.Sprintf("taux %s/%s invalide: %v", base, quote, rate)}
+	}
+	return nil
+}
+
+// Rate retourne le taux de from vers to : 1 from = Rate to. Une table nil
+// ne connait que le taux d'une devise vers elle-meme.
+func (fx *FXRates) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if fx != nil {
+		fx.mu.RLock()
+		defer fx.mu.RUnlock()
+		if r, ok := fx.direct(from, to); ok {
+			return r, nil
+		}
+		for _, pivot := range fx.currencies() {
+			r1, ok1 := fx.direct(from, pivot)
+			r2, ok2 := fx.direct(pivot, to)
+			if ok1 && ok2 {
+				return r1 * r2, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: %s/%s", ErrNoRate, from, to)
+}
+
+// Convert convertit amount de from vers to aux taux courants.
+func (fx *FXRates) Convert(amount float64, from, to string) (float64, error) {
+	r, err := fx.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * r, nil
+}
+
+// direct cherche la paire ou son inverse. Appele avec fx.mu tenu.
+func (fx *FXRates) direct(from, to string) (float64, bool) {
+	if r, ok := fx.rates[fxPair{from, to}]; ok {
+		return r, true
+	}
+	if r, ok := fx.rates[fxPair{to, from}]; ok {
+		return 1 / r, true
+	}
+	return 0, false
+}
+
+// currencies retourne, triees, les devises de la table : le pivot choisi
+// est ainsi le meme d'un appel a l'autre. Appele avec fx.mu tenu.
+func (fx *FXRates) currencies() []string {
+	seen := make(map[string]bool)
+	for p := range fx.rates {
+		seen[p.base], seen[p.quote] = true, true
+	}
+	out := make([]string, 0, len(seen))
+	for c := range seen {
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,169 @@
+// fx_test.go — Tests des devises d'instrument et de la table de change :
+// taux directs, inverses et croises, rechargement a chaud, notionnel par
+// devise et persistance de la devise.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Instrument|FX|Currency' -v
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInstruments(t *testing.T) {
+	got, err := ParseInstruments("AAPL, SAP:EUR,7203:JPY")
+	want := []Instrument{{"AAPL", "USD"}, {"SAP", "EUR"}, {"7203", "JPY"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseInstruments: %v, %v", got, err)
+	}
+	for _, bad := range []string{"", "SAP:eur", "SAP:", ":EUR", "SAP:EURO"} {
+		var ve *ValidationError
+		if _, err := ParseInstruments(bad); !errors.As(err, &ve) {
+			t.Errorf("%q accepte: %v", bad, err)
+		}
+	}
+}
+
+func TestFXRates(t *testing.T) {
+	fx := NewFXRates()
+	if err := fx.Load(strings.NewReader("# taux du jour\nEUR/USD 1.25\n\nUSD/JPY 150 # fixing\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		from, to string
+		want     float64
+	}{
+		{"EUR", "USD", 1.25},
+		{"USD", "EUR", 0.8},
+		{"EUR", "JPY", 187.5}, // Pivot USD
+		{"JPY", "EUR", 1 / 187.5},
+		{"GBP", "GBP", 1},
+	} {
+		if got, err := fx.Rate(c.from, c.to); err != nil || !near(got, c.want) {
+			t.Errorf("%s/%s = %v, %v ; attendu %v", c.from, c.to, got, err, c.want)
+		}
+	}
+	if _, err := fx.Convert(1, "GBP", "USD"); !errors.Is(err, ErrNoRate) {
+		t.Errorf("GBP/USD: %v", err)
+	}
+	var none *FXRates
+	if v, err := none.Convert(10, "USD", "USD"); err != nil || v != 10 {
+		t.Errorf("table nil, meme devise: %v, %v", v, err)
+	}
+
+	// Un fichier invalide laisse la table en place ; Set met a jour un taux.
+	for _, bad := range []string{"EUR/USD 1.1\nEURUSD 1.1\n", "EUR/USD -1\n", "EUR/USD x\n", "EUR/EUR 1\n"} {
+		if err := fx.Load(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q accepte", bad)
+		}
+	}
+	if err := fx.Set("EUR", "USD", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fx.Convert(100, "JPY", "EUR"); !near(got, 100.0/150/1.5) {
+		t.Errorf("apres Set: %v", got)
+	}
+}
+
+// TestFXRatesWatch remplace le fichier pendant Watch : les nouveaux taux
+// sont pris, un fichier casse est ignore.
+func TestFXRatesWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fx.txt")
+	write := func(content string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mod, mod) // Date distincte meme si le FS est grossier
+	}
+	base := time.Now().Add(-time.Hour)
+	write("EUR/USD 1.10\n", base)
+	fx, err := LoadFXRates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var errs strings.Builder
+	done := make(chan struct{})
+	go func() {
+		fx.Watch(ctx, time.Millisecond, &errs)
+		close(done)
+	}()
+	rate := func() float64 { r, _ := fx.Rate("EUR", "USD"); return r }
+
+	write("EUR/USD 1.20\n", base.Add(time.Second))
+	waitFor(t, "rechargement", func() bool { return rate() == 1.2 })
+	write("EUR/USD ?\n", base.Add(2*time.Second))
+	time.Sleep(20 * time.Millisecond)
+	write("EUR/USD 1.30\n", base.Add(3*time.Second))
+	waitFor(t, "rechargement apres erreur", func() bool { return rate() == 1.3 })
+	cancel()
+	<-done
+	if !strings.Contains(errs.String(), "ligne 1") {
+		t.Errorf("erreur de rechargement non signalee: %q", errs.String())
+	}
+}
+
+// TestMultiCurrencyNotional trade AAPL en USD et SAP en EUR : chaque trade
+// porte sa devise, le TradeLog agrege par devise et convertit, le store
+// garde la devise et relit les anciens records en USD.
+func TestMultiCurrencyNotional(t *testing.T) {
+	log := NewTradeLog()
+	gw := NewGatewayWithInstruments([]Instrument{{"AAPL", "USD"}, {"SAP", "EUR"}}, log, NewSimClock(time.Unix(0, 0), time.Nanosecond))
+	if ccy, _ := gw.Currency("SAP"); ccy != "EUR" {
+		t.Errorf("devise SAP: %q", ccy)
+	}
+	cross := func(symbol string, price float64, qty int64) {
+		mustSubmit(t, gw, NewLimitOrder(symbol, Sell, price, qty))
+		mustSubmit(t, gw, NewLimitOrder(symbol, Buy, price, qty))
+	}
+	cross("AAPL", 200, 10) // 2000 USD
+	cross("SAP", 150, 20)  // 3000 EUR
+
+	trades := log.Trades()
+	if len(trades) != 2 || trades[0].Currency != "USD" || trades[1].Currency != "EUR" || trades[1].Notional() != 3000 {
+		t.Fatalf("trades: %v", trades)
+	}
+	if !strings.Contains(trades[1].String(), "@ 150.00 EUR (notional: 3000.00 EUR)") {
+		t.Errorf("String: %s", trades[1])
+	}
+	if st, _ := gw.Order(trades[1].SellOrderID); !strings.Contains(st.Order.String(), "@ 150.00 EUR") {
+		t.Errorf("ordre: %s", &st.Order)
+	}
+	if tk, _ := log.Ticker("SAP"); tk.Currency != "EUR" {
+		t.Errorf("ticker: %v", tk)
+	}
+	if got := log.NotionalByCurrency(); !reflect.DeepEqual(got, map[string]float64{"USD": 2000, "EUR": 3000}) {
+		t.Errorf("par devise: %v", got)
+	}
+
+	fx := NewFXRates()
+	if _, err := log.TotalNotionalIn(fx, "USD"); !errors.Is(err, ErrNoRate) {
+		t.Errorf("sans taux: %v", err)
+	}
+	fx.Set("EUR", "USD", 1.1)
+	if got, err := log.TotalNotionalIn(fx, "USD"); err != nil || !near(got, 2000+3300) {
+		t.Errorf("en USD: %v, %v", got, err)
+	}
+	if got, err := log.TotalNotionalIn(fx, "EUR"); err != nil || !near(got, 2000/1.1+3000) {
+		t.Errorf("en EUR: %v, %v", got, err)
+	}
+
+	rec := encodeTrade(trades[1])
+	if got, err := decodeTrade(rec[8:]); err != nil || got.Currency != "EUR" || got.Symbol != "SAP" {
+		t.Errorf("store: %+v, %v", got, err)
+	}
+	legacy := rec[8 : len(rec)-4] // Record sans devise : ecrit avant les instruments
+	if got, err := decodeTrade(legacy); err != nil || got.Currency != DefaultCurrency || got.Symbol != "SAP" {
+		t.Errorf("ancien record: %+v, %v", got, err)
+	}
+	if _, err := decodeTrade(rec[8 : len(rec)-1]); err == nil {
+		t.Error("devise tronquee acceptee")
+	}
+}
@@ -4,7 +4,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 )
 
 // ---------------------------------------------------------------------------
@@ -21,26 +24,80 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("validation error [%s]: %s", e.Field, e.Message)
 }
 
+// ErrOrderNotFound est retourne (wrappe) quand l'ordre vise n'existe pas
+// ou ne peut plus etre annule/modifie.
+var ErrOrderNotFound = errors.New("ordre introuvable ou deja inactif")
+
 // ---------------------------------------------------------------------------
 // Gateway — Valide et route les ordres
 // ---------------------------------------------------------------------------
 
 // Gateway est le point d'entree du systeme.
 // Il valide les ordres, puis les soumet au bon OrderBook.
+//
+// Le Gateway garde aussi l'etat qui depasse un seul book : stops en attente,
+// dernier prix par symbole, groupes d'ordres lies (OCO / bracket).
+// Un fill peut declencher d'autres ordres (stop, jambe de bracket) : mu
+// serialise donc Submit/Cancel pour que la cascade soit atomique.
 type Gateway struct {
-	books map[string]*OrderBook // symbol -> OrderBook
-	log   *TradeLog
+	mu      sync.Mutex
+	books   map[string]*OrderBook // symbol -> OrderBook
+	log     *TradeLog
+	orders  map[uint64]*Order      // index de tous les ordres recus (rejetes compris)
+	clOrd   map[clOrdKey]uint64    // (account, ClOrdID) -> ID interne
+	history map[uint64][]Event     // orderID -> evenements publies
+	stops   map[string][]*Order    // symbol -> stops en attente (lazy deletion)
+	last    map[string]float64     // symbol -> prix du dernier trade
+	groups  map[uint64]*orderGroup // groupID -> groupe
+	legOf   map[uint64]*orderGroup // orderID -> groupe (entree et jambes)
+	killed  map[string]string      // account -> raison du kill switch
+	retired map[string][2]int64    // symbol -> executes (achat, vente) des ordres oublies par Release
+	owners  map[uint64]string      // orderID -> compte des ordres executes oublies par Release (clearing)
+	subs    []EventHandler
+	audit   *TradeAudit    // busts et corrections de trades
+	metrics *EngineMetrics // nil tant que Metrics n'a pas ete appele
+	debug   bool           // Invariants controles apres chaque operation (SetDebug)
+	clock   Clock          // Heure de reception, des matchs et des evenements
+	ids     *Sequencer     // IDs d'ordres, de trades et de groupes de ce moteur
 }
 
-// NewGateway cree un Gateway avec les symboles pre-enregistres.
+// NewGateway cree un Gateway avec les symboles pre-enregistres,
+// sur l'horloge systeme.
 func NewGateway(symbols []string, log *TradeLog) *Gateway {
-	books := make(map[string]*OrderBook, len(symbols))
-	for _, s := range symbols {
-		books[s] = NewOrderBook(s)
+	return NewGatewayWithClock(symbols, log, SystemClock{})
+}
+
+// NewGatewayWithClock cree un Gateway pilote par clock (SimClock pour les
+// tests, le replay et le backtest). Ses IDs commencent a 1. Les symboles
+// sont cotes dans la devise par defaut.
+func NewGatewayWithClock(symbols []string, log *TradeLog, clock Clock) *Gateway {
+	return NewGatewayWithInstruments(usdInstruments(symbols), log, clock)
+}
+
+// NewGatewayWithInstruments cree un Gateway dont chaque symbole a sa devise
+// (ParseInstruments) : ses trades sont dans cette devise.
+func NewGatewayWithInstruments(instruments []Instrument, log *TradeLog, clock Clock) *Gateway {
+	ids := &Sequencer{}
+	books := make(map[string]*OrderBook, len(instruments))
+	for _, inst := range instruments {
+		books[inst.Symbol] = newOrderBook(inst, clock, ids)
 	}
 	return &Gateway{
-		books: books,
-		log:   log,
+		clock:   clock,
+		ids:     ids,
+		books:   books,
+		log:     log,
+		orders:  make(map[uint64]*Order),
+		clOrd:   make(map[clOrdKey]uint64),
+		history: make(map[uint64][]Event),
+		stops:   make(map[string][]*Order),
+		last:    make(map[string]float64),
+		groups:  make(map[uint64]*orderGroup),
+		legOf:   make(map[uint64]*orderGroup),
+		killed:  make(map[string]string),
+		retired: make(map[string][2]int64),
+		owners:  make(map[uint64]string),
+		audit:   &TradeAudit{},
 	}
 }
 
@@ -64,7 +121,7 @@ func validateOrder(o *Order) error {
 		return &ValidationError{Field: "side", Message: fmt.Sprintf("cote invalide: %q", o.Side)}
 	}
 
-	if o.Type != Limit && o.Type != Market && o.Type != IOC {
+	if o.Type != Limit && o.Type != Market && o.Type != IOC && o.Type != Stop {
 		return &ValidationError{Field: "type", Message: fmt.Sprintf("type invalide: %q", o.Type)}
 	}
 
@@ -72,16 +129,20 @@ func validateOrder(o *Order) error {
 		return &ValidationError{Field: "quantity", Message: fmt.Sprintf("quantite doit etre > 0, recu: %d", o.Quantity)}
 	}
 
-	// Les Market orders n'ont pas de prix limite
-	if o.Type == Limit && o.Price <= 0 {
+	// Les Market orders n'ont pas de prix limite ; un IOC en a un
+	if (o.Type == Limit || o.Type == IOC) && o.Price <= 0 {
 		return &ValidationError{Field: "price", Message: fmt.Sprintf("prix limite doit etre > 0, recu: %.2f", o.Price)}
 	}
 
 	// Garde-fou contre les prix aberrants (circuit breaker simplifie)
-	if o.Type == Limit && o.Price > 1_000_000 {
+	if (o.Type == Limit || o.Type == IOC) && o.Price > 1_000_000 {
 		return &ValidationError{Field: "price", Message: fmt.Sprintf("prix anormalement eleve: %.2f", o.Price)}
 	}
 
+	if o.Type == Stop && (o.StopPrice <= 0 || o.StopPrice > 1_000_000) {
+		return &ValidationError{Field: "stop_price", Message: fmt.Sprintf("prix stop invalide: %.2f", o.StopPrice)}
+	}
+
 	return nil
 }
 
@@ -91,45 +152,390 @@ func validateOrder(o *Order) error {
 
 // Submit valide un ordre, le route vers le bon book, et retourne les trades.
 // C'est la methode principale appelee par les clients.
+// Les trades retournes incluent la cascade eventuelle (stops declenches,
+// jambes d'ordres lies activees) provoquee par cet ordre.
 func (gw *Gateway) Submit(o *Order) ([]Trade, error) {
-	// Etape 1 : Validation
-	if err := validateOrder(o); err != nil {
-		o.Status = StatusRejected
-		return nil, fmt.Errorf("ordre #%d rejete: %w", o.ID, err)
+	return gw.SubmitAppend(nil, o)
+}
+
+// SubmitAppend est Submit avec un buffer fourni par l'appelant : les trades
+// sont ajoutes a dst (voir pool.go). En cas de refus, dst est retourne tel
+// quel avec l'erreur. Contrairement au book, le Gateway alloue encore
+// (index, historique, evenements).
+func (gw *Gateway) SubmitAppend(dst []Trade, o *Order) ([]Trade, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if m := gw.metrics; m != nil {
+		start := time.Now()
+		defer func() { m.submit.ObserveDuration(time.Since(start)) }()
 	}
 
-	// Etape 2 : Routing vers le bon OrderBook
-	book, exists := gw.books[o.Symbol]
-	if !exists {
-		o.Status = StatusRejected
-		return nil, fmt.Errorf("ordre #%d rejete: symbole %q non supporte", o.ID, o.Symbol)
+	// Etape 1 : Reception + Validation
+	if err := gw.receive(o); err != nil {
+		return dst, err
 	}
 
-	// Etape 3 : Matching
-	trades := book.Submit(o)
+	// Etape 2 et 3 : Routing + Matching (+ cascade), dans la capacite libre de dst
+	n := len(dst)
+	trades := gw.route(dst[n:], o)
 
 	// Etape 4 : Logging des trades
+	gw.record(trades)
+	if n == 0 {
+		return trades, nil
+	}
+	return append(dst, trades...), nil
+}
+
+// receive attribue a l'ordre son ID et son heure de reception, l'indexe,
+// publie EventReceived puis applique check, l'unicite du ClOrdID et le kill
+// switch. Un refus est publie en EventRejected avec son motif.
+// Appele avec gw.mu tenu.
+func (gw *Gateway) receive(o *Order) error {
+	if o != nil {
+		o.ID = gw.ids.NextOrderID()
+		o.Timestamp = gw.clock.Now()
+		if ob, ok := gw.books[o.Symbol]; ok {
+			o.Currency = ob.currency
+		}
+		gw.orders[o.ID] = o
+		gw.emit(orderEvent(EventReceived, o, ""))
+	}
+	err := gw.check(o)
+	if err == nil {
+		err = gw.reserveClOrdID(o)
+	}
+	if err == nil {
+		err = gw.admit(o)
+	}
+	if err != nil {
+		return gw.reject(o, err)
+	}
+	return nil
+}
+
+// reject passe un ordre en REJECTED, libere son ClOrdID, publie
+// EventRejected et retourne err. Appele avec gw.mu tenu.
+func (gw *Gateway) reject(o *Order, err error) error {
+	if o != nil {
+		o.Status = StatusRejected
+		gw.releaseClOrdID(o)
+		gw.emit(orderEvent(EventRejected, o, err.Error()))
+	}
+	if gw.metrics != nil {
+		gw.metrics.onRejected(err)
+	}
+	return err
+}
+
+// check applique validateOrder et verifie que le symbole est route.
+// Un ordre refuse passe en REJECTED.
+func (gw *Gateway) check(o *Order) error {
+	if err := validateOrder(o); err != nil {
+		if o != nil {
+			o.Status = StatusRejected
+			return fmt.Errorf("ordre #%d rejete: %w", o.ID, err)
+		}
+		return fmt.Errorf("ordre rejete: %w", err)
+	}
+	if _, exists := gw.books[o.Symbol]; !exists {
+		o.Status = StatusRejected
+		return fmt.Errorf("ordre #%d rejete: %w", o.ID,
+			&ValidationError{Field: "symbol", Message: fmt.Sprintf("symbole %q non supporte", o.Symbol)})
+	}
+	return nil
+}
+
+// record ajoute les trades au TradeLog. Appele avec gw.mu tenu.
+func (gw *Gateway) record(trades []Trade) {
 	if gw.log != nil {
 		gw.log.AddAll(trades)
 	}
+	if gw.metrics != nil {
+		gw.metrics.onTrades(trades)
+	}
+	if gw.debug {
+		gw.assertInvariants(trades)
+	}
+}
+
+// route place un ordre valide puis propage les consequences de ses trades,
+// ajoutes a trades. Appele avec gw.mu tenu.
+func (gw *Gateway) route(trades []Trade, o *Order) []Trade {
+	gw.accept(o)
+	trades = gw.place(trades, o)
+	if o.Type == Stop {
+		// Le marche a peut-etre deja franchi le prix stop.
+		return gw.settle(trades, gw.electStops(o.Symbol)...)
+	}
+	return gw.settle(trades)
+}
 
-	return trades, nil
+// accept publie EventAccepted pour un ordre dont le Gateway prend la charge.
+// Appele une seule fois par ordre, avec gw.mu tenu.
+func (gw *Gateway) accept(o *Order) {
+	gw.emit(orderEvent(EventAccepted, o, ""))
+	if gw.metrics != nil {
+		gw.metrics.onAccepted(o)
+	}
+}
+
+// place pose un ordre accepte : dans la file des stops s'il s'agit d'un
+// stop, sinon dans son book. Les trades sont ajoutes a trades.
+// Aucune propagation ici (voir settle).
+func (gw *Gateway) place(trades []Trade, o *Order) []Trade {
+	if o.Type == Stop {
+		o.Status = StatusPending
+		gw.stops[o.Symbol] = append(gw.stops[o.Symbol], o)
+		gw.emit(orderEvent(EventRested, o, "stop en attente"))
+		return trades
+	}
+	if o.Status == StatusPending {
+		o.Status = StatusOpen // jambe liee activee : sa priorite date de sa pose
+		o.Timestamp = gw.clock.Now()
+	}
+	return gw.submit(trades, o)
+}
+
+// submit envoie un ordre a son book et publie ce que le book a decide :
+// EventFilled pour chaque cote de chaque trade, puis EventRested si l'ordre
+// reste au carnet, ou EventCancelled si son reliquat est abandonne (IOC, Market).
+// Les trades sont ajoutes a trades.
+func (gw *Gateway) submit(trades []Trade, o *Order) []Trade {
+	n := len(trades)
+	trades = gw.books[o.Symbol].SubmitAppend(trades, o)
+	gw.report(o, trades[n:])
+	return trades
+}
+
+// report publie les consequences d'un passage de o dans le book.
+func (gw *Gateway) report(o *Order, trades []Trade) {
+	for _, t := range trades {
+		for _, id := range [2]uint64{t.BuyOrderID, t.SellOrderID} {
+			if x, ok := gw.orders[id]; ok {
+				gw.emit(fillEvent(x, t))
+			}
+		}
+	}
+	switch {
+	case o.IsActive():
+		gw.emit(orderEvent(EventRested, o, ""))
+	case o.Status == StatusCancelled:
+		gw.emit(orderEvent(EventCancelled, o, fmt.Sprintf("%s: reliquat non execute", o.Type)))
+	}
+}
+
+// settle traite les trades un par un : mise a jour du dernier prix,
+// reequilibrage des groupes lies touches, election des stops.
+// Les trades ainsi provoques sont ajoutes a la file et traites a leur tour.
+// Les stops elus partent au book un par un, file de trades videe : les
+// trades d'un stop reequilibrent les groupes avant le depart du suivant
+// (les deux jambes stop d'un OCO peuvent etre elues par le meme trade).
+// Les EventFilled sont deja publies par submit.
+func (gw *Gateway) settle(trades []Trade, elected ...electedStop) []Trade {
+	for i := 0; ; {
+		for ; i < len(trades); i++ {
+			t := trades[i]
+			gw.last[t.Symbol] = t.Price
+			for _, id := range [2]uint64{t.BuyOrderID, t.SellOrderID} {
+				if g, ok := gw.legOf[id]; ok {
+					trades = append(trades, gw.rebalance(g)...)
+				}
+			}
+			elected = append(elected, gw.electStops(t.Symbol)...)
+		}
+		if len(elected) == 0 {
+			return trades
+		}
+		trades = append(trades, gw.fire(elected[0])...)
+		elected = elected[1:]
+	}
+}
+
+// electedStop est un stop franchi par le dernier prix, pas encore parti.
+type electedStop struct {
+	o    *Order
+	last float64 // Dernier prix qui l'a elu
+}
+
+// electStops retire de l'attente les stops franchis par le dernier prix.
+// Ils restent PENDING jusqu'a fire.
+func (gw *Gateway) electStops(symbol string) []electedStop {
+	last, ok := gw.last[symbol]
+	if !ok {
+		return nil
+	}
+	var elected []electedStop
+	kept := gw.stops[symbol][:0]
+	for _, o := range gw.stops[symbol] {
+		if o.Status != StatusPending {
+			continue // annule ou deja declenche : lazy deletion
+		}
+		triggered := (o.Side == Sell && last <= o.StopPrice) ||
+			(o.Side == Buy && last >= o.StopPrice)
+		if !triggered || o.Remaining() <= 0 {
+			kept = append(kept, o)
+			continue
+		}
+		elected = append(elected, electedStop{o, last})
+	}
+	gw.stops[symbol] = kept
+	return elected
+}
+
+// fire envoie au book, en Market, un stop elu. Annule entre-temps, il ne
+// part pas ; ramene a zero par son groupe, il retourne en attente.
+func (gw *Gateway) fire(e electedStop) []Trade {
+	o := e.o
+	switch {
+	case o.Status != StatusPending:
+		return nil
+	case o.Remaining() <= 0:
+		gw.stops[o.Symbol] = append(gw.stops[o.Symbol], o)
+		return nil
+	}
+	o.Type = Market
+	o.Status = StatusOpen
+	gw.emit(orderEvent(EventTriggered, o, fmt.Sprintf("dernier prix %.2f", e.last)))
+	return gw.submit(nil, o)
 }
 
 // Cancel annule un ordre dans le book correspondant.
+// Un stop en attente est annule sans passer par le book ; annuler une
+// jambe d'ordre lie s'applique au groupe (voir cancelLinked).
 func (gw *Gateway) Cancel(symbol string, orderID uint64) error {
-	book, exists := gw.books[symbol]
-	if !exists {
+	if _, exists := gw.books[symbol]; !exists {
 		return fmt.Errorf("symbole %q non supporte", symbol)
 	}
-	if !book.Cancel(orderID) {
-		return fmt.Errorf("ordre #%d non trouve ou deja inactif", orderID)
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	if o, ok := gw.orders[orderID]; ok && o.Symbol != symbol {
+		return fmt.Errorf("%w: #%d", ErrOrderNotFound, orderID)
+	}
+	return gw.cancel(orderID, "client")
+}
+
+// cancel annule un ordre par ID ; un ordre lie entraine son groupe
+// (voir cancelLinked). Appele avec gw.mu tenu.
+func (gw *Gateway) cancel(orderID uint64, reason string) error {
+	if g, ok := gw.legOf[orderID]; ok {
+		_, err := gw.cancelLinked(g, orderID, reason)
+		return err
+	}
+	o, ok := gw.orders[orderID]
+	if !ok || !gw.cancelOrder(o, reason) {
+		return fmt.Errorf("%w: #%d", ErrOrderNotFound, orderID)
 	}
 	return nil
 }
 
+// Amend modifie le prix et/ou la quantite totale d'un ordre limite au repos
+// (cancel/replace sans changer d'ID). price ou qty a 0 : valeur inchangee.
+// La nouvelle quantite doit depasser ce qui est deja execute. Un changement
+// de prix ou une hausse de quantite fait perdre la priorite ; l'ordre peut
+// alors croiser le carnet : les trades (et leur cascade) sont retournes.
+// Les ordres lies et les stops en attente ne sont pas modifiables.
+func (gw *Gateway) Amend(symbol string, orderID uint64, price float64, qty int64) ([]Trade, error) {
+	if _, exists := gw.books[symbol]; !exists {
+		return nil, fmt.Errorf("symbole %q non supporte", symbol)
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	o, ok := gw.orders[orderID]
+	if !ok || o.Symbol != symbol || !o.IsActive() {
+		return nil, fmt.Errorf("%w: #%d", ErrOrderNotFound, orderID)
+	}
+	return gw.amend(o, price, qty, "")
+}
+
+// amend applique Amend a un ordre actif. clOrdID non vide : l'ordre est
+// ensuite designe par ce nouveau ClOrdID (cancel/replace FIX).
+// Appele avec gw.mu tenu.
+func (gw *Gateway) amend(o *Order, price float64, qty int64, clOrdID string) ([]Trade, error) {
+	if _, linked := gw.legOf[o.ID]; linked {
+		return nil, fmt.Errorf("ordre #%d lie a un groupe: annuler le groupe puis resoumettre", o.ID)
+	}
+	if price == 0 {
+		price = o.Price
+	}
+	if qty == 0 {
+		qty = o.Quantity
+	}
+	switch {
+	case o.Type != Limit:
+		return nil, &ValidationError{Field: "type", Message: fmt.Sprintf("seul un LIMIT est modifiable, recu: %q", o.Type)}
+	case price < 0 || price > 1_000_000:
+		return nil, &ValidationError{Field: "price", Message: fmt.Sprintf("prix invalide: %.2f", price)}
+	case qty <= o.Filled:
+		return nil, &ValidationError{Field: "quantity", Message: fmt.Sprintf("quantite doit depasser le deja execute (%d), recu: %d", o.Filled, qty)}
+	case price == o.Price && qty == o.Quantity && clOrdID == "":
+		return nil, &ValidationError{Field: "order", Message: "rien a modifier"}
+	}
+	if clOrdID != "" {
+		if err := gw.checkClOrdID(o.Account, clOrdID); err != nil {
+			return nil, err
+		}
+	}
+
+	oldPrice, oldQty := o.Price, o.Quantity
+	trades, ok := gw.books[o.Symbol].Amend(o, price, qty)
+	if !ok {
+		return nil, fmt.Errorf("%w: #%d", ErrOrderNotFound, o.ID)
+	}
+	if clOrdID != "" {
+		// L'ancien ClOrdID reste reserve : unique pour la journee.
+		gw.clOrd[clOrdKey{o.Account, clOrdID}] = o.ID
+		o.ClOrdID = clOrdID
+	}
+	gw.emit(orderEvent(EventAmended, o, fmt.Sprintf("client: %.2f x%d -> %.2f x%d", oldPrice, oldQty, price, qty)))
+	if price != oldPrice || qty > oldQty {
+		gw.report(o, trades)
+	}
+	trades = gw.settle(trades)
+	gw.record(trades)
+	return trades, nil
+}
+
+// cancelOrder retire un ordre actif ou en attente, ou qu'il soit, et publie
+// un EventCancelled. Appele avec gw.mu tenu.
+func (gw *Gateway) cancelOrder(o *Order, reason string) bool {
+	switch {
+	case o.Status == StatusPending:
+		o.Status = StatusCancelled
+	case o.IsActive():
+		if !gw.books[o.Symbol].Cancel(o.ID) {
+			return false
+		}
+		if gw.debug {
+			gw.assertBook(o.Symbol)
+		}
+	default:
+		return false
+	}
+	gw.emit(orderEvent(EventCancelled, o, reason))
+	return true
+}
+
 // Book retourne le OrderBook d'un symbole (pour affichage/monitoring).
 func (gw *Gateway) Book(symbol string) (*OrderBook, bool) {
 	b, ok := gw.books[symbol]
 	return b, ok
 }
+
+// Currency retourne la devise de cotation d'un symbole.
+func (gw *Gateway) Currency(symbol string) (string, bool) {
+	b, ok := gw.books[symbol]
+	if !ok {
+		return "", false
+	}
+	return b.currency, true
+}
+
+// Clock retourne l'horloge du Gateway.
+func (gw *Gateway) Clock() Clock {
+	return gw.clock
+}
@@ -0,0 +1,162 @@
+// histogram.go — Histogramme de latences a buckets log-lineaires.
+//
+// Un tri de toutes les mesures (reportLatencies des benchmarks) ne tient
+// pas sur un soak de plusieurs heures. Ici, memoire fixe : chaque puissance
+// de 2 est decoupee en histSub buckets egaux, soit une erreur relative
+// bornee par 1/histSub (~6%) sur les quantiles, de 1ns a ~292 ans.
+//
+//   [0,16)ns      : un bucket par nanoseconde
+//   [16,32)ns     : 16 buckets de 1ns
+//   [32,64)ns     : 16 buckets de 2ns
+//   [2^k,2^k+1)ns : 16 buckets de 2^(k-4)ns
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+const (
+	histSubBits = 4
+	histSub     = 1 << histSubBits
+	histBuckets = (64 - histSubBits + 1) * histSub
+)
+
+// LatencyHistogram compte des durees. Pas thread-safe : un histogramme
+// par goroutine, fusionnes avec Merge.
+type LatencyHistogram struct {
+	counts [histBuckets]uint64
+	n      uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// histIndex retourne le bucket d'une valeur positive.
+func histIndex(v uint64) int {
+	if v < histSub {
+		return int(v)
+	}
+	e := bits.Len64(v) - 1 // v dans [2^e, 2^(e+1))
+	sub := (v >> (e - histSubBits)) & (histSub - 1)
+	return (e-histSubBits+1)*histSub + int(sub)
+}
+
+// histLower retourne la borne basse du bucket i.
+func histLower(i int) uint64 {
+	if i < histSub {
+		return uint64(i)
+	}
+	e := i/histSub + histSubBits - 1
+	sub := uint64(i % histSub)
+	return (histSub + sub) << (e - histSubBits)
+}
+
+// Record ajoute une mesure. Les durees negatives comptent pour 0.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	d = max(d, 0)
+	h.counts[histIndex(uint64(d))]++
+	if h.n == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.n++
+	h.sum += d
+}
+
+// Merge ajoute les mesures de o.
+func (h *LatencyHistogram) Merge(o *LatencyHistogram) {
+	if o.n == 0 {
+		return
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.n == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	h.max = max(h.max, o.max)
+	h.n += o.n
+	h.sum += o.sum
+}
+
+// Count retourne le nombre de mesures.
+func (h *LatencyHistogram) Count() uint64 { return h.n }
+
+// Mean retourne la moyenne exacte.
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.n)
+}
+
+// Max retourne la plus grande mesure exacte.
+func (h *LatencyHistogram) Max() time.Duration { return h.max }
+
+// Quantile retourne la borne haute du bucket qui contient le quantile q
+// (0 < q <= 1) : une estimation pessimiste, jamais au-dela de Max.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.n))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i+1 == histBuckets {
+				return h.max
+			}
+			return min(time.Duration(histLower(i+1)-1), h.max)
+		}
+	}
+	return h.max
+}
+
+// String resume l'histogramme : quantiles usuels et extremes.
+func (h *LatencyHistogram) String() string {
+	return fmt.Sprintf("n=%d min=%v moy=%v p50=%v p90=%v p99=%v p99.9=%v max=%v",
+		h.n, h.min, h.Mean(), h.Quantile(0.5), h.Quantile(0.9), h.Quantile(0.99), h.Quantile(0.999), h.max)
+}
+
+// Print ecrit la distribution par puissance de 2, en barres de largeur
+// proportionnelle au nombre de mesures.
+func (h *LatencyHistogram) Print(w io.Writer) {
+	if h.n == 0 {
+		return
+	}
+	// Regroupement par puissance de 2 : 16 sous-buckets, c'est trop a l'ecran.
+	var rows [64]uint64
+	last, first := 0, -1
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		r := bits.Len64(histLower(i))
+		rows[r] += c
+		last = max(last, r)
+		if first < 0 || r < first {
+			first = r
+		}
+	}
+	var peak uint64
+	for _, c := range rows {
+		peak = max(peak, c)
+	}
+	for r := first; r <= last; r++ {
+		lo := time.Duration(0)
+		if r > 0 {
+			lo = time.Duration(1) << (r - 1)
+		}
+		bar := strings.Repeat("#", int(rows[r]*40/peak))
+		fmt.Fprintf(w, "  %12v - %-12v %9d %s\n", lo, time.Duration(1)<<r, rows[r], bar)
+	}
+}
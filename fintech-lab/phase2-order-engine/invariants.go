@@ -0,0 +1,243 @@
+// invariants.go — Controles de coherence du carnet et du Gateway.
+//
+// La lazy deletion laisse des ordres morts dans les heaps : un oubli (statut
+// non mis a jour, ordre remis deux fois, sommet inactif) ne casse rien
+// tout de suite et se voit des milliers d'ordres plus tard. Ces controles
+// le detectent au plus pres :
+//
+//   - OrderBook.CheckInvariants : heaps ordonnes, sommets actifs, ordres
+//     bien ranges (cote, symbole, type), Filled dans [0, Quantity], statut
+//     coherent avec Filled, pas de doublon, carnet non croise.
+//   - Gateway.CheckInvariants : tous les books, plus la conservation des
+//     quantites (Filled de chaque ordre = trades du matching qui le
+//     concernent, reliquats actifs = carnet).
+//   - Gateway.SetDebug : controles apres chaque operation, plus le prix des
+//     trades produits contre la limite de leurs deux ordres ; un invariant
+//     viole fait paniquer l'appelant.
+//
+// Le prix des trades n'est controle qu'en mode debug, au moment du match :
+// apres un Amend ou une correction, la limite d'origine n'est plus connue.
+//
+// Fuzzing : go test ./phase2-order-engine/ -run '^$' -fuzz FuzzGateway -fuzztime 1m
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ErrInvariant est retourne (wrappe) par CheckInvariants, et leve en
+// panique en mode debug.
+var ErrInvariant = errors.New("invariant viole")
+
+// invariantError regroupe des violations ; nil s'il n'y en a aucune.
+func invariantError(violations []string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w:\n  %s", ErrInvariant, strings.Join(violations, "\n  "))
+}
+
+// ---------------------------------------------------------------------------
+// OrderBook
+// ---------------------------------------------------------------------------
+
+// CheckInvariants controle la structure du carnet. O(n).
+func (ob *OrderBook) CheckInvariants() error {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return invariantError(ob.violations())
+}
+
+// violations liste les invariants du carnet violes. Appele avec ob.mu tenu.
+func (ob *OrderBook) violations() []string {
+	var out []string
+	bad := func(format string, args ...any) {
+		out = append(out, ob.symbol+": "+fmt.Sprintf(format, args...))
+	}
+	seen := make(map[*Order]bool, ob.bids.Len()+ob.asks.Len())
+	for _, h := range []struct {
+		side   Side
+		less   func(i, j int) bool
+		orders []*Order
+	}{{Buy, ob.bids.Less, *ob.bids}, {Sell, ob.asks.Less, *ob.asks}} {
+		for i, o := range h.orders {
+			switch {
+			case seen[o]:
+				bad("ordre #%d present deux fois dans les heaps", o.ID)
+			case !o.inBook:
+				bad("ordre #%d dans le heap %s sans inBook", o.ID, h.side)
+			case o.released && o.IsActive():
+				bad("ordre #%d %s rendu au pool", o.ID, o.Status)
+			case o.Side != h.side || o.Symbol != ob.symbol:
+				bad("ordre #%d (%s %s) dans le heap %s", o.ID, o.Symbol, o.Side, h.side)
+			case o.Filled < 0 || o.Filled > o.Quantity:
+				bad("ordre #%d: filled %d hors de [0, %d]", o.ID, o.Filled, o.Quantity)
+			case o.Status != StatusOpen && o.Status != StatusPartial && o.Status != StatusFilled && o.Status != StatusCancelled:
+				bad("ordre #%d %s dans le heap", o.ID, o.Status)
+			case o.IsActive() && o.Type != Limit:
+				bad("ordre #%d %s au carnet", o.ID, o.Type)
+			case o.IsActive() && o.Remaining() == 0:
+				bad("ordre #%d %s sans reliquat", o.ID, o.Status)
+			case (o.Status == StatusOpen) != (o.Filled == 0) && o.IsActive():
+				bad("ordre #%d %s avec filled %d", o.ID, o.Status, o.Filled)
+			}
+			seen[o] = true
+			if i > 0 && h.less(i, (i-1)/2) {
+				bad("heap %s desordonne: #%d passe avant son parent #%d", h.side, o.ID, h.orders[(i-1)/2].ID)
+			}
+		}
+		// Matching, Cancel et Resize purgent le sommet : BestBid/BestAsk
+		// lisent sous RLock et ne peuvent pas sauter un ordre mort.
+		if len(h.orders) > 0 && !h.orders[0].IsActive() {
+			bad("ordre inactif #%d (%s) au sommet du heap %s", h.orders[0].ID, h.orders[0].Status, h.side)
+		}
+	}
+
+	bid := bestLevel(*ob.bids, nil, func(a, b float64) bool { return a > b })
+	ask := bestLevel(*ob.asks, nil, func(a, b float64) bool { return a < b })
+	if bid.Orders > 0 && ask.Orders > 0 && bid.Price >= ask.Price {
+		bad("carnet croise, bid %.2f >= ask %.2f", bid.Price, ask.Price)
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// Gateway
+// ---------------------------------------------------------------------------
+
+// sideIndex range les sommes par cote : 0 achat, 1 vente.
+func sideIndex(s Side) int {
+	if s == Sell {
+		return 1
+	}
+	return 0
+}
+
+// CheckInvariants controle tous les books et la conservation des quantites
+// entre ordres, trades et carnets.
+func (gw *Gateway) CheckInvariants() error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return invariantError(gw.invariantViolations())
+}
+
+// SetDebug active les controles apres chaque operation (tests, fuzzing,
+// soak) : un invariant viole fait paniquer l'appelant avec une erreur
+// wrappant ErrInvariant.
+func (gw *Gateway) SetDebug(on bool) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.debug = on
+}
+
+// invariantViolations liste les invariants du Gateway violes. Les trades
+// comptes sont ceux du matching, busts compris (un bust ne rend pas la
+// quantite aux ordres) ; les corrections, qui n'executent rien, sont
+// ignorees. Les ordres oublies par Release comptent via gw.retired.
+// Appele avec gw.mu tenu.
+func (gw *Gateway) invariantViolations() []string {
+	var out []string
+	symbols := slices.Sorted(maps.Keys(gw.books))
+	for _, symbol := range symbols {
+		ob := gw.books[symbol]
+		ob.mu.RLock()
+		out = append(out, ob.violations()...)
+		ob.mu.RUnlock()
+	}
+
+	type sideSums struct{ filled, resting [2]int64 } // [0] achat, [1] vente
+	sums := make(map[string]*sideSums, len(gw.books))
+	for symbol := range gw.books {
+		sums[symbol] = &sideSums{filled: gw.retired[symbol]}
+	}
+	var volume map[string]int64
+	var byOrder map[uint64]int64
+	if gw.log != nil {
+		volume, byOrder = make(map[string]int64), make(map[uint64]int64)
+		for _, t := range gw.log.Trades() {
+			if t.Corrects == 0 {
+				volume[t.Symbol] += t.Quantity
+				byOrder[t.BuyOrderID] += t.Quantity
+				byOrder[t.SellOrderID] += t.Quantity
+			}
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(gw.orders)) {
+		o := gw.orders[id]
+		switch {
+		case o.Filled < 0 || o.Filled > o.Quantity:
+			out = append(out, fmt.Sprintf("ordre #%d: filled %d hors de [0, %d]", o.ID, o.Filled, o.Quantity))
+		case byOrder != nil && byOrder[o.ID] != o.Filled:
+			out = append(out, fmt.Sprintf("ordre #%d: filled %d, %d en trades", o.ID, o.Filled, byOrder[o.ID]))
+		}
+		s, ok := sums[o.Symbol]
+		if !ok {
+			continue // Rejete : symbole inconnu
+		}
+		i := sideIndex(o.Side)
+		s.filled[i] += o.Filled
+		if o.IsActive() {
+			s.resting[i] += o.Remaining()
+		}
+	}
+
+	for _, symbol := range symbols {
+		s := sums[symbol]
+		if s.filled[0] != s.filled[1] || (volume != nil && s.filled[0] != volume[symbol]) {
+			out = append(out, fmt.Sprintf("%s: execute %d a l'achat, %d a la vente, %d en trades", symbol, s.filled[0], s.filled[1], volume[symbol]))
+		}
+		bids, asks := gw.books[symbol].Levels(0)
+		var inBook [2]int64
+		for _, l := range bids {
+			inBook[0] += l.Quantity
+		}
+		for _, l := range asks {
+			inBook[1] += l.Quantity
+		}
+		if inBook != s.resting {
+			out = append(out, fmt.Sprintf("%s: carnet %d/%d (bid/ask), ordres actifs %d/%d", symbol, inBook[0], inBook[1], s.resting[0], s.resting[1]))
+		}
+	}
+	return out
+}
+
+// tradeViolations verifie le prix de trades qui viennent d'etre produits :
+// au plus la limite de l'acheteur, au moins celle du vendeur (un Market,
+// stop declenche compris, n'a pas de limite). Appele avec gw.mu tenu.
+func (gw *Gateway) tradeViolations(trades []Trade) []string {
+	var out []string
+	for _, t := range trades {
+		buy, sell := gw.orders[t.BuyOrderID], gw.orders[t.SellOrderID]
+		switch {
+		case buy == nil || sell == nil:
+			out = append(out, fmt.Sprintf("trade #%d: ordre inconnu (#%d/#%d)", t.ID, t.BuyOrderID, t.SellOrderID))
+		case buy.Type != Market && t.Price > buy.Price:
+			out = append(out, fmt.Sprintf("trade #%d a %.2f au-dessus de la limite %.2f de l'achat #%d (%s)", t.ID, t.Price, buy.Price, buy.ID, buy.Type))
+		case sell.Type != Market && t.Price < sell.Price:
+			out = append(out, fmt.Sprintf("trade #%d a %.2f sous la limite %.2f de la vente #%d (%s)", t.ID, t.Price, sell.Price, sell.ID, sell.Type))
+		}
+	}
+	return out
+}
+
+// assertInvariants panique si un invariant est viole apres une operation
+// qui a produit trades. Appele avec gw.mu tenu, en mode debug.
+func (gw *Gateway) assertInvariants(trades []Trade) {
+	if err := invariantError(append(gw.tradeViolations(trades), gw.invariantViolations()...)); err != nil {
+		panic(err)
+	}
+}
+
+// assertBook panique si le carnet de symbol est incoherent. Pour les
+// operations sans trade (annulations), possibles au milieu d'une cascade :
+// la conservation n'y est pas encore verifiable.
+func (gw *Gateway) assertBook(symbol string) {
+	if err := gw.books[symbol].CheckInvariants(); err != nil {
+		panic(err)
+	}
+}
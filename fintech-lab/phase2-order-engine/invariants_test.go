@@ -0,0 +1,205 @@
+// invariants_test.go — Tests des controles d'invariants et fuzzing du
+// matching a travers le Gateway.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Invariant|Fuzz' -v
+// Fuzzing     : go test ./phase2-order-engine/ -run '^$' -fuzz FuzzGateway -fuzztime 1m
+
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCheckInvariants corrompt un carnet sain de plusieurs facons et
+// verifie que chaque corruption est signalee.
+func TestCheckInvariants(t *testing.T) {
+	setup := func() (*OrderBook, []*Order) {
+		ob := NewOrderBook("AAPL")
+		orders := []*Order{
+			NewLimitOrder("AAPL", Buy, 99, 10),
+			NewLimitOrder("AAPL", Buy, 98, 10),
+			NewLimitOrder("AAPL", Sell, 101, 10),
+			NewLimitOrder("AAPL", Sell, 102, 10),
+		}
+		for _, o := range orders {
+			ob.Submit(o)
+		}
+		if err := ob.CheckInvariants(); err != nil {
+			t.Fatalf("carnet sain: %v", err)
+		}
+		return ob, orders
+	}
+
+	for _, c := range []struct {
+		name    string
+		corrupt func(ob *OrderBook, o []*Order)
+		want    string
+	}{
+		{"sommet inactif", func(ob *OrderBook, o []*Order) { o[0].Status = StatusCancelled }, "ordre inactif #1 (CANCELLED) au sommet du heap BUY"},
+		{"surexecution", func(ob *OrderBook, o []*Order) { o[3].Filled = 11 }, "ordre #4: filled 11 hors de [0, 10]"},
+		{"statut", func(ob *OrderBook, o []*Order) { o[1].Filled = 4 }, "ordre #2 OPEN avec filled 4"},
+		{"doublon", func(ob *OrderBook, o []*Order) { heap.Push(ob.bids, o[1]) }, "ordre #2 present deux fois"},
+		{"mauvais heap", func(ob *OrderBook, o []*Order) { heap.Push(ob.bids, o[3]) }, "ordre #4 (AAPL SELL) dans le heap BUY"},
+		{"desordre", func(ob *OrderBook, o []*Order) { o[1].Price = 100 }, "heap BUY desordonne: #2 passe avant son parent #1"},
+		{"croise", func(ob *OrderBook, o []*Order) { o[2].Price, o[3].Price = 98, 98.5 }, "carnet croise, bid 99.00 >= ask 98.00"},
+		{"market au carnet", func(ob *OrderBook, o []*Order) { o[3].Type = Market }, "ordre #4 MARKET au carnet"},
+	} {
+		ob, orders := setup()
+		c.corrupt(ob, orders)
+		err := ob.CheckInvariants()
+		if !errors.Is(err, ErrInvariant) || !strings.Contains(err.Error(), "AAPL: "+c.want) {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+// TestCancelPrunesTop verifie qu'annuler le meilleur ordre ne masque pas le
+// suivant : BestBid ne peut pas sauter un sommet mort sous RLock.
+func TestCancelPrunesTop(t *testing.T) {
+	gw, _ := newTestGateway()
+	gw.SetDebug(true)
+	best := NewLimitOrder("AAPL", Buy, 100, 10)
+	mustSubmit(t, gw, best)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 99, 10))
+	if err := gw.Cancel("AAPL", best.ID); err != nil {
+		t.Fatal(err)
+	}
+	ob, _ := gw.Book("AAPL")
+	if bid, ok := ob.BestBid(); !ok || bid != 99 {
+		t.Errorf("BestBid apres annulation du sommet = %v, %v ; attendu 99", bid, ok)
+	}
+}
+
+// TestIOCLimit verifie qu'un IOC respecte son prix limite.
+func TestIOCLimit(t *testing.T) {
+	gw, _ := newTestGateway()
+	gw.SetDebug(true)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 100, 10))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 105, 10))
+	ioc := NewLimitOrder("AAPL", Buy, 101, 30)
+	ioc.Type = IOC
+	trades := mustSubmit(t, gw, ioc)
+	if len(trades) != 1 || trades[0].Price != 100 || ioc.Filled != 10 || ioc.Status != StatusCancelled {
+		t.Errorf("IOC 101: %v, %s filled %d", trades, ioc.Status, ioc.Filled)
+	}
+	var ve *ValidationError
+	if _, err := gw.Submit(&Order{Symbol: "AAPL", Side: Buy, Type: IOC, Quantity: 10}); !errors.As(err, &ve) || ve.Field != "price" {
+		t.Errorf("IOC sans prix: %v", err)
+	}
+}
+
+// TestDebugPanics verifie qu'en mode debug une incoherence fait paniquer
+// l'operation suivante, et que le prix des trades est controle.
+func TestDebugPanics(t *testing.T) {
+	gw, _ := newTestGateway()
+	gw.SetDebug(true)
+	sell := NewLimitOrder("AAPL", Sell, 100, 50)
+	mustSubmit(t, gw, sell)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 100, 20))
+
+	gw.mu.Lock()
+	sell.Filled = 25 // Execution sans trade
+	gw.mu.Unlock()
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, ErrInvariant) || !strings.Contains(err.Error(), "ordre #1: filled 25, 20 en trades") {
+				t.Errorf("panique attendue, obtenu %v", err)
+			}
+		}()
+		gw.Submit(NewLimitOrder("MSFT", Buy, 10, 1))
+	}()
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	v := gw.tradeViolations([]Trade{{ID: 9, BuyOrderID: 2, SellOrderID: 1, Price: 100.5, Quantity: 1}})
+	if len(v) != 1 || !strings.Contains(v[0], "trade #9 a 100.50 au-dessus de la limite 100.00 de l'achat #2") {
+		t.Errorf("tradeViolations: %v", v)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Fuzzing
+// ---------------------------------------------------------------------------
+
+// FuzzGateway rejoue une sequence d'operations aleatoires (4 octets par
+// operation) sur un Gateway en mode debug : toute incoherence panique.
+// Prix entiers de 95 a 105 pour que les ordres se croisent souvent.
+func FuzzGateway(f *testing.F) {
+	f.Add([]byte{0, 0, 5, 10, 0, 1, 5, 4, 6, 0, 0, 0, 3, 1, 0, 10})
+	f.Add([]byte{0, 0, 6, 10, 0, 0, 4, 10, 6, 0, 0, 0, 4, 1, 8, 20, 7, 1, 3, 0})
+	f.Add([]byte{5, 1, 4, 10, 0, 0, 5, 10, 3, 1, 0, 15, 8, 0, 7, 10, 9, 1, 6, 10, 3, 0, 0, 40, 10, 0, 0, 0})
+	f.Add([]byte{9, 0, 5, 10, 1, 1, 5, 5, 2, 0, 3, 10, 3, 1, 0, 30, 7, 2, 9, 2, 6, 3, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		gw := NewGatewayWithClock([]string{"AAPL"}, NewTradeLog(), NewSimClock(time.Unix(0, 0), time.Nanosecond))
+		gw.SetDebug(true)
+		var ids []uint64
+		for i := 0; i+4 <= len(data) && i < 4*500; i += 4 {
+			op, a, b, c := data[i], data[i+1], data[i+2], data[i+3]
+			side, other := Buy, Sell
+			if a&1 == 1 {
+				side, other = Sell, Buy
+			}
+			price := float64(95 + int(b)%11)
+			qty := int64(1 + int(c)%40)
+			account := fmt.Sprintf("ACC%d", a>>1&1)
+			target := func() uint64 {
+				if len(ids) == 0 {
+					return 0
+				}
+				return ids[int(a>>1)%len(ids)]
+			}
+			var submitted []*Order
+			switch op % 11 {
+			case 0, 1, 2:
+				submitted = append(submitted, NewLimitOrder("AAPL", side, price, qty))
+			case 3:
+				submitted = append(submitted, NewMarketOrder("AAPL", side, qty))
+			case 4:
+				o := NewLimitOrder("AAPL", side, price, qty)
+				o.Type = IOC
+				submitted = append(submitted, o)
+			case 5:
+				submitted = append(submitted, NewStopOrder("AAPL", side, price, qty))
+			case 6:
+				gw.Cancel("AAPL", target())
+			case 7:
+				if b&0x80 != 0 {
+					price = 0 // Prix inchange
+				}
+				gw.Amend("AAPL", target(), price, int64(int(c)%45))
+			case 8:
+				legs := []*Order{NewLimitOrder("AAPL", side, price, qty), NewStopOrder("AAPL", side, float64(95+int(c)%11), qty)}
+				for _, o := range legs {
+					o.Account = account
+				}
+				gw.SubmitOCO(legs[0], legs[1])
+				ids = append(ids, legs[0].ID, legs[1].ID)
+			case 9:
+				legs := []*Order{NewLimitOrder("AAPL", side, price, qty), NewLimitOrder("AAPL", other, price+float64(1+c%4), qty), NewStopOrder("AAPL", other, price-float64(1+c%4), qty)}
+				if side == Sell {
+					legs[1].Price, legs[2].StopPrice = price-float64(1+c%4), price+float64(1+c%4)
+				}
+				for _, o := range legs {
+					o.Account = account
+				}
+				gw.SubmitBracket(legs[0], legs[1], legs[2])
+				ids = append(ids, legs[0].ID, legs[1].ID, legs[2].ID)
+			case 10:
+				gw.MassCancel(CancelFilter{Account: account, Side: side})
+			}
+			for _, o := range submitted {
+				o.Account = account
+				gw.Submit(o)
+				ids = append(ids, o.ID)
+			}
+		}
+		if err := gw.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
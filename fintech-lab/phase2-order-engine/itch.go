@@ -0,0 +1,319 @@
+// itch.go — Flux de marche binaire, dans l'esprit d'ITCH sur MoldUDP64.
+//
+// PAQUET (un datagramme UDP) :
+//
+//	[session 10][seq u64][count u16] puis count x [len u16][message]
+//
+// seq est le numero du premier message du paquet ; les suivants sont
+// seq+1, seq+2... count 0 : heartbeat (seq = prochain numero a venir),
+// count 0xFFFF : fin de session. Un recepteur qui voit seq au-dela de ce
+// qu'il attend a perdu des messages (voir itchreceiver.go).
+//
+// MESSAGES : premier octet = type, puis largeur fixe, big-endian. Prix en
+// 1/10000 et textes completes par des 0, comme OUCH (ouch.go).
+//
+//	'A' AddOrder       38 octets  ordre pose au carnet (quantite restante)
+//	'E' OrderExecuted  29 octets  execution d'un ordre au carnet
+//	'X' OrderCancel    21 octets  reduction de quantite, priorite gardee
+//	'D' OrderDelete    17 octets  ordre retire du carnet
+//	'P' Trade          53 octets  ruban des trades (ne touche pas au carnet)
+//	'B' BrokenTrade    17 octets  trade annule ou corrige apres coup
+//	'K' BookChecksum   21 octets  checksum du carnet d'un symbole (bookbuilder.go)
+//
+// OrderRef est l'ID interne de l'ordre : A/E/X/D suffisent a reconstruire
+// le carnet L3. Le format ne connait pas le transport : voir itchfeed.go.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Types de messages ITCH.
+const (
+	itchAddOrder    uint8 = 'A'
+	itchExecuted    uint8 = 'E'
+	itchCancel      uint8 = 'X'
+	itchDelete      uint8 = 'D'
+	itchTrade       uint8 = 'P'
+	itchBrokenTrade uint8 = 'B'
+	itchChecksum    uint8 = 'K'
+)
+
+// Tailles des messages, octet de type compris.
+const (
+	itchAddOrderLen    = 1 + 8 + 8 + 1 + 4 + ouchSymbolLen + 8
+	itchExecutedLen    = 1 + 8 + 8 + 4 + 8
+	itchCancelLen      = 1 + 8 + 8 + 4
+	itchDeleteLen      = 1 + 8 + 8
+	itchTradeLen       = 1 + 8 + ouchSymbolLen + 4 + 8 + 8 + 8 + 8
+	itchBrokenTradeLen = 1 + 8 + 8
+	itchChecksumLen    = 1 + 8 + ouchSymbolLen + 4
+)
+
+// itchMessageLen donne la taille de chaque type de message.
+var itchMessageLen = map[uint8]int{
+	itchAddOrder:    itchAddOrderLen,
+	itchExecuted:    itchExecutedLen,
+	itchCancel:      itchCancelLen,
+	itchDelete:      itchDeleteLen,
+	itchTrade:       itchTradeLen,
+	itchBrokenTrade: itchBrokenTradeLen,
+	itchChecksum:    itchChecksumLen,
+}
+
+// En-tete de paquet.
+const (
+	itchSessionLen   = 10
+	itchHeaderLen    = itchSessionLen + 8 + 2
+	itchEndOfSession = 0xFFFF
+	itchMaxCount     = itchEndOfSession - 1
+)
+
+// ---------------------------------------------------------------------------
+// Messages
+// ---------------------------------------------------------------------------
+
+// ITCHAddOrder : un ordre limite est pose au carnet. Shares est la quantite
+// restante au moment de la pose.
+type ITCHAddOrder struct {
+	Timestamp int64 // Unix nanoseconds
+	OrderRef  uint64
+	Side      Side
+	Shares    int64
+	Symbol    string
+	Price     float64
+}
+
+// ITCHOrderExecuted : un ordre au carnet est execute (prix : le sien).
+type ITCHOrderExecuted struct {
+	Timestamp   int64
+	OrderRef    uint64
+	Shares      int64
+	MatchNumber uint64 // ID du trade
+}
+
+// ITCHOrderCancel : la quantite d'un ordre au carnet baisse de Shares.
+type ITCHOrderCancel struct {
+	Timestamp int64
+	OrderRef  uint64
+	Shares    int64
+}
+
+// ITCHOrderDelete : un ordre quitte le carnet (annulation, modification
+// avec perte de priorite : un AddOrder suit alors s'il reste au carnet).
+type ITCHOrderDelete struct {
+	Timestamp int64
+	OrderRef  uint64
+}
+
+// ITCHTrade : un trade, pour le ruban. Une correction est publiee en
+// BrokenTrade suivi d'un Trade au prix et a la quantite corriges, avec le
+// meme MatchNumber et sans OrderRef.
+type ITCHTrade struct {
+	Timestamp   int64
+	Symbol      string
+	Shares      int64
+	Price       float64
+	MatchNumber uint64
+	BuyRef      uint64
+	SellRef     uint64
+}
+
+// ITCHBrokenTrade : un trade est annule (bust) ou remplace (correction).
+type ITCHBrokenTrade struct {
+	Timestamp   int64
+	MatchNumber uint64
+}
+
+// ITCHBookChecksum : checksum du carnet de Symbol calcule par le moteur
+// apres tous les messages precedents (voir bookChecksum).
+type ITCHBookChecksum struct {
+	Timestamp int64
+	Symbol    string
+	Checksum  uint32
+}
+
+// ---------------------------------------------------------------------------
+// Encodage
+// ---------------------------------------------------------------------------
+
+// itchMessage est un message ITCH encodable.
+type itchMessage interface {
+	encode() []byte
+}
+
+func (m ITCHAddOrder) encode() []byte {
+	w := wireWriter{make([]byte, 0, itchAddOrderLen)}
+	w.u8(itchAddOrder)
+	w.i64(m.Timestamp)
+	w.u64(m.OrderRef)
+	w.u8(ouchSideByte(m.Side))
+	w.u32(m.Shares)
+	w.text(m.Symbol, ouchSymbolLen)
+	w.price(m.Price)
+	return w.b
+}
+
+func (m ITCHOrderExecuted) encode() []byte {
+	w := wireWriter{make([]byte, 0, itchExecutedLen)}
+	w.u8(itchExecuted)
+	w.i64(m.Timestamp)
+	w.u64(m.OrderRef)
+	w.u32(m.Shares)
+	w.u64(m.MatchNumber)
+	return w.b
+}
+
+func (m ITCHOrderCancel) encode() []byte {
+	w := wireWriter{make([]byte, 0, itchCancelLen)}
+	w.u8(itchCancel)
+	w.i64(m.Timestamp)
+	w.u64(m.OrderRef)
+	w.u32(m.Shares)
+	return w.b
+}
+
+func (m ITCHOrderDelete) encode() []byte {
+	w := wireWriter{make([]byte, 0, itchDeleteLen)}
+	w.u8(itchDelete)
+	w.i64(m.Timestamp)
+	w.u64(m.OrderRef)
+	return w.b
+}
+
+func (m ITCHTrade) encode() []byte {
+	w := wireWriter{make([]byte, 0, itchTradeLen)}
+	w.u8(itchTrade)
+	w.i64(m.Timestamp)
+	w.text(m.Symbol, ouchSymbolLen)
+	w.u32(m.Shares)
+	w.price(m.Price)
+	w.u64(m.MatchNumber)
+	w.u64(m.BuyRef)
+	w.u64(m.SellRef)
+	return w.b
+}
+
+func (m ITCHBrokenTrade) encode() []byte {
+	w := wireWriter{make([]byte, 0, itchBrokenTradeLen)}
+	w.u8(itchBrokenTrade)
+	w.i64(m.Timestamp)
+	w.u64(m.MatchNumber)
+	return w.b
+}
+
+func (m ITCHBookChecksum) encode() []byte {
+	w := wireWriter{make([]byte, 0, itchChecksumLen)}
+	w.u8(itchChecksum)
+	w.i64(m.Timestamp)
+	w.text(m.Symbol, ouchSymbolLen)
+	w.u32(int64(m.Checksum))
+	return w.b
+}
+
+// DecodeITCHMessage decode un message : ITCHAddOrder, ITCHOrderExecuted,
+// ITCHOrderCancel, ITCHOrderDelete, ITCHTrade, ITCHBrokenTrade ou
+// ITCHBookChecksum.
+func DecodeITCHMessage(b []byte) (any, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("itch: message vide")
+	}
+	want, ok := itchMessageLen[b[0]]
+	if !ok {
+		return nil, fmt.Errorf("itch: type de message inconnu %q", b[0])
+	}
+	if len(b) != want {
+		return nil, fmt.Errorf("itch: message %q de %d octets, attendu %d", b[0], len(b), want)
+	}
+
+	r := wireReader{b: b, off: 1}
+	switch b[0] {
+	case itchAddOrder:
+		return ITCHAddOrder{
+			Timestamp: r.i64(),
+			OrderRef:  r.u64(),
+			Side:      ouchSide(r.u8()),
+			Shares:    r.u32(),
+			Symbol:    r.text(ouchSymbolLen),
+			Price:     r.price(),
+		}, nil
+	case itchExecuted:
+		return ITCHOrderExecuted{Timestamp: r.i64(), OrderRef: r.u64(), Shares: r.u32(), MatchNumber: r.u64()}, nil
+	case itchCancel:
+		return ITCHOrderCancel{Timestamp: r.i64(), OrderRef: r.u64(), Shares: r.u32()}, nil
+	case itchDelete:
+		return ITCHOrderDelete{Timestamp: r.i64(), OrderRef: r.u64()}, nil
+	case itchTrade:
+		return ITCHTrade{
+			Timestamp:   r.i64(),
+			Symbol:      r.text(ouchSymbolLen),
+			Shares:      r.u32(),
+			Price:       r.price(),
+			MatchNumber: r.u64(),
+			BuyRef:      r.u64(),
+			SellRef:     r.u64(),
+		}, nil
+	case itchBrokenTrade:
+		return ITCHBrokenTrade{Timestamp: r.i64(), MatchNumber: r.u64()}, nil
+	default:
+		return ITCHBookChecksum{Timestamp: r.i64(), Symbol: r.text(ouchSymbolLen), Checksum: uint32(r.u32())}, nil
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Paquets
+// ---------------------------------------------------------------------------
+
+// itchPacket est un paquet decode. msgs pointe dans le tampon lu.
+type itchPacket struct {
+	session string
+	seq     uint64
+	count   int // itchEndOfSession : fin de session
+	msgs    [][]byte
+}
+
+// appendITCHPacket ajoute a dst un paquet portant msgs a partir de seq.
+// count force le compteur (heartbeat, fin de session) si msgs est vide.
+func appendITCHPacket(dst []byte, session string, seq uint64, count int, msgs [][]byte) []byte {
+	if len(msgs) > 0 {
+		count = len(msgs)
+	}
+	start := len(dst)
+	dst = append(dst, make([]byte, itchSessionLen)...)
+	copy(dst[start:], session)
+	dst = binary.BigEndian.AppendUint64(dst, seq)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(count))
+	for _, m := range msgs {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(m)))
+		dst = append(dst, m...)
+	}
+	return dst
+}
+
+// parseITCHPacket decoupe un paquet sans copier les messages.
+func parseITCHPacket(b []byte) (itchPacket, error) {
+	if len(b) < itchHeaderLen {
+		return itchPacket{}, fmt.Errorf("itch: paquet de %d octets, en-tete de %d", len(b), itchHeaderLen)
+	}
+	r := wireReader{b: b}
+	p := itchPacket{session: r.text(itchSessionLen), seq: r.u64()}
+	p.count = int(binary.BigEndian.Uint16(b[r.off:]))
+	off := itchHeaderLen
+	if p.count == itchEndOfSession {
+		return p, nil
+	}
+	for i := 0; i < p.count; i++ {
+		if off+2 > len(b) {
+			return itchPacket{}, fmt.Errorf("itch: paquet tronque (message %d/%d)", i+1, p.count)
+		}
+		n := int(binary.BigEndian.Uint16(b[off:]))
+		if off+2+n > len(b) {
+			return itchPacket{}, fmt.Errorf("itch: paquet tronque (message %d/%d)", i+1, p.count)
+		}
+		p.msgs = append(p.msgs, b[off+2:off+2+n])
+		off += 2 + n
+	}
+	return p, nil
+}
@@ -0,0 +1,267 @@
+// itch_test.go — Tests du flux ITCH : format, publication UDP,
+// retransmission et recepteur.
+// Lancer avec : go test ./phase2-order-engine/ -run ITCH -v
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestITCHCodec verifie l'aller-retour des messages et des paquets.
+func TestITCHCodec(t *testing.T) {
+	msgs := []itchMessage{
+		ITCHAddOrder{Timestamp: 1, OrderRef: 7, Side: Sell, Shares: 300, Symbol: "AAPL", Price: 189.1234},
+		ITCHOrderExecuted{Timestamp: 2, OrderRef: 7, Shares: 100, MatchNumber: 3},
+		ITCHOrderCancel{Timestamp: 3, OrderRef: 7, Shares: 50},
+		ITCHOrderDelete{Timestamp: 4, OrderRef: 7},
+		ITCHTrade{Timestamp: 5, Symbol: "MSFT", Shares: 10, Price: 410.5, MatchNumber: 4, BuyRef: 8, SellRef: 9},
+		ITCHBrokenTrade{Timestamp: 6, MatchNumber: 4},
+		ITCHBookChecksum{Timestamp: 7, Symbol: "AAPL", Checksum: 0xDEADBEEF},
+	}
+	var raw [][]byte
+	for _, m := range msgs {
+		b := m.encode()
+		if want := itchMessageLen[b[0]]; len(b) != want {
+			t.Errorf("%T: %d octets, attendu %d", m, len(b), want)
+		}
+		got, err := DecodeITCHMessage(b)
+		if err != nil {
+			t.Fatalf("%T: %v", m, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("aller-retour: %+v, attendu %+v", got, m)
+		}
+		raw = append(raw, b)
+	}
+	if _, err := DecodeITCHMessage(raw[0][:10]); err == nil {
+		t.Error("message tronque accepte")
+	}
+	if _, err := DecodeITCHMessage([]byte{'Z'}); err == nil {
+		t.Error("type inconnu accepte")
+	}
+
+	packet := appendITCHPacket(nil, "TEST", 42, 0, raw)
+	p, err := parseITCHPacket(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.session != "TEST" || p.seq != 42 || p.count != len(raw) || !reflect.DeepEqual(p.msgs, raw) {
+		t.Errorf("paquet: %+v", p)
+	}
+	if _, err := parseITCHPacket(packet[:len(packet)-1]); err == nil {
+		t.Error("paquet tronque accepte")
+	}
+	end, err := parseITCHPacket(appendITCHPacket(nil, "TEST", 43, itchEndOfSession, nil))
+	if err != nil || end.count != itchEndOfSession || len(end.msgs) != 0 {
+		t.Errorf("fin de session: %+v %v", end, err)
+	}
+}
+
+// startITCHFeed publie le flux de gw vers un recepteur local. opts.drop
+// simule la perte de paquets.
+func startITCHFeed(t *testing.T, gw *Gateway, opts ITCHOptions) (*ITCHPublisher, *ITCHReceiver) {
+	t.Helper()
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	conn, err := net.Dial("udp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	opts.Session = "TEST"
+	opts.Heartbeat = 20 * time.Millisecond
+	pub, err := NewITCHPublisher(gw, conn, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pub.Close() })
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go pub.ServeRetransmit(l)
+
+	r := NewITCHReceiver(udp, l.Addr().String())
+	r.ReadTimeout = 2 * time.Second
+	t.Cleanup(func() { r.Close() })
+	return pub, r
+}
+
+// readITCH lit les messages jusqu'a la fin de session et verifie la
+// continuite des numeros.
+func readITCH(t *testing.T, r *ITCHReceiver) []any {
+	t.Helper()
+	var msgs []any
+	for {
+		seq, msg, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("Next apres %d messages: %v", len(msgs), err)
+		}
+		if seq != uint64(len(msgs)+1) {
+			t.Fatalf("numero %d, attendu %d", seq, len(msgs)+1)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// TestITCHFeed verifie la traduction des Events et la livraison complete
+// malgre des paquets perdus, y compris le dernier.
+func TestITCHFeed(t *testing.T) {
+	gw, _ := newTestGateway()
+	lost := map[uint64]bool{2: true, 5: true, 7: true}
+	// Un message par paquet : drop vise un message precis.
+	pub, r := startITCHFeed(t, gw, ITCHOptions{
+		MaxPacket: itchHeaderLen + 2 + itchTradeLen,
+		Checksum:  -1,
+		drop:      func(seq uint64) bool { return lost[seq] },
+	})
+
+	sell := NewLimitOrder("AAPL", Sell, 10, 100)
+	mustSubmit(t, gw, sell)
+	buy := NewLimitOrder("AAPL", Buy, 10, 40)
+	trades := mustSubmit(t, gw, buy)
+	if _, err := gw.Amend("AAPL", sell.ID, 0, 80); err != nil { // Reduction : priorite gardee
+		t.Fatal(err)
+	}
+	if _, err := gw.Amend("AAPL", sell.ID, 10.5, 0); err != nil { // Nouveau prix : Delete puis Add
+		t.Fatal(err)
+	}
+	if err := gw.Cancel("AAPL", sell.ID); err != nil {
+		t.Fatal(err)
+	}
+	gw.Submit(NewMarketOrder("MSFT", Buy, 10)) // Aucun message : jamais au carnet
+	if got := pub.NextSeq(); got != 8 {
+		t.Fatalf("NextSeq = %d, attendu 8", got)
+	}
+	pub.EndSession()
+
+	msgs := readITCH(t, r)
+	if len(msgs) != 7 {
+		t.Fatalf("%d messages, attendu 7: %+v", len(msgs), msgs)
+	}
+	match := trades[0].ID
+	want := []any{
+		ITCHAddOrder{OrderRef: sell.ID, Side: Sell, Shares: 100, Symbol: "AAPL", Price: 10},
+		ITCHTrade{Symbol: "AAPL", Shares: 40, Price: 10, MatchNumber: match, BuyRef: buy.ID, SellRef: sell.ID},
+		ITCHOrderExecuted{OrderRef: sell.ID, Shares: 40, MatchNumber: match},
+		ITCHOrderCancel{OrderRef: sell.ID, Shares: 20},
+		ITCHOrderDelete{OrderRef: sell.ID},
+		ITCHAddOrder{OrderRef: sell.ID, Side: Sell, Shares: 40, Symbol: "AAPL", Price: 10.5},
+		ITCHOrderDelete{OrderRef: sell.ID},
+	}
+	for i, m := range msgs {
+		// Les horodatages viennent de l'horloge du Gateway : seul leur
+		// remplissage est verifie.
+		v := reflect.ValueOf(&m).Elem()
+		c := reflect.New(v.Elem().Type()).Elem()
+		c.Set(v.Elem())
+		if c.FieldByName("Timestamp").Int() == 0 {
+			t.Errorf("message %d sans horodatage: %+v", i+1, m)
+		}
+		c.FieldByName("Timestamp").SetInt(0)
+		if got := c.Interface(); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("message %d: %+v, attendu %+v", i+1, got, want[i])
+		}
+	}
+	if r.Gaps == 0 || r.Recovered != len(lost) {
+		t.Errorf("Gaps = %d, Recovered = %d, attendu > 0 et %d", r.Gaps, r.Recovered, len(lost))
+	}
+}
+
+// TestITCHBustAndCorrect verifie la publication des corrections de trades.
+func TestITCHBustAndCorrect(t *testing.T) {
+	gw, _ := newTestGateway()
+	pub, r := startITCHFeed(t, gw, ITCHOptions{Checksum: -1})
+
+	mustSubmit(t, gw, NewLimitOrder("MSFT", Sell, 20, 10))
+	trades := mustSubmit(t, gw, NewLimitOrder("MSFT", Buy, 20, 10))
+	mustSubmit(t, gw, NewLimitOrder("MSFT", Sell, 21, 5))
+	second := mustSubmit(t, gw, NewLimitOrder("MSFT", Buy, 21, 5))
+	if _, err := gw.CorrectTrade(trades[0].ID, 19.5, 8, "ops", "prix errone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.BustTrade(second[0].ID, "ops", "erreur manifeste"); err != nil {
+		t.Fatal(err)
+	}
+	pub.EndSession()
+
+	msgs := readITCH(t, r)
+	if len(msgs) < 3 {
+		t.Fatalf("%d messages: %+v", len(msgs), msgs)
+	}
+	tail := msgs[len(msgs)-3:]
+	if b, ok := tail[0].(ITCHBrokenTrade); !ok || b.MatchNumber != trades[0].ID {
+		t.Errorf("correction: %+v, attendu BrokenTrade %d", tail[0], trades[0].ID)
+	}
+	if tr, ok := tail[1].(ITCHTrade); !ok || tr.MatchNumber != trades[0].ID || tr.Price != 19.5 || tr.Shares != 8 || tr.BuyRef != 0 {
+		t.Errorf("correction: %+v, attendu Trade corrige sans OrderRef", tail[1])
+	}
+	if b, ok := tail[2].(ITCHBrokenTrade); !ok || b.MatchNumber != second[0].ID {
+		t.Errorf("bust: %+v, attendu BrokenTrade %d", tail[2], second[0].ID)
+	}
+}
+
+// itchRemaining applique les messages A/E/X/D et retourne la quantite
+// restante de chaque ordre au carnet.
+func itchRemaining(t *testing.T, msgs []any) map[uint64]int64 {
+	t.Helper()
+	left := make(map[uint64]int64)
+	for _, m := range msgs {
+		switch m := m.(type) {
+		case ITCHAddOrder:
+			left[m.OrderRef] = m.Shares
+		case ITCHOrderExecuted:
+			left[m.OrderRef] -= m.Shares
+		case ITCHOrderCancel:
+			left[m.OrderRef] -= m.Shares
+		case ITCHOrderDelete:
+			delete(left, m.OrderRef)
+		}
+	}
+	for ref, n := range left {
+		if n < 0 {
+			t.Errorf("ordre %d: quantite %d", ref, n)
+		}
+		if n == 0 {
+			delete(left, ref)
+		}
+	}
+	return left
+}
+
+// TestITCHLinkedResize verifie le suivi des sorties de bracket : hausse
+// sans perte de priorite et retour au carnet apres un fill complet.
+func TestITCHLinkedResize(t *testing.T) {
+	gw, _ := newTestGateway()
+	pub, r := startITCHFeed(t, gw, ITCHOptions{Checksum: -1})
+
+	entry := NewLimitOrder("AAPL", Buy, 190.00, 100)
+	takeProfit := NewLimitOrder("AAPL", Sell, 195.00, 100)
+	if _, _, err := gw.SubmitBracket(entry, takeProfit, NewStopOrder("AAPL", Sell, 185.00, 100)); err != nil {
+		t.Fatal(err)
+	}
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 30)) // Sortie posee pour 30
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 50)) // Hausse a 80
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 195.00, 80))  // Sortie executee
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 20)) // Sortie reactivee pour 20
+	pub.EndSession()
+
+	got := itchRemaining(t, readITCH(t, r))
+	want := map[uint64]int64{takeProfit.ID: 20}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("carnet reconstruit: %v, attendu %v", got, want)
+	}
+}
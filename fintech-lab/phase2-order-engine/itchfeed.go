@@ -0,0 +1,557 @@
+// itchfeed.go — Publication du flux ITCH (voir itch.go) et serveur de
+// retransmission.
+//
+// Le publisher traduit les Events du Gateway en messages numerotes, les
+// garde dans un journal en memoire (la session) et les envoie en paquets
+// UDP vers une adresse multicast ou unicast. Le moteur ne bloque jamais :
+// onEvent ajoute au journal et reveille l'emetteur, qui envoie tout ce qui
+// n'est pas encore parti.
+//
+// Toutes les Checksum, un BookChecksum par symbole est publie si le flux
+// a bouge, calcule sur le carnet du moteur : les clients (bookbuilder.go)
+// detectent ainsi toute divergence. Le publisher tient lui-meme un
+// BookBuilder, qui verifie sa propre traduction et sert les snapshots.
+//
+// RETRANSMISSION ET SNAPSHOTS (TCP, frames de ouch.go) :
+//
+//	requete  'R'  [seq u64][count u16]
+//	reponse  'P'  un paquet (meme format qu'en UDP) a partir de seq, au plus
+//	              itchMaxRetransmit messages ; count 0 si seq n'est pas
+//	              encore publie.
+//	requete  'S'  (vide)
+//	reponse  'N'  paquets d'AddOrder, seq = premier message apres le
+//	              snapshot, termines par un paquet vide (voir ITCHSnapshot).
+//
+// Lancer : go run ./phase2-order-engine/ serve -itch 239.1.1.1:30001 -itch-retrans :30002
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Valeurs par defaut des options ITCH.
+const (
+	defaultITCHMaxPacket = 1400 // Tient dans une trame Ethernet avec les en-tetes IP/UDP
+	defaultITCHHeartbeat = time.Second
+	defaultITCHChecksum  = time.Second
+)
+
+// Requetes et reponses du serveur TCP (retransmission, snapshots).
+const (
+	itchRetransRequest  uint8 = 'R'
+	itchRetransPacket   uint8 = 'P'
+	itchSnapshotRequest uint8 = 'S'
+	itchSnapshotPacket  uint8 = 'N'
+	itchRetransLen            = 8 + 2
+	itchMaxRetransmit         = 8192 // Messages par reponse (la frame reste sous maxFramePayload)
+)
+
+// ITCHOptions regle le publisher. Les zeros prennent les defauts.
+type ITCHOptions struct {
+	Session   string        // Nom de session, 10 octets max (defaut : "GME" + AAMMJJ)
+	MaxPacket int           // Taille max d'un datagramme
+	Heartbeat time.Duration // Paquet vide apres ce silence
+	Checksum  time.Duration // Intervalle des BookChecksum (< 0 : aucun)
+	Log       io.Writer     // Journal (nil : aucun)
+
+	drop func(seq uint64) bool // Tests : paquets "perdus" (seq du premier message)
+}
+
+// itchOrder suit un ordre pour savoir s'il est au carnet et ce qu'il y
+// reste.
+type itchOrder struct {
+	price  float64
+	qty    int64
+	filled int64
+	onBook bool
+	rested bool // Deja pose au carnet au moins une fois
+	linked bool // Sortie de bracket : peut revenir au carnet apres un fill complet
+}
+
+// ---------------------------------------------------------------------------
+// ITCHPublisher
+// ---------------------------------------------------------------------------
+
+// ITCHPublisher publie les Events d'un Gateway en flux ITCH.
+type ITCHPublisher struct {
+	gw   *Gateway
+	conn net.Conn // UDP connecte a la destination
+	opts ITCHOptions
+
+	mu        sync.Mutex
+	journal   [][]byte // journal[seq-1] : message seq
+	orders    map[uint64]*itchOrder
+	book      *BookBuilder // Carnets tels que publies
+	checked   int          // len(journal) au dernier tour de checksums
+	diverged  int          // Checksums du moteur differents du carnet publie
+	listeners []net.Listener
+	retrans   map[net.Conn]struct{}
+	ended     bool // Fin de session publiee
+	closed    bool
+
+	wake       chan struct{}
+	done       chan struct{} // Fin de session demandee
+	flushed    chan struct{} // Tout est envoye, fin de session comprise
+	stop       chan struct{} // Close : plus de repetition de la fin de session
+	senderDone chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewITCHPublisher publie le flux de gw sur conn (net.Dial("udp", ...)),
+// a partir du numero 1.
+func NewITCHPublisher(gw *Gateway, conn net.Conn, opts ITCHOptions) (*ITCHPublisher, error) {
+	if opts.Session == "" {
+		opts.Session = "GME" + time.Now().Format("060102")
+	}
+	if len(opts.Session) > itchSessionLen {
+		return nil, fmt.Errorf("itch: session %q: plus de %d octets", opts.Session, itchSessionLen)
+	}
+	if opts.MaxPacket <= 0 {
+		opts.MaxPacket = defaultITCHMaxPacket
+	}
+	if opts.MaxPacket < itchHeaderLen+2+itchTradeLen {
+		return nil, fmt.Errorf("itch: MaxPacket %d trop petit", opts.MaxPacket)
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = defaultITCHHeartbeat
+	}
+	if opts.Checksum == 0 {
+		opts.Checksum = defaultITCHChecksum
+	}
+	p := &ITCHPublisher{
+		gw:         gw,
+		conn:       conn,
+		opts:       opts,
+		orders:     make(map[uint64]*itchOrder),
+		book:       NewBookBuilder(),
+		retrans:    make(map[net.Conn]struct{}),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		flushed:    make(chan struct{}),
+		stop:       make(chan struct{}),
+		senderDone: make(chan struct{}),
+	}
+	gw.Subscribe(p.onEvent)
+	go p.sendLoop()
+	return p, nil
+}
+
+// Session retourne le nom de session publie dans chaque paquet.
+func (p *ITCHPublisher) Session() string {
+	return p.opts.Session
+}
+
+// NextSeq retourne le numero du prochain message.
+func (p *ITCHPublisher) NextSeq() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return uint64(len(p.journal)) + 1
+}
+
+// EndSession envoie ce qui reste et publie la fin de session, repetee a
+// chaque Heartbeat jusqu'a Close (un datagramme se perd). Les Events
+// suivants sont ignores ; la retransmission reste servie jusqu'a Close pour
+// les recepteurs qui ont perdu les derniers paquets.
+func (p *ITCHPublisher) EndSession() {
+	p.mu.Lock()
+	if p.ended {
+		p.mu.Unlock()
+		return
+	}
+	p.ended = true
+	p.mu.Unlock()
+
+	close(p.done)
+	<-p.flushed
+}
+
+// Close termine la session si besoin et arrete la retransmission. La
+// connexion UDP reste a l'appelant.
+func (p *ITCHPublisher) Close() error {
+	p.EndSession()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	for _, l := range p.listeners {
+		l.Close()
+	}
+	for c := range p.retrans {
+		c.Close()
+	}
+	p.mu.Unlock()
+	<-p.senderDone
+	p.wg.Wait()
+	return nil
+}
+
+func (p *ITCHPublisher) logf(format string, args ...any) {
+	if p.opts.Log != nil {
+		fmt.Fprintf(p.opts.Log, "[ITCH] "+format+"\n", args...)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Events -> messages
+// ---------------------------------------------------------------------------
+
+// onEvent traduit un Event en messages ITCH. Appele avec gw.mu tenu.
+func (p *ITCHPublisher) onEvent(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ended {
+		return
+	}
+	n := len(p.journal)
+
+	o := p.orders[e.OrderID]
+	switch e.Type {
+	case EventAccepted:
+		p.orders[e.OrderID] = &itchOrder{price: e.Price, qty: e.Quantity}
+	case EventRested:
+		if o == nil || e.OrderType == Stop { // Stop en attente : hors carnet
+			break
+		}
+		o.onBook, o.rested, o.price = true, true, e.Price
+		p.publish(ITCHAddOrder{e.Timestamp, e.OrderID, e.Side, o.qty - o.filled, e.Symbol, e.Price})
+	case EventFilled:
+		if e.Side == Buy {
+			p.publish(ITCHTrade{e.Timestamp, e.Symbol, e.Quantity, e.Price, e.TradeID, e.OrderID, e.ContraID})
+		}
+		if o == nil {
+			break
+		}
+		if o.onBook {
+			p.publish(ITCHOrderExecuted{e.Timestamp, e.OrderID, e.Quantity, e.TradeID})
+		}
+		if o.filled += e.Quantity; o.filled >= o.qty {
+			o.onBook = false
+			if !o.linked {
+				delete(p.orders, e.OrderID)
+			}
+		}
+	case EventAmended:
+		if o == nil {
+			break
+		}
+		// Jambe de groupe redimensionnee (linked.go) : l'ordre garde sa
+		// priorite et ne repasse pas par le matching.
+		resize := e.Reason == string(OCO) || e.Reason == string(Bracket)
+		if e.Reason == reasonBracketArmed {
+			o.linked = true
+		}
+		switch {
+		case !o.onBook:
+			if resize && o.rested && e.OrderType == Limit && e.Quantity > o.filled {
+				// Sortie de bracket reactivee par un nouveau fill de l'entree.
+				o.onBook = true
+				p.publish(ITCHAddOrder{e.Timestamp, e.OrderID, e.Side, e.Quantity - o.filled, e.Symbol, e.Price})
+			}
+		case e.Price == o.price && e.Quantity < o.qty:
+			p.publish(ITCHOrderCancel{e.Timestamp, e.OrderID, o.qty - e.Quantity})
+			o.onBook = e.Quantity > o.filled
+		case resize:
+			// Hausse sans perte de priorite : Delete puis Add. Le carnet
+			// reconstruit est exact au niveau de prix, pas dans la file.
+			p.publish(ITCHOrderDelete{e.Timestamp, e.OrderID})
+			p.publish(ITCHAddOrder{e.Timestamp, e.OrderID, e.Side, e.Quantity - o.filled, e.Symbol, e.Price})
+		case e.Price != o.price || e.Quantity > o.qty:
+			// Perte de priorite : EventRested (et AddOrder) suivra s'il reste au carnet.
+			o.onBook = false
+			p.publish(ITCHOrderDelete{e.Timestamp, e.OrderID})
+		}
+		o.price, o.qty = e.Price, e.Quantity
+		if !o.onBook && !o.linked && o.filled >= o.qty {
+			delete(p.orders, e.OrderID)
+		}
+	case EventCancelled:
+		if o != nil && o.onBook {
+			p.publish(ITCHOrderDelete{e.Timestamp, e.OrderID})
+		}
+		delete(p.orders, e.OrderID)
+	case EventTradeBusted:
+		p.publish(ITCHBrokenTrade{e.Timestamp, e.TradeID})
+	case EventTradeCorrected:
+		p.publish(ITCHBrokenTrade{e.Timestamp, e.TradeID})
+		p.publish(ITCHTrade{Timestamp: e.Timestamp, Symbol: e.Symbol, Shares: e.Quantity, Price: e.Price, MatchNumber: e.TradeID})
+	}
+
+	if len(p.journal) > n {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// publish ajoute un message au journal et l'applique au carnet publie.
+// Appele avec p.mu tenu.
+func (p *ITCHPublisher) publish(m itchMessage) {
+	p.journal = append(p.journal, m.encode())
+	if err := p.book.apply(m); err != nil {
+		p.diverged++
+		p.logf("message %d: %v", len(p.journal), err)
+	}
+}
+
+// publishChecksums publie le checksum de chaque carnet du moteur, si des
+// messages sont parus depuis le dernier tour.
+func (p *ITCHPublisher) publishChecksums() {
+	p.mu.Lock()
+	idle := len(p.journal) == p.checked
+	p.mu.Unlock()
+	if idle {
+		return
+	}
+	now := p.gw.Clock().Now()
+	p.gw.bookChecksums(func(symbol string, sum uint32) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.ended {
+			p.publish(ITCHBookChecksum{now, symbol, sum})
+			p.checked = len(p.journal)
+		}
+	})
+}
+
+// snapshot retourne les carnets publies et le numero du message suivant.
+func (p *ITCHPublisher) snapshot() (uint64, []ITCHAddOrder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return uint64(len(p.journal)) + 1, p.book.snapshot()
+}
+
+// messages retourne les messages a partir de seq, au plus max (0 : tous).
+// Les messages du journal ne sont jamais modifies : la tranche reste
+// valable hors verrou.
+func (p *ITCHPublisher) messages(seq uint64, max int) [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if seq < 1 || seq > uint64(len(p.journal)) {
+		return nil
+	}
+	msgs := p.journal[seq-1:]
+	if max > 0 && len(msgs) > max {
+		msgs = msgs[:max]
+	}
+	return msgs
+}
+
+// ---------------------------------------------------------------------------
+// Emission UDP
+// ---------------------------------------------------------------------------
+
+// sendLoop envoie les nouveaux messages a chaque reveil, les checksums a
+// intervalle regulier, un heartbeat apres chaque silence, et la fin de
+// session a l'arret, repetee jusqu'a Close.
+func (p *ITCHPublisher) sendLoop() {
+	defer close(p.senderDone)
+	tick := time.NewTicker(max(p.opts.Heartbeat/4, time.Millisecond))
+	defer tick.Stop()
+	var checksums <-chan time.Time
+	if p.opts.Checksum > 0 {
+		t := time.NewTicker(p.opts.Checksum)
+		defer t.Stop()
+		checksums = t.C
+	}
+
+	next := uint64(1)
+	lastSent := time.Now()
+	buf := make([]byte, 0, p.opts.MaxPacket)
+	for {
+		select {
+		case <-p.wake:
+		case <-checksums:
+			p.publishChecksums()
+		case <-tick.C:
+			if time.Since(lastSent) < p.opts.Heartbeat {
+				continue
+			}
+			p.send(appendITCHPacket(buf[:0], p.opts.Session, next, 0, nil), next)
+			lastSent = time.Now()
+			continue
+		case <-p.done:
+			next = p.flush(next, buf)
+			end := appendITCHPacket(buf[:0], p.opts.Session, next, itchEndOfSession, nil)
+			p.send(end, next)
+			close(p.flushed)
+			repeat := time.NewTicker(p.opts.Heartbeat)
+			defer repeat.Stop()
+			for {
+				select {
+				case <-repeat.C:
+					p.send(end, next)
+				case <-p.stop:
+					return
+				}
+			}
+		}
+		next = p.flush(next, buf)
+		lastSent = time.Now()
+	}
+}
+
+// flush envoie les messages a partir de next, en paquets pleins, et
+// retourne le prochain numero a envoyer.
+func (p *ITCHPublisher) flush(next uint64, buf []byte) uint64 {
+	msgs := p.messages(next, 0)
+	for len(msgs) > 0 {
+		size, n := itchHeaderLen, 0
+		for n < len(msgs) && n < itchMaxCount && size+2+len(msgs[n]) <= p.opts.MaxPacket {
+			size += 2 + len(msgs[n])
+			n++
+		}
+		p.send(appendITCHPacket(buf[:0], p.opts.Session, next, 0, msgs[:n]), next)
+		next += uint64(n)
+		msgs = msgs[n:]
+	}
+	return next
+}
+
+// send ecrit un datagramme. Une erreur d'emission n'arrete pas le flux :
+// les recepteurs recupereront les messages par retransmission.
+func (p *ITCHPublisher) send(packet []byte, seq uint64) {
+	if p.opts.drop != nil && p.opts.drop(seq) {
+		return
+	}
+	if _, err := p.conn.Write(packet); err != nil {
+		p.logf("emission du paquet %d: %v", seq, err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Retransmission TCP
+// ---------------------------------------------------------------------------
+
+// ServeRetransmit repond aux demandes de retransmission recues sur l,
+// jusqu'a Close.
+func (p *ITCHPublisher) ServeRetransmit(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return net.ErrClosed
+	}
+	p.listeners = append(p.listeners, l)
+	p.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			nc.Close()
+			return nil
+		}
+		p.retrans[nc] = struct{}{}
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go func() {
+			defer p.wg.Done()
+			p.retransmit(nc)
+			p.mu.Lock()
+			delete(p.retrans, nc)
+			p.mu.Unlock()
+			nc.Close()
+		}()
+	}
+}
+
+// retransmit sert les requetes d'une connexion jusqu'a sa fermeture.
+func (p *ITCHPublisher) retransmit(nc net.Conn) {
+	br := bufio.NewReader(nc)
+	var buf []byte
+	for {
+		f, err := DecodeFrame(br)
+		if err != nil {
+			return
+		}
+		buf = buf[:0]
+		switch {
+		case f.Type == itchRetransRequest && len(f.Payload) == itchRetransLen:
+			seq := binary.BigEndian.Uint64(f.Payload)
+			count := int(binary.BigEndian.Uint16(f.Payload[8:]))
+			var msgs [][]byte
+			if count > 0 {
+				msgs = p.messages(seq, min(count, itchMaxRetransmit))
+			}
+			buf = appendFrame(buf, itchRetransPacket, appendITCHPacket(nil, p.opts.Session, seq, 0, msgs))
+		case f.Type == itchSnapshotRequest:
+			buf = p.appendSnapshot(buf)
+		default:
+			p.logf("%s: requete de retransmission invalide %q", nc.RemoteAddr(), f.Type)
+			return
+		}
+		if _, err := nc.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+// appendSnapshot ajoute a dst les frames d'un snapshot : paquets d'au plus
+// itchMaxRetransmit AddOrder, puis un paquet vide.
+func (p *ITCHPublisher) appendSnapshot(dst []byte) []byte {
+	seq, orders := p.snapshot()
+	msgs := make([][]byte, 0, min(len(orders), itchMaxRetransmit))
+	for len(orders) > 0 {
+		n := min(len(orders), itchMaxRetransmit)
+		msgs = msgs[:0]
+		for _, o := range orders[:n] {
+			msgs = append(msgs, o.encode())
+		}
+		dst = appendFrame(dst, itchSnapshotPacket, appendITCHPacket(nil, p.opts.Session, seq, 0, msgs))
+		orders = orders[n:]
+	}
+	return appendFrame(dst, itchSnapshotPacket, appendITCHPacket(nil, p.opts.Session, seq, 0, nil))
+}
+
+// ---------------------------------------------------------------------------
+// Branchement sur les sous-commandes
+// ---------------------------------------------------------------------------
+
+// startITCH publie le flux de gw vers feed (UDP, multicast ou unicast) et
+// sert les retransmissions sur retrans. feed vide : pas de flux (nil).
+func startITCH(gw *Gateway, feed, retrans string, log io.Writer) (*ITCHPublisher, error) {
+	if feed == "" {
+		return nil, nil
+	}
+	if retrans == "" {
+		return nil, fmt.Errorf("itch: -itch-retrans requis avec -itch")
+	}
+	conn, err := net.Dial("udp", feed)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewITCHPublisher(gw, conn, ITCHOptions{Log: log})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	l, err := net.Listen("tcp", retrans)
+	if err != nil {
+		p.Close()
+		conn.Close()
+		return nil, err
+	}
+	go p.ServeRetransmit(l)
+	fmt.Fprintf(log, "GME ITCH session %s sur %s (retransmission %s)\n", p.Session(), feed, retrans)
+	return p, nil
+}
@@ -0,0 +1,336 @@
+// itchreceiver.go — Recepteur du flux ITCH (voir itch.go, itchfeed.go).
+//
+// Le recepteur lit les paquets UDP et livre les messages dans l'ordre
+// strict des numeros, sans trou ni doublon :
+//   - paquet deja vu (ou en retard apres une retransmission) : ignore ;
+//   - paquet ou heartbeat au-dela du numero attendu : trou, les messages
+//     manquants sont demandes au serveur de retransmission (TCP) avant de
+//     livrer la suite ;
+//   - fin de session : les derniers messages sont recuperes si besoin, puis
+//     Next retourne io.EOF.
+//
+// Un recepteur qui arrive en cours de session demande un Snapshot des
+// carnets (voir bookbuilder.go) : la livraison reprend juste apres.
+//
+//	r := NewITCHReceiver(udpConn, "localhost:30002")
+//	for {
+//		seq, msg, err := r.Next() // ITCHAddOrder, ITCHOrderExecuted, ...
+//	}
+//
+// Lancer : go run ./phase2-order-engine/ itch-listen -feed 239.1.1.1:30001 -retrans localhost:30002
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// ITCHReceiver reconstitue le flux ordonne. Non sur pour un usage
+// concurrent : une goroutine appelle Next.
+type ITCHReceiver struct {
+	Session     string        // Session suivie (fixee par le premier paquet si vide)
+	ReadTimeout time.Duration // Delai max d'attente d'un paquet (0 : aucun)
+	Gaps        int           // Trous detectes
+	Recovered   int           // Messages obtenus par retransmission
+
+	conn        net.PacketConn
+	retransAddr string
+	retrans     net.Conn
+	rbr         *bufio.Reader
+
+	next  uint64   // Prochain numero a livrer a l'application
+	ready [][]byte // Messages prets, a partir de next - len(ready)
+	ended bool
+	buf   []byte
+}
+
+// NewITCHReceiver lit le flux sur conn (net.ListenUDP ou
+// net.ListenMulticastUDP) et demande les messages manquants a retransAddr.
+// La livraison commence au message 1.
+func NewITCHReceiver(conn net.PacketConn, retransAddr string) *ITCHReceiver {
+	return &ITCHReceiver{
+		conn:        conn,
+		retransAddr: retransAddr,
+		next:        1,
+		buf:         make([]byte, 64<<10),
+	}
+}
+
+// Next retourne le prochain message et son numero. io.EOF : fin de
+// session, tout a ete livre.
+func (r *ITCHReceiver) Next() (uint64, any, error) {
+	for len(r.ready) == 0 {
+		if r.ended {
+			return 0, nil, io.EOF
+		}
+		if err := r.receive(); err != nil {
+			return 0, nil, err
+		}
+	}
+	seq := r.next - uint64(len(r.ready))
+	raw := r.ready[0]
+	r.ready[0] = nil
+	r.ready = r.ready[1:]
+	msg, err := DecodeITCHMessage(raw)
+	if err != nil {
+		return seq, nil, fmt.Errorf("itch: message %d: %w", seq, err)
+	}
+	return seq, msg, nil
+}
+
+// Close ferme la connexion de retransmission. La connexion UDP reste a
+// l'appelant.
+func (r *ITCHReceiver) Close() error {
+	if r.retrans != nil {
+		return r.retrans.Close()
+	}
+	return nil
+}
+
+// receive lit un paquet et ajoute a ready les messages qu'il debloque.
+func (r *ITCHReceiver) receive() error {
+	if r.ReadTimeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.ReadTimeout))
+	}
+	n, _, err := r.conn.ReadFrom(r.buf)
+	if err != nil {
+		return err
+	}
+	p, err := parseITCHPacket(r.buf[:n])
+	if err != nil {
+		return nil // Datagramme illisible : le trou sera vu au suivant
+	}
+	if r.Session == "" {
+		r.Session = p.session
+	}
+	if p.session != r.Session {
+		return nil
+	}
+
+	if p.count == itchEndOfSession {
+		if p.seq > r.next {
+			if err := r.recover(p.seq - 1); err != nil {
+				return err
+			}
+		}
+		r.ended = true
+		return nil
+	}
+	if p.seq > r.next {
+		if err := r.recover(p.seq - 1); err != nil {
+			return err
+		}
+	}
+	r.accept(p.seq, p.msgs, true)
+	return nil
+}
+
+// accept ajoute les messages nouveaux d'un paquet commencant a seq.
+// copyMsgs : les messages pointent dans r.buf, reutilise au prochain paquet.
+func (r *ITCHReceiver) accept(seq uint64, msgs [][]byte, copyMsgs bool) {
+	for i, m := range msgs {
+		if seq+uint64(i) != r.next {
+			continue // Doublon
+		}
+		if copyMsgs {
+			m = append([]byte(nil), m...)
+		}
+		r.ready = append(r.ready, m)
+		r.next++
+	}
+}
+
+// recover demande au serveur de retransmission les messages de r.next a
+// last inclus.
+func (r *ITCHReceiver) recover(last uint64) error {
+	r.Gaps++
+	for r.next <= last {
+		if err := r.dialRetrans(); err != nil {
+			return err
+		}
+		req := binary.BigEndian.AppendUint64(nil, r.next)
+		req = binary.BigEndian.AppendUint16(req, uint16(min(last-r.next+1, itchMaxCount)))
+		r.retrans.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := r.retrans.Write(EncodeFrame(Frame{Type: itchRetransRequest, Payload: req})); err != nil {
+			return r.retransFailed(err)
+		}
+		f, err := DecodeFrame(r.rbr)
+		if err != nil {
+			return r.retransFailed(err)
+		}
+		p, err := parseITCHPacket(f.Payload)
+		if err != nil || f.Type != itchRetransPacket {
+			return r.retransFailed(fmt.Errorf("reponse invalide %q (%v)", f.Type, err))
+		}
+		if p.session != r.Session || p.seq != r.next || len(p.msgs) == 0 {
+			return r.retransFailed(fmt.Errorf("messages %d-%d indisponibles (session %q)", r.next, last, p.session))
+		}
+		before := r.next
+		r.accept(p.seq, p.msgs, false) // f.Payload n'est pas reutilise
+		r.Recovered += int(r.next - before)
+	}
+	return nil
+}
+
+// Snapshot demande l'etat des carnets au serveur de retransmission et
+// repositionne la livraison : Next reprend au premier message apres le
+// snapshot. A appeler avant le premier Next, ou pour repartir apres une
+// divergence.
+func (r *ITCHReceiver) Snapshot() (*ITCHSnapshot, error) {
+	if err := r.dialRetrans(); err != nil {
+		return nil, err
+	}
+	r.retrans.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := r.retrans.Write(EncodeFrame(Frame{Type: itchSnapshotRequest})); err != nil {
+		return nil, r.retransFailed(err)
+	}
+	var s *ITCHSnapshot
+	for {
+		f, err := DecodeFrame(r.rbr)
+		if err != nil {
+			return nil, r.retransFailed(err)
+		}
+		p, err := parseITCHPacket(f.Payload)
+		if err != nil || f.Type != itchSnapshotPacket {
+			return nil, r.retransFailed(fmt.Errorf("snapshot invalide %q (%v)", f.Type, err))
+		}
+		if s == nil {
+			s = &ITCHSnapshot{Session: p.session, Seq: p.seq}
+		}
+		if len(p.msgs) == 0 {
+			break
+		}
+		for _, raw := range p.msgs {
+			m, err := DecodeITCHMessage(raw)
+			add, ok := m.(ITCHAddOrder)
+			if err != nil || !ok {
+				return nil, r.retransFailed(fmt.Errorf("snapshot: message %T invalide (%v)", m, err))
+			}
+			s.Orders = append(s.Orders, add)
+		}
+	}
+	if r.Session == "" {
+		r.Session = s.Session
+	}
+	if s.Session != r.Session {
+		return nil, fmt.Errorf("itch: snapshot de la session %q, attendu %q", s.Session, r.Session)
+	}
+	r.next, r.ready = s.Seq, nil
+	return s, nil
+}
+
+// dialRetrans ouvre la connexion de retransmission si besoin.
+func (r *ITCHReceiver) dialRetrans() error {
+	if r.retrans != nil {
+		return nil
+	}
+	nc, err := net.DialTimeout("tcp", r.retransAddr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("itch: retransmission: %w", err)
+	}
+	r.retrans, r.rbr = nc, bufio.NewReader(nc)
+	return nil
+}
+
+// retransFailed ferme la connexion de retransmission (rouverte au prochain
+// trou) et retourne l'erreur.
+func (r *ITCHReceiver) retransFailed(err error) error {
+	r.retrans.Close()
+	r.retrans, r.rbr = nil, nil
+	return fmt.Errorf("itch: retransmission: %w", err)
+}
+
+// ---------------------------------------------------------------------------
+// Sous-commande itch-listen
+// ---------------------------------------------------------------------------
+
+// runITCHListen affiche le flux ITCH recu, jusqu'a la fin de session ou
+// Ctrl+C. Avec -book, reconstruit les carnets depuis un snapshot et affiche
+// le BBO de chaque symbole a chaque checksum verifie.
+func runITCHListen(args []string) error {
+	fs := flag.NewFlagSet("itch-listen", flag.ContinueOnError)
+	feed := fs.String("feed", "239.1.1.1:30001", "adresse UDP du flux (multicast ou locale)")
+	retrans := fs.String("retrans", "localhost:30002", "serveur de retransmission")
+	books := fs.Bool("book", false, "reconstruire les carnets et afficher les BBO")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", *feed)
+	if err != nil {
+		return err
+	}
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", nil, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	r := NewITCHReceiver(conn, *retrans)
+	defer r.Close()
+	var b *BookBuilder
+	if *books {
+		b = NewBookBuilder()
+		if err := resyncBooks(r, b); err != nil {
+			return err
+		}
+	}
+	for {
+		seq, msg, err := r.Next()
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			fmt.Printf("fin : %d trous, %d messages retransmis\n", r.Gaps, r.Recovered)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			fmt.Printf("%8d %T %+v\n", seq, msg, msg)
+			continue
+		}
+		if err := b.Apply(seq, msg); err != nil {
+			fmt.Printf("%8d %v : resynchronisation\n", seq, err)
+			if err := resyncBooks(r, b); err != nil {
+				return err
+			}
+			continue
+		}
+		if c, ok := msg.(ITCHBookChecksum); ok {
+			book, _ := b.Book(c.Symbol)
+			if book == nil {
+				book = &LocalBook{Symbol: c.Symbol}
+			}
+			bid, ask := book.BBO()
+			fmt.Printf("%8d %-6s %6d @ %-10.4f | %-10.4f x %d\n", seq, c.Symbol, bid.Quantity, bid.Price, ask.Price, ask.Quantity)
+		}
+	}
+}
+
+// resyncBooks recharge les carnets depuis un snapshot.
+func resyncBooks(r *ITCHReceiver, b *BookBuilder) error {
+	snap, err := r.Snapshot()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("snapshot : %d ordres, reprise au message %d\n", len(snap.Orders), snap.Seq)
+	return b.LoadSnapshot(snap)
+}
@@ -0,0 +1,346 @@
+// linked.go — Ordres lies : OCO (One-Cancels-Other) et bracket.
+// Le Gateway suit chaque groupe et reequilibre ses jambes apres chaque fill.
+
+package main
+
+import (
+	"fmt"
+)
+
+// ---------------------------------------------------------------------------
+// Types de groupes
+// ---------------------------------------------------------------------------
+
+// GroupType definit la relation entre les ordres d'un groupe.
+type GroupType string
+
+const (
+	OCO     GroupType = "OCO"     // Deux jambes : ce qui s'execute sur l'une est retire de l'autre
+	Bracket GroupType = "BRACKET" // Entree dont les fills activent une paire OCO take-profit/stop-loss
+)
+
+// GroupStatus suit le cycle de vie d'un groupe.
+type GroupStatus string
+
+const (
+	GroupPending   GroupStatus = "PENDING"   // Bracket : entree pas encore executee
+	GroupActive    GroupStatus = "ACTIVE"    // Au moins une jambe peut encore s'executer
+	GroupDone      GroupStatus = "DONE"      // Quantite du groupe entierement couverte
+	GroupCancelled GroupStatus = "CANCELLED" // Annule par le client
+)
+
+// reasonBracketArmed est la raison de l'EventAmended qui dimensionne les
+// sorties d'un bracket au premier fill de l'entree. Les redimensionnements
+// suivants portent le type du groupe (OCO, BRACKET).
+const reasonBracketArmed = "bracket arme"
+
+// ---------------------------------------------------------------------------
+// orderGroup — etat interne, protege par Gateway.mu
+// ---------------------------------------------------------------------------
+//
+// INVARIANT : les deux jambes partagent une seule quantite "ouverte".
+//
+//   OCO     : open = Quantity       - legs[0].Filled - legs[1].Filled
+//   Bracket : open = entry.Filled   - legs[0].Filled - legs[1].Filled
+//
+// Apres chaque fill, chaque jambe est redimensionnee a Filled + open :
+// un fill partiel sur le take-profit reduit d'autant le stop-loss, et un
+// fill partiel de l'entree agrandit d'autant la paire de sortie.
+
+type orderGroup struct {
+	id       uint64
+	typ      GroupType
+	status   GroupStatus
+	quantity int64     // OCO : quantite partagee par les deux jambes
+	entry    *Order    // Bracket uniquement
+	legs     [2]*Order // OCO : les deux jambes. Bracket : take-profit, stop-loss
+	armed    bool      // Bracket : jambes de sortie posees (premier fill de l'entree)
+}
+
+func (g *orderGroup) open() int64 {
+	total := g.quantity
+	if g.typ == Bracket {
+		total = g.entry.Filled
+	}
+	return total - g.legs[0].Filled - g.legs[1].Filled
+}
+
+// GroupState est une photo d'un groupe. Les Orders sont des copies :
+// les originaux continuent d'evoluer dans le moteur.
+type GroupState struct {
+	ID     uint64
+	Type   GroupType
+	Status GroupStatus
+	Entry  *Order   // nil pour un OCO
+	Legs   [2]Order // OCO : jambes dans l'ordre de soumission. Bracket : take-profit, stop-loss
+	Open   int64    // Quantite encore couverte par les jambes
+}
+
+// ---------------------------------------------------------------------------
+// API publique du Gateway
+// ---------------------------------------------------------------------------
+
+// SubmitOCO soumet deux ordres lies (meme symbole, meme cote, meme quantite).
+// Chaque jambe est un Limit ou un Stop. Ce qui s'execute sur une jambe est
+// retire de l'autre ; quand la quantite est epuisee, l'autre est annulee.
+func (gw *Gateway) SubmitOCO(a, b *Order) (uint64, []Trade, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	legs := []*Order{a, b}
+	for i, o := range legs {
+		err := gw.receive(o)
+		if err == nil && o.Type != Limit && o.Type != Stop {
+			err = gw.reject(o, fmt.Errorf("ordre #%d rejete: %w", o.ID,
+				&ValidationError{Field: "type", Message: fmt.Sprintf("jambe OCO doit etre LIMIT ou STOP, recu: %q", o.Type)}))
+		}
+		if err != nil {
+			return 0, nil, gw.rejectLinked(legs[:i], err)
+		}
+	}
+	if a.Symbol != b.Symbol || a.Side != b.Side || a.Quantity != b.Quantity {
+		return 0, nil, gw.rejectLinked(legs, fmt.Errorf("OCO rejete: %w",
+			&ValidationError{Field: "legs", Message: "les jambes doivent partager symbole, cote et quantite"}))
+	}
+
+	g := &orderGroup{
+		id:       gw.ids.NextGroupID(),
+		typ:      OCO,
+		status:   GroupActive,
+		quantity: a.Quantity,
+		legs:     [2]*Order{a, b},
+	}
+	gw.register(g)
+
+	// Jambe par jambe : si la premiere s'execute immediatement,
+	// la seconde est deja redimensionnee (voire annulee) avant d'etre posee.
+	trades := gw.settle(gw.place(nil, a))
+	if g.status == GroupActive && b.Status == StatusPending {
+		trades = append(trades, gw.settle(gw.place(nil, b))...)
+	}
+
+	gw.record(trades)
+	return g.id, trades, nil
+}
+
+// SubmitBracket soumet une entree et sa paire de sortie : un take-profit
+// (Limit) et un stop-loss (Stop), du cote oppose a l'entree.
+// Les sorties doivent avoir la meme quantite que l'entree ; elles restent
+// PENDING jusqu'au premier fill, puis couvrent exactement la quantite executee.
+func (gw *Gateway) SubmitBracket(entry, takeProfit, stopLoss *Order) (uint64, []Trade, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	orders := []*Order{entry, takeProfit, stopLoss}
+	for i, o := range orders {
+		if err := gw.receive(o); err != nil {
+			return 0, nil, gw.rejectLinked(orders[:i], err)
+		}
+	}
+	var verr *ValidationError
+	switch {
+	case takeProfit.Type != Limit:
+		verr = &ValidationError{Field: "take_profit", Message: fmt.Sprintf("doit etre LIMIT, recu: %q", takeProfit.Type)}
+	case stopLoss.Type != Stop:
+		verr = &ValidationError{Field: "stop_loss", Message: fmt.Sprintf("doit etre STOP, recu: %q", stopLoss.Type)}
+	case entry.Type == Stop:
+		verr = &ValidationError{Field: "entry", Message: "l'entree ne peut pas etre un STOP"}
+	case takeProfit.Symbol != entry.Symbol || stopLoss.Symbol != entry.Symbol:
+		verr = &ValidationError{Field: "symbol", Message: "les trois ordres doivent porter le meme symbole"}
+	case takeProfit.Side == entry.Side || stopLoss.Side == entry.Side:
+		verr = &ValidationError{Field: "side", Message: "les sorties doivent etre du cote oppose a l'entree"}
+	case takeProfit.Quantity != entry.Quantity || stopLoss.Quantity != entry.Quantity:
+		verr = &ValidationError{Field: "quantity", Message: "les sorties doivent avoir la quantite de l'entree"}
+	}
+	if verr != nil {
+		return 0, nil, gw.rejectLinked(orders, fmt.Errorf("bracket rejete: %w", verr))
+	}
+
+	// Les sorties ne couvrent rien tant que l'entree n'a pas execute.
+	takeProfit.Quantity, stopLoss.Quantity = 0, 0
+	g := &orderGroup{
+		id:     gw.ids.NextGroupID(),
+		typ:    Bracket,
+		status: GroupPending,
+		entry:  entry,
+		legs:   [2]*Order{takeProfit, stopLoss},
+	}
+	gw.register(g)
+
+	trades := gw.settle(gw.place(nil, entry))
+	if g.status == GroupPending && !entry.IsActive() {
+		// Entree IOC/Market sans aucun fill : rien a proteger.
+		gw.closeGroup(g, GroupCancelled, "entree non executee")
+	}
+
+	gw.record(trades)
+	return g.id, trades, nil
+}
+
+// Group retourne l'etat courant d'un groupe d'ordres lies.
+func (gw *Gateway) Group(id uint64) (GroupState, bool) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	g, ok := gw.groups[id]
+	if !ok {
+		return GroupState{}, false
+	}
+	st := GroupState{
+		ID:     g.id,
+		Type:   g.typ,
+		Status: g.status,
+		Legs:   [2]Order{*g.legs[0], *g.legs[1]},
+		Open:   g.open(),
+	}
+	if g.entry != nil {
+		e := *g.entry
+		st.Entry = &e
+	}
+	return st, true
+}
+
+// CancelGroup annule toutes les jambes encore vivantes d'un groupe.
+func (gw *Gateway) CancelGroup(id uint64) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	g, ok := gw.groups[id]
+	if !ok {
+		return fmt.Errorf("groupe #%d inconnu", id)
+	}
+	if g.status == GroupDone || g.status == GroupCancelled {
+		return fmt.Errorf("groupe #%d deja termine (%s)", id, g.status)
+	}
+	if g.entry != nil {
+		gw.cancelOrder(g.entry, "annulation du groupe")
+	}
+	gw.closeGroup(g, GroupCancelled, "annulation du groupe")
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Mecanique interne (toujours avec gw.mu tenu)
+// ---------------------------------------------------------------------------
+
+// rejectLinked rejette les ordres deja recus d'une soumission liee refusee :
+// un groupe est accepte en entier ou pas du tout. Retourne err.
+func (gw *Gateway) rejectLinked(orders []*Order, err error) error {
+	for _, o := range orders {
+		gw.reject(o, err)
+	}
+	return err
+}
+
+// register indexe le groupe, accepte tous ses ordres et met ses jambes en attente.
+func (gw *Gateway) register(g *orderGroup) {
+	gw.groups[g.id] = g
+	if g.entry != nil {
+		gw.legOf[g.entry.ID] = g
+		gw.accept(g.entry)
+	}
+	for _, l := range g.legs {
+		l.Status = StatusPending
+		gw.legOf[l.ID] = g
+		gw.accept(l)
+	}
+}
+
+// rebalance recalcule la quantite ouverte du groupe apres un fill et
+// redimensionne ses jambes. Retourne les trades provoques par l'activation
+// des sorties d'un bracket (le take-profit peut matcher immediatement).
+func (gw *Gateway) rebalance(g *orderGroup) []Trade {
+	if g.status == GroupDone || g.status == GroupCancelled {
+		return nil
+	}
+	open := g.open()
+
+	if g.typ == Bracket && !g.armed {
+		if open <= 0 {
+			return nil
+		}
+		g.armed = true
+		g.status = GroupActive
+		var trades []Trade
+		for _, l := range g.legs {
+			l.Quantity = open
+			gw.emit(orderEvent(EventAmended, l, reasonBracketArmed))
+			trades = append(trades, gw.place(nil, l)...)
+		}
+		return trades
+	}
+
+	for _, l := range g.legs {
+		gw.resize(g, l, l.Filled+open)
+	}
+	if open <= 0 && (g.typ == OCO || !g.entry.IsActive()) {
+		gw.closeGroup(g, GroupDone, "OCO")
+	}
+	return nil
+}
+
+// resize applique une nouvelle quantite a une jambe et publie EventAmended
+// (puis EventCancelled si la jambe tombe a zero sans avoir execute).
+// Un stop deja declenche (devenu Market) n'est plus redimensionne.
+func (gw *Gateway) resize(g *orderGroup, l *Order, qty int64) {
+	if l.Quantity == qty {
+		return
+	}
+	was := l.Status
+	switch {
+	case l.Type == Stop && l.Status == StatusPending:
+		l.Quantity = qty // electStops ignore les stops a Remaining() == 0
+	case l.Type == Limit:
+		gw.books[l.Symbol].Resize(l, qty)
+	default:
+		return
+	}
+	gw.emit(orderEvent(EventAmended, l, string(g.typ)))
+	if l.Status == StatusCancelled && was != StatusCancelled {
+		gw.emit(orderEvent(EventCancelled, l, "quantite du groupe epuisee"))
+	}
+}
+
+// closeGroup termine un groupe : toute jambe encore vivante est annulee.
+// Retourne les IDs des jambes annulees.
+func (gw *Gateway) closeGroup(g *orderGroup, status GroupStatus, reason string) []uint64 {
+	g.status = status
+	var ids []uint64
+	for _, l := range g.legs {
+		if gw.cancelOrder(l, reason) {
+			ids = append(ids, l.ID)
+		}
+	}
+	return ids
+}
+
+// cancelLinked traite l'annulation d'un ordre appartenant a un groupe.
+//   - jambe de sortie ou jambe OCO : tout le groupe est annule.
+//   - entree de bracket : l'entree est annulee ; si elle a deja execute,
+//     les sorties restent en place pour proteger la quantite executee.
+//
+// Retourne les IDs de tous les ordres annules.
+func (gw *Gateway) cancelLinked(g *orderGroup, orderID uint64, reason string) ([]uint64, error) {
+	if g.status == GroupDone || g.status == GroupCancelled {
+		return nil, fmt.Errorf("%w: #%d", ErrOrderNotFound, orderID)
+	}
+	var ids []uint64
+	if g.entry == nil || g.entry.ID != orderID {
+		if g.entry != nil && gw.cancelOrder(g.entry, reason) {
+			ids = append(ids, g.entry.ID)
+		}
+		return append(ids, gw.closeGroup(g, GroupCancelled, reason)...), nil
+	}
+
+	if !gw.cancelOrder(g.entry, reason) {
+		return nil, fmt.Errorf("%w: #%d", ErrOrderNotFound, orderID)
+	}
+	ids = append(ids, g.entry.ID)
+	switch {
+	case g.entry.Filled == 0:
+		ids = append(ids, gw.closeGroup(g, GroupCancelled, reason)...)
+	case g.open() <= 0:
+		ids = append(ids, gw.closeGroup(g, GroupDone, reason)...)
+	}
+	return ids, nil
+}
@@ -0,0 +1,255 @@
+// linked_test.go — Tests des ordres lies (OCO, bracket) et des stops.
+// Lancer avec : go test ./phase2-order-engine/ -run 'OCO|Bracket|Stop' -v
+
+package main
+
+import (
+	"testing"
+)
+
+// TestStopOrderTrigger verifie qu'un stop reste en attente puis part en Market.
+func TestStopOrderTrigger(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 188.00, 100))
+	stop := NewStopOrder("AAPL", Sell, 189.00, 100)
+	mustSubmit(t, gw, stop)
+	if stop.Status != StatusPending {
+		t.Fatalf("stop: attendu PENDING, obtenu %s", stop.Status)
+	}
+
+	// Un trade a 189.00 franchit le stop : il vend au meilleur bid (188.00).
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 189.00, 10))
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 189.00, 10))
+
+	if len(trades) != 2 {
+		t.Fatalf("attendu 2 trades (declencheur + stop), obtenu %d", len(trades))
+	}
+	if trades[1].SellOrderID != stop.ID || trades[1].Price != 188.00 {
+		t.Errorf("trade du stop inattendu: %v", trades[1])
+	}
+	if stop.Status != StatusFilled {
+		t.Errorf("stop: attendu FILLED, obtenu %s", stop.Status)
+	}
+}
+
+// TestOCOPartialFillReducesSibling verifie qu'un fill partiel reduit l'autre jambe.
+func TestOCOPartialFillReducesSibling(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	takeProfit := NewLimitOrder("AAPL", Sell, 195.00, 100)
+	stopLoss := NewStopOrder("AAPL", Sell, 185.00, 100)
+	id, _, err := gw.SubmitOCO(takeProfit, stopLoss)
+	if err != nil {
+		t.Fatalf("SubmitOCO: %v", err)
+	}
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 195.00, 40))
+
+	st, ok := gw.Group(id)
+	if !ok {
+		t.Fatal("groupe introuvable")
+	}
+	if st.Status != GroupActive || st.Open != 60 {
+		t.Errorf("attendu ACTIVE/open=60, obtenu %s/open=%d", st.Status, st.Open)
+	}
+	if st.Legs[1].Remaining() != 60 {
+		t.Errorf("stop-loss: attendu 60 restants, obtenu %d", st.Legs[1].Remaining())
+	}
+
+	// Le reste du take-profit s'execute : le stop-loss doit etre annule.
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 195.00, 60))
+	st, _ = gw.Group(id)
+	if st.Status != GroupDone {
+		t.Errorf("groupe: attendu DONE, obtenu %s", st.Status)
+	}
+	if stopLoss.Status != StatusCancelled {
+		t.Errorf("stop-loss: attendu CANCELLED, obtenu %s", stopLoss.Status)
+	}
+}
+
+// TestOCOStopLegCancelsLimitLeg verifie le declenchement du stop d'un OCO.
+func TestOCOStopLegCancelsLimitLeg(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 184.00, 500))
+	takeProfit := NewLimitOrder("AAPL", Sell, 195.00, 100)
+	stopLoss := NewStopOrder("AAPL", Sell, 185.00, 100)
+	id, _, err := gw.SubmitOCO(takeProfit, stopLoss)
+	if err != nil {
+		t.Fatalf("SubmitOCO: %v", err)
+	}
+
+	// Un trade a 184.00 declenche le stop-loss.
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 184.00, 10))
+
+	if stopLoss.Filled != 100 {
+		t.Errorf("stop-loss: attendu 100 executes, obtenu %d", stopLoss.Filled)
+	}
+	if takeProfit.Status != StatusCancelled {
+		t.Errorf("take-profit: attendu CANCELLED, obtenu %s", takeProfit.Status)
+	}
+	if st, _ := gw.Group(id); st.Status != GroupDone {
+		t.Errorf("groupe: attendu DONE, obtenu %s", st.Status)
+	}
+	if _, asks := gw.books["AAPL"].Depth(); asks != 0 {
+		t.Errorf("le take-profit ne doit plus etre dans le book, %d asks actifs", asks)
+	}
+}
+
+// TestOCOStopElectedWithSibling verifie qu'un stop OCO elu par le meme trade
+// qu'un autre stop ne part pas si ce dernier a epuise le groupe.
+func TestOCOStopElectedWithSibling(t *testing.T) {
+	gw, _ := newTestGateway()
+	gw.SetDebug(true)
+
+	bid := NewLimitOrder("AAPL", Buy, 95.00, 9)
+	mustSubmit(t, gw, bid)
+	buyStop := NewStopOrder("AAPL", Buy, 95.00, 9)
+	mustSubmit(t, gw, buyStop)
+	takeProfit := NewLimitOrder("AAPL", Sell, 99.00, 9)
+	stopLoss := NewStopOrder("AAPL", Sell, 99.00, 9)
+	id, _, err := gw.SubmitOCO(takeProfit, stopLoss)
+	if err != nil {
+		t.Fatalf("SubmitOCO: %v", err)
+	}
+
+	// Le trade a 99.00 elit les deux stops ; le premier remplit le
+	// take-profit : le stop-loss doit etre annule, pas envoye.
+	ioc := NewLimitOrder("AAPL", Buy, 99.00, 1)
+	ioc.Type = IOC
+	mustSubmit(t, gw, ioc)
+
+	if takeProfit.Filled != 9 || stopLoss.Filled != 0 || stopLoss.Status != StatusCancelled {
+		t.Errorf("jambes: take-profit %d executes, stop-loss %d (%s)", takeProfit.Filled, stopLoss.Filled, stopLoss.Status)
+	}
+	if bid.Filled != 0 {
+		t.Errorf("bid touche par un stop-loss annule: %d executes", bid.Filled)
+	}
+	if st, _ := gw.Group(id); st.Status != GroupDone {
+		t.Errorf("groupe: attendu DONE, obtenu %s", st.Status)
+	}
+}
+
+// TestBracketActivatesOnPartialEntry verifie que les sorties suivent les fills de l'entree.
+func TestBracketActivatesOnPartialEntry(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	entry := NewLimitOrder("AAPL", Buy, 190.00, 100)
+	takeProfit := NewLimitOrder("AAPL", Sell, 195.00, 100)
+	stopLoss := NewStopOrder("AAPL", Sell, 185.00, 100)
+	id, _, err := gw.SubmitBracket(entry, takeProfit, stopLoss)
+	if err != nil {
+		t.Fatalf("SubmitBracket: %v", err)
+	}
+	if st, _ := gw.Group(id); st.Status != GroupPending {
+		t.Fatalf("groupe: attendu PENDING, obtenu %s", st.Status)
+	}
+	if _, asks := gw.books["AAPL"].Depth(); asks != 0 {
+		t.Fatalf("take-profit pose avant tout fill de l'entree")
+	}
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 30))
+	st, _ := gw.Group(id)
+	if st.Status != GroupActive || st.Open != 30 {
+		t.Fatalf("attendu ACTIVE/open=30, obtenu %s/open=%d", st.Status, st.Open)
+	}
+	if takeProfit.Remaining() != 30 || stopLoss.Remaining() != 30 {
+		t.Errorf("sorties: attendu 30/30, obtenu %d/%d", takeProfit.Remaining(), stopLoss.Remaining())
+	}
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 50))
+	if takeProfit.Remaining() != 80 || stopLoss.Remaining() != 80 {
+		t.Errorf("sorties: attendu 80/80, obtenu %d/%d", takeProfit.Remaining(), stopLoss.Remaining())
+	}
+
+	// Le take-profit execute 80 : le stop-loss tombe a 0, l'entree travaille encore.
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 195.00, 80))
+	if st, _ := gw.Group(id); st.Status != GroupActive || st.Open != 0 {
+		t.Errorf("attendu ACTIVE/open=0, obtenu %s/open=%d", st.Status, st.Open)
+	}
+
+	// Le dernier fill de l'entree reactive la paire de sortie pour 20.
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 20))
+	if takeProfit.Remaining() != 20 || !takeProfit.IsActive() {
+		t.Errorf("take-profit: attendu actif avec 20 restants, obtenu %v", takeProfit)
+	}
+	if stopLoss.Remaining() != 20 || stopLoss.Status != StatusPending {
+		t.Errorf("stop-loss: attendu PENDING avec 20 restants, obtenu %v", stopLoss)
+	}
+}
+
+// TestBracketReactivationLosesPriority verifie qu'une sortie reactivee
+// repart en fin de file : un vendeur arrive pendant qu'elle etait inactive
+// est servi avant elle.
+func TestBracketReactivationLosesPriority(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	entry := NewLimitOrder("AAPL", Buy, 190.00, 100)
+	takeProfit := NewLimitOrder("AAPL", Sell, 195.00, 100)
+	stopLoss := NewStopOrder("AAPL", Sell, 185.00, 100)
+	if _, _, err := gw.SubmitBracket(entry, takeProfit, stopLoss); err != nil {
+		t.Fatalf("SubmitBracket: %v", err)
+	}
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 80))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 195.00, 80)) // Take-profit inactif
+	other := NewLimitOrder("AAPL", Sell, 195.00, 20)
+	mustSubmit(t, gw, other)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 20)) // Reactive le take-profit
+	if !takeProfit.IsActive() {
+		t.Fatalf("take-profit: attendu actif, obtenu %v", takeProfit)
+	}
+
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 195.00, 20))
+	if len(trades) != 1 || trades[0].SellOrderID != other.ID {
+		t.Errorf("attendu un fill contre #%d (arrive avant la reactivation), obtenu %v", other.ID, trades)
+	}
+	if takeProfit.Remaining() != 20 {
+		t.Errorf("take-profit: attendu 20 restants, obtenu %d", takeProfit.Remaining())
+	}
+}
+
+// TestBracketCancelEntryWithoutFill verifie que l'annulation de l'entree annule le groupe.
+func TestBracketCancelEntryWithoutFill(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	entry := NewLimitOrder("AAPL", Buy, 190.00, 100)
+	takeProfit := NewLimitOrder("AAPL", Sell, 195.00, 100)
+	stopLoss := NewStopOrder("AAPL", Sell, 185.00, 100)
+	id, _, err := gw.SubmitBracket(entry, takeProfit, stopLoss)
+	if err != nil {
+		t.Fatalf("SubmitBracket: %v", err)
+	}
+
+	if err := gw.Cancel("AAPL", entry.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	st, _ := gw.Group(id)
+	if st.Status != GroupCancelled {
+		t.Errorf("groupe: attendu CANCELLED, obtenu %s", st.Status)
+	}
+	for _, l := range st.Legs {
+		if l.Status != StatusCancelled {
+			t.Errorf("jambe #%d: attendu CANCELLED, obtenu %s", l.ID, l.Status)
+		}
+	}
+}
+
+// TestLinkedValidation verifie les rejets des groupes mal formes.
+func TestLinkedValidation(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	if _, _, err := gw.SubmitOCO(
+		NewLimitOrder("AAPL", Sell, 195.00, 100),
+		NewStopOrder("AAPL", Sell, 185.00, 50),
+	); err == nil {
+		t.Error("OCO avec quantites differentes: erreur attendue")
+	}
+	if _, _, err := gw.SubmitBracket(
+		NewLimitOrder("AAPL", Buy, 190.00, 100),
+		NewLimitOrder("AAPL", Buy, 195.00, 100),
+		NewStopOrder("AAPL", Sell, 185.00, 100),
+	); err == nil {
+		t.Error("bracket avec take-profit du meme cote: erreur attendue")
+	}
+}
@@ -0,0 +1,402 @@
+// loadgen.go — Generateur de flux d'ordres pour les tests de charge et
+// d'endurance (soak).
+//
+// Plusieurs workers soumettent en parallele au Gateway un flux realiste :
+// arrivees de Poisson (ecarts exponentiels) au debit cible, prix autour
+// d'un mid en marche aleatoire par symbole, melange configurable de
+// limites, marches, IOC et annulations. Chaque appel au Gateway est
+// chronometre dans un histogramme par worker, fusionnes a la fin.
+//
+// Le debit est en boucle ouverte : un worker en retard sur son planning
+// soumet sans attendre, il ne ralentit pas la cadence. Les latences
+// mesurent l'appel a Submit / Cancel seul (attente du verrou comprise).
+//
+// FIN DE RUN : le Gateway au repos, on verifie les invariants des carnets
+// (aucun carnet croise, quantites conservees : voir invariants.go).
+//
+// Lancer : go run ./phase2-order-engine/ loadgen -rate 50000 -duration 30s -workers 4
+//          go run ./phase2-order-engine/ loadgen -rate -1 -orders 1000000 -mix limit=50,market=5,ioc=15,cancel=30
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadMix pondere les operations generees. Les poids sont relatifs.
+type LoadMix struct {
+	Limit  int
+	Market int
+	IOC    int
+	Cancel int
+}
+
+// defaultLoadMix : surtout des limites, un quart d'annulations.
+var defaultLoadMix = LoadMix{Limit: 60, Market: 5, IOC: 10, Cancel: 25}
+
+// ParseLoadMix lit un melange "limit=60,market=5,ioc=10,cancel=25". Les
+// operations absentes ont un poids nul.
+func ParseLoadMix(s string) (LoadMix, error) {
+	var m LoadMix
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil || n < 0 {
+			return LoadMix{}, &ValidationError{Field: "mix", Message: fmt.Sprintf("%q : attendu operation=poids", part)}
+		}
+		switch strings.ToLower(name) {
+		case "limit":
+			m.Limit = n
+		case "market":
+			m.Market = n
+		case "ioc":
+			m.IOC = n
+		case "cancel":
+			m.Cancel = n
+		default:
+			return LoadMix{}, &ValidationError{Field: "mix", Message: fmt.Sprintf("operation inconnue %q (limit, market, ioc, cancel)", name)}
+		}
+	}
+	if m.Limit+m.Market+m.IOC+m.Cancel == 0 {
+		return LoadMix{}, &ValidationError{Field: "mix", Message: "tous les poids sont nuls"}
+	}
+	return m, nil
+}
+
+// LoadOptions regle un run. Les zeros prennent les defauts.
+type LoadOptions struct {
+	Symbols  []string      // defaut : AAPL, MSFT, TSLA (doivent exister dans le Gateway)
+	Rate     float64       // Operations par seconde, tous workers confondus (defaut : 10000 ; < 0 : sans cadence)
+	Duration time.Duration // Duree maximale du run (0 : pas de limite)
+	Orders   int64         // Operations maximales (defaut : 100000 si Duration est nul)
+	Workers  int           // Goroutines qui soumettent (defaut : 1)
+	Mix      LoadMix       // defaut : defaultLoadMix
+	Price    float64       // Mid initial de chaque symbole (defaut : 100)
+	Tick     float64       // defaut : 0.01
+	Vol      float64       // Ecart-type du pas du mid a chaque operation, en ticks (defaut : 0.5)
+	Account  string        // defaut : LOAD
+	Seed     uint64
+}
+
+// LoadReport resume un run.
+type LoadReport struct {
+	Limits       int64
+	Markets      int64
+	IOCs         int64
+	Cancels      int64
+	Rejects      int64 // Submit refuses
+	CancelMisses int64 // Ordre deja execute ou annule
+	Trades       int
+	Volume       int64
+	Elapsed      time.Duration
+
+	Submit LatencyHistogram // Latence de Gateway.Submit
+	Cancel LatencyHistogram // Latence de Gateway.Cancel
+
+	Violations []string // Invariants des carnets casses en fin de run
+}
+
+// Operations retourne le nombre d'appels au Gateway.
+func (r *LoadReport) Operations() int64 {
+	return r.Limits + r.Markets + r.IOCs + r.Cancels
+}
+
+// Throughput retourne le debit realise, en operations par seconde.
+func (r *LoadReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Operations()) / r.Elapsed.Seconds()
+}
+
+// loadSymbol est le mid en marche aleatoire d'un symbole, partage par les
+// workers.
+type loadSymbol struct {
+	name string
+	mu   sync.Mutex
+	mid  float64
+}
+
+// step fait avancer le mid et le retourne.
+func (s *loadSymbol) step(delta, floor float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mid = max(s.mid+delta, floor)
+	return s.mid
+}
+
+// restingRef designe un ordre limite soumis, candidat a l'annulation.
+type restingRef struct {
+	symbol string
+	id     uint64
+}
+
+// maxLoadResting borne la liste des candidats a l'annulation d'un worker.
+const maxLoadResting = 4096
+
+// GenerateLoad soumet un flux d'ordres a gw jusqu'a Orders operations,
+// Duration ecoulee ou annulation de ctx, puis verifie les carnets.
+func GenerateLoad(ctx context.Context, gw *Gateway, opts LoadOptions) (*LoadReport, error) {
+	if len(opts.Symbols) == 0 {
+		opts.Symbols = []string{"AAPL", "MSFT", "TSLA"}
+	}
+	if opts.Rate == 0 {
+		opts.Rate = 10_000
+	}
+	if opts.Orders <= 0 && opts.Duration <= 0 {
+		opts.Orders = 100_000
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.Mix == (LoadMix{}) {
+		opts.Mix = defaultLoadMix
+	}
+	if opts.Price <= 0 {
+		opts.Price = 100
+	}
+	if opts.Tick <= 0 {
+		opts.Tick = 0.01
+	}
+	if opts.Vol <= 0 {
+		opts.Vol = 0.5
+	}
+	if opts.Account == "" {
+		opts.Account = "LOAD"
+	}
+	symbols := make([]*loadSymbol, len(opts.Symbols))
+	for i, name := range opts.Symbols {
+		if _, ok := gw.Book(name); !ok {
+			return nil, &ValidationError{Field: "symbols", Message: fmt.Sprintf("symbole %q non supporte", name)}
+		}
+		symbols[i] = &loadSymbol{name: name, mid: opts.Price}
+	}
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	var left *atomic.Int64 // Operations restantes, nil : pas de limite
+	if opts.Orders > 0 {
+		left = new(atomic.Int64)
+		left.Store(opts.Orders)
+	}
+	trades0 := gw.log.Count()
+
+	reports := make([]LoadReport, opts.Workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lw := &loadWorker{
+				gw:      gw,
+				opts:    &opts,
+				symbols: symbols,
+				rng:     rand.New(rand.NewPCG(opts.Seed, uint64(w))),
+				rep:     &reports[w],
+			}
+			lw.run(ctx, left, start)
+		}()
+	}
+	wg.Wait()
+
+	rep := &LoadReport{Elapsed: time.Since(start)}
+	for i := range reports {
+		r := &reports[i]
+		rep.Limits += r.Limits
+		rep.Markets += r.Markets
+		rep.IOCs += r.IOCs
+		rep.Cancels += r.Cancels
+		rep.Rejects += r.Rejects
+		rep.CancelMisses += r.CancelMisses
+		rep.Volume += r.Volume
+		rep.Submit.Merge(&r.Submit)
+		rep.Cancel.Merge(&r.Cancel)
+	}
+	rep.Trades = gw.log.Count() - trades0
+	gw.mu.Lock()
+	rep.Violations = gw.invariantViolations()
+	gw.mu.Unlock()
+	return rep, nil
+}
+
+// loadWorker est l'etat d'une goroutine du generateur.
+type loadWorker struct {
+	gw      *Gateway
+	opts    *LoadOptions
+	symbols []*loadSymbol
+	rng     *rand.Rand
+	rep     *LoadReport
+	resting []restingRef
+}
+
+// run soumet des operations au rythme de Poisson du worker.
+func (lw *loadWorker) run(ctx context.Context, left *atomic.Int64, start time.Time) {
+	rate := lw.opts.Rate / float64(lw.opts.Workers)
+	var due time.Duration // Planning du worker, depuis start
+	for ctx.Err() == nil {
+		if left != nil && left.Add(-1) < 0 {
+			return
+		}
+		if rate > 0 {
+			due += time.Duration(lw.rng.ExpFloat64() / rate * float64(time.Second))
+			// En avance de moins d'une milliseconde : time.Sleep n'est pas
+			// assez fin, on soumet tout de suite et le retard se rattrape.
+			if wait := due - time.Since(start); wait > time.Millisecond {
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return
+				}
+			}
+		}
+		lw.next()
+	}
+}
+
+// next tire et execute une operation.
+func (lw *loadWorker) next() {
+	m := lw.opts.Mix
+	u := lw.rng.IntN(m.Limit + m.Market + m.IOC + m.Cancel)
+	if u >= m.Limit+m.Market+m.IOC && len(lw.resting) > 0 {
+		lw.cancel()
+		return
+	}
+	s := lw.symbols[lw.rng.IntN(len(lw.symbols))]
+	tick := lw.opts.Tick
+	mid := s.step(lw.rng.NormFloat64()*lw.opts.Vol*tick, 10*tick)
+	side, sign := Buy, 1.0
+	if lw.rng.IntN(2) == 1 {
+		side, sign = Sell, -1
+	}
+	qty := int64(10 * (1 + lw.rng.IntN(10)))
+
+	var o *Order
+	switch {
+	case u < m.Limit || u >= m.Limit+m.Market+m.IOC: // Annulation sans candidat : limite
+		// De 2 ticks agressif a 8 ticks passif : la plupart reposent.
+		o = NewLimitOrder(s.name, side, roundTick(mid+sign*float64(2-lw.rng.IntN(11))*tick, tick), qty)
+		lw.rep.Limits++
+	case u < m.Limit+m.Market:
+		o = NewMarketOrder(s.name, side, qty)
+		lw.rep.Markets++
+	default:
+		o = NewLimitOrder(s.name, side, roundTick(mid+sign*float64(1+lw.rng.IntN(3))*tick, tick), qty)
+		o.Type = IOC
+		lw.rep.IOCs++
+	}
+	o.Account = lw.opts.Account
+
+	t0 := time.Now()
+	trades, err := lw.gw.Submit(o)
+	lw.rep.Submit.Record(time.Since(t0))
+	if err != nil {
+		lw.rep.Rejects++
+		return
+	}
+	for _, t := range trades {
+		lw.rep.Volume += t.Quantity
+	}
+	// o.ID n'est plus ecrit apres Submit : lecture sans verrou sure (le
+	// statut, lui, bouge sous gw.mu avec les autres workers).
+	if o.Type == Limit {
+		ref := restingRef{s.name, o.ID}
+		if len(lw.resting) < maxLoadResting {
+			lw.resting = append(lw.resting, ref)
+		} else {
+			lw.resting[lw.rng.IntN(len(lw.resting))] = ref
+		}
+	}
+}
+
+// cancel annule un ordre limite soumis plus tot par ce worker.
+func (lw *loadWorker) cancel() {
+	k := lw.rng.IntN(len(lw.resting))
+	ref := lw.resting[k]
+	lw.resting[k] = lw.resting[len(lw.resting)-1]
+	lw.resting = lw.resting[:len(lw.resting)-1]
+
+	lw.rep.Cancels++
+	t0 := time.Now()
+	err := lw.gw.Cancel(ref.symbol, ref.id)
+	lw.rep.Cancel.Record(time.Since(t0))
+	if errors.Is(err, ErrOrderNotFound) {
+		lw.rep.CancelMisses++
+	}
+}
+
+// ---------------------------------------------------------------------------
+// CLI
+// ---------------------------------------------------------------------------
+
+// runLoadgen est le point d'entree de la commande loadgen.
+func runLoadgen(args []string) error {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	rate := fs.Float64("rate", 10_000, "operations par seconde (< 0 : sans cadence)")
+	duration := fs.Duration("duration", 0, "duree du run (0 : jusqu'a -orders)")
+	orders := fs.Int64("orders", 0, "operations a soumettre (0 : 100000 sans -duration, sinon illimite)")
+	workers := fs.Int("workers", 1, "goroutines qui soumettent")
+	symbols := fs.String("symbols", "AAPL,MSFT,TSLA", "symboles, separes par des virgules")
+	mix := fs.String("mix", "limit=60,market=5,ioc=10,cancel=25", "poids des operations")
+	seed := fs.Uint64("seed", 1, "graine")
+	hist := fs.Bool("hist", false, "afficher la distribution des latences de Submit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := ParseLoadMix(*mix)
+	if err != nil {
+		return fmt.Errorf("loadgen: %w", err)
+	}
+	opts := LoadOptions{
+		Symbols:  strings.Split(*symbols, ","),
+		Rate:     *rate,
+		Duration: *duration,
+		Orders:   *orders,
+		Workers:  *workers,
+		Mix:      m,
+		Seed:     *seed,
+	}
+	gw := NewGateway(opts.Symbols, NewTradeLog())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	rep, err := GenerateLoad(ctx, gw, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("loadgen: %d operations en %v, %.0f ops/s (%d workers)\n",
+		rep.Operations(), rep.Elapsed.Round(time.Millisecond), rep.Throughput(), opts.Workers)
+	fmt.Printf("  limit=%d market=%d ioc=%d cancel=%d (%d sans objet) refus=%d\n",
+		rep.Limits, rep.Markets, rep.IOCs, rep.Cancels, rep.CancelMisses, rep.Rejects)
+	fmt.Printf("  trades=%d volume=%d\n", rep.Trades, rep.Volume)
+	fmt.Printf("  Submit : %v\n", &rep.Submit)
+	fmt.Printf("  Cancel : %v\n", &rep.Cancel)
+	if *hist {
+		rep.Submit.Print(os.Stdout)
+	}
+	if len(rep.Violations) > 0 {
+		for _, v := range rep.Violations {
+			fmt.Printf("  INVARIANT: %s\n", v)
+		}
+		return fmt.Errorf("loadgen: %d invariants casses", len(rep.Violations))
+	}
+	fmt.Println("  invariants : OK (aucun carnet croise, quantites conservees)")
+	return nil
+}
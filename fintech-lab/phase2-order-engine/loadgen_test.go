@@ -0,0 +1,151 @@
+// loadgen_test.go — Tests du generateur de charge et de l'histogramme de
+// latences.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Load|Histogram|Invariant' -v
+
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h LatencyHistogram
+	if h.Quantile(0.5) != 0 || h.Mean() != 0 {
+		t.Error("histogramme vide: attendu des zeros")
+	}
+	// 1..1000 microsecondes : p50 ~ 500us, p99 ~ 990us, a 1/16 pres.
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 500 * time.Microsecond}, {0.9, 900 * time.Microsecond}, {0.99, 990 * time.Microsecond}} {
+		got := h.Quantile(c.q)
+		if got < c.want || float64(got) > float64(c.want)*(1+1.0/histSub) {
+			t.Errorf("p%v = %v, attendu dans [%v, +6%%]", c.q*100, got, c.want)
+		}
+	}
+	if h.Quantile(1) != time.Millisecond || h.Max() != time.Millisecond {
+		t.Errorf("p100 = %v, max = %v, attendu 1ms", h.Quantile(1), h.Max())
+	}
+	if h.Mean() != 500500*time.Nanosecond {
+		t.Errorf("moyenne = %v, attendu 500.5us", h.Mean())
+	}
+
+	// Chaque bucket couvre sa borne basse, et les buckets se suivent.
+	for _, v := range []uint64{0, 1, 15, 16, 17, 31, 32, 33, 1000, 1 << 40, 1<<63 - 1} {
+		i := histIndex(v)
+		if histLower(i) > v || (i+1 < histBuckets && histLower(i+1) <= v) {
+			t.Errorf("%d: bucket %d = [%d, %d)", v, i, histLower(i), histLower(i+1))
+		}
+	}
+
+	var other, merged LatencyHistogram
+	other.Record(5 * time.Second)
+	merged.Merge(&h)
+	merged.Merge(&other)
+	if merged.Count() != 1001 || merged.Max() != 5*time.Second || merged.Quantile(0.5) != h.Quantile(0.5) {
+		t.Errorf("merge: %v", &merged)
+	}
+}
+
+func TestParseLoadMix(t *testing.T) {
+	m, err := ParseLoadMix("limit=50, IOC=20,cancel=30")
+	if err != nil || m != (LoadMix{Limit: 50, IOC: 20, Cancel: 30}) {
+		t.Errorf("ParseLoadMix: %+v, %v", m, err)
+	}
+	for _, bad := range []string{"limit", "limit=-1", "stop=10", "limit=0,cancel=0"} {
+		var ve *ValidationError
+		if _, err := ParseLoadMix(bad); !errors.As(err, &ve) {
+			t.Errorf("%q: attendu une ValidationError, obtenu %v", bad, err)
+		}
+	}
+}
+
+// TestGenerateLoad lance un run sans cadence sur plusieurs workers et
+// verifie comptes et invariants.
+func TestGenerateLoad(t *testing.T) {
+	gw, log := newTestGateway()
+	rep, err := GenerateLoad(context.Background(), gw, LoadOptions{
+		Symbols: []string{"AAPL", "MSFT"},
+		Rate:    -1,
+		Orders:  20_000,
+		Workers: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Operations() != 20_000 {
+		t.Errorf("%d operations, attendu 20000", rep.Operations())
+	}
+	if rep.Submit.Count() != uint64(rep.Limits+rep.Markets+rep.IOCs) || rep.Cancel.Count() != uint64(rep.Cancels) {
+		t.Errorf("histogrammes: %d submits, %d cancels", rep.Submit.Count(), rep.Cancel.Count())
+	}
+	if rep.Limits == 0 || rep.Markets == 0 || rep.IOCs == 0 || rep.Cancels == 0 {
+		t.Errorf("melange incomplet: %+v", rep)
+	}
+	if rep.Rejects != 0 || rep.Trades == 0 || rep.Trades != log.Count() || rep.Volume != log.TotalVolume() {
+		t.Errorf("refus %d, trades %d (log %d), volume %d (log %d)", rep.Rejects, rep.Trades, log.Count(), rep.Volume, log.TotalVolume())
+	}
+	if len(rep.Violations) > 0 {
+		t.Errorf("invariants: %v", rep.Violations)
+	}
+
+	if _, err := GenerateLoad(context.Background(), gw, LoadOptions{Symbols: []string{"TSLA"}}); err == nil {
+		t.Error("symbole absent du Gateway accepte")
+	}
+}
+
+// TestGenerateLoadRate verifie la cadence et l'arret sur duree.
+func TestGenerateLoadRate(t *testing.T) {
+	gw, _ := newTestGateway()
+	// 300 arrivees a 2000/s : ~150ms.
+	rep, err := GenerateLoad(context.Background(), gw, LoadOptions{Rate: 2000, Orders: 300, Symbols: []string{"AAPL"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Elapsed < 90*time.Millisecond || rep.Throughput() > 3500 {
+		t.Errorf("300 ordres a 2000/s en %v (%.0f/s)", rep.Elapsed, rep.Throughput())
+	}
+
+	rep, _ = GenerateLoad(context.Background(), gw, LoadOptions{Rate: 1000, Duration: 100 * time.Millisecond, Symbols: []string{"MSFT"}, Workers: 2})
+	if rep.Elapsed > time.Second || rep.Operations() < 20 || rep.Operations() > 250 {
+		t.Errorf("100ms a 1000/s: %d operations en %v", rep.Operations(), rep.Elapsed)
+	}
+}
+
+// TestInvariantViolations verifie que les controles de fin de run
+// detectent un carnet croise et une quantite non conservee.
+func TestInvariantViolations(t *testing.T) {
+	gw, _ := newTestGateway()
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 100, 50))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 100, 20))
+	bid := NewLimitOrder("AAPL", Buy, 99, 10)
+	mustSubmit(t, gw, bid)
+	if err := gw.CheckInvariants(); err != nil {
+		t.Fatalf("carnet sain: %v", err)
+	}
+
+	gw.mu.Lock()
+	bid.Filled = 3 // Execution sans trade
+	ob := gw.books["AAPL"]
+	heap.Push(ob.bids, &Order{ID: 99, Symbol: "AAPL", Side: Buy, Type: Limit, Status: StatusOpen, Price: 101, Quantity: 5})
+	gw.mu.Unlock()
+
+	err := gw.CheckInvariants()
+	if !errors.Is(err, ErrInvariant) {
+		t.Fatalf("CheckInvariants: %v", err)
+	}
+	v := err.Error()
+	for _, want := range []string{"AAPL: execute 23 a l'achat, 20 a la vente, 20 en trades", "AAPL: carnet 12/30 (bid/ask), ordres actifs 7/30", "carnet croise, bid 101.00 >= ask 100.00"} {
+		if !strings.Contains(v, want) {
+			t.Errorf("violation %q absente de:\n%s", want, v)
+		}
+	}
+}
@@ -1,14 +1,42 @@
 // main.go — Simulation du matching engine GoMatchEngine (GME).
 // Lancer avec : go run ./phase2-order-engine/
+// Outils : go run ./phase2-order-engine/ <commande> [options]
 
 package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 )
 
+// commands associe chaque sous-commande a son point d'entree.
+// Sans argument, le binaire joue la simulation.
+var commands = map[string]func(args []string) error{
+	"audit-export": runAuditExport,
+	"backtest":     runBacktest,
+	"fix":          runFIX,
+	"itch-listen":  runITCHListen,
+	"loadgen":      runLoadgen,
+	"ouch":         runOUCH,
+	"replay":       runReplay,
+	"serve":        runServe,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		run, ok := commands[os.Args[1]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "commande inconnue %q\n", os.Args[1])
+			os.Exit(2)
+		}
+		if err := run(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("╔══════════════════════════════════════════════════╗")
 	fmt.Println("║     GoMatchEngine (GME) — Simulation Session     ║")
 	fmt.Println("╚══════════════════════════════════════════════════╝")
@@ -40,19 +68,19 @@ func main() {
 	bid, _ := gw.books["AAPL"].BestBid()
 	ask, _ := gw.books["AAPL"].BestAsk()
 	spread, _ := gw.books["AAPL"].Spread()
-	fmt.Printf("\nTop of Book : Bid=$%.2f | Ask=$%.2f | Spread=$%.4f\n\n", bid, ask, spread)
+	ccy := gw.books["AAPL"].Currency()
+	fmt.Printf("\nTop of Book : Bid=%.2f %s | Ask=%.2f %s | Spread=%.4f %s\n\n", bid, ccy, ask, ccy, spread, ccy)
 
 	// =========================================================================
 	// SCENARIO 2 : Matching — Un acheteur agressif croise le ask
 	// Un BUY @ $191.00 va matcher contre les SELL @ $190.00 et $190.50
 	// =========================================================================
-	fmt.Println("━━━ SCENARIO 2 : Matching agressif (BUY @ $191.00 x250) ━━━")
+	fmt.Println("━━━ SCENARIO 2 : Matching agressif (BUY @ 191.00 x250) ━━━")
 	fmt.Println()
 
 	aggressiveBuy := NewLimitOrder("AAPL", Buy, 191.00, 250)
-	fmt.Printf("Soumission : %v\n", aggressiveBuy)
-
 	trades, err := gw.Submit(aggressiveBuy)
+	fmt.Printf("Soumission : %v\n", aggressiveBuy)
 	if err != nil {
 		fmt.Printf("ERREUR : %v\n", err)
 	} else {
@@ -77,9 +105,8 @@ func main() {
 	fmt.Println()
 
 	marketSell := NewMarketOrder("AAPL", Sell, 100)
-	fmt.Printf("Soumission : %v\n", marketSell)
-
 	trades, err = gw.Submit(marketSell)
+	fmt.Printf("Soumission : %v\n", marketSell)
 	if err != nil {
 		fmt.Printf("ERREUR : %v\n", err)
 	} else {
@@ -0,0 +1,108 @@
+// masscancel.go — Annulation en masse et kill switch par compte.
+// Outils de controle du risque : retirer d'un coup tout ce qu'un compte,
+// un symbole ou un cote a dans le marche.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrKillSwitch est retourne (wrappe) pour tout ordre d'un compte bloque.
+var ErrKillSwitch = errors.New("kill switch actif")
+
+// CancelFilter selectionne les ordres d'un MassCancel.
+// Un champ vide ne filtre pas : CancelFilter{} selectionne tout.
+type CancelFilter struct {
+	Account string
+	Symbol  string
+	Side    Side
+}
+
+func (f CancelFilter) matches(o *Order) bool {
+	return (f.Account == "" || o.Account == f.Account) &&
+		(f.Symbol == "" || o.Symbol == f.Symbol) &&
+		(f.Side == "" || o.Side == f.Side)
+}
+
+// MassCancel annule tous les ordres vivants (actifs ou en attente) qui
+// correspondent au filtre. Un ordre lie est annule avec son groupe, comme
+// pour Cancel. Retourne les IDs annules, dans l'ordre d'annulation ;
+// chaque annulation publie un EventCancelled.
+func (gw *Gateway) MassCancel(f CancelFilter) []uint64 {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.massCancel(f, "mass cancel")
+}
+
+// massCancel est le coeur de MassCancel. Appele avec gw.mu tenu.
+func (gw *Gateway) massCancel(f CancelFilter, reason string) []uint64 {
+	var candidates []uint64
+	for id, o := range gw.orders {
+		if (o.IsActive() || o.Status == StatusPending) && f.matches(o) {
+			candidates = append(candidates, id)
+		}
+	}
+	// Iteration de map aleatoire : on trie pour un resultat deterministe.
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+
+	var cancelled []uint64
+	for _, id := range candidates {
+		if g, ok := gw.legOf[id]; ok {
+			ids, err := gw.cancelLinked(g, id, reason)
+			if err == nil {
+				cancelled = append(cancelled, ids...)
+			}
+			continue
+		}
+		if gw.cancelOrder(gw.orders[id], reason) {
+			cancelled = append(cancelled, id)
+		}
+	}
+	return cancelled
+}
+
+// Kill active le kill switch d'un compte : tous ses ordres sont annules et
+// tout nouvel ordre est rejete jusqu'a ResetKill par un operateur.
+func (gw *Gateway) Kill(account, reason string) ([]uint64, error) {
+	if account == "" {
+		return nil, &ValidationError{Field: "account", Message: "compte vide"}
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	gw.killed[account] = reason
+	return gw.massCancel(CancelFilter{Account: account}, "kill switch: "+reason), nil
+}
+
+// ResetKill leve le kill switch d'un compte. Retourne false s'il n'etait pas actif.
+func (gw *Gateway) ResetKill(account string) bool {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	if _, ok := gw.killed[account]; !ok {
+		return false
+	}
+	delete(gw.killed, account)
+	return true
+}
+
+// Killed indique si le kill switch d'un compte est actif, et pourquoi.
+func (gw *Gateway) Killed(account string) (string, bool) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	reason, ok := gw.killed[account]
+	return reason, ok
+}
+
+// admit rejette les ordres d'un compte bloque. Appele avec gw.mu tenu.
+func (gw *Gateway) admit(o *Order) error {
+	if reason, ok := gw.killed[o.Account]; ok {
+		o.Status = StatusRejected
+		return fmt.Errorf("ordre #%d rejete: compte %q: %w (%s)", o.ID, o.Account, ErrKillSwitch, reason)
+	}
+	return nil
+}
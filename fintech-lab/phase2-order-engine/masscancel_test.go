@@ -0,0 +1,90 @@
+// masscancel_test.go — Tests du mass cancel et du kill switch.
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// newAccountOrder cree un ordre limite rattache a un compte.
+func newAccountOrder(account, symbol string, side Side, price float64, qty int64) *Order {
+	o := NewLimitOrder(symbol, side, price, qty)
+	o.Account = account
+	return o
+}
+
+// TestMassCancelFilters verifie la selection par compte, symbole et cote.
+func TestMassCancelFilters(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	a1 := newAccountOrder("ACC-A", "AAPL", Buy, 189.00, 100)
+	a2 := newAccountOrder("ACC-A", "AAPL", Sell, 191.00, 100)
+	a3 := newAccountOrder("ACC-A", "MSFT", Buy, 400.00, 100)
+	b1 := newAccountOrder("ACC-B", "AAPL", Buy, 188.00, 100)
+	for _, o := range []*Order{a1, a2, a3, b1} {
+		mustSubmit(t, gw, o)
+	}
+
+	var events []Event
+	gw.Subscribe(func(e Event) { events = append(events, e) })
+
+	ids := gw.MassCancel(CancelFilter{Account: "ACC-A", Symbol: "AAPL", Side: Buy})
+	if len(ids) != 1 || ids[0] != a1.ID {
+		t.Fatalf("attendu [#%d], obtenu %v", a1.ID, ids)
+	}
+
+	ids = gw.MassCancel(CancelFilter{Account: "ACC-A"})
+	if len(ids) != 2 || ids[0] != a2.ID || ids[1] != a3.ID {
+		t.Fatalf("attendu [#%d #%d], obtenu %v", a2.ID, a3.ID, ids)
+	}
+	if b1.Status != StatusOpen {
+		t.Errorf("ordre d'un autre compte annule: %v", b1)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("attendu 3 evenements, obtenu %d", len(events))
+	}
+	for _, e := range events {
+		if e.Type != EventCancelled || e.Account != "ACC-A" {
+			t.Errorf("evenement inattendu: %+v", e)
+		}
+	}
+
+	if ids := gw.MassCancel(CancelFilter{}); len(ids) != 1 || ids[0] != b1.ID {
+		t.Errorf("filtre vide: attendu [#%d], obtenu %v", b1.ID, ids)
+	}
+}
+
+// TestKillSwitch verifie le blocage d'un compte puis sa remise en service.
+func TestKillSwitch(t *testing.T) {
+	gw, _ := newTestGateway()
+
+	resting := newAccountOrder("ACC-A", "AAPL", Buy, 189.00, 100)
+	stop := NewStopOrder("MSFT", Sell, 390.00, 50)
+	stop.Account = "ACC-A"
+	mustSubmit(t, gw, resting)
+	mustSubmit(t, gw, stop)
+
+	ids, err := gw.Kill("ACC-A", "perte max atteinte")
+	if err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("attendu 2 ordres annules, obtenu %v", ids)
+	}
+	if stop.Status != StatusCancelled {
+		t.Errorf("stop en attente: attendu CANCELLED, obtenu %s", stop.Status)
+	}
+
+	_, err = gw.Submit(newAccountOrder("ACC-A", "AAPL", Buy, 189.00, 10))
+	if !errors.Is(err, ErrKillSwitch) {
+		t.Fatalf("attendu ErrKillSwitch, obtenu %v", err)
+	}
+	mustSubmit(t, gw, newAccountOrder("ACC-B", "AAPL", Buy, 189.00, 10))
+
+	if !gw.ResetKill("ACC-A") {
+		t.Fatal("ResetKill: kill switch non trouve")
+	}
+	mustSubmit(t, gw, newAccountOrder("ACC-A", "AAPL", Buy, 189.00, 10))
+}
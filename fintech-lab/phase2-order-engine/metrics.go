@@ -0,0 +1,441 @@
+// metrics.go — Metriques du moteur au format texte Prometheus.
+//
+// Counter, Gauge, Histogram et Registry.Render reprennent netlab (phase4,
+// lesson13), avec des labels : une famille (nom, aide, type) porte
+// plusieurs series, une par combinaison de valeurs de labels.
+//
+// METRIQUES DU MOTEUR (EngineMetrics) :
+//
+//	gme_orders_accepted_total{symbol,type}   ordres acceptes
+//	gme_orders_rejected_total{reason}        ordres refuses, par motif
+//	gme_trades_total{symbol}                 trades du matching (hors corrections)
+//	gme_volume_total{symbol}                 quantite executee
+//	gme_submit_duration_seconds              latence de Gateway.Submit
+//	gme_book_orders{symbol,side}             ordres au carnet
+//	gme_book_quantity{symbol,side}           quantite au carnet
+//	gme_orders_active{symbol,state}          ordres actifs : au carnet (book)
+//	                                         ou retenus par le Gateway (pending :
+//	                                         stops, jambes de bracket)
+//
+// Les compteurs sont tenus par le Gateway (hooks sous gw.mu) ; les gauges
+// sont calculees au scrape, sous gw.mu. Servi par le serveur HTTP sur
+// GET /metrics, sans authentification comme /healthz.
+//
+//	curl http://localhost:8080/metrics
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Counter — monotone (orders_total, volume_total)
+// ---------------------------------------------------------------------------
+
+// Counter est une serie qui ne fait que croitre.
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// CounterVec est une famille de Counters indexee par valeurs de labels.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.RWMutex
+	series map[string]*Counter // valeurs jointes par \xff -> serie
+}
+
+// With retourne la serie des valeurs donnees (une par label, dans l'ordre),
+// creee a zero au premier appel.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return c
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.series[key]; !ok {
+		c = &Counter{}
+		v.series[key] = c
+	}
+	return c
+}
+
+// render ecrit les series, triees par labels.
+func (v *CounterVec) render(w io.Writer) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	writeFamilyHeader(w, v.name, v.help, "counter")
+	keys := slices.Sorted(maps.Keys(v.series))
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, formatLabels(v.labels, strings.Split(key, "\xff")), v.series[key].Value())
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Gauge — valeur courante, calculee au scrape
+// ---------------------------------------------------------------------------
+
+// GaugeFunc est une famille de gauges dont les series sont produites a
+// chaque rendu par collect : rien a tenir a jour dans le hot path.
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func(emit func(value float64, values ...string))
+}
+
+func (g *GaugeFunc) render(w io.Writer) {
+	writeFamilyHeader(w, g.name, g.help, "gauge")
+	g.collect(func(value float64, values ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, values), formatFloat(value))
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Histogram — distribution (latences)
+//
+// Compteurs cumulatifs : combien d'observations <= chaque borne.
+// ---------------------------------------------------------------------------
+
+// Histogram compte des observations dans des buckets fixes.
+type Histogram struct {
+	name    string
+	help    string
+	mu      sync.Mutex
+	buckets []float64 // bornes hautes, en secondes
+	counts  []uint64  // counts[i] = observations <= buckets[i], counts[last] = +Inf
+	sum     float64
+	count   uint64
+}
+
+// Observe ajoute une observation.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sum += v
+	h.count++
+	for i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets); i++ {
+		h.counts[i]++
+	}
+	h.counts[len(h.buckets)]++ // +Inf recoit toutes les observations
+}
+
+// ObserveDuration ajoute une duree, en secondes.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Percentile estime le p-ieme percentile (p dans 0-100) : la borne du
+// premier bucket qui l'atteint.
+func (h *Histogram) Percentile(p float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(float64(h.count) * p / 100))
+	for i, cnt := range h.counts {
+		if cnt >= target && i < len(h.buckets) {
+			return h.buckets[i]
+		}
+	}
+	return math.Inf(1)
+}
+
+func (h *Histogram) render(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeFamilyHeader(w, h.name, h.help, "histogram")
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.counts[len(h.buckets)])
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// ---------------------------------------------------------------------------
+// Registry — collecte les familles et rend /metrics
+// ---------------------------------------------------------------------------
+
+// metricFamily est une famille enregistree.
+type metricFamily interface {
+	render(w io.Writer)
+}
+
+// Registry regroupe des familles, rendues dans l'ordre d'enregistrement.
+// Pas de registre global : chaque moteur a le sien.
+type Registry struct {
+	mu       sync.RWMutex
+	families []metricFamily
+}
+
+// NewRegistry cree un registre vide.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f metricFamily) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// Counter enregistre une famille de compteurs.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*Counter)}
+	r.register(v)
+	return v
+}
+
+// Gauge enregistre une famille de gauges calculees au rendu.
+func (r *Registry) Gauge(name, help string, labels []string, collect func(emit func(value float64, values ...string))) {
+	r.register(&GaugeFunc{name: name, help: help, labels: labels, collect: collect})
+}
+
+// Histogram enregistre un histogramme. buckets : bornes hautes, en secondes.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	b := slices.Clone(buckets)
+	slices.Sort(b)
+	h := &Histogram{name: name, help: help, buckets: b, counts: make([]uint64, len(b)+1)}
+	r.register(h)
+	return h
+}
+
+// Render produit le format texte Prometheus.
+func (r *Registry) Render(w io.Writer) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i, f := range r.families {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		f.render(w)
+	}
+}
+
+// ServeHTTP sert le rendu : le Registry est le handler de /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Render(w)
+}
+
+// writeFamilyHeader ecrit les lignes HELP et TYPE d'une famille.
+func writeFamilyHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatLabels rend {k1="v1",k2="v2"} ; vide sans label.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// labelEscaper echappe une valeur de label (\, " et saut de ligne).
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatFloat rend un nombre au format Prometheus, sans perte.
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ---------------------------------------------------------------------------
+// EngineMetrics — Metriques du Gateway
+// ---------------------------------------------------------------------------
+
+// submitBuckets couvre Submit de la microseconde (carnet vide) a 100ms
+// (cascade de stops, GC).
+var submitBuckets = []float64{1e-6, 2.5e-6, 5e-6, 1e-5, 2.5e-5, 5e-5, 1e-4, 2.5e-4, 5e-4, 1e-3, 1e-2, 1e-1}
+
+// EngineMetrics tient les metriques d'un Gateway.
+type EngineMetrics struct {
+	Registry *Registry
+
+	accepted *CounterVec
+	rejected *CounterVec
+	trades   *CounterVec
+	volume   *CounterVec
+	submit   *Histogram
+}
+
+// Metrics retourne les metriques du Gateway, creees et branchees au
+// premier appel.
+func (gw *Gateway) Metrics() *EngineMetrics {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if gw.metrics == nil {
+		gw.metrics = newEngineMetrics(gw)
+	}
+	return gw.metrics
+}
+
+func newEngineMetrics(gw *Gateway) *EngineMetrics {
+	r := NewRegistry()
+	m := &EngineMetrics{
+		Registry: r,
+		accepted: r.Counter("gme_orders_accepted_total", "Ordres acceptes par le Gateway", "symbol", "type"),
+		rejected: r.Counter("gme_orders_rejected_total", "Ordres refuses par le Gateway, par motif", "reason"),
+		trades:   r.Counter("gme_trades_total", "Trades executes par le matching (hors corrections)", "symbol"),
+		volume:   r.Counter("gme_volume_total", "Quantite executee", "symbol"),
+		submit:   r.Histogram("gme_submit_duration_seconds", "Duree de Gateway.Submit sous verrou, cascade comprise", submitBuckets),
+	}
+	r.Gauge("gme_book_orders", "Ordres au carnet", []string{"symbol", "side"}, func(emit func(float64, ...string)) {
+		gw.eachBookSide(func(symbol string, side Side, orders int, qty int64) {
+			emit(float64(orders), symbol, string(side))
+		})
+	})
+	r.Gauge("gme_book_quantity", "Quantite au carnet", []string{"symbol", "side"}, func(emit func(float64, ...string)) {
+		gw.eachBookSide(func(symbol string, side Side, orders int, qty int64) {
+			emit(float64(qty), symbol, string(side))
+		})
+	})
+	r.Gauge("gme_orders_active", "Ordres actifs, au carnet ou retenus par le Gateway", []string{"symbol", "state"}, func(emit func(float64, ...string)) {
+		for _, a := range gw.activeOrders() {
+			emit(float64(a.book), a.symbol, "book")
+			emit(float64(a.pending), a.symbol, "pending")
+		}
+	})
+	return m
+}
+
+// rejectReason classe un refus pour le label reason : champ invalide,
+// kill switch, ou other.
+func rejectReason(err error) string {
+	var ve *ValidationError
+	switch {
+	case errors.Is(err, ErrKillSwitch):
+		return "kill_switch"
+	case errors.As(err, &ve):
+		return ve.Field
+	}
+	return "other"
+}
+
+// Hooks du Gateway, appeles avec gw.mu tenu.
+
+func (m *EngineMetrics) onAccepted(o *Order) {
+	m.accepted.With(o.Symbol, string(o.Type)).Inc()
+}
+
+func (m *EngineMetrics) onRejected(err error) {
+	m.rejected.With(rejectReason(err)).Inc()
+}
+
+func (m *EngineMetrics) onTrades(trades []Trade) {
+	for _, t := range trades {
+		m.trades.With(t.Symbol).Inc()
+		m.volume.With(t.Symbol).Add(uint64(t.Quantity))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Lectures pour les gauges
+// ---------------------------------------------------------------------------
+
+// eachBookSide parcourt les carnets, symboles tries, et donne pour chaque
+// cote le nombre d'ordres actifs et leur quantite restante. Une passe par
+// cote, sans tri : moins cher que Levels a chaque scrape.
+func (gw *Gateway) eachBookSide(fn func(symbol string, side Side, orders int, qty int64)) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	for _, symbol := range slices.Sorted(maps.Keys(gw.books)) {
+		ob := gw.books[symbol]
+		ob.mu.RLock()
+		for _, side := range []struct {
+			side   Side
+			orders []*Order
+		}{{Buy, *ob.bids}, {Sell, *ob.asks}} {
+			n, qty := 0, int64(0)
+			for _, o := range side.orders {
+				if o.IsActive() {
+					n++
+					qty += o.Remaining()
+				}
+			}
+			fn(symbol, side.side, n, qty)
+		}
+		ob.mu.RUnlock()
+	}
+}
+
+// activeCount est le nombre d'ordres actifs d'un symbole.
+type activeCount struct {
+	symbol  string
+	book    int
+	pending int // Stops et jambes de bracket retenus
+}
+
+// activeOrders compte les ordres actifs par symbole, symboles tries.
+func (gw *Gateway) activeOrders() []activeCount {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	out := make([]activeCount, 0, len(gw.books))
+	index := make(map[string]int, len(gw.books))
+	for _, symbol := range slices.Sorted(maps.Keys(gw.books)) {
+		index[symbol] = len(out)
+		ob := gw.books[symbol]
+		bids, asks := ob.Depth()
+		out = append(out, activeCount{symbol: symbol, book: bids + asks})
+	}
+	pending := make(map[uint64]*Order)
+	for _, stops := range gw.stops {
+		for _, o := range stops {
+			pending[o.ID] = o
+		}
+	}
+	for _, g := range gw.groups {
+		for _, o := range g.legs {
+			pending[o.ID] = o
+		}
+	}
+	for _, o := range pending {
+		if i, ok := index[o.Symbol]; ok && o.Status == StatusPending {
+			out[i].pending++
+		}
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+// metrics_test.go — Tests des metriques Prometheus du moteur.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Metrics|Registry' -v
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryRender(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("req_total", "Requetes", "path", "code")
+	c.With("/b", "200").Add(3)
+	c.With("/a", "500").Inc()
+	c.With("/a\"\n", "200").Inc()
+	r.Gauge("queue_depth", "Profondeur", nil, func(emit func(float64, ...string)) { emit(2.5) })
+	h := r.Histogram("latency_seconds", "Latence", []float64{0.01, 0.001})
+	h.ObserveDuration(500 * time.Microsecond)
+	h.Observe(0.005)
+	h.Observe(2)
+
+	var sb strings.Builder
+	r.Render(&sb)
+	want := `# HELP req_total Requetes
+# TYPE req_total counter
+req_total{path="/a\"\n",code="200"} 1
+req_total{path="/a",code="500"} 1
+req_total{path="/b",code="200"} 3
+
+# HELP queue_depth Profondeur
+# TYPE queue_depth gauge
+queue_depth 2.5
+
+# HELP latency_seconds Latence
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.001"} 1
+latency_seconds_bucket{le="0.01"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.0055
+latency_seconds_count 3
+`
+	if sb.String() != want {
+		t.Errorf("rendu:\n%s\nattendu:\n%s", sb.String(), want)
+	}
+	if p := h.Percentile(50); p != 0.01 {
+		t.Errorf("p50 = %v, attendu 0.01", p)
+	}
+}
+
+// TestEngineMetrics verifie /metrics apres un scenario connu : 4 ordres
+// acceptes dont un stop en attente, 3 refus, un trade.
+func TestEngineMetrics(t *testing.T) {
+	srv, gw := newTestHTTPServer(t)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 100, 100))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 101, 50))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 100, 30))
+	mustSubmit(t, gw, NewStopOrder("MSFT", Sell, 90, 10))
+	gw.Submit(NewLimitOrder("AAPL", Buy, 99, 0))
+	gw.Submit(NewLimitOrder("TSLA", Buy, 99, 10))
+	gw.Kill("K", "test")
+	killed := NewLimitOrder("AAPL", Buy, 99, 10)
+	killed.Account = "K"
+	gw.Submit(killed)
+
+	resp, err := http.Get(srv.URL + "/metrics") // Sans token
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("/metrics: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := strings.Split(string(body), "\n")
+	for _, want := range []string{
+		`gme_orders_accepted_total{symbol="AAPL",type="LIMIT"} 3`,
+		`gme_orders_accepted_total{symbol="MSFT",type="STOP"} 1`,
+		`gme_orders_rejected_total{reason="kill_switch"} 1`,
+		`gme_orders_rejected_total{reason="quantity"} 1`,
+		`gme_orders_rejected_total{reason="symbol"} 1`,
+		`gme_trades_total{symbol="AAPL"} 1`,
+		`gme_volume_total{symbol="AAPL"} 30`,
+		`gme_submit_duration_seconds_count 7`,
+		`gme_submit_duration_seconds_bucket{le="+Inf"} 7`,
+		`gme_book_orders{symbol="AAPL",side="BUY"} 0`,
+		`gme_book_orders{symbol="AAPL",side="SELL"} 2`,
+		`gme_book_quantity{symbol="AAPL",side="SELL"} 120`,
+		`gme_book_quantity{symbol="MSFT",side="SELL"} 0`,
+		`gme_orders_active{symbol="AAPL",state="book"} 2`,
+		`gme_orders_active{symbol="AAPL",state="pending"} 0`,
+		`gme_orders_active{symbol="MSFT",state="pending"} 1`,
+	} {
+		found := false
+		for _, l := range lines {
+			found = found || l == want
+		}
+		if !found {
+			t.Errorf("ligne absente: %s", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("/metrics:\n%s", body)
+	}
+
+	// Un Gateway n'a qu'un jeu de metriques.
+	if gw.Metrics() != gw.Metrics() {
+		t.Error("Metrics: deux instances")
+	}
+}
@@ -5,8 +5,6 @@ package main
 
 import (
 	"fmt"
-	"sync/atomic"
-	"time"
 )
 
 // ---------------------------------------------------------------------------
@@ -28,6 +26,7 @@ const (
 	Limit  OrderType = "LIMIT"  // Execute seulement au prix fixe ou mieux
 	Market OrderType = "MARKET" // Execute immediatement au meilleur prix dispo
 	IOC    OrderType = "IOC"    // Immediate or Cancel : execute ce qui peut l'etre, annule le reste
+	Stop   OrderType = "STOP"   // Devient un Market order quand le dernier prix franchit StopPrice
 )
 
 // OrderStatus suit le cycle de vie d'un ordre.
@@ -35,22 +34,13 @@ type OrderStatus string
 
 const (
 	StatusOpen      OrderStatus = "OPEN"
-	StatusPartial   OrderStatus = "PARTIAL"   // Partiellement execute
-	StatusFilled    OrderStatus = "FILLED"    // Completement execute
+	StatusPartial   OrderStatus = "PARTIAL" // Partiellement execute
+	StatusFilled    OrderStatus = "FILLED"  // Completement execute
 	StatusCancelled OrderStatus = "CANCELLED"
 	StatusRejected  OrderStatus = "REJECTED"
+	StatusPending   OrderStatus = "PENDING" // Retenu par le Gateway : stop non declenche, jambe de bracket
 )
 
-// ---------------------------------------------------------------------------
-// Sequence d'IDs : atomic pour la thread-safety sans mutex
-// ---------------------------------------------------------------------------
-
-var globalOrderSeq uint64
-
-func nextOrderID() uint64 {
-	return atomic.AddUint64(&globalOrderSeq, 1)
-}
-
 // ---------------------------------------------------------------------------
 // Order — La structure centrale de tout le systeme.
 // ---------------------------------------------------------------------------
@@ -63,41 +53,66 @@ func nextOrderID() uint64 {
 
 type Order struct {
 	ID        uint64
+	Account   string // Compte client proprietaire (filtre du mass cancel, kill switch)
+	ClOrdID   string // ID choisi par le client, unique par compte (optionnel)
 	Symbol    string
+	Currency  string // Devise du symbole, fixee a la reception (vide avant)
 	Side      Side
 	Type      OrderType
 	Status    OrderStatus
 	Price     float64 // 0 pour les Market orders
+	StopPrice float64 // Prix de declenchement des Stop orders (0 sinon)
 	Quantity  int64
 	Filled    int64 // Quantite deja executee
 	Timestamp int64 // Unix nanoseconds — pour la priorite FIFO
+
+	arrival uint64 // Rang d'entree dans le heap, departage les Timestamp egaux (sous ob.mu)
+
+	// Recyclage (pool.go), sous le verrou du book
+	inBook   bool       // Physiquement dans un heap (lazy deletion comprise)
+	released bool       // Release demande avant la sortie du heap
+	pool     *OrderPool // Pool d'origine (nil : ordre alloue par un constructeur)
 }
 
+// Les constructeurs ne fixent ni ID ni Timestamp : le Gateway (ou le book,
+// pour un book utilise seul) les attribue a la reception, depuis son
+// Sequencer et sa Clock.
+
 // NewLimitOrder cree un nouvel ordre a cours limite.
 func NewLimitOrder(symbol string, side Side, price float64, qty int64) *Order {
 	return &Order{
-		ID:        nextOrderID(),
-		Symbol:    symbol,
-		Side:      side,
-		Type:      Limit,
-		Status:    StatusOpen,
-		Price:     price,
-		Quantity:  qty,
-		Timestamp: time.Now().UnixNano(),
+		Symbol:   symbol,
+		Side:     side,
+		Type:     Limit,
+		Status:   StatusOpen,
+		Price:    price,
+		Quantity: qty,
 	}
 }
 
 // NewMarketOrder cree un ordre au marche (execute immediatement).
 func NewMarketOrder(symbol string, side Side, qty int64) *Order {
 	return &Order{
-		ID:        nextOrderID(),
+		Symbol:   symbol,
+		Side:     side,
+		Type:     Market,
+		Status:   StatusOpen,
+		Price:    0,
+		Quantity: qty,
+	}
+}
+
+// NewStopOrder cree un stop : retenu par le Gateway jusqu'a ce qu'un trade
+// touche stopPrice (a la baisse pour un SELL, a la hausse pour un BUY),
+// puis envoye au book comme Market order.
+func NewStopOrder(symbol string, side Side, stopPrice float64, qty int64) *Order {
+	return &Order{
 		Symbol:    symbol,
 		Side:      side,
-		Type:      Market,
+		Type:      Stop,
 		Status:    StatusOpen,
-		Price:     0,
+		StopPrice: stopPrice,
 		Quantity:  qty,
-		Timestamp: time.Now().UnixNano(),
 	}
 }
 
@@ -118,21 +133,29 @@ func (o *Order) IsActive() bool {
 
 // String implemente fmt.Stringer pour un affichage lisible.
 func (o *Order) String() string {
-	return fmt.Sprintf("[#%d] %s %s %s x%d/%d @ $%.2f (%s)",
+	return fmt.Sprintf("[#%d] %s %s %s x%d/%d @ %.2f %s (%s)",
 		o.ID, o.Side, o.Type, o.Symbol,
-		o.Filled, o.Quantity, o.Price, o.Status)
+		o.Filled, o.Quantity, o.Price, o.Currency, o.Status)
 }
 
-// Reset remet un ordre a zero pour reutilisation via sync.Pool (Phase 4).
+// Reset remet un ordre a zero pour reutilisation via sync.Pool (OrderPool).
 // IMPORTANT : ne jamais utiliser un Order apres Reset() sans le reinitialiser.
 func (o *Order) Reset() {
 	o.ID = 0
+	o.Account = ""
+	o.ClOrdID = ""
 	o.Symbol = ""
+	o.Currency = ""
 	o.Side = ""
 	o.Type = ""
 	o.Status = ""
 	o.Price = 0
+	o.StopPrice = 0
 	o.Quantity = 0
 	o.Filled = 0
 	o.Timestamp = 0
+	o.arrival = 0
+	o.inBook = false
+	o.released = false
+	o.pool = nil
 }
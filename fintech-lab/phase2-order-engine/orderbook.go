@@ -6,6 +6,7 @@ package main
 import (
 	"container/heap"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -23,13 +24,18 @@ func (h BidHeap) Less(i, j int) bool {
 	if h[i].Price != h[j].Price {
 		return h[i].Price > h[j].Price // Max-heap : prix plus haut = priorite plus haute
 	}
-	return h[i].Timestamp < h[j].Timestamp // FIFO a prix egal
+	if h[i].Timestamp != h[j].Timestamp {
+		return h[i].Timestamp < h[j].Timestamp // FIFO a prix egal
+	}
+	return h[i].arrival < h[j].arrival // Meme heure (SimClock a pas nul) : ordre d'entree
 }
 
 func (h BidHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
 
 func (h *BidHeap) Push(x interface{}) {
-	*h = append(*h, x.(*Order))
+	o := x.(*Order)
+	o.inBook = true
+	*h = append(*h, o)
 }
 
 func (h *BidHeap) Pop() interface{} {
@@ -38,6 +44,7 @@ func (h *BidHeap) Pop() interface{} {
 	x := old[n-1]
 	old[n-1] = nil // Evite la fuite memoire (reference fantome dans le slice)
 	*h = old[:n-1]
+	x.leaveBook()
 	return x
 }
 
@@ -54,13 +61,18 @@ func (h AskHeap) Less(i, j int) bool {
 	if h[i].Price != h[j].Price {
 		return h[i].Price < h[j].Price // Min-heap : prix plus bas = priorite plus haute
 	}
-	return h[i].Timestamp < h[j].Timestamp // FIFO a prix egal
+	if h[i].Timestamp != h[j].Timestamp {
+		return h[i].Timestamp < h[j].Timestamp // FIFO a prix egal
+	}
+	return h[i].arrival < h[j].arrival // Meme heure (SimClock a pas nul) : ordre d'entree
 }
 
 func (h AskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
 
 func (h *AskHeap) Push(x interface{}) {
-	*h = append(*h, x.(*Order))
+	o := x.(*Order)
+	o.inBook = true
+	*h = append(*h, o)
 }
 
 func (h *AskHeap) Pop() interface{} {
@@ -69,6 +81,7 @@ func (h *AskHeap) Pop() interface{} {
 	x := old[n-1]
 	old[n-1] = nil
 	*h = old[:n-1]
+	x.leaveBook()
 	return x
 }
 
@@ -91,25 +104,44 @@ func (h *AskHeap) Pop() interface{} {
 //   Submit() et Cancel() ecrivent => besoin du lock exclusif.
 
 type OrderBook struct {
-	mu     sync.RWMutex
-	symbol string
-	bids   *BidHeap
-	asks   *AskHeap
+	mu       sync.RWMutex
+	symbol   string
+	currency string // Devise des prix et des trades du carnet
+	bids     *BidHeap
+	asks     *AskHeap
+	clock    Clock      // Heure des matchs
+	ids      *Sequencer // IDs des trades (partage avec le Gateway)
+	arrivals uint64     // Dernier Order.arrival attribue
 }
 
-// NewOrderBook cree un carnet d'ordres vide pour un symbole.
+// NewOrderBook cree un carnet d'ordres vide pour un symbole, avec sa propre
+// horloge systeme et son propre Sequencer (book utilise seul), cote dans la
+// devise par defaut.
 func NewOrderBook(symbol string) *OrderBook {
+	return newOrderBook(Instrument{Symbol: symbol, Currency: DefaultCurrency}, SystemClock{}, &Sequencer{})
+}
+
+// newOrderBook cree un book branche sur la Clock et le Sequencer d'un moteur.
+func newOrderBook(inst Instrument, clock Clock, ids *Sequencer) *OrderBook {
 	bids := &BidHeap{}
 	asks := &AskHeap{}
 	heap.Init(bids)
 	heap.Init(asks)
 	return &OrderBook{
-		symbol: symbol,
-		bids:   bids,
-		asks:   asks,
+		symbol:   inst.Symbol,
+		currency: inst.Currency,
+		bids:     bids,
+		asks:     asks,
+		clock:    clock,
+		ids:      ids,
 	}
 }
 
+// Currency retourne la devise de cotation du carnet.
+func (ob *OrderBook) Currency() string {
+	return ob.currency
+}
+
 // ---------------------------------------------------------------------------
 // Lecture du top of book (thread-safe, multi-lecteurs simultanement)
 // ---------------------------------------------------------------------------
@@ -125,7 +157,7 @@ func (ob *OrderBook) BestBid() (float64, bool) {
 		}
 		// Lazy deletion : on retire les ordres inactifs du sommet
 		// ATTENTION : RLock ne permet pas de modifier le heap.
-		// Cette situation ne devrait pas arriver souvent (annulations rares).
+		// Matching, Cancel et Resize purgent le sommet (prune) : ne devrait pas arriver.
 		break
 	}
 	return 0, false
@@ -173,6 +205,53 @@ func (ob *OrderBook) Depth() (bidCount, askCount int) {
 	return
 }
 
+// Level est un niveau de prix agrege du carnet.
+type Level struct {
+	Price    float64
+	Quantity int64 // Somme des quantites restantes au prix
+	Orders   int
+}
+
+// Levels retourne les depth meilleurs niveaux de chaque cote, meilleur prix
+// en premier. depth <= 0 : tous les niveaux. O(n log n) : pour l'affichage
+// et les snapshots, pas pour le hot path.
+func (ob *OrderBook) Levels(depth int) (bids, asks []Level) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return aggregate(*ob.bids, depth, true), aggregate(*ob.asks, depth, false)
+}
+
+// aggregate regroupe les ordres actifs par prix.
+func aggregate(orders []*Order, depth int, desc bool) []Level {
+	byPrice := make(map[float64]*Level)
+	for _, o := range orders {
+		if !o.IsActive() {
+			continue
+		}
+		l, ok := byPrice[o.Price]
+		if !ok {
+			l = &Level{Price: o.Price}
+			byPrice[o.Price] = l
+		}
+		l.Quantity += o.Remaining()
+		l.Orders++
+	}
+	levels := make([]Level, 0, len(byPrice))
+	for _, l := range byPrice {
+		levels = append(levels, *l)
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if desc {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	if depth > 0 && len(levels) > depth {
+		levels = levels[:depth]
+	}
+	return levels
+}
+
 // ---------------------------------------------------------------------------
 // Submit — Point d'entree principal : soumet un ordre et declenche le matching
 // ---------------------------------------------------------------------------
@@ -180,30 +259,46 @@ func (ob *OrderBook) Depth() (bidCount, askCount int) {
 // Submit accepte un nouvel ordre, tente de le matcher, et l'ajoute au book
 // s'il n'est pas completement execute.
 // Retourne la liste des trades generes (peut etre vide).
+// Un ordre sans ID ni Timestamp (book utilise sans Gateway) les recoit ici.
 func (ob *OrderBook) Submit(incoming *Order) []Trade {
+	return ob.SubmitAppend(nil, incoming)
+}
+
+// SubmitAppend est Submit avec un buffer fourni par l'appelant : les trades
+// sont ajoutes a dst, comme strconv.AppendInt. Avec un dst[:0] reutilise
+// d'un appel a l'autre, le matching n'alloue rien (voir pool.go).
+func (ob *OrderBook) SubmitAppend(dst []Trade, incoming *Order) []Trade {
 	ob.mu.Lock()
 	defer ob.mu.Unlock()
-	return ob.match(incoming)
+	if incoming.ID == 0 {
+		incoming.ID = ob.ids.NextOrderID()
+	}
+	if incoming.Timestamp == 0 {
+		incoming.Timestamp = ob.clock.Now()
+	}
+	incoming.Currency = ob.currency
+	return ob.match(dst, incoming)
 }
 
 // match est la logique interne de matching. Appele uniquement avec ob.mu tenu.
 // Ne pas appeler directement depuis l'exterieur.
-func (ob *OrderBook) match(incoming *Order) []Trade {
-	var trades []Trade
-
+// Tous les trades d'un meme passage portent l'heure du match ; ils sont
+// ajoutes a trades.
+func (ob *OrderBook) match(trades []Trade, incoming *Order) []Trade {
+	now := ob.clock.Now()
 	switch incoming.Side {
 	case Buy:
-		trades = ob.matchBuy(incoming)
+		trades = ob.matchBuy(trades, incoming, now)
 	case Sell:
-		trades = ob.matchSell(incoming)
+		trades = ob.matchSell(trades, incoming, now)
 	}
 
+	// L'ordre entrant a pu s'arreter (rempli) juste au-dessus d'un ordre mort.
+	ob.prune()
 	return trades
 }
 
-func (ob *OrderBook) matchBuy(incoming *Order) []Trade {
-	var trades []Trade
-
+func (ob *OrderBook) matchBuy(trades []Trade, incoming *Order, now int64) []Trade {
 	for ob.asks.Len() > 0 && incoming.Remaining() > 0 {
 		bestAsk := (*ob.asks)[0]
 
@@ -214,8 +309,8 @@ func (ob *OrderBook) matchBuy(incoming *Order) []Trade {
 		}
 
 		// Verifier si les prix se croisent
-		// Pour un Market order, il n'y a pas de limite de prix.
-		if incoming.Type == Limit && incoming.Price < bestAsk.Price {
+		// Pour un Market order, il n'y a pas de limite de prix (un IOC en a une).
+		if incoming.Type != Market && incoming.Price < bestAsk.Price {
 			break // Pas de match possible, prix trop loin
 		}
 
@@ -223,7 +318,7 @@ func (ob *OrderBook) matchBuy(incoming *Order) []Trade {
 		qty := min64(incoming.Remaining(), bestAsk.Remaining())
 		execPrice := bestAsk.Price // Passive order pricing rule
 
-		trade := newTrade(ob.symbol, incoming.ID, bestAsk.ID, execPrice, qty, incoming.Timestamp)
+		trade := newTrade(ob.ids.NextTradeID(), ob.symbol, ob.currency, incoming.ID, bestAsk.ID, execPrice, qty, now)
 		trades = append(trades, trade)
 
 		// Mettre a jour les quantites executees
@@ -246,9 +341,7 @@ func (ob *OrderBook) matchBuy(incoming *Order) []Trade {
 	return trades
 }
 
-func (ob *OrderBook) matchSell(incoming *Order) []Trade {
-	var trades []Trade
-
+func (ob *OrderBook) matchSell(trades []Trade, incoming *Order, now int64) []Trade {
 	for ob.bids.Len() > 0 && incoming.Remaining() > 0 {
 		bestBid := (*ob.bids)[0]
 
@@ -257,14 +350,14 @@ func (ob *OrderBook) matchSell(incoming *Order) []Trade {
 			continue
 		}
 
-		if incoming.Type == Limit && incoming.Price > bestBid.Price {
+		if incoming.Type != Market && incoming.Price > bestBid.Price {
 			break
 		}
 
 		qty := min64(incoming.Remaining(), bestBid.Remaining())
 		execPrice := bestBid.Price
 
-		trade := newTrade(ob.symbol, bestBid.ID, incoming.ID, execPrice, qty, incoming.Timestamp)
+		trade := newTrade(ob.ids.NextTradeID(), ob.symbol, ob.currency, bestBid.ID, incoming.ID, execPrice, qty, now)
 		trades = append(trades, trade)
 
 		incoming.Filled += qty
@@ -301,6 +394,8 @@ func (ob *OrderBook) finalizeOrder(o *Order, book heap.Interface) {
 		if o.Filled > 0 {
 			o.Status = StatusPartial
 		}
+		ob.arrivals++
+		o.arrival = ob.arrivals
 		heap.Push(book, o)
 	}
 }
@@ -309,7 +404,8 @@ func (ob *OrderBook) finalizeOrder(o *Order, book heap.Interface) {
 // Cancel — Annulation d'un ordre (lazy deletion)
 // ---------------------------------------------------------------------------
 
-// Cancel marque un ordre comme annule. Il sera retire du heap lors du prochain matching.
+// Cancel marque un ordre comme annule. Il sera retire du heap lors du prochain
+// matching, ou tout de suite s'il etait au sommet.
 // Complexite : O(n) pour la recherche. En production, on utiliserait un index
 // map[orderID]*Order pour O(1).
 func (ob *OrderBook) Cancel(orderID uint64) bool {
@@ -319,18 +415,124 @@ func (ob *OrderBook) Cancel(orderID uint64) bool {
 	for _, o := range *ob.bids {
 		if o.ID == orderID && o.IsActive() {
 			o.Status = StatusCancelled
+			ob.prune()
 			return true
 		}
 	}
 	for _, o := range *ob.asks {
 		if o.ID == orderID && o.IsActive() {
 			o.Status = StatusCancelled
+			ob.prune()
 			return true
 		}
 	}
 	return false
 }
 
+// prune retire les ordres inactifs du sommet des heaps. La lazy deletion
+// ne laisse ainsi des ordres morts que sous le sommet : BestBid et BestAsk,
+// qui lisent sous RLock sans pouvoir retirer, voient toujours un ordre actif.
+// Appele avec ob.mu tenu, apres chaque matching et chaque desactivation.
+func (ob *OrderBook) prune() {
+	for ob.bids.Len() > 0 && !(*ob.bids)[0].IsActive() {
+		heap.Pop(ob.bids)
+	}
+	for ob.asks.Len() > 0 && !(*ob.asks)[0].IsActive() {
+		heap.Pop(ob.asks)
+	}
+}
+
+// Resize fixe la quantite totale d'un ordre limite (utilise par les ordres lies).
+//   - Remaining() tombe a 0 : l'ordre devient inactif (FILLED s'il a deja
+//     execute quelque chose, CANCELLED sinon), puis lazy deletion classique.
+//   - Remaining() redevient > 0 sur un ordre inactif : il est reactive et
+//     rentre dans le heap en fin de file (nouveau timestamp, comme un Amend
+//     qui perd sa priorite) : les ordres arrives pendant qu'il etait
+//     inactif restent devant lui.
+//
+// Un ordre PENDING n'est pas encore dans le book : seule la quantite change.
+func (ob *OrderBook) Resize(o *Order, qty int64) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	o.Quantity = qty
+	if o.Status == StatusPending {
+		return
+	}
+
+	switch {
+	case o.Remaining() <= 0 && o.IsActive():
+		if o.Filled > 0 {
+			o.Status = StatusFilled
+		} else {
+			o.Status = StatusCancelled
+		}
+		ob.prune()
+
+	case o.Remaining() > 0 && !o.IsActive():
+		if o.Filled > 0 {
+			o.Status = StatusPartial
+		} else {
+			o.Status = StatusOpen
+		}
+		if o.inBook {
+			ob.remove(o) // Encore la, sous le sommet : sa place date d'avant
+		}
+		o.Timestamp = ob.clock.Now()
+		ob.arrivals++
+		o.arrival = ob.arrivals
+		if o.Side == Buy {
+			heap.Push(ob.bids, o)
+		} else {
+			heap.Push(ob.asks, o)
+		}
+	}
+}
+
+// Amend modifie le prix et la quantite totale d'un ordre limite actif.
+//   - Baisse de quantite a prix inchange : l'ordre garde sa priorite.
+//   - Changement de prix ou hausse de quantite : l'ordre perd sa priorite
+//     (nouveau timestamp) et repasse par le matching comme un ordre entrant.
+//
+// Retourne false si l'ordre n'est pas actif dans ce book. La validation
+// (prix > 0, quantite > Filled) est a la charge de l'appelant.
+func (ob *OrderBook) Amend(o *Order, price float64, qty int64) ([]Trade, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if !o.IsActive() || !o.inBook {
+		return nil, false
+	}
+	if price == o.Price && qty <= o.Quantity {
+		o.Quantity = qty
+		return nil, true
+	}
+
+	ob.remove(o)
+	o.Price, o.Quantity = price, qty
+	o.Timestamp = ob.clock.Now()
+	return ob.match(nil, o), true
+}
+
+// remove retire physiquement un ordre de son heap. O(n).
+func (ob *OrderBook) remove(o *Order) {
+	if o.Side == Buy {
+		for i, x := range *ob.bids {
+			if x == o {
+				heap.Remove(ob.bids, i)
+				return
+			}
+		}
+		return
+	}
+	for i, x := range *ob.asks {
+		if x == o {
+			heap.Remove(ob.asks, i)
+			return
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Display — Affichage du carnet
 // ---------------------------------------------------------------------------
@@ -341,7 +543,7 @@ func (ob *OrderBook) PrintBook(levels int) {
 	defer ob.mu.RUnlock()
 
 	fmt.Printf("╔══════════════════════════════════════╗\n")
-	fmt.Printf("║  ORDER BOOK : %-22s║\n", ob.symbol)
+	fmt.Printf("║  ORDER BOOK : %-22s║\n", ob.symbol+" ("+ob.currency+")")
 	fmt.Printf("╠══════════════════════════════════════╣\n")
 
 	// Collecter les asks actifs
@@ -357,7 +559,7 @@ func (ob *OrderBook) PrintBook(levels int) {
 	for i := len(activeAsks) - 1; i >= 0 && count < levels; i-- {
 		o := activeAsks[i]
 		bar := strings.Repeat("█", int(o.Remaining()/10))
-		fmt.Printf("║  SELL  %8d  $%8.2f  %-5s ║\n", o.Remaining(), o.Price, bar)
+		fmt.Printf("║  SELL  %8d  %9.2f  %-5s ║\n", o.Remaining(), o.Price, bar)
 		count++
 	}
 
@@ -365,7 +567,7 @@ func (ob *OrderBook) PrintBook(levels int) {
 	bid, hasBid := ob.BestBid()
 	ask, hasAsk := ob.BestAsk()
 	if hasBid && hasAsk {
-		fmt.Printf("║  ---- SPREAD: $%-6.4f          ----  ║\n", ask-bid)
+		fmt.Printf("║  ---- SPREAD: %-6.4f %-3s       ----  ║\n", ask-bid, ob.currency)
 	} else {
 		fmt.Printf("║  ---- NO SPREAD (book vide)    ----  ║\n")
 	}
@@ -375,7 +577,7 @@ func (ob *OrderBook) PrintBook(levels int) {
 	for _, o := range *ob.bids {
 		if o.IsActive() && count < levels {
 			bar := strings.Repeat("█", int(o.Remaining()/10))
-			fmt.Printf("║  BUY   %8d  $%8.2f  %-5s ║\n", o.Remaining(), o.Price, bar)
+			fmt.Printf("║  BUY   %8d  %9.2f  %-5s ║\n", o.Remaining(), o.Price, bar)
 			count++
 		}
 	}
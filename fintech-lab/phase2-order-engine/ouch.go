@@ -0,0 +1,562 @@
+// ouch.go — Protocole binaire d'entree d'ordres, dans l'esprit d'OUCH.
+//
+// TRANSPORT : les frames de netlab (phase2-protocols, lesson07), reprises
+// ici car netlab est un module separe :
+//
+//	[longueur u32 BE][type u8][payload]   longueur = 1 + len(payload)
+//
+// MESSAGES : largeur fixe, big-endian, sans padding. Texte (Token, Symbol)
+// cadre a gauche et complete par des 0. Prix en 1/10000 (4 decimales
+// implicites, comme OUCH). Quantites en u32.
+//
+//	Client -> serveur                      Serveur -> client
+//	'L' Login      token (texte brut)      'L' LoginAccepted  compte (texte brut)
+//	'H' Heartbeat  vide                    'K' LoginRejected  motif (texte brut)
+//	'O' EnterOrder   37 octets             'H' Heartbeat      vide
+//	'U' ReplaceOrder 40 octets             'A' Accepted       53 octets
+//	'X' CancelOrder  18 octets             'U' Replaced       65 octets
+//	                                       'E' Executed       42 octets
+//	                                       'C' Canceled       27 octets
+//	                                       'J' Rejected       23 octets
+//
+// Le Token est le ClOrdID de l'ordre, unique par compte. Le codec ne
+// connait pas la session : voir ouchserver.go et ouchclient.go.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ---------------------------------------------------------------------------
+// Frames (netlab lesson07)
+// ---------------------------------------------------------------------------
+
+// Frame est l'unite de transport : un type et un payload.
+type Frame struct {
+	Type    uint8
+	Payload []byte
+}
+
+// maxFramePayload borne un payload recu (protection contre une longueur
+// aberrante).
+const maxFramePayload = 1 << 20
+
+// EncodeFrame serialise une frame : [longueur u32][type u8][payload].
+func EncodeFrame(f Frame) []byte {
+	return appendFrame(make([]byte, 0, 5+len(f.Payload)), f.Type, f.Payload)
+}
+
+// appendFrame ajoute une frame a dst, sans allocation si dst a la place.
+func appendFrame(dst []byte, typ uint8, payload []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(1+len(payload)))
+	dst = append(dst, typ)
+	return append(dst, payload...)
+}
+
+// DecodeFrame lit une frame complete depuis r.
+func DecodeFrame(r io.Reader) (Frame, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Frame{}, fmt.Errorf("read header: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length == 0 {
+		return Frame{}, errors.New("frame vide (longueur 0)")
+	}
+	if length-1 > maxFramePayload {
+		return Frame{}, fmt.Errorf("payload trop grand: %d octets", length-1)
+	}
+	f := Frame{Type: header[4], Payload: make([]byte, length-1)}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return Frame{}, fmt.Errorf("read payload: %w", err)
+	}
+	return f, nil
+}
+
+// ---------------------------------------------------------------------------
+// Types de messages
+// ---------------------------------------------------------------------------
+
+// Types de frames OUCH. 'L', 'H' et 'U' existent dans les deux sens.
+const (
+	ouchLogin         uint8 = 'L'
+	ouchLoginAccepted uint8 = 'L'
+	ouchLoginRejected uint8 = 'K'
+	ouchHeartbeat     uint8 = 'H'
+
+	ouchEnterOrder   uint8 = 'O'
+	ouchReplaceOrder uint8 = 'U'
+	ouchCancelOrder  uint8 = 'X'
+
+	ouchAccepted uint8 = 'A'
+	ouchReplaced uint8 = 'U'
+	ouchExecuted uint8 = 'E'
+	ouchCanceled uint8 = 'C'
+	ouchRejected uint8 = 'J'
+)
+
+// Largeurs des champs texte.
+const (
+	ouchTokenLen  = 14
+	ouchSymbolLen = 8
+)
+
+// Tailles des payloads.
+const (
+	ouchEnterOrderLen   = ouchTokenLen + 1 + 4 + ouchSymbolLen + 8 + 1 + 1
+	ouchReplaceOrderLen = 2*ouchTokenLen + 4 + 8
+	ouchCancelOrderLen  = ouchTokenLen + 4
+	ouchAcceptedLen     = 8 + ouchTokenLen + 1 + 4 + ouchSymbolLen + 8 + 1 + 1 + 8
+	ouchReplacedLen     = 8 + ouchTokenLen + 1 + 4 + ouchSymbolLen + 8 + 8 + ouchTokenLen
+	ouchExecutedLen     = 8 + ouchTokenLen + 4 + 8 + 8
+	ouchCanceledLen     = 8 + ouchTokenLen + 4 + 1
+	ouchRejectedLen     = 8 + ouchTokenLen + 1
+)
+
+// Valeurs des champs a un octet.
+const (
+	OUCHDay byte = 'D' // TimeInForce : reste au carnet
+	OUCHIOC byte = 'I' // TimeInForce : Immediate or Cancel
+
+	OUCHLimitOrder  byte = 'L' // OrdType
+	OUCHMarketOrder byte = 'M'
+	OUCHStopOrder   byte = 'S' // Ordres saisis par un autre canal (jamais en entree)
+)
+
+// Motifs d'annulation (Canceled.Reason).
+const (
+	OUCHCancelUser        byte = 'U' // Demande du client
+	OUCHCancelIOC         byte = 'I' // Reliquat IOC / Market non execute
+	OUCHCancelSupervisory byte = 'S' // Moteur : OCO, mass cancel, kill switch
+)
+
+// Motifs de refus (Rejected.Reason).
+const (
+	OUCHRejectSymbol     byte = 'S' // Symbole inconnu
+	OUCHRejectPriceQty   byte = 'X' // Prix ou quantite invalides
+	OUCHRejectToken      byte = 'D' // Token deja utilise ou invalide
+	OUCHRejectKillSwitch byte = 'K' // Compte bloque par le kill switch
+	OUCHRejectTooLate    byte = 'T' // Ordre inconnu ou deja inactif (cancel, replace)
+	OUCHRejectOther      byte = 'O'
+)
+
+// ---------------------------------------------------------------------------
+// Messages
+// ---------------------------------------------------------------------------
+
+// OUCHEnterOrder saisit un ordre. Price est ignore pour un Market.
+type OUCHEnterOrder struct {
+	Token       string
+	Side        Side
+	Quantity    int64
+	Symbol      string
+	Price       float64
+	TimeInForce byte // OUCHDay, OUCHIOC
+	OrdType     byte // OUCHLimitOrder, OUCHMarketOrder
+}
+
+// OUCHReplaceOrder modifie un ordre limite et le renomme NewToken.
+// Quantity est la nouvelle quantite totale (execute compris), comme en
+// FIX ; Price ou Quantity a 0 : valeur inchangee.
+type OUCHReplaceOrder struct {
+	ExistingToken string
+	NewToken      string
+	Quantity      int64
+	Price         float64
+}
+
+// OUCHCancelOrder annule un ordre (Quantity 0) ou reduit sa quantite
+// totale a Quantity.
+type OUCHCancelOrder struct {
+	Token    string
+	Quantity int64
+}
+
+// OUCHAccepted confirme la prise en charge d'un ordre.
+type OUCHAccepted struct {
+	Timestamp   int64 // Unix nanoseconds
+	Token       string
+	Side        Side
+	Quantity    int64
+	Symbol      string
+	Price       float64
+	TimeInForce byte
+	OrdType     byte
+	OrderRef    uint64 // ID interne du moteur
+}
+
+// OUCHReplaced confirme un remplacement. Quantity est la quantite restante.
+type OUCHReplaced struct {
+	Timestamp     int64
+	Token         string // Nouveau token
+	Side          Side
+	Quantity      int64
+	Symbol        string
+	Price         float64
+	OrderRef      uint64
+	PreviousToken string
+}
+
+// OUCHExecuted rapporte une execution.
+type OUCHExecuted struct {
+	Timestamp   int64
+	Token       string
+	Quantity    int64
+	Price       float64
+	MatchNumber uint64 // ID du trade
+}
+
+// OUCHCanceled rapporte une annulation (totale, ou reduction de
+// Decrement).
+type OUCHCanceled struct {
+	Timestamp int64
+	Token     string
+	Decrement int64
+	Reason    byte
+}
+
+// OUCHRejected refuse un EnterOrder, ou un Cancel/Replace (Token est alors
+// celui de la demande : le token vise pour un cancel, le nouveau pour un
+// replace).
+type OUCHRejected struct {
+	Timestamp int64
+	Token     string
+	Reason    byte
+}
+
+// ---------------------------------------------------------------------------
+// Encodage
+// ---------------------------------------------------------------------------
+
+// ouchPrice convertit un prix en 1/10000.
+func ouchPrice(p float64) uint64 {
+	return uint64(math.Round(p * 10_000))
+}
+
+func ouchPriceFloat(p uint64) float64 {
+	return float64(p) / 10_000
+}
+
+func ouchSideByte(s Side) byte {
+	switch s {
+	case Buy:
+		return 'B'
+	case Sell:
+		return 'S'
+	}
+	return '?' // Refuse a la reception
+}
+
+func ouchSide(b byte) Side {
+	switch b {
+	case 'B':
+		return Buy
+	case 'S':
+		return Sell
+	}
+	return Side(string(b)) // Refuse par validateOrder
+}
+
+// wireWriter remplit un message binaire de largeur fixe (OUCH, ITCH).
+type wireWriter struct {
+	b []byte
+}
+
+func (w *wireWriter) u8(v byte)    { w.b = append(w.b, v) }
+func (w *wireWriter) u32(v int64)  { w.b = binary.BigEndian.AppendUint32(w.b, uint32(v)) }
+func (w *wireWriter) u64(v uint64) { w.b = binary.BigEndian.AppendUint64(w.b, v) }
+func (w *wireWriter) i64(v int64)  { w.u64(uint64(v)) }
+func (w *wireWriter) price(p float64) {
+	w.u64(ouchPrice(p))
+}
+
+// text ecrit s sur n octets (tronque a n : les longueurs sont verifiees par
+// checkText avant l'envoi).
+func (w *wireWriter) text(s string, n int) {
+	start := len(w.b)
+	w.b = append(w.b, make([]byte, n)...)
+	copy(w.b[start:], s)
+}
+
+// wireReader lit un message binaire de largeur fixe, dont la taille a ete
+// verifiee au prealable.
+type wireReader struct {
+	b   []byte
+	off int
+}
+
+func (r *wireReader) u8() byte {
+	v := r.b[r.off]
+	r.off++
+	return v
+}
+
+func (r *wireReader) u32() int64 {
+	v := binary.BigEndian.Uint32(r.b[r.off:])
+	r.off += 4
+	return int64(v)
+}
+
+func (r *wireReader) u64() uint64 {
+	v := binary.BigEndian.Uint64(r.b[r.off:])
+	r.off += 8
+	return v
+}
+
+func (r *wireReader) i64() int64     { return int64(r.u64()) }
+func (r *wireReader) price() float64 { return ouchPriceFloat(r.u64()) }
+
+func (r *wireReader) text(n int) string {
+	field := r.b[r.off : r.off+n]
+	r.off += n
+	end := n
+	for end > 0 && field[end-1] == 0 {
+		end--
+	}
+	return string(field[:end])
+}
+
+// checkText verifie qu'un champ texte tient dans sa largeur.
+func checkText(field, s string, n int) error {
+	if len(s) > n {
+		return &ValidationError{Field: field, Message: fmt.Sprintf("plus de %d octets: %q", n, s)}
+	}
+	return nil
+}
+
+// checkLen verifie la taille d'un payload recu.
+func checkLen(typ uint8, p []byte, want int) error {
+	if len(p) != want {
+		return fmt.Errorf("ouch: message %q de %d octets, attendu %d", typ, len(p), want)
+	}
+	return nil
+}
+
+func (m *OUCHEnterOrder) encode() ([]byte, error) {
+	if err := errors.Join(checkText("token", m.Token, ouchTokenLen), checkText("symbol", m.Symbol, ouchSymbolLen)); err != nil {
+		return nil, err
+	}
+	if m.Quantity < 0 || m.Quantity > math.MaxUint32 {
+		return nil, &ValidationError{Field: "quantity", Message: fmt.Sprintf("hors u32: %d", m.Quantity)}
+	}
+	w := wireWriter{make([]byte, 0, ouchEnterOrderLen)}
+	w.text(m.Token, ouchTokenLen)
+	w.u8(ouchSideByte(m.Side))
+	w.u32(m.Quantity)
+	w.text(m.Symbol, ouchSymbolLen)
+	w.price(m.Price)
+	w.u8(m.TimeInForce)
+	w.u8(m.OrdType)
+	return w.b, nil
+}
+
+func decodeOUCHEnterOrder(p []byte) (OUCHEnterOrder, error) {
+	if err := checkLen(ouchEnterOrder, p, ouchEnterOrderLen); err != nil {
+		return OUCHEnterOrder{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHEnterOrder{
+		Token:       r.text(ouchTokenLen),
+		Side:        ouchSide(r.u8()),
+		Quantity:    r.u32(),
+		Symbol:      r.text(ouchSymbolLen),
+		Price:       r.price(),
+		TimeInForce: r.u8(),
+		OrdType:     r.u8(),
+	}, nil
+}
+
+func (m *OUCHReplaceOrder) encode() ([]byte, error) {
+	if err := errors.Join(checkText("token", m.ExistingToken, ouchTokenLen), checkText("token", m.NewToken, ouchTokenLen)); err != nil {
+		return nil, err
+	}
+	if m.Quantity < 0 || m.Quantity > math.MaxUint32 {
+		return nil, &ValidationError{Field: "quantity", Message: fmt.Sprintf("hors u32: %d", m.Quantity)}
+	}
+	w := wireWriter{make([]byte, 0, ouchReplaceOrderLen)}
+	w.text(m.ExistingToken, ouchTokenLen)
+	w.text(m.NewToken, ouchTokenLen)
+	w.u32(m.Quantity)
+	w.price(m.Price)
+	return w.b, nil
+}
+
+func decodeOUCHReplaceOrder(p []byte) (OUCHReplaceOrder, error) {
+	if err := checkLen(ouchReplaceOrder, p, ouchReplaceOrderLen); err != nil {
+		return OUCHReplaceOrder{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHReplaceOrder{
+		ExistingToken: r.text(ouchTokenLen),
+		NewToken:      r.text(ouchTokenLen),
+		Quantity:      r.u32(),
+		Price:         r.price(),
+	}, nil
+}
+
+func (m *OUCHCancelOrder) encode() ([]byte, error) {
+	if err := checkText("token", m.Token, ouchTokenLen); err != nil {
+		return nil, err
+	}
+	if m.Quantity < 0 || m.Quantity > math.MaxUint32 {
+		return nil, &ValidationError{Field: "quantity", Message: fmt.Sprintf("hors u32: %d", m.Quantity)}
+	}
+	w := wireWriter{make([]byte, 0, ouchCancelOrderLen)}
+	w.text(m.Token, ouchTokenLen)
+	w.u32(m.Quantity)
+	return w.b, nil
+}
+
+func decodeOUCHCancelOrder(p []byte) (OUCHCancelOrder, error) {
+	if err := checkLen(ouchCancelOrder, p, ouchCancelOrderLen); err != nil {
+		return OUCHCancelOrder{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHCancelOrder{Token: r.text(ouchTokenLen), Quantity: r.u32()}, nil
+}
+
+// Les messages sortants sont construits par le serveur a partir d'Events
+// deja valides : leur encodage ne peut pas echouer.
+
+func (m *OUCHAccepted) encode() []byte {
+	w := wireWriter{make([]byte, 0, ouchAcceptedLen)}
+	w.i64(m.Timestamp)
+	w.text(m.Token, ouchTokenLen)
+	w.u8(ouchSideByte(m.Side))
+	w.u32(m.Quantity)
+	w.text(m.Symbol, ouchSymbolLen)
+	w.price(m.Price)
+	w.u8(m.TimeInForce)
+	w.u8(m.OrdType)
+	w.u64(m.OrderRef)
+	return w.b
+}
+
+func decodeOUCHAccepted(p []byte) (OUCHAccepted, error) {
+	if err := checkLen(ouchAccepted, p, ouchAcceptedLen); err != nil {
+		return OUCHAccepted{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHAccepted{
+		Timestamp:   r.i64(),
+		Token:       r.text(ouchTokenLen),
+		Side:        ouchSide(r.u8()),
+		Quantity:    r.u32(),
+		Symbol:      r.text(ouchSymbolLen),
+		Price:       r.price(),
+		TimeInForce: r.u8(),
+		OrdType:     r.u8(),
+		OrderRef:    r.u64(),
+	}, nil
+}
+
+func (m *OUCHReplaced) encode() []byte {
+	w := wireWriter{make([]byte, 0, ouchReplacedLen)}
+	w.i64(m.Timestamp)
+	w.text(m.Token, ouchTokenLen)
+	w.u8(ouchSideByte(m.Side))
+	w.u32(m.Quantity)
+	w.text(m.Symbol, ouchSymbolLen)
+	w.price(m.Price)
+	w.u64(m.OrderRef)
+	w.text(m.PreviousToken, ouchTokenLen)
+	return w.b
+}
+
+func decodeOUCHReplaced(p []byte) (OUCHReplaced, error) {
+	if err := checkLen(ouchReplaced, p, ouchReplacedLen); err != nil {
+		return OUCHReplaced{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHReplaced{
+		Timestamp:     r.i64(),
+		Token:         r.text(ouchTokenLen),
+		Side:          ouchSide(r.u8()),
+		Quantity:      r.u32(),
+		Symbol:        r.text(ouchSymbolLen),
+		Price:         r.price(),
+		OrderRef:      r.u64(),
+		PreviousToken: r.text(ouchTokenLen),
+	}, nil
+}
+
+func (m *OUCHExecuted) encode() []byte {
+	w := wireWriter{make([]byte, 0, ouchExecutedLen)}
+	w.i64(m.Timestamp)
+	w.text(m.Token, ouchTokenLen)
+	w.u32(m.Quantity)
+	w.price(m.Price)
+	w.u64(m.MatchNumber)
+	return w.b
+}
+
+func decodeOUCHExecuted(p []byte) (OUCHExecuted, error) {
+	if err := checkLen(ouchExecuted, p, ouchExecutedLen); err != nil {
+		return OUCHExecuted{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHExecuted{
+		Timestamp:   r.i64(),
+		Token:       r.text(ouchTokenLen),
+		Quantity:    r.u32(),
+		Price:       r.price(),
+		MatchNumber: r.u64(),
+	}, nil
+}
+
+func (m *OUCHCanceled) encode() []byte {
+	w := wireWriter{make([]byte, 0, ouchCanceledLen)}
+	w.i64(m.Timestamp)
+	w.text(m.Token, ouchTokenLen)
+	w.u32(m.Decrement)
+	w.u8(m.Reason)
+	return w.b
+}
+
+func decodeOUCHCanceled(p []byte) (OUCHCanceled, error) {
+	if err := checkLen(ouchCanceled, p, ouchCanceledLen); err != nil {
+		return OUCHCanceled{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHCanceled{Timestamp: r.i64(), Token: r.text(ouchTokenLen), Decrement: r.u32(), Reason: r.u8()}, nil
+}
+
+func (m *OUCHRejected) encode() []byte {
+	w := wireWriter{make([]byte, 0, ouchRejectedLen)}
+	w.i64(m.Timestamp)
+	w.text(m.Token, ouchTokenLen)
+	w.u8(m.Reason)
+	return w.b
+}
+
+func decodeOUCHRejected(p []byte) (OUCHRejected, error) {
+	if err := checkLen(ouchRejected, p, ouchRejectedLen); err != nil {
+		return OUCHRejected{}, err
+	}
+	r := wireReader{b: p}
+	return OUCHRejected{Timestamp: r.i64(), Token: r.text(ouchTokenLen), Reason: r.u8()}, nil
+}
+
+// decodeOUCHOutbound decode un message serveur -> client (cote client).
+// Retourne un OUCHAccepted, OUCHReplaced, OUCHExecuted, OUCHCanceled ou
+// OUCHRejected.
+func decodeOUCHOutbound(f Frame) (any, error) {
+	switch f.Type {
+	case ouchAccepted:
+		return decodeOUCHAccepted(f.Payload)
+	case ouchReplaced:
+		return decodeOUCHReplaced(f.Payload)
+	case ouchExecuted:
+		return decodeOUCHExecuted(f.Payload)
+	case ouchCanceled:
+		return decodeOUCHCanceled(f.Payload)
+	case ouchRejected:
+		return decodeOUCHRejected(f.Payload)
+	}
+	return nil, fmt.Errorf("ouch: type de message inconnu %q", f.Type)
+}
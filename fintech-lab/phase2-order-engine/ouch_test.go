@@ -0,0 +1,387 @@
+// ouch_test.go — Tests du protocole binaire OUCH, serveur et client.
+// Lancer avec : go test ./phase2-order-engine/ -run OUCH -v
+// Latences   : go test ./phase2-order-engine/ -run ^$ -bench OUCH
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startOUCH demarre un serveur sur un port local (tok-1 : ACC1, tok-2 : ACC2).
+func startOUCH(t testing.TB, gw *Gateway, opts OUCHOptions) string {
+	t.Helper()
+	opts.Tokens = map[string]string{"tok-1": "ACC1", "tok-2": "ACC2"}
+	srv := NewOUCHServer(gw, opts)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func dialOUCH(t testing.TB, addr, token string) *OUCHClient {
+	t.Helper()
+	c, err := DialOUCH(addr, token, 0)
+	if err != nil {
+		t.Fatalf("dial %s: %v", token, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.ReadTimeout = 2 * time.Second
+	return c
+}
+
+// readOUCH lit le prochain message et verifie son type.
+func readOUCH[T any](t testing.TB, c *OUCHClient) T {
+	t.Helper()
+	msg, err := c.Read()
+	if err != nil {
+		t.Fatalf("lecture: %v", err)
+	}
+	m, ok := msg.(T)
+	if !ok {
+		var want T
+		t.Fatalf("attendu %T, obtenu %T %+v", want, msg, msg)
+	}
+	return m
+}
+
+func limitOUCH(token string, side Side, price float64, qty int64) OUCHEnterOrder {
+	return OUCHEnterOrder{Token: token, Side: side, Quantity: qty, Symbol: "AAPL", Price: price, TimeInForce: OUCHDay, OrdType: OUCHLimitOrder}
+}
+
+// TestOUCHCodec verifie les frames et l'aller-retour de chaque message.
+func TestOUCHCodec(t *testing.T) {
+	raw := EncodeFrame(Frame{Type: ouchHeartbeat, Payload: []byte("ab")})
+	if want := []byte{0, 0, 0, 3, 'H', 'a', 'b'}; !bytes.Equal(raw, want) {
+		t.Fatalf("frame: %v, attendu %v", raw, want)
+	}
+	f, err := DecodeFrame(bytes.NewReader(raw))
+	if err != nil || f.Type != ouchHeartbeat || string(f.Payload) != "ab" {
+		t.Fatalf("relecture frame: %+v %v", f, err)
+	}
+	if _, err := DecodeFrame(bytes.NewReader(raw[:4])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("frame tronquee: attendu ErrUnexpectedEOF, obtenu %v", err)
+	}
+
+	enter := limitOUCH("o-1", Sell, 189.1234, 300)
+	p, err := enter.encode()
+	if err != nil || len(p) != ouchEnterOrderLen {
+		t.Fatalf("EnterOrder: %d octets, %v", len(p), err)
+	}
+	if got, _ := decodeOUCHEnterOrder(p); got != enter {
+		t.Errorf("EnterOrder: %+v, attendu %+v", got, enter)
+	}
+	replace := OUCHReplaceOrder{ExistingToken: "o-1", NewToken: "o-2", Quantity: 500, Price: 190.5}
+	p, _ = replace.encode()
+	if got, _ := decodeOUCHReplaceOrder(p); got != replace {
+		t.Errorf("ReplaceOrder: %+v, attendu %+v", got, replace)
+	}
+	cancel := OUCHCancelOrder{Token: "o-2", Quantity: 100}
+	p, _ = cancel.encode()
+	if got, _ := decodeOUCHCancelOrder(p); got != cancel {
+		t.Errorf("CancelOrder: %+v, attendu %+v", got, cancel)
+	}
+
+	accepted := OUCHAccepted{1, "o-1", Buy, 300, "AAPL", 189.5, OUCHIOC, OUCHLimitOrder, 42}
+	replaced := OUCHReplaced{2, "o-2", Sell, 200, "MSFT", 410.25, 42, "o-1"}
+	executed := OUCHExecuted{3, "o-2", 100, 410.25, 7}
+	canceled := OUCHCanceled{4, "o-2", 100, OUCHCancelUser}
+	rejected := OUCHRejected{5, "o-3", OUCHRejectSymbol}
+	outbound := []struct {
+		typ     uint8
+		want    any
+		payload []byte
+		len     int
+	}{
+		{ouchAccepted, accepted, accepted.encode(), ouchAcceptedLen},
+		{ouchReplaced, replaced, replaced.encode(), ouchReplacedLen},
+		{ouchExecuted, executed, executed.encode(), ouchExecutedLen},
+		{ouchCanceled, canceled, canceled.encode(), ouchCanceledLen},
+		{ouchRejected, rejected, rejected.encode(), ouchRejectedLen},
+	}
+	for _, tc := range outbound {
+		if len(tc.payload) != tc.len {
+			t.Errorf("%q: %d octets, attendu %d", tc.typ, len(tc.payload), tc.len)
+		}
+		got, err := decodeOUCHOutbound(Frame{Type: tc.typ, Payload: tc.payload})
+		if err != nil || got != tc.want {
+			t.Errorf("%q: %+v (%v), attendu %+v", tc.typ, got, err, tc.want)
+		}
+	}
+
+	long := limitOUCH("token-beaucoup-trop-long", Buy, 1, 1)
+	if _, err := long.encode(); err == nil {
+		t.Error("token de plus de 14 octets: attendu une erreur")
+	}
+	if _, err := decodeOUCHCancelOrder(make([]byte, 5)); err == nil {
+		t.Error("payload de mauvaise taille: attendu une erreur")
+	}
+}
+
+// TestOUCHOrderLifecycle deroule saisie, execution, IOC, replace, reduction
+// et annulation entre deux comptes.
+func TestOUCHOrderLifecycle(t *testing.T) {
+	gw, _ := newTestGateway()
+	addr := startOUCH(t, gw, OUCHOptions{})
+	c1 := dialOUCH(t, addr, "tok-1")
+	c2 := dialOUCH(t, addr, "tok-2")
+	if c1.Account != "ACC1" || c2.Account != "ACC2" {
+		t.Fatalf("comptes: %q %q", c1.Account, c2.Account)
+	}
+
+	c1.Enter(limitOUCH("s1", Sell, 190.00, 100))
+	acc := readOUCH[OUCHAccepted](t, c1)
+	if acc.Token != "s1" || acc.Quantity != 100 || acc.Price != 190.00 || acc.OrdType != OUCHLimitOrder || acc.OrderRef == 0 {
+		t.Fatalf("Accepted: %+v", acc)
+	}
+
+	c2.Enter(limitOUCH("b1", Buy, 190.00, 40))
+	readOUCH[OUCHAccepted](t, c2)
+	exec := readOUCH[OUCHExecuted](t, c2)
+	if exec.Token != "b1" || exec.Quantity != 40 || exec.Price != 190.00 {
+		t.Errorf("Executed acheteur: %+v", exec)
+	}
+	if e := readOUCH[OUCHExecuted](t, c1); e.Token != "s1" || e.Quantity != 40 || e.MatchNumber != exec.MatchNumber {
+		t.Errorf("Executed vendeur: %+v (match %d)", e, exec.MatchNumber)
+	}
+
+	ioc := limitOUCH("b2", Buy, 189.00, 10)
+	ioc.Symbol, ioc.TimeInForce = "MSFT", OUCHIOC // Carnet vide : rien a executer
+	c2.Enter(ioc)
+	if a := readOUCH[OUCHAccepted](t, c2); a.TimeInForce != OUCHIOC {
+		t.Errorf("Accepted IOC: %+v", a)
+	}
+	if cx := readOUCH[OUCHCanceled](t, c2); cx.Token != "b2" || cx.Decrement != 10 || cx.Reason != OUCHCancelIOC {
+		t.Errorf("Canceled IOC: %+v", cx)
+	}
+
+	// Replace : quantite totale 80 dont 40 executes -> 40 restants.
+	c1.Replace(OUCHReplaceOrder{ExistingToken: "s1", NewToken: "s2", Quantity: 80, Price: 191.00})
+	rep := readOUCH[OUCHReplaced](t, c1)
+	if rep.Token != "s2" || rep.PreviousToken != "s1" || rep.Quantity != 40 || rep.Price != 191.00 || rep.OrderRef != acc.OrderRef {
+		t.Errorf("Replaced: %+v", rep)
+	}
+
+	c1.Cancel(OUCHCancelOrder{Token: "s2", Quantity: 60})
+	if cx := readOUCH[OUCHCanceled](t, c1); cx.Token != "s2" || cx.Decrement != 20 || cx.Reason != OUCHCancelUser {
+		t.Errorf("reduction: %+v", cx)
+	}
+	c1.Cancel(OUCHCancelOrder{Token: "s2"})
+	if cx := readOUCH[OUCHCanceled](t, c1); cx.Decrement != 20 || cx.Reason != OUCHCancelUser {
+		t.Errorf("annulation: %+v", cx)
+	}
+	c1.Cancel(OUCHCancelOrder{Token: "s2"})
+	if rj := readOUCH[OUCHRejected](t, c1); rj.Token != "s2" || rj.Reason != OUCHRejectTooLate {
+		t.Errorf("annulation d'un ordre inactif: %+v", rj)
+	}
+}
+
+// TestOUCHRejects verifie les motifs de refus.
+func TestOUCHRejects(t *testing.T) {
+	gw, _ := newTestGateway()
+	addr := startOUCH(t, gw, OUCHOptions{})
+	c := dialOUCH(t, addr, "tok-1")
+
+	c.Enter(limitOUCH("r1", Buy, 100.00, 10))
+	readOUCH[OUCHAccepted](t, c)
+
+	unknown := limitOUCH("r3", Buy, 100.00, 10)
+	unknown.Symbol = "GOOG"
+	market := OUCHEnterOrder{Token: "r5", Side: Sell, Quantity: 10, Symbol: "AAPL", TimeInForce: 'G', OrdType: OUCHMarketOrder}
+	tests := []struct {
+		name   string
+		send   func() error
+		token  string
+		reason byte
+	}{
+		{"token deja utilise", func() error { return c.Enter(limitOUCH("r1", Buy, 100.00, 10)) }, "r1", OUCHRejectToken},
+		{"token vide", func() error { return c.Enter(limitOUCH("", Buy, 100.00, 10)) }, "", OUCHRejectToken},
+		{"symbole inconnu", func() error { return c.Enter(unknown) }, "r3", OUCHRejectSymbol},
+		{"prix nul", func() error { return c.Enter(limitOUCH("r4", Buy, 0, 10)) }, "r4", OUCHRejectPriceQty},
+		{"TimeInForce inconnu", func() error { return c.Enter(market) }, "r5", OUCHRejectOther},
+		{"replace d'un token inconnu", func() error {
+			return c.Replace(OUCHReplaceOrder{ExistingToken: "zz", NewToken: "r6", Quantity: 5})
+		}, "r6", OUCHRejectTooLate},
+		{"annulation qui augmente", func() error { return c.Cancel(OUCHCancelOrder{Token: "r1", Quantity: 20}) }, "r1", OUCHRejectPriceQty},
+	}
+	for _, tc := range tests {
+		if err := tc.send(); err != nil {
+			t.Fatalf("%s: envoi: %v", tc.name, err)
+		}
+		rj := readOUCH[OUCHRejected](t, c)
+		if rj.Token != tc.token || rj.Reason != tc.reason {
+			t.Errorf("%s: obtenu %q motif %q, attendu %q motif %q", tc.name, rj.Token, rj.Reason, tc.token, tc.reason)
+		}
+	}
+
+	// Le kill switch annule l'ordre au repos puis refuse les suivants.
+	if _, err := gw.Kill("ACC1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if cx := readOUCH[OUCHCanceled](t, c); cx.Token != "r1" || cx.Reason != OUCHCancelSupervisory {
+		t.Errorf("kill switch: %+v", cx)
+	}
+	c.Enter(limitOUCH("r7", Buy, 100.00, 10))
+	if rj := readOUCH[OUCHRejected](t, c); rj.Reason != OUCHRejectKillSwitch {
+		t.Errorf("compte bloque: motif %q", rj.Reason)
+	}
+}
+
+// TestOUCHLogin verifie le refus d'un token inconnu et d'une deuxieme
+// connexion sur le meme compte.
+func TestOUCHLogin(t *testing.T) {
+	gw, _ := newTestGateway()
+	addr := startOUCH(t, gw, OUCHOptions{})
+
+	if _, err := DialOUCH(addr, "faux", 0); err == nil || !strings.Contains(err.Error(), "token invalide") {
+		t.Errorf("token inconnu: %v", err)
+	}
+	c := dialOUCH(t, addr, "tok-1")
+	if _, err := DialOUCH(addr, "tok-1", 0); err == nil || !strings.Contains(err.Error(), "deja connecte") {
+		t.Errorf("deuxieme connexion: %v", err)
+	}
+
+	// Apres deconnexion, le compte est de nouveau disponible.
+	c.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c2, err := DialOUCH(addr, "tok-1", 0)
+		if err == nil {
+			c2.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reconnexion: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestOUCHHeartbeat verifie les heartbeats du serveur et la coupure d'un
+// client muet.
+func TestOUCHHeartbeat(t *testing.T) {
+	gw, _ := newTestGateway()
+	addr := startOUCH(t, gw, OUCHOptions{Heartbeat: 20 * time.Millisecond, IdleTimeout: 100 * time.Millisecond})
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	nc.Write(EncodeFrame(Frame{Type: ouchLogin, Payload: []byte("tok-1")}))
+	br := bufio.NewReader(nc)
+	nc.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var heartbeats int
+	for {
+		f, err := DecodeFrame(br)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("attendu une fermeture propre, obtenu %v", err)
+			}
+			break
+		}
+		if f.Type == ouchHeartbeat {
+			heartbeats++
+		}
+	}
+	if heartbeats < 2 {
+		t.Errorf("attendu au moins 2 heartbeats avant la coupure, obtenu %d", heartbeats)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Benchmarks de latence
+// ---------------------------------------------------------------------------
+
+// reportLatencies ajoute p50 et p99 aux resultats du benchmark.
+func reportLatencies(b *testing.B, lat []time.Duration) {
+	if len(lat) == 0 {
+		return
+	}
+	slices.Sort(lat)
+	b.ReportMetric(float64(lat[len(lat)/2].Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(lat[len(lat)*99/100].Nanoseconds()), "p99-ns")
+}
+
+// BenchmarkOUCHRoundTrip mesure EnterOrder -> Accepted sur TCP local
+// (IOC sans contrepartie : le carnet reste vide).
+func BenchmarkOUCHRoundTrip(b *testing.B) {
+	gw, _ := newTestGateway()
+	c := dialOUCH(b, startOUCH(b, gw, OUCHOptions{}), "tok-1")
+	m := limitOUCH("", Buy, 100.00, 10)
+	m.TimeInForce = OUCHIOC
+	lat := make([]time.Duration, 0, b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Token = "b" + strconv.Itoa(i)
+		start := time.Now()
+		c.Enter(m)
+		readOUCH[OUCHAccepted](b, c)
+		lat = append(lat, time.Since(start))
+		readOUCH[OUCHCanceled](b, c)
+	}
+	b.StopTimer()
+	reportLatencies(b, lat)
+}
+
+// BenchmarkOUCHRoundTripMatch mesure EnterOrder -> Executed quand l'ordre
+// croise un ordre au repos pose par un autre compte.
+func BenchmarkOUCHRoundTripMatch(b *testing.B) {
+	gw, _ := newTestGateway()
+	addr := startOUCH(b, gw, OUCHOptions{})
+	maker, taker := dialOUCH(b, addr, "tok-1"), dialOUCH(b, addr, "tok-2")
+	lat := make([]time.Duration, 0, b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		maker.Enter(limitOUCH("s"+strconv.Itoa(i), Sell, 100.00, 10))
+		readOUCH[OUCHAccepted](b, maker)
+		b.StartTimer()
+
+		start := time.Now()
+		taker.Enter(limitOUCH("b"+strconv.Itoa(i), Buy, 100.00, 10))
+		readOUCH[OUCHAccepted](b, taker)
+		readOUCH[OUCHExecuted](b, taker)
+		lat = append(lat, time.Since(start))
+
+		b.StopTimer()
+		readOUCH[OUCHExecuted](b, maker)
+		b.StartTimer()
+	}
+	b.StopTimer()
+	reportLatencies(b, lat)
+}
+
+// BenchmarkOUCHCodec mesure l'encodage d'un EnterOrder et la relecture de
+// sa frame.
+func BenchmarkOUCHCodec(b *testing.B) {
+	m := limitOUCH("o-123456", Buy, 189.50, 100)
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p, _ := m.encode()
+		buf = appendFrame(buf[:0], ouchEnterOrder, p)
+		f, err := DecodeFrame(bytes.NewReader(buf))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := decodeOUCHEnterOrder(f.Payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// ouchclient.go — Client du protocole binaire (voir ouch.go).
+//
+//	c, err := DialOUCH("localhost:9001", "tok-1", 0)
+//	c.Enter(OUCHEnterOrder{Token: "o1", Side: Buy, Quantity: 100, Symbol: "AAPL",
+//		Price: 189.50, TimeInForce: OUCHDay, OrdType: OUCHLimitOrder})
+//	msg, err := c.Read() // OUCHAccepted, OUCHExecuted, ...
+//
+// Les envois sont surs depuis plusieurs goroutines ; Read ne l'est pas.
+// Le client emet les heartbeats tout seul.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// OUCHClient est une connexion authentifiee au serveur.
+type OUCHClient struct {
+	Account     string        // Compte attribue par le serveur
+	ReadTimeout time.Duration // Delai max d'un Read (0 : aucun)
+
+	nc        net.Conn
+	br        *bufio.Reader
+	done      chan struct{}
+	closeOnce sync.Once
+
+	wmu      sync.Mutex
+	buf      []byte // Tampon d'emission reutilise
+	lastSent time.Time
+}
+
+// DialOUCH se connecte, s'authentifie avec token et demarre les heartbeats
+// (toutes les heartbeat ; 0 : defaut du serveur).
+func DialOUCH(addr, token string, heartbeat time.Duration) (*OUCHClient, error) {
+	if heartbeat <= 0 {
+		heartbeat = defaultOUCHHeartbeat
+	}
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := nc.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+	c := &OUCHClient{nc: nc, br: bufio.NewReader(nc), done: make(chan struct{}), lastSent: time.Now()}
+
+	nc.SetDeadline(time.Now().Add(defaultOUCHLoginTimeout))
+	if err := c.send(ouchLogin, []byte(token)); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	f, err := DecodeFrame(c.br)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("ouch: login: %w", err)
+	}
+	switch f.Type {
+	case ouchLoginAccepted:
+		c.Account = string(f.Payload)
+	case ouchLoginRejected:
+		nc.Close()
+		return nil, fmt.Errorf("ouch: login refuse: %s", f.Payload)
+	default:
+		nc.Close()
+		return nil, fmt.Errorf("ouch: reponse au login inattendue %q", f.Type)
+	}
+	nc.SetDeadline(time.Time{})
+
+	go c.heartbeats(heartbeat)
+	return c, nil
+}
+
+// Enter envoie un EnterOrder.
+func (c *OUCHClient) Enter(m OUCHEnterOrder) error {
+	p, err := m.encode()
+	if err != nil {
+		return err
+	}
+	return c.send(ouchEnterOrder, p)
+}
+
+// Replace envoie un ReplaceOrder.
+func (c *OUCHClient) Replace(m OUCHReplaceOrder) error {
+	p, err := m.encode()
+	if err != nil {
+		return err
+	}
+	return c.send(ouchReplaceOrder, p)
+}
+
+// Cancel envoie un CancelOrder.
+func (c *OUCHClient) Cancel(m OUCHCancelOrder) error {
+	p, err := m.encode()
+	if err != nil {
+		return err
+	}
+	return c.send(ouchCancelOrder, p)
+}
+
+// Read retourne le prochain message du serveur, heartbeats exclus :
+// OUCHAccepted, OUCHReplaced, OUCHExecuted, OUCHCanceled ou OUCHRejected.
+func (c *OUCHClient) Read() (any, error) {
+	for {
+		if c.ReadTimeout > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+		}
+		f, err := DecodeFrame(c.br)
+		if err != nil {
+			return nil, err
+		}
+		if f.Type != ouchHeartbeat {
+			return decodeOUCHOutbound(f)
+		}
+	}
+}
+
+// Close ferme la connexion.
+func (c *OUCHClient) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.nc.Close()
+	})
+	return err
+}
+
+// send ecrit une frame en un seul appel systeme.
+func (c *OUCHClient) send(typ uint8, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.buf = appendFrame(c.buf[:0], typ, payload)
+	if _, err := c.nc.Write(c.buf); err != nil {
+		return err
+	}
+	c.lastSent = time.Now()
+	return nil
+}
+
+// heartbeats emet un Heartbeat apres chaque silence de interval.
+func (c *OUCHClient) heartbeats(interval time.Duration) {
+	tick := time.NewTicker(max(interval/4, time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			c.wmu.Lock()
+			idle := time.Since(c.lastSent) >= interval
+			c.wmu.Unlock()
+			if idle {
+				if err := c.send(ouchHeartbeat, nil); err != nil {
+					return
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,611 @@
+// ouchserver.go — Serveur d'entree d'ordres binaire (voir ouch.go).
+//
+// SESSION
+//   - Login (L) : premiere frame obligatoire, le payload est un token
+//     d'acces (comme le Bearer HTTP) qui donne le compte. Une seule
+//     connexion par compte.
+//   - Heartbeat (H) emis apres Heartbeat sans emission ; un client muet
+//     pendant IdleTimeout est deconnecte.
+//   - Pas de numerotation ni de rejeu : apres une coupure, le client
+//     consulte ses ordres (API HTTP) avant de reprendre.
+//
+// APPLICATION
+//   EnterOrder (O), ReplaceOrder (U), CancelOrder (X) -> Submit,
+//   ReplaceByClOrdID, CancelByClOrdID / Amend. Les reponses Accepted,
+//   Replaced, Executed et Canceled sont produites a partir des Events du
+//   Gateway ; un refus donne un Rejected, construit depuis l'erreur
+//   retournee (motif precis) et non depuis EventRejected. Seuls les ordres
+//   avec ClOrdID (token) sont rapportes.
+//
+// Les frames sortantes d'une rafale sont ecrites en un seul appel systeme.
+//
+// Lancer : go run ./phase2-order-engine/ ouch -addr :9001 -tokens tok-1=ACC1
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Valeurs par defaut des options OUCH.
+const (
+	defaultOUCHHeartbeat    = time.Second
+	defaultOUCHLoginTimeout = 5 * time.Second
+	defaultOUCHSendQueue    = 4096
+)
+
+// OUCHOptions regle le serveur. Les zeros prennent les defauts.
+type OUCHOptions struct {
+	Tokens       map[string]string // token de login -> compte
+	Heartbeat    time.Duration     // Heartbeat emis apres ce silence
+	IdleTimeout  time.Duration     // Deconnexion apres ce silence du client (3 x Heartbeat)
+	LoginTimeout time.Duration     // Delai pour recevoir le Login
+	SendQueue    int               // Frames en attente d'ecriture avant deconnexion
+	Log          io.Writer         // Journal des sessions (nil : aucun)
+}
+
+// ---------------------------------------------------------------------------
+// OUCHServer
+// ---------------------------------------------------------------------------
+
+// OUCHServer accepte les connexions binaires et les route vers le Gateway.
+type OUCHServer struct {
+	gw   *Gateway
+	opts OUCHOptions
+
+	mu        sync.Mutex
+	conns     map[string]*ouchConn // compte -> connexion
+	listeners []net.Listener
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewOUCHServer cree un serveur et s'abonne aux Events du Gateway.
+func NewOUCHServer(gw *Gateway, opts OUCHOptions) *OUCHServer {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = defaultOUCHHeartbeat
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 3 * opts.Heartbeat
+	}
+	if opts.LoginTimeout <= 0 {
+		opts.LoginTimeout = defaultOUCHLoginTimeout
+	}
+	if opts.SendQueue <= 0 {
+		opts.SendQueue = defaultOUCHSendQueue
+	}
+	s := &OUCHServer{gw: gw, opts: opts, conns: make(map[string]*ouchConn)}
+	gw.Subscribe(s.onEvent)
+	return s
+}
+
+// Serve accepte les connexions de l, jusqu'a Close.
+func (s *OUCHServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(nc)
+		}()
+	}
+}
+
+// Close arrete l'ecoute et coupe les connexions.
+func (s *OUCHServer) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for _, c := range s.conns {
+		c.kill()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *OUCHServer) logf(format string, args ...any) {
+	if s.opts.Log != nil {
+		fmt.Fprintf(s.opts.Log, "[OUCH] "+format+"\n", args...)
+	}
+}
+
+// handle attend le Login puis lit la connexion jusqu'a la coupure.
+func (s *OUCHServer) handle(nc net.Conn) {
+	br := bufio.NewReader(nc)
+	nc.SetReadDeadline(time.Now().Add(s.opts.LoginTimeout))
+	f, err := DecodeFrame(br)
+	if err != nil || f.Type != ouchLogin {
+		s.logf("%s: Login attendu (%v)", nc.RemoteAddr(), err)
+		nc.Close()
+		return
+	}
+	account, ok := s.opts.Tokens[string(f.Payload)]
+	if !ok {
+		s.refuse(nc, "token invalide")
+		return
+	}
+
+	s.mu.Lock()
+	switch {
+	case s.closed:
+		s.mu.Unlock()
+		nc.Close()
+		return
+	case s.conns[account] != nil:
+		s.mu.Unlock()
+		s.refuse(nc, fmt.Sprintf("compte %q deja connecte", account))
+		return
+	}
+	c := newOUCHConn(s, nc, account)
+	s.conns[account] = c
+	// Avant tout Event : LoginAccepted est la premiere frame emise.
+	c.enqueue(appendFrame(nil, ouchLoginAccepted, []byte(account)))
+	s.mu.Unlock()
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		c.writeLoop()
+	}()
+	s.logf("%s: %s connecte", nc.RemoteAddr(), account)
+	c.readLoop(br)
+	c.kill()
+	<-writerDone
+
+	s.mu.Lock()
+	if s.conns[account] == c {
+		delete(s.conns, account)
+	}
+	s.mu.Unlock()
+	s.logf("%s: %s deconnecte", nc.RemoteAddr(), account)
+}
+
+// refuse envoie un LoginRejected et ferme la connexion.
+func (s *OUCHServer) refuse(nc net.Conn, reason string) {
+	s.logf("%s: login refuse: %s", nc.RemoteAddr(), reason)
+	nc.SetWriteDeadline(time.Now().Add(s.opts.Heartbeat))
+	nc.Write(EncodeFrame(Frame{Type: ouchLoginRejected, Payload: []byte(reason)}))
+	nc.Close()
+}
+
+// onEvent transmet un Event a la connexion du compte. Appele avec gw.mu
+// tenu.
+func (s *OUCHServer) onEvent(e Event) {
+	if e.ClOrdID == "" || e.OrderID == 0 {
+		return
+	}
+	s.mu.Lock()
+	c := s.conns[e.Account]
+	s.mu.Unlock()
+	if c != nil {
+		c.report(e)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// ouchConn — une connexion authentifiee
+// ---------------------------------------------------------------------------
+
+// ouchOrder suit un ordre de la connexion pour calculer les quantites
+// restantes et les decrements.
+type ouchOrder struct {
+	qty int64
+	cum int64
+}
+
+type ouchConn struct {
+	srv      *OUCHServer
+	nc       net.Conn
+	account  string
+	out      chan []byte // Frames encodees
+	done     chan struct{}
+	killOnce sync.Once
+
+	mu       sync.Mutex
+	orders   map[uint64]*ouchOrder
+	cancels  map[string]bool   // Tokens en cours d'annulation ou de reduction
+	replaces map[string]string // Nouveau token -> token remplace
+}
+
+func newOUCHConn(s *OUCHServer, nc net.Conn, account string) *ouchConn {
+	return &ouchConn{
+		srv:      s,
+		nc:       nc,
+		account:  account,
+		out:      make(chan []byte, s.opts.SendQueue),
+		done:     make(chan struct{}),
+		orders:   make(map[uint64]*ouchOrder),
+		cancels:  make(map[string]bool),
+		replaces: make(map[string]string),
+	}
+}
+
+// enqueue met une frame en file sans bloquer. File pleine : le client ne
+// lit plus, la connexion est coupee.
+func (c *ouchConn) enqueue(frame []byte) {
+	select {
+	case c.out <- frame:
+	case <-c.done:
+	default:
+		c.srv.logf("%s: file d'emission pleine, deconnexion", c.account)
+		c.kill()
+	}
+}
+
+// kill ferme la connexion immediatement.
+func (c *ouchConn) kill() {
+	c.killOnce.Do(func() {
+		close(c.done)
+		c.nc.Close()
+	})
+}
+
+// writeLoop ecrit les frames en file, par rafales, et les heartbeats.
+func (c *ouchConn) writeLoop() {
+	hb := c.srv.opts.Heartbeat
+	bw := bufio.NewWriter(c.nc)
+	tick := time.NewTicker(max(hb/4, time.Millisecond))
+	defer tick.Stop()
+	lastSent := time.Now()
+
+	flush := func() bool {
+		c.nc.SetWriteDeadline(time.Now().Add(c.srv.opts.IdleTimeout))
+		if err := bw.Flush(); err != nil {
+			c.kill()
+			return false
+		}
+		lastSent = time.Now()
+		return true
+	}
+	for {
+		select {
+		case frame := <-c.out:
+			bw.Write(frame)
+			for more := true; more; {
+				select {
+				case frame := <-c.out:
+					bw.Write(frame)
+				default:
+					more = false
+				}
+			}
+			if !flush() {
+				return
+			}
+		case <-tick.C:
+			if time.Since(lastSent) >= hb {
+				bw.Write(appendFrame(nil, ouchHeartbeat, nil))
+				if !flush() {
+					return
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readLoop traite les frames du client jusqu'a la coupure ou une erreur de
+// protocole.
+func (c *ouchConn) readLoop(br *bufio.Reader) {
+	for {
+		c.nc.SetReadDeadline(time.Now().Add(c.srv.opts.IdleTimeout))
+		f, err := DecodeFrame(br)
+		if err != nil {
+			return
+		}
+		switch f.Type {
+		case ouchHeartbeat:
+		case ouchEnterOrder:
+			m, err := decodeOUCHEnterOrder(f.Payload)
+			if err != nil {
+				c.srv.logf("%s: %v", c.account, err)
+				return
+			}
+			c.enterOrder(m)
+		case ouchReplaceOrder:
+			m, err := decodeOUCHReplaceOrder(f.Payload)
+			if err != nil {
+				c.srv.logf("%s: %v", c.account, err)
+				return
+			}
+			c.replaceOrder(m)
+		case ouchCancelOrder:
+			m, err := decodeOUCHCancelOrder(f.Payload)
+			if err != nil {
+				c.srv.logf("%s: %v", c.account, err)
+				return
+			}
+			c.cancelOrder(m)
+		default:
+			c.srv.logf("%s: type de message inconnu %q", c.account, f.Type)
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Application : ordres entrants (appeles sans c.mu)
+// ---------------------------------------------------------------------------
+
+func (c *ouchConn) enterOrder(m OUCHEnterOrder) {
+	if m.Token == "" {
+		c.reject(m.Token, &ValidationError{Field: "cl_ord_id", Message: "token requis"})
+		return
+	}
+	o := &Order{
+		Account:  c.account,
+		ClOrdID:  m.Token,
+		Symbol:   m.Symbol,
+		Side:     m.Side,
+		Status:   StatusOpen,
+		Quantity: m.Quantity,
+	}
+	switch {
+	case m.TimeInForce != OUCHDay && m.TimeInForce != OUCHIOC:
+		c.reject(m.Token, &ValidationError{Field: "time_in_force", Message: fmt.Sprintf("%q non supporte", m.TimeInForce)})
+		return
+	case m.OrdType == OUCHMarketOrder:
+		o.Type = Market
+	case m.OrdType == OUCHLimitOrder && m.TimeInForce == OUCHIOC:
+		o.Type, o.Price = IOC, m.Price
+	case m.OrdType == OUCHLimitOrder:
+		o.Type, o.Price = Limit, m.Price
+	default:
+		c.reject(m.Token, &ValidationError{Field: "type", Message: fmt.Sprintf("%q non supporte", m.OrdType)})
+		return
+	}
+	if _, err := c.srv.gw.Submit(o); err != nil {
+		c.reject(m.Token, err)
+	}
+}
+
+func (c *ouchConn) cancelOrder(m OUCHCancelOrder) {
+	c.mu.Lock()
+	c.cancels[m.Token] = true
+	c.mu.Unlock()
+
+	var err error
+	if m.Quantity == 0 {
+		err = c.srv.gw.CancelByClOrdID(c.account, m.Token)
+	} else {
+		err = c.reduce(m.Token, m.Quantity)
+	}
+
+	c.mu.Lock()
+	delete(c.cancels, m.Token)
+	c.mu.Unlock()
+	if err != nil {
+		c.reject(m.Token, err)
+	}
+}
+
+// reduce ramene la quantite totale d'un ordre a qty.
+func (c *ouchConn) reduce(token string, qty int64) error {
+	st, ok := c.srv.gw.OrderByClOrdID(c.account, token)
+	if !ok || !st.Order.IsActive() {
+		return fmt.Errorf("%w: token %q", ErrOrderNotFound, token)
+	}
+	if qty >= st.Order.Quantity {
+		return &ValidationError{Field: "quantity", Message: fmt.Sprintf("une annulation reduit la quantite (%d), recu: %d", st.Order.Quantity, qty)}
+	}
+	_, err := c.srv.gw.Amend(st.Order.Symbol, st.Order.ID, 0, qty)
+	return err
+}
+
+func (c *ouchConn) replaceOrder(m OUCHReplaceOrder) {
+	c.mu.Lock()
+	c.replaces[m.NewToken] = m.ExistingToken
+	c.mu.Unlock()
+
+	_, err := c.srv.gw.ReplaceByClOrdID(c.account, m.ExistingToken, m.NewToken, m.Price, m.Quantity)
+
+	c.mu.Lock()
+	delete(c.replaces, m.NewToken)
+	c.mu.Unlock()
+	if err != nil {
+		c.reject(m.NewToken, err)
+	}
+}
+
+// reject envoie un Rejected pour token, motif tire de err.
+func (c *ouchConn) reject(token string, err error) {
+	c.srv.logf("%s: %q refuse: %v", c.account, token, err)
+	m := OUCHRejected{Timestamp: c.srv.gw.Clock().Now(), Token: token, Reason: ouchRejectReason(err)}
+	c.enqueue(appendFrame(nil, ouchRejected, m.encode()))
+}
+
+// ouchRejectReason traduit une erreur du Gateway en motif de Rejected.
+func ouchRejectReason(err error) byte {
+	var ve *ValidationError
+	switch {
+	case errors.Is(err, ErrKillSwitch):
+		return OUCHRejectKillSwitch
+	case errors.Is(err, ErrOrderNotFound):
+		return OUCHRejectTooLate
+	case errors.As(err, &ve):
+		switch ve.Field {
+		case "symbol":
+			return OUCHRejectSymbol
+		case "price", "quantity", "stop_price":
+			return OUCHRejectPriceQty
+		case "cl_ord_id":
+			return OUCHRejectToken
+		}
+	}
+	return OUCHRejectOther
+}
+
+// ---------------------------------------------------------------------------
+// Application : messages sortants
+// ---------------------------------------------------------------------------
+
+// report traduit un Event en message sortant. Appele avec gw.mu tenu.
+func (c *ouchConn) report(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	o := c.orders[e.OrderID]
+	if o == nil && e.Type != EventAccepted {
+		return
+	}
+	var typ uint8
+	var payload []byte
+	switch e.Type {
+	case EventAccepted:
+		c.orders[e.OrderID] = &ouchOrder{qty: e.Quantity}
+		tif, ordType := ouchOrdTypeOut(e.OrderType)
+		m := OUCHAccepted{
+			Timestamp:   e.Timestamp,
+			Token:       e.ClOrdID,
+			Side:        e.Side,
+			Quantity:    e.Quantity,
+			Symbol:      e.Symbol,
+			Price:       e.Price,
+			TimeInForce: tif,
+			OrdType:     ordType,
+			OrderRef:    e.OrderID,
+		}
+		typ, payload = ouchAccepted, m.encode()
+	case EventFilled:
+		o.cum += e.Quantity
+		if o.cum >= o.qty {
+			delete(c.orders, e.OrderID)
+		}
+		m := OUCHExecuted{Timestamp: e.Timestamp, Token: e.ClOrdID, Quantity: e.Quantity, Price: e.Price, MatchNumber: e.TradeID}
+		typ, payload = ouchExecuted, m.encode()
+	case EventCancelled:
+		delete(c.orders, e.OrderID)
+		reason := OUCHCancelSupervisory
+		switch {
+		case c.cancels[e.ClOrdID]:
+			reason = OUCHCancelUser
+		case strings.HasSuffix(e.Reason, "reliquat non execute"):
+			reason = OUCHCancelIOC
+		}
+		m := OUCHCanceled{Timestamp: e.Timestamp, Token: e.ClOrdID, Decrement: o.qty - o.cum, Reason: reason}
+		typ, payload = ouchCanceled, m.encode()
+	case EventAmended:
+		prev, replaced := c.replaces[e.ClOrdID]
+		if !replaced && c.cancels[e.ClOrdID] && e.Quantity < o.qty {
+			m := OUCHCanceled{Timestamp: e.Timestamp, Token: e.ClOrdID, Decrement: o.qty - e.Quantity, Reason: OUCHCancelUser}
+			typ, payload = ouchCanceled, m.encode()
+		} else {
+			if !replaced {
+				prev = e.ClOrdID // Redimensionnement par le moteur
+			}
+			m := OUCHReplaced{
+				Timestamp:     e.Timestamp,
+				Token:         e.ClOrdID,
+				Side:          e.Side,
+				Quantity:      e.Quantity - o.cum,
+				Symbol:        e.Symbol,
+				Price:         e.Price,
+				OrderRef:      e.OrderID,
+				PreviousToken: prev,
+			}
+			typ, payload = ouchReplaced, m.encode()
+		}
+		o.qty = e.Quantity
+	default:
+		return
+	}
+	c.enqueue(appendFrame(make([]byte, 0, 5+len(payload)), typ, payload))
+}
+
+// ouchOrdTypeOut retourne TimeInForce et OrdType d'un type d'ordre.
+func ouchOrdTypeOut(t OrderType) (byte, byte) {
+	switch t {
+	case Market:
+		return OUCHIOC, OUCHMarketOrder
+	case IOC:
+		return OUCHIOC, OUCHLimitOrder
+	case Stop:
+		return OUCHDay, OUCHStopOrder
+	}
+	return OUCHDay, OUCHLimitOrder
+}
+
+// ---------------------------------------------------------------------------
+// Sous-commande ouch
+// ---------------------------------------------------------------------------
+
+// runOUCH demarre le serveur binaire sur un Gateway neuf, jusqu'a Ctrl+C.
+func runOUCH(args []string) error {
+	fs := flag.NewFlagSet("ouch", flag.ContinueOnError)
+	addr := fs.String("addr", ":9001", "adresse d'ecoute")
+	symbols := fs.String("symbols", "AAPL,MSFT,TSLA", "symboles routes, separes par des virgules (SYM:DEVISE, defaut USD)")
+	tokens := fs.String("tokens", "", "token=compte, separes par des virgules")
+	itchFeed := fs.String("itch", "", "adresse UDP du flux ITCH (multicast ou unicast, vide : pas de flux)")
+	itchRetrans := fs.String("itch-retrans", "", "adresse TCP de retransmission ITCH")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	accounts, err := parseTokens(*tokens)
+	if err != nil {
+		return fmt.Errorf("ouch: %w", err)
+	}
+
+	instruments, err := ParseInstruments(*symbols)
+	if err != nil {
+		return fmt.Errorf("ouch: %w", err)
+	}
+	gw := NewGatewayWithInstruments(instruments, NewTradeLog(), SystemClock{})
+	if pub, err := startITCH(gw, *itchFeed, *itchRetrans, os.Stdout); err != nil {
+		return err
+	} else if pub != nil {
+		defer pub.Close()
+	}
+	srv := NewOUCHServer(gw, OUCHOptions{Tokens: accounts, Log: os.Stdout})
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	fmt.Printf("GME OUCH sur %s (%d comptes, Ctrl+C pour arreter)\n", *addr, len(accounts))
+	if err := srv.Serve(l); err != nil {
+		return err
+	}
+	return srv.Close()
+}
@@ -0,0 +1,143 @@
+// pool.go — Ordres recycles et buffers de trades fournis par l'appelant.
+//
+// Sur le chemin chaud, deux allocations par ordre : l'Order lui-meme et le
+// []Trade du matching. OrderPool recycle les premiers ; SubmitAppend (book
+// et Gateway) ajoute les trades a un buffer de l'appelant, comme
+// strconv.AppendInt : avec trades[:0] reutilise, le book n'alloue plus rien.
+//
+// REGLES DE RECYCLAGE — un Order vit plus longtemps que l'appel qui l'a
+// soumis : la lazy deletion le laisse dans son heap apres annulation, le
+// Gateway l'indexe (Order, historique). Le remettre en circulation trop tot
+// corrompt le carnet sans bruit. Donc :
+//
+//   - OrderPool.Get donne un ordre remis a zero ; l'appelant le remplit.
+//   - Seul un ordre en statut terminal (FILLED, CANCELLED, REJECTED) est
+//     rendu, une fois ce statut publie : trades retournes par le book, ou
+//     evenement emis par le Gateway. OrderBook.Release pour un book utilise
+//     seul, Gateway.Release sinon (qui oublie aussi l'ordre).
+//   - Encore dans un heap (annule, pas encore atteint par la lazy deletion),
+//     l'ordre ne rejoint le pool qu'a sa sortie du heap.
+//   - Apres Release, l'appelant ne touche plus l'ordre.
+//
+// SEUL LE BOOK EST SANS ALLOCATION. Le Gateway alloue encore par ordre :
+// entrees de ses index (orders, history), historique et evenements, soit
+// environ 6 allocations par aller-retour (BenchmarkGatewaySubmitPooled).
+// Pool et buffer n'y retirent que l'Order et le []Trade.
+//
+// Mesure : go test ./phase2-order-engine/ -run '^$' -bench Pooled -benchmem
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// ErrOrderLive est retourne (wrappe) par Release pour un ordre qui peut
+// encore evoluer.
+var ErrOrderLive = errors.New("ordre encore vivant")
+
+// OrderPool recycle des Orders. La valeur zero est prete a l'emploi.
+type OrderPool struct {
+	p sync.Pool
+}
+
+// Get retourne un ordre remis a zero.
+func (p *OrderPool) Get() *Order {
+	o, _ := p.p.Get().(*Order)
+	if o == nil {
+		o = &Order{}
+	}
+	o.pool = p
+	return o
+}
+
+// put remet o a zero et le rend au pool.
+func (p *OrderPool) put(o *Order) {
+	o.Reset()
+	p.p.Put(o)
+}
+
+// release rend au pool un ordre termine, tout de suite ou a sa sortie du
+// heap. Un ordre hors pool est seulement verifie. Appele avec le verrou du
+// book de l'ordre tenu.
+func release(o *Order) error {
+	if err := checkTerminal(o); err != nil {
+		return err
+	}
+	switch {
+	case o.pool == nil:
+	case o.inBook:
+		o.released = true // Voir leaveBook
+	default:
+		o.pool.put(o)
+	}
+	return nil
+}
+
+// checkTerminal verifie que o ne peut plus evoluer.
+func checkTerminal(o *Order) error {
+	switch o.Status {
+	case StatusFilled, StatusCancelled, StatusRejected:
+		return nil
+	}
+	return fmt.Errorf("%w: #%d %s", ErrOrderLive, o.ID, o.Status)
+}
+
+// leaveBook est appele par les heaps quand o en sort : un Release differe
+// s'acheve ici.
+func (o *Order) leaveBook() {
+	o.inBook = false
+	if o.released && o.pool != nil {
+		o.pool.put(o)
+	}
+}
+
+// Release rend au pool un ordre termine de ce book (book utilise seul).
+func (ob *OrderBook) Release(o *Order) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return release(o)
+}
+
+// Release oublie un ordre termine (Order, OrderByClOrdID et son historique
+// ne le connaissent plus) et le rend a son pool. Son ClOrdID reste reserve
+// pour la journee ; le compte d'un ordre execute reste connu du clearing. Son statut terminal est
+// deja publie : les evenements partent sous gw.mu, que Release prend.
+// A appeler hors d'un EventHandler (deadlock), par exemple apres Submit pour
+// les ordres dont l'appelant a vu le dernier evenement.
+// Les ordres lies restent au Gateway : Group les expose.
+func (gw *Gateway) Release(id uint64) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	o, ok := gw.orders[id]
+	if !ok {
+		return fmt.Errorf("%w: #%d", ErrOrderNotFound, id)
+	}
+	if _, linked := gw.legOf[id]; linked {
+		return fmt.Errorf("%w: #%d lie a un groupe", ErrOrderLive, id)
+	}
+	if err := checkTerminal(o); err != nil {
+		return err
+	}
+	delete(gw.orders, id)
+	delete(gw.history, id)
+	if o.Filled > 0 {
+		r := gw.retired[o.Symbol] // Pour la conservation (invariants.go)
+		r[sideIndex(o.Side)] += o.Filled
+		gw.retired[o.Symbol] = r
+		gw.owners[id] = o.Account // Pour Clear, ses trades restent au TradeLog
+	}
+	if stops := gw.stops[o.Symbol]; len(stops) > 0 {
+		// Stop annule en attente : encore dans la file (lazy deletion).
+		gw.stops[o.Symbol] = slices.DeleteFunc(stops, func(x *Order) bool { return x == o })
+	}
+	if ob, ok := gw.books[o.Symbol]; ok { // Sinon rejete : symbole inconnu
+		ob.mu.Lock()
+		defer ob.mu.Unlock()
+	}
+	return release(o)
+}
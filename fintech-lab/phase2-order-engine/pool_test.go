@@ -0,0 +1,281 @@
+// pool_test.go — Tests du recyclage des ordres et des buffers de trades.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Pool|Release|Append|Alloc' -v
+// Mesure     : go test ./phase2-order-engine/ -run '^$' -bench Pooled -benchmem
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// limitFrom remplit un ordre du pool comme NewLimitOrder.
+func limitFrom(p *OrderPool, side Side, price float64, qty int64) *Order {
+	o := p.Get()
+	o.Symbol, o.Side, o.Type, o.Status = "AAPL", side, Limit, StatusOpen
+	o.Price, o.Quantity = price, qty
+	return o
+}
+
+// TestOrderPoolRelease verifie les regles de recyclage sur un book seul :
+// pas d'ordre vivant, et un ordre annule encore dans son heap n'est
+// recycle qu'a sa sortie.
+func TestOrderPoolRelease(t *testing.T) {
+	var pool OrderPool
+	ob := NewOrderBook("AAPL")
+	best := limitFrom(&pool, Sell, 100, 10)
+	buried := limitFrom(&pool, Sell, 101, 10)
+	ob.Submit(best)
+	ob.Submit(buried)
+
+	if err := ob.Release(buried); !errors.Is(err, ErrOrderLive) {
+		t.Errorf("Release d'un ordre actif: %v", err)
+	}
+	ob.Cancel(buried.ID)
+	if err := ob.Release(buried); err != nil {
+		t.Fatal(err)
+	}
+	if buried.Status != StatusCancelled || !buried.inBook {
+		t.Fatalf("ordre enterre recycle trop tot: %+v", buried)
+	}
+
+	// L'achat remplit best : buried arrive au sommet, la purge le sort du
+	// heap, il est alors remis a zero et rendu.
+	buy := limitFrom(&pool, Buy, 102, 10)
+	if trades := ob.Submit(buy); len(trades) != 1 {
+		t.Fatalf("trades: %v", trades)
+	}
+	if buried.Status != "" || buried.inBook {
+		t.Errorf("ordre enterre non recycle a sa sortie du heap: %+v", buried)
+	}
+	for _, o := range []*Order{best, buy} {
+		if err := ob.Release(o); err != nil || o.Status != "" {
+			t.Errorf("Release d'un ordre FILLED: %v, %+v", err, o)
+		}
+	}
+	if err := ob.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestGatewayRelease verifie que le Gateway oublie un ordre rendu sans
+// casser la conservation des quantites.
+func TestGatewayRelease(t *testing.T) {
+	gw, _ := newTestGateway()
+	gw.SetDebug(true)
+	var pool OrderPool
+	sell := limitFrom(&pool, Sell, 100, 50)
+	buy := limitFrom(&pool, Buy, 100, 50)
+	mustSubmit(t, gw, sell)
+	if err := gw.Release(sell.ID); !errors.Is(err, ErrOrderLive) {
+		t.Errorf("Release d'un ordre au carnet: %v", err)
+	}
+	mustSubmit(t, gw, buy)
+	sellID, buyID := sell.ID, buy.ID
+	for _, id := range []uint64{sellID, buyID} {
+		if err := gw.Release(id); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := gw.Order(id); ok {
+			t.Errorf("ordre #%d encore connu apres Release", id)
+		}
+	}
+	if err := gw.Release(buyID); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("second Release: %v", err)
+	}
+
+	stop := NewStopOrder("AAPL", Sell, 90, 10)
+	mustSubmit(t, gw, stop)
+	gw.Cancel("AAPL", stop.ID)
+	if err := gw.Release(stop.ID); err != nil || len(gw.stops["AAPL"]) != 0 {
+		t.Errorf("stop annule: %v, file %v", err, gw.stops["AAPL"])
+	}
+
+	tp, sl := NewLimitOrder("AAPL", Sell, 110, 10), NewStopOrder("AAPL", Sell, 90, 10)
+	gw.SubmitOCO(tp, sl)
+	gw.Cancel("AAPL", tp.ID)
+	if err := gw.Release(tp.ID); !errors.Is(err, ErrOrderLive) {
+		t.Errorf("Release d'une jambe OCO: %v", err)
+	}
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 100, 10)) // Controle debug apres les Release
+	if err := gw.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestGatewayReleaseKeepsClOrdAndAccount verifie qu'un ordre libere reste
+// introuvable par son ClOrdID, toujours reserve, et que ses trades sont
+// nettes sous son compte.
+func TestGatewayReleaseKeepsClOrdAndAccount(t *testing.T) {
+	gw, _ := newTestGateway()
+	gw.SetDebug(true)
+
+	resting := newClientOrder("ACC1", "c-1", Buy, 180, 10)
+	mustSubmit(t, gw, resting)
+	gw.Cancel("AAPL", resting.ID)
+	if err := gw.Release(resting.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gw.ReplaceByClOrdID("ACC1", "c-1", "c-2", 181, 10); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("ReplaceByClOrdID d'un ordre libere: %v", err)
+	}
+	if err := gw.CancelByClOrdID("ACC1", "c-1"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("CancelByClOrdID d'un ordre libere: %v", err)
+	}
+	if _, ok := gw.OrderByClOrdID("ACC1", "c-1"); ok {
+		t.Error("OrderByClOrdID connait encore l'ordre libere")
+	}
+	if _, err := gw.Submit(newClientOrder("ACC1", "c-1", Buy, 180, 10)); err == nil {
+		t.Error("ClOrdID d'un ordre libere reutilise")
+	}
+
+	sell := newClientOrder("ACC1", "s-1", Sell, 100, 50)
+	buy := newClientOrder("ACC2", "b-1", Buy, 100, 50)
+	mustSubmit(t, gw, sell)
+	mustSubmit(t, gw, buy)
+	for _, id := range []uint64{sell.ID, buy.ID} {
+		if err := gw.Release(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := gw.Clear(FeeSchedule{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"ACC1": -50, "ACC2": 50}
+	for _, ob := range report.Obligations {
+		if ob.NetQty != want[ob.Account] {
+			t.Errorf("obligation %s: net %d, attendu %d", ob.Account, ob.NetQty, want[ob.Account])
+		}
+		delete(want, ob.Account)
+	}
+	if len(want) != 0 {
+		t.Errorf("comptes absents du clearing: %v (%+v)", want, report.Obligations)
+	}
+}
+
+// TestSubmitAppend verifie que les trades sont ajoutes au buffer fourni,
+// sans toucher a son contenu ni reallouer s'il a la place.
+func TestSubmitAppend(t *testing.T) {
+	gw, _ := newTestGateway()
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 100, 10))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 101, 10))
+
+	buf := make([]Trade, 1, 8)
+	buf[0].ID = 42
+	out, err := gw.SubmitAppend(buf, NewLimitOrder("AAPL", Buy, 101, 20))
+	if err != nil || len(out) != 3 || out[0].ID != 42 || out[1].Price != 100 || out[2].Price != 101 || &out[0] != &buf[0] {
+		t.Errorf("SubmitAppend: %v, %v", out, err)
+	}
+	out, err = gw.SubmitAppend(out[:0], &Order{Symbol: "AAPL", Side: Buy, Type: Limit})
+	if err == nil || len(out) != 0 {
+		t.Errorf("refus: %v, %v", out, err)
+	}
+}
+
+// TestBookZeroAllocs verifie que les chemins repos et match du book
+// n'allouent pas, buffer de trades et ordres fournis par l'appelant.
+func TestBookZeroAllocs(t *testing.T) {
+	ob := NewOrderBook("AAPL")
+	for i := 0; i < 100; i++ {
+		ob.Submit(NewLimitOrder("AAPL", Sell, 190+float64(i)*0.01, 100))
+		ob.Submit(NewLimitOrder("AAPL", Buy, 180-float64(i)*0.01, 100))
+	}
+	trades := make([]Trade, 0, 4)
+	resting, buy, sell := &Order{}, &Order{}, &Order{}
+	reset := func(o *Order, side Side, price float64) *Order {
+		o.Reset()
+		o.Symbol, o.Side, o.Type, o.Status, o.Price, o.Quantity = "AAPL", side, Limit, StatusOpen, price, 100
+		return o
+	}
+
+	if n := testing.AllocsPerRun(1000, func() {
+		trades = ob.SubmitAppend(trades[:0], reset(resting, Buy, 185))
+		ob.Cancel(resting.ID)
+	}); n != 0 {
+		t.Errorf("repos + annulation: %v allocations", n)
+	}
+	if n := testing.AllocsPerRun(1000, func() {
+		trades = ob.SubmitAppend(trades[:0], reset(sell, Sell, 189))
+		trades = ob.SubmitAppend(trades[:0], reset(buy, Buy, 200))
+	}); n != 0 || len(trades) != 1 {
+		t.Errorf("match: %v allocations, %d trades", n, len(trades))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Benchmarks
+// ---------------------------------------------------------------------------
+
+// BenchmarkBookRestingPooled pose un ordre du pool au sommet d'un carnet de
+// 2000 ordres, l'annule et le rend.
+func BenchmarkBookRestingPooled(b *testing.B) {
+	ob := NewOrderBook("AAPL")
+	for i := 0; i < 1000; i++ {
+		ob.Submit(NewLimitOrder("AAPL", Sell, 190+float64(i)*0.01, 100))
+		ob.Submit(NewLimitOrder("AAPL", Buy, 180-float64(i)*0.01, 100))
+	}
+	var pool OrderPool
+	trades := make([]Trade, 0, 16)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := limitFrom(&pool, Buy, 185, 100)
+		trades = ob.SubmitAppend(trades[:0], o)
+		ob.Cancel(o.ID)
+		if err := ob.Release(o); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBookMatchPooled remplit le meilleur ask par un achat agressif,
+// rend les deux ordres et repose un vendeur : un trade par iteration.
+func BenchmarkBookMatchPooled(b *testing.B) {
+	ob := NewOrderBook("AAPL")
+	var pool OrderPool
+	best := limitFrom(&pool, Sell, 190, 100)
+	ob.Submit(best)
+	for i := 1; i < 1000; i++ {
+		ob.Submit(NewLimitOrder("AAPL", Sell, 190+float64(i)*0.01, 100))
+	}
+	trades := make([]Trade, 0, 16)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buy := limitFrom(&pool, Buy, 200, 100)
+		trades = ob.SubmitAppend(trades[:0], buy)
+		if len(trades) != 1 {
+			b.Fatalf("%d trades", len(trades))
+		}
+		ob.Release(buy)
+		ob.Release(best)
+		best = limitFrom(&pool, Sell, 190, 100)
+		trades = ob.SubmitAppend(trades[:0], best)
+	}
+}
+
+// BenchmarkGatewaySubmitPooled fait le meme aller-retour a travers le
+// Gateway. Il n'est PAS sans allocation : index, historique et evenements
+// allouent encore (~6 allocs/op), seul le book l'est (TestBookZeroAllocs).
+func BenchmarkGatewaySubmitPooled(b *testing.B) {
+	gw, _ := newTestGateway()
+	var pool OrderPool
+	best := limitFrom(&pool, Sell, 190, 100)
+	gw.Submit(best)
+	trades := make([]Trade, 0, 16)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buy := limitFrom(&pool, Buy, 200, 100)
+		trades, _ = gw.SubmitAppend(trades[:0], buy)
+		gw.Release(buy.ID)
+		gw.Release(best.ID)
+		best = limitFrom(&pool, Sell, 190, 100)
+		trades, _ = gw.SubmitAppend(trades[:0], best)
+	}
+}
@@ -0,0 +1,520 @@
+// replay.go — Rejeu d'un fichier d'ordres historique dans un Gateway neuf.
+//
+// Sert aux tests de non-regression du matching : on rejoue un flux capture
+// et on compare les trades et les carnets finaux d'une version a l'autre.
+// Le Gateway est pilote par une SimClock calee sur l'horodatage de chaque
+// ligne : IDs, timestamps et trades sont reproductibles, quelle que soit
+// la vitesse de rejeu.
+//
+// FORMAT : CSV avec en-tete, ou JSONL (extension .jsonl ou .json), memes
+// noms de champs :
+//
+//	ts              Unix nanosecondes ou RFC 3339 (obligatoire, croissant)
+//	action          NEW (defaut), CANCEL ou REPLACE
+//	account         compte (defaut : REPLAY)
+//	cl_ord_id       NEW : ID client. REPLACE : nouveau ClOrdID
+//	orig_cl_ord_id  CANCEL, REPLACE : ordre vise (CANCEL : defaut cl_ord_id)
+//	symbol, side, type (LIMIT par defaut), price, stop_price, quantity
+//
+//	ts,action,account,cl_ord_id,orig_cl_ord_id,symbol,side,type,price,stop_price,quantity
+//	1760000000000000000,NEW,ACC1,a-1,,AAPL,BUY,LIMIT,189.5,,100
+//	1760000000250000000,CANCEL,ACC1,,a-1,,,,,,
+//
+// VITESSE : -speed 0 rejoue aussi vite que possible, 1 en temps reel, N a
+// N fois la vitesse reelle (les ecarts entre lignes sont divises par N).
+//
+// Lancer : go run ./phase2-order-engine/ replay -in flux.csv -speed 0 -trades trades.csv -books books.json
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplayAction est l'operation d'une ligne.
+type ReplayAction string
+
+const (
+	ReplayNew     ReplayAction = "NEW"
+	ReplayCancel  ReplayAction = "CANCEL"
+	ReplayReplace ReplayAction = "REPLACE"
+)
+
+// defaultReplayAccount est le compte des lignes sans account.
+const defaultReplayAccount = "REPLAY"
+
+// ReplayRecord est une ligne du fichier.
+type ReplayRecord struct {
+	Line        int   // Numero de ligne dans le fichier
+	TS          int64 // Unix nanoseconds
+	Action      ReplayAction
+	Account     string
+	ClOrdID     string
+	OrigClOrdID string
+	Symbol      string
+	Side        Side
+	Type        OrderType
+	Price       float64
+	StopPrice   float64
+	Quantity    int64
+}
+
+// ---------------------------------------------------------------------------
+// Lecture
+// ---------------------------------------------------------------------------
+
+// replayFields sont les colonnes reconnues.
+var replayFields = []string{
+	"ts", "action", "account", "cl_ord_id", "orig_cl_ord_id",
+	"symbol", "side", "type", "price", "stop_price", "quantity",
+}
+
+// ReadReplayFile lit un fichier CSV ou JSONL selon son extension.
+func ReadReplayFile(path string) ([]ReplayRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []ReplayRecord
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".json":
+		records, err = ReadReplayJSONL(f)
+	default:
+		records, err = ReadReplayCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return records, nil
+}
+
+// ReadReplayCSV lit un fichier CSV avec en-tete. Les colonnes peuvent etre
+// dans n'importe quel ordre ; seule ts est obligatoire.
+func ReadReplayCSV(r io.Reader) ([]ReplayRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("en-tete: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if !slices.Contains(replayFields, name) {
+			return nil, fmt.Errorf("en-tete: colonne inconnue %q", name)
+		}
+		cols[name] = i
+	}
+	if _, ok := cols["ts"]; !ok {
+		return nil, fmt.Errorf("en-tete: colonne ts obligatoire")
+	}
+
+	var records []ReplayRecord
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		fields := make(map[string]string, len(cols))
+		for name, i := range cols {
+			if i < len(row) {
+				fields[name] = strings.TrimSpace(row[i])
+			}
+		}
+		rec, err := parseReplayFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("ligne %d: %w", line, err)
+		}
+		rec.Line = line
+		if records, err = appendReplay(records, rec); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// ReadReplayJSONL lit un objet JSON par ligne. ts peut etre un nombre ou
+// une chaine.
+func ReadReplayJSONL(r io.Reader) ([]ReplayRecord, error) {
+	var records []ReplayRecord
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var raw map[string]any
+		dec := json.NewDecoder(strings.NewReader(sc.Text()))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("ligne %d: %w", line, err)
+		}
+		fields := make(map[string]string, len(raw))
+		for name, v := range raw {
+			if !slices.Contains(replayFields, name) {
+				return nil, fmt.Errorf("ligne %d: champ inconnu %q", line, name)
+			}
+			if v != nil {
+				fields[name] = fmt.Sprint(v)
+			}
+		}
+		rec, err := parseReplayFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("ligne %d: %w", line, err)
+		}
+		rec.Line = line
+		if records, err = appendReplay(records, rec); err != nil {
+			return nil, err
+		}
+	}
+	return records, sc.Err()
+}
+
+// appendReplay ajoute rec en verifiant que les horodatages ne reculent pas.
+func appendReplay(records []ReplayRecord, rec ReplayRecord) ([]ReplayRecord, error) {
+	if n := len(records); n > 0 && rec.TS < records[n-1].TS {
+		return nil, fmt.Errorf("ligne %d: horodatage anterieur a la ligne %d", rec.Line, records[n-1].Line)
+	}
+	return append(records, rec), nil
+}
+
+// parseReplayFields convertit les champs d'une ligne (chaines, vides
+// absents) et valide ce qui ne depend pas du Gateway.
+func parseReplayFields(f map[string]string) (ReplayRecord, error) {
+	rec := ReplayRecord{
+		Action:      ReplayAction(strings.ToUpper(f["action"])),
+		Account:     f["account"],
+		ClOrdID:     f["cl_ord_id"],
+		OrigClOrdID: f["orig_cl_ord_id"],
+		Symbol:      f["symbol"],
+		Side:        Side(strings.ToUpper(f["side"])),
+		Type:        OrderType(strings.ToUpper(f["type"])),
+	}
+	var err error
+	if rec.TS, err = parseReplayTime(f["ts"]); err != nil {
+		return rec, err
+	}
+	if rec.Action == "" {
+		rec.Action = ReplayNew
+	}
+	if rec.Account == "" {
+		rec.Account = defaultReplayAccount
+	}
+	if rec.Type == "" {
+		rec.Type = Limit
+	}
+	for name, dst := range map[string]*float64{"price": &rec.Price, "stop_price": &rec.StopPrice} {
+		if v := f[name]; v != "" {
+			if *dst, err = strconv.ParseFloat(v, 64); err != nil {
+				return rec, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	if v := f["quantity"]; v != "" {
+		if rec.Quantity, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return rec, fmt.Errorf("quantity: %w", err)
+		}
+	}
+
+	switch rec.Action {
+	case ReplayNew:
+		if rec.Symbol == "" {
+			return rec, fmt.Errorf("NEW sans symbol")
+		}
+	case ReplayCancel:
+		if rec.OrigClOrdID == "" {
+			rec.OrigClOrdID = rec.ClOrdID
+		}
+		if rec.OrigClOrdID == "" {
+			return rec, fmt.Errorf("CANCEL sans orig_cl_ord_id")
+		}
+	case ReplayReplace:
+		if rec.OrigClOrdID == "" || rec.ClOrdID == "" {
+			return rec, fmt.Errorf("REPLACE sans orig_cl_ord_id ou cl_ord_id")
+		}
+	default:
+		return rec, fmt.Errorf("action inconnue %q", rec.Action)
+	}
+	return rec, nil
+}
+
+// parseReplayTime lit un horodatage : entier (Unix nanosecondes) ou
+// RFC 3339.
+func parseReplayTime(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("ts manquant")
+	}
+	if ns, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ns, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("ts %q: ni Unix nanosecondes ni RFC 3339", s)
+	}
+	return t.UnixNano(), nil
+}
+
+// ---------------------------------------------------------------------------
+// Rejeu
+// ---------------------------------------------------------------------------
+
+// ReplayOptions regle le rejeu. Les zeros prennent les defauts.
+type ReplayOptions struct {
+	Speed   float64  // 0 : aussi vite que possible, 1 : temps reel, N : N fois plus vite
+	Symbols []string // Symboles du Gateway (defaut : ceux du fichier)
+}
+
+// ReplayError est un refus du Gateway pendant le rejeu. Il fait partie du
+// resultat (un flux capture contient des rejets) et n'arrete pas le rejeu.
+type ReplayError struct {
+	Line int
+	Err  error
+}
+
+// ReplayStats resume un rejeu.
+type ReplayStats struct {
+	Records  int
+	Orders   int
+	Cancels  int
+	Replaces int
+	Errors   []ReplayError
+	Trades   int
+	Span     time.Duration // Ecart entre premiere et derniere ligne
+	Elapsed  time.Duration // Duree reelle du rejeu
+}
+
+// Replay rejoue records dans un Gateway neuf, dont l'horloge suit les
+// horodatages du fichier. Annule par ctx, il s'arrete a la ligne en cours
+// et retourne le Gateway partiel avec ctx.Err().
+func Replay(ctx context.Context, records []ReplayRecord, opts ReplayOptions) (gw *Gateway, st ReplayStats, err error) {
+	symbols := opts.Symbols
+	if len(symbols) == 0 {
+		symbols = replaySymbols(records)
+	}
+	var first int64
+	if len(records) > 0 {
+		first = records[0].TS
+	}
+	// Pas de 1ns : deux evenements d'une meme ligne ne partagent pas leur
+	// timestamp, et la priorite FIFO reste deterministe.
+	clock := NewSimClock(time.Unix(0, first), time.Nanosecond)
+	log := NewTradeLog()
+	gw = NewGatewayWithClock(symbols, log, clock)
+
+	start := time.Now()
+	defer func() {
+		st.Trades = log.Count()
+		st.Elapsed = time.Since(start)
+	}()
+	for _, r := range records {
+		if opts.Speed > 0 {
+			due := time.Duration(float64(r.TS-first) / opts.Speed)
+			if wait := due - time.Since(start); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return gw, st, ctx.Err()
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return gw, st, err
+		}
+
+		clock.Set(r.TS)
+		st.Records++
+		st.Span = time.Duration(r.TS - first)
+		switch r.Action {
+		case ReplayNew:
+			st.Orders++
+		case ReplayCancel:
+			st.Cancels++
+		case ReplayReplace:
+			st.Replaces++
+		}
+		// Refus du Gateway : resultat du rejeu, pas une erreur
+		if err := applyReplay(gw, r); err != nil {
+			st.Errors = append(st.Errors, ReplayError{Line: r.Line, Err: err})
+		}
+	}
+	return gw, st, nil
+}
+
+// ---------------------------------------------------------------------------
+// Sorties
+// ---------------------------------------------------------------------------
+
+// replaySymbols retourne les symboles cites par records, tries.
+func replaySymbols(records []ReplayRecord) []string {
+	seen := make(map[string]bool)
+	for _, r := range records {
+		if r.Symbol != "" {
+			seen[r.Symbol] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(seen))
+}
+
+// applyReplay soumet une ligne au Gateway. L'horloge doit deja etre calee
+// sur r.TS.
+func applyReplay(gw *Gateway, r ReplayRecord) error {
+	switch r.Action {
+	case ReplayCancel:
+		return gw.CancelByClOrdID(r.Account, r.OrigClOrdID)
+	case ReplayReplace:
+		_, err := gw.ReplaceByClOrdID(r.Account, r.OrigClOrdID, r.ClOrdID, r.Price, r.Quantity)
+		return err
+	default:
+		_, err := gw.Submit(&Order{
+			Account:   r.Account,
+			ClOrdID:   r.ClOrdID,
+			Symbol:    r.Symbol,
+			Side:      r.Side,
+			Type:      r.Type,
+			Status:    StatusOpen,
+			Price:     r.Price,
+			StopPrice: r.StopPrice,
+			Quantity:  r.Quantity,
+		})
+		return err
+	}
+}
+
+// writeReplayTrades ecrit les trades du Gateway en CSV, dans l'ordre
+// d'execution, avec les ClOrdID des deux ordres.
+func writeReplayTrades(w io.Writer, gw *Gateway) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "ts", "symbol", "price", "quantity", "buy_order_id", "buy_cl_ord_id", "sell_order_id", "sell_cl_ord_id"})
+	clOrdID := func(id uint64) string {
+		st, _ := gw.Order(id)
+		return st.Order.ClOrdID
+	}
+	for _, t := range gw.log.Trades() {
+		cw.Write([]string{
+			strconv.FormatUint(t.ID, 10),
+			strconv.FormatInt(t.Timestamp, 10),
+			t.Symbol,
+			strconv.FormatFloat(t.Price, 'f', -1, 64),
+			strconv.FormatInt(t.Quantity, 10),
+			strconv.FormatUint(t.BuyOrderID, 10),
+			clOrdID(t.BuyOrderID),
+			strconv.FormatUint(t.SellOrderID, 10),
+			clOrdID(t.SellOrderID),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeReplayBooks ecrit les carnets finaux en JSON : niveaux L2 complets
+// par symbole.
+func writeReplayBooks(w io.Writer, gw *Gateway) error {
+	books := make(map[string]bookData, len(gw.books))
+	for symbol, ob := range gw.books {
+		bids, asks := ob.Levels(0)
+		books[symbol] = bookData{Bids: toLevelsJSON(bids), Asks: toLevelsJSON(asks)}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(books)
+}
+
+// writeFile cree path et y ecrit avec write.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ---------------------------------------------------------------------------
+// Sous-commande replay
+// ---------------------------------------------------------------------------
+
+// runReplay rejoue un fichier et ecrit les trades et les carnets finaux.
+// Ctrl+C arrete le rejeu ; les sorties refletent alors l'etat partiel.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	in := fs.String("in", "", "fichier d'ordres (CSV, ou JSONL si .jsonl/.json)")
+	speed := fs.Float64("speed", 0, "vitesse : 0 aussi vite que possible, 1 temps reel, N fois plus vite")
+	symbols := fs.String("symbols", "", "symboles du Gateway, separes par des virgules (defaut : ceux du fichier)")
+	tradesPath := fs.String("trades", "replay_trades.csv", "fichier CSV des trades")
+	booksPath := fs.String("books", "replay_books.json", "fichier JSON des carnets finaux")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("replay: -in obligatoire")
+	}
+	if *speed < 0 {
+		return fmt.Errorf("replay: -speed doit etre >= 0")
+	}
+	records, err := ReadReplayFile(*in)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	opts := ReplayOptions{Speed: *speed}
+	if *symbols != "" {
+		opts.Symbols = strings.Split(*symbols, ",")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	gw, st, err := Replay(ctx, records, opts)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: interrompu apres %d lignes sur %d\n", st.Records, len(records))
+	}
+	if err := writeFile(*tradesPath, func(w io.Writer) error { return writeReplayTrades(w, gw) }); err != nil {
+		return err
+	}
+	if err := writeFile(*booksPath, func(w io.Writer) error { return writeReplayBooks(w, gw) }); err != nil {
+		return err
+	}
+
+	fmt.Printf("replay: %d lignes (%d NEW, %d CANCEL, %d REPLACE), %d trades, %d refus\n",
+		st.Records, st.Orders, st.Cancels, st.Replaces, st.Trades, len(st.Errors))
+	fmt.Printf("replay: %v de flux rejoues en %v\n", st.Span, st.Elapsed.Round(time.Millisecond))
+	for _, e := range st.Errors[:min(len(st.Errors), 10)] {
+		fmt.Printf("  ligne %d: %v\n", e.Line, e.Err)
+	}
+	if len(st.Errors) > 10 {
+		fmt.Printf("  ... %d autres refus\n", len(st.Errors)-10)
+	}
+	fmt.Printf("replay: trades -> %s, carnets -> %s\n", *tradesPath, *booksPath)
+	return nil
+}
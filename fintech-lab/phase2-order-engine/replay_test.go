@@ -0,0 +1,172 @@
+// replay_test.go — Tests du rejeu de fichiers d'ordres.
+// Lancer avec : go test ./phase2-order-engine/ -run Replay -v
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replaySample : construction d'un carnet, un croisement, une modification,
+// une annulation et deux refus (ClOrdID inconnu, quantite nulle).
+const replaySample = `ts,action,account,cl_ord_id,orig_cl_ord_id,symbol,side,type,price,stop_price,quantity
+1760000000000000000,NEW,ACC1,s-1,,AAPL,SELL,LIMIT,190.5,,100
+1760000000100000000,NEW,ACC1,s-2,,AAPL,SELL,LIMIT,190,,50
+1760000000200000000,NEW,ACC2,b-1,,AAPL,BUY,,189,,200
+1760000000300000000,NEW,ACC2,b-2,,AAPL,BUY,LIMIT,191,,120
+1760000000400000000,REPLACE,ACC2,b-1b,b-1,,,,189.5,,150
+1760000000500000000,CANCEL,ACC1,,s-9,,,,,,
+1760000000600000000,CANCEL,ACC1,s-1,,,,,,,
+1760000000700000000,NEW,ACC3,,,MSFT,BUY,MARKET,,,0
+`
+
+func TestReplayRead(t *testing.T) {
+	csvRecs, err := ReadReplayCSV(strings.NewReader(replaySample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(csvRecs) != 8 {
+		t.Fatalf("%d lignes, attendu 8", len(csvRecs))
+	}
+	want := ReplayRecord{Line: 4, TS: 1760000000200000000, Action: ReplayNew, Account: "ACC2", ClOrdID: "b-1", Symbol: "AAPL", Side: Buy, Type: Limit, Price: 189, Quantity: 200}
+	if csvRecs[2] != want {
+		t.Errorf("ligne 4: %+v, attendu %+v", csvRecs[2], want)
+	}
+	if r := csvRecs[6]; r.Action != ReplayCancel || r.OrigClOrdID != "s-1" {
+		t.Errorf("CANCEL sans orig_cl_ord_id: %+v, attendu la cible s-1", r)
+	}
+
+	// Le meme flux en JSONL, ts en nombre ou en RFC 3339.
+	jsonl := `{"ts":1760000000000000000,"account":"ACC1","cl_ord_id":"s-1","symbol":"AAPL","side":"SELL","price":190.5,"quantity":100}
+
+{"ts":"2025-10-09T08:53:20.2Z","action":"new","account":"ACC2","cl_ord_id":"b-1","symbol":"AAPL","side":"buy","price":189,"quantity":200}
+`
+	jsonRecs, err := ReadReplayJSONL(strings.NewReader(jsonl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Line = 3
+	if len(jsonRecs) != 2 || jsonRecs[1] != want {
+		t.Errorf("JSONL: %+v, attendu en 2e %+v", jsonRecs, want)
+	}
+
+	bad := map[string]string{
+		"colonne inconnue":  "ts,foo\n1,x\n",
+		"ts manquant":       "ts,symbol\n,AAPL\n",
+		"action inconnue":   "ts,action,symbol\n1,MODIFY,AAPL\n",
+		"prix illisible":    "ts,symbol,price\n1,AAPL,abc\n",
+		"REPLACE incomplet": "ts,action,orig_cl_ord_id\n1,REPLACE,a\n",
+		"horodatage recule": "ts,symbol\n2,AAPL\n1,AAPL\n",
+		"NEW sans symbole":  "ts,side\n1,BUY\n",
+		"en-tete sans ts":   "symbol\nAAPL\n",
+	}
+	for name, in := range bad {
+		if _, err := ReadReplayCSV(strings.NewReader(in)); err == nil {
+			t.Errorf("%s: accepte", name)
+		}
+	}
+	if _, err := ReadReplayJSONL(strings.NewReader(`{"ts":1,"symbol":"AAPL","venue":"X"}`)); err == nil {
+		t.Error("JSONL: champ inconnu accepte")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	records, err := ReadReplayCSV(strings.NewReader(replaySample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, st, err := Replay(context.Background(), records, ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Records != 8 || st.Orders != 5 || st.Cancels != 2 || st.Replaces != 1 || st.Trades != 2 {
+		t.Errorf("stats: %+v", st)
+	}
+	if len(st.Errors) != 2 || st.Errors[0].Line != 7 || !errors.Is(st.Errors[0].Err, ErrOrderNotFound) || st.Errors[1].Line != 9 {
+		t.Errorf("refus: %+v, attendu lignes 7 (ErrOrderNotFound) et 9", st.Errors)
+	}
+	if st.Span != 700*time.Millisecond {
+		t.Errorf("Span = %v, attendu 700ms", st.Span)
+	}
+
+	// Les trades portent l'heure du fichier.
+	trades := gw.log.Trades()
+	if len(trades) != 2 || trades[0].Timestamp < 1760000000300000000 || trades[0].Timestamp >= 1760000000400000000 {
+		t.Fatalf("trades: %+v", trades)
+	}
+
+	var out bytes.Buffer
+	if err := writeReplayTrades(&out, gw); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[1], ",AAPL,190,50,4,b-2,2,s-2") || !strings.HasSuffix(lines[2], ",AAPL,190.5,70,4,b-2,1,s-1") {
+		t.Errorf("trades CSV:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := writeReplayBooks(&out, gw); err != nil {
+		t.Fatal(err)
+	}
+	var books map[string]bookData
+	if err := json.Unmarshal(out.Bytes(), &books); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bookData{
+		"AAPL": {Bids: []levelJSON{{Price: 189.5, Quantity: 150, Orders: 1}}, Asks: []levelJSON{}},
+		"MSFT": {Bids: []levelJSON{}, Asks: []levelJSON{}},
+	}
+	if !reflect.DeepEqual(books, want) {
+		t.Errorf("carnets: %+v, attendu %+v", books, want)
+	}
+}
+
+// TestReplayDeterministic verifie que deux rejeux donnent des sorties
+// identiques, quelle que soit la vitesse.
+func TestReplayDeterministic(t *testing.T) {
+	records, _ := ReadReplayCSV(strings.NewReader(replaySample))
+	outputs := make([]string, 2)
+	for i, speed := range []float64{0, 100} {
+		gw, _, err := Replay(context.Background(), records, ReplayOptions{Speed: speed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		writeReplayTrades(&out, gw)
+		writeReplayBooks(&out, gw)
+		outputs[i] = out.String()
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("sorties differentes:\n%s\n---\n%s", outputs[0], outputs[1])
+	}
+}
+
+// TestReplaySpeed verifie le cadencement et l'arret par contexte.
+func TestReplaySpeed(t *testing.T) {
+	records, _ := ReadReplayCSV(strings.NewReader(replaySample)) // 700ms de flux
+
+	_, st, err := Replay(context.Background(), records, ReplayOptions{Speed: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Elapsed < 70*time.Millisecond {
+		t.Errorf("x10: rejoue en %v, attendu >= 70ms", st.Elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	gw, st, err := Replay(ctx, records, ReplayOptions{Speed: 1})
+	if !errors.Is(err, context.DeadlineExceeded) || gw == nil {
+		t.Fatalf("temps reel interrompu: %v", err)
+	}
+	if st.Records == 0 || st.Records >= len(records) {
+		t.Errorf("interrompu apres %d lignes, attendu entre 1 et %d", st.Records, len(records)-1)
+	}
+}
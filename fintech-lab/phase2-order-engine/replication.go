@@ -0,0 +1,710 @@
+// replication.go — Replication primaire/backup du journal d'entree, avec
+// bascule a chaud.
+//
+// Le primaire sequence les commandes dans un Ring (ring.go) dont l'etape
+// Journal les date, les garde en memoire et les envoie au backup par TCP.
+// Le matcher n'applique une commande qu'une fois acquittee par le backup :
+// tout ce que le primaire a execute (donc publie aux clients) existe sur le
+// backup, a la meme sequence.
+//
+// Le backup applique le journal au fil de l'eau a son propre Gateway, date
+// par une SimClock placee a l'heure de chaque commande : memes commandes,
+// memes heures, memes IDs, donc memes carnets. Quand le primaire se tait
+// pendant FailoverTimeout (ni journal ni heartbeat, reconnexion impossible),
+// le backup devient primaire : sa sequence continue la precedente et un
+// nouveau backup peut s'y abonner.
+//
+// PROTOCOLE (frames de ouch.go, big-endian) :
+//
+//	Backup -> primaire                  Primaire -> backup
+//	'S' Subscribe  derniere seq u64     'J' Entry      une commande (encodeEntry)
+//	'A' Ack        derniere seq u64     'H' Heartbeat  vide, apres Heartbeat de silence
+//
+// Au Subscribe, le primaire renvoie son journal depuis la sequence suivante :
+// un backup qui se reconnecte rattrape son retard. Le backup acquitte chaque
+// rafale lue (heartbeat compris) : le primaire sait aussi qu'il est vivant.
+//
+// LIMITES :
+//   - Sans backup (pas encore abonne, ou coupe faute d'Ack en AckTimeout),
+//     le primaire continue seul : la disponibilite d'abord. Une partition
+//     reseau peut alors donner deux primaires (split brain) ; l'eviter
+//     demande un arbitre tiers.
+//   - Seules les commandes du ring sont repliquees : Kill, MassCancel, ordres
+//     lies ou corrections appeles sur le Gateway du primaire feraient
+//     diverger le backup.
+//   - Une commande envoyee mais pas encore appliquee au crash du primaire est
+//     appliquee par le backup : le client ne l'a jamais vue acquittee et la
+//     retrouve par son ClOrdID.
+//   - Le journal est en memoire (pas de persistance disque).
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Types de frames de replication.
+const (
+	replSubscribe uint8 = 'S'
+	replAck       uint8 = 'A'
+	replEntry     uint8 = 'J'
+	replHeartbeat uint8 = 'H'
+)
+
+// Valeurs par defaut des options de replication.
+const (
+	defaultReplHeartbeat = 50 * time.Millisecond
+)
+
+// ErrPrimaryDown est retourne par Publish une fois le primaire arrete.
+var ErrPrimaryDown = errors.New("primaire arrete")
+
+// ReplicationOptions regle le primaire et le backup. Les zeros prennent les
+// defauts ; les deux instances doivent avoir les memes.
+type ReplicationOptions struct {
+	Heartbeat       time.Duration // Heartbeat du primaire apres ce silence
+	FailoverTimeout time.Duration // Bascule du backup apres ce silence du primaire (4 x Heartbeat)
+	AckTimeout      time.Duration // Le primaire coupe un backup muet et continue seul (2 x Heartbeat)
+	Ring            RingOptions   // Taille du ring (Journal et Clock sont fixes par le primaire)
+	Log             io.Writer     // Journal des connexions et bascules (nil : aucun)
+}
+
+// withDefaults retourne les options completees.
+func (o ReplicationOptions) withDefaults() ReplicationOptions {
+	if o.Heartbeat <= 0 {
+		o.Heartbeat = defaultReplHeartbeat
+	}
+	if o.FailoverTimeout <= 0 {
+		o.FailoverTimeout = 4 * o.Heartbeat
+	}
+	if o.AckTimeout <= 0 {
+		o.AckTimeout = 2 * o.Heartbeat
+	}
+	return o
+}
+
+// logf ecrit dans le journal des options, prefixe par role.
+func (o ReplicationOptions) logf(role, format string, args ...any) {
+	if o.Log != nil {
+		fmt.Fprintf(o.Log, "[REPL %s] "+format+"\n", append([]any{role}, args...)...)
+	}
+}
+
+// newReplicaGateway cree le Gateway d'une instance : les deux instances
+// partent de la meme horloge simulee, placee a l'heure de chaque commande.
+func newReplicaGateway(symbols []string, log *TradeLog) (*Gateway, *SimClock) {
+	clock := NewSimClock(time.Unix(0, 0), time.Nanosecond)
+	return NewGatewayWithClock(symbols, log, clock), clock
+}
+
+// ---------------------------------------------------------------------------
+// Journal : encodage d'une commande
+// ---------------------------------------------------------------------------
+
+// encodeEntry ajoute a dst le payload d'une Entry :
+//
+//	Seq u64 | Time i64 | Kind u8 | Symbol | OrderID u64 | Price f64 | Quantity i64 | HasOrder u8
+//	[Account | ClOrdID | Symbol | Side | Type | Status | Price f64 | StopPrice f64 | Quantity i64]
+//
+// Texte : longueur uvarint puis octets. Prix en bits IEEE 754 : le backup
+// doit matcher exactement aux memes prix. L'ordre est copie tel que soumis,
+// avant que le matcher y touche.
+func encodeEntry(dst []byte, seq uint64, e *RingEvent) []byte {
+	c := &e.Command
+	text := func(s string) {
+		dst = binary.AppendUvarint(dst, uint64(len(s)))
+		dst = append(dst, s...)
+	}
+	dst = binary.BigEndian.AppendUint64(dst, seq)
+	dst = binary.BigEndian.AppendUint64(dst, uint64(e.Time))
+	dst = append(dst, byte(c.Kind))
+	text(c.Symbol)
+	dst = binary.BigEndian.AppendUint64(dst, c.OrderID)
+	dst = binary.BigEndian.AppendUint64(dst, math.Float64bits(c.Price))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(c.Quantity))
+	o := c.Order
+	if o == nil {
+		return append(dst, 0)
+	}
+	dst = append(dst, 1)
+	for _, s := range []string{o.Account, o.ClOrdID, o.Symbol, string(o.Side), string(o.Type), string(o.Status)} {
+		text(s)
+	}
+	dst = binary.BigEndian.AppendUint64(dst, math.Float64bits(o.Price))
+	dst = binary.BigEndian.AppendUint64(dst, math.Float64bits(o.StopPrice))
+	return binary.BigEndian.AppendUint64(dst, uint64(o.Quantity))
+}
+
+// entryReader lit un payload d'Entry ; la premiere lecture hors limites
+// fixe err et les suivantes retournent des zeros.
+type entryReader struct {
+	b   []byte
+	err error
+}
+
+func (r *entryReader) next(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errors.New("replication: entry tronquee")
+		return make([]byte, n)
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p
+}
+
+func (r *entryReader) u8() byte     { return r.next(1)[0] }
+func (r *entryReader) u64() uint64  { return binary.BigEndian.Uint64(r.next(8)) }
+func (r *entryReader) f64() float64 { return math.Float64frombits(r.u64()) }
+func (r *entryReader) text() string {
+	if r.err != nil {
+		return ""
+	}
+	n, k := binary.Uvarint(r.b)
+	if k <= 0 || n > uint64(len(r.b)-k) {
+		r.err = errors.New("replication: texte invalide")
+		return ""
+	}
+	r.b = r.b[k:]
+	return string(r.next(int(n)))
+}
+
+// decodeEntry decode un payload d'Entry ; Seq est la sequence du journal.
+func decodeEntry(p []byte) (RingEvent, error) {
+	r := entryReader{b: p}
+	e := RingEvent{Seq: r.u64(), Time: int64(r.u64())}
+	c := &e.Command
+	c.Kind = CommandKind(r.u8())
+	c.Symbol = r.text()
+	c.OrderID = r.u64()
+	c.Price = r.f64()
+	c.Quantity = int64(r.u64())
+	if r.u8() == 1 {
+		o := &Order{Account: r.text(), ClOrdID: r.text(), Symbol: r.text()}
+		o.Side, o.Type, o.Status = Side(r.text()), OrderType(r.text()), OrderStatus(r.text())
+		o.Price, o.StopPrice, o.Quantity = r.f64(), r.f64(), int64(r.u64())
+		c.Order = o
+	}
+	if r.err == nil && len(r.b) > 0 {
+		r.err = fmt.Errorf("replication: %d octets en trop", len(r.b))
+	}
+	return e, r.err
+}
+
+// ---------------------------------------------------------------------------
+// Primary
+// ---------------------------------------------------------------------------
+
+// ReplicationStatus decrit la replication vue du primaire.
+type ReplicationStatus struct {
+	Seq    uint64 // Derniere sequence journalisee
+	Acked  uint64 // Derniere sequence acquittee par le backup
+	Backup string // Adresse du backup abonne ("" : primaire seul)
+}
+
+// Primary est l'instance active : producteurs -> Ring -> journal replique
+// -> matcher -> handlers.
+type Primary struct {
+	gw   *Gateway
+	ring *Ring
+	rep  *replicator
+}
+
+// NewPrimary cree un moteur pour symbols et accepte les abonnements de
+// backup sur l (nil : pas de replication). Les handlers suivent le ring ;
+// ils voient la sequence du journal dans RingEvent.Seq.
+func NewPrimary(symbols []string, log *TradeLog, l net.Listener, opts ReplicationOptions, handlers ...RingHandler) *Primary {
+	gw, clock := newReplicaGateway(symbols, log)
+	return startPrimary(gw, clock, nil, 0, l, opts.withDefaults(), handlers)
+}
+
+// startPrimary demarre un primaire sur un Gateway deja alimente par journal
+// (bascule d'un backup), dont la derniere commande date de last.
+func startPrimary(gw *Gateway, clock *SimClock, journal [][]byte, last int64, l net.Listener, opts ReplicationOptions, handlers []RingHandler) *Primary {
+	rep := &replicator{opts: opts, l: l, journal: journal, last: last}
+	rep.cond = sync.NewCond(&rep.mu)
+	ringOpts := opts.Ring
+	ringOpts.Journal, ringOpts.Clock = rep, clock
+	p := &Primary{gw: gw, rep: rep, ring: NewRing(gw, ringOpts, handlers...)}
+	if l != nil {
+		rep.wg.Add(1)
+		go rep.serve()
+	}
+	return p
+}
+
+// Publish met une commande en file (voir Ring.Publish). Elle sera
+// appliquee une fois recue par le backup.
+func (p *Primary) Publish(cmd Command) (uint64, error) {
+	return p.ring.Publish(cmd)
+}
+
+// Gateway retourne le moteur, pour les lectures (Book, Order...).
+func (p *Primary) Gateway() *Gateway { return p.gw }
+
+// Status retourne l'etat de la replication.
+func (p *Primary) Status() ReplicationStatus {
+	r := p.rep
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := ReplicationStatus{Seq: r.seq()}
+	if b := r.backup; b != nil {
+		st.Acked, st.Backup = b.acked, b.nc.RemoteAddr().String()
+	}
+	return st
+}
+
+// Close applique et replique les commandes publiees puis arrete le
+// primaire. Le backup, sans nouvelles, prendra le relais.
+func (p *Primary) Close() {
+	p.ring.Close()
+	p.rep.shutdown()
+}
+
+// crash simule la mort du process : plus rien n'est envoye ni applique,
+// les commandes publiees mais pas encore acquittees sont perdues pour le
+// primaire (le backup applique celles qu'il a recues).
+func (p *Primary) crash() {
+	p.rep.shutdown()
+	p.ring.Close()
+}
+
+// replicator est l'etape Journal du ring du primaire.
+type replicator struct {
+	opts ReplicationOptions
+	l    net.Listener
+	wg   sync.WaitGroup
+
+	// Etat de la goroutine du journal (Append, Commit) : last seulement.
+	last int64 // Heure de la derniere commande
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signale Ack, coupure et arret ; Locker = mu
+	journal [][]byte   // Payloads d'Entry ; journal[i] est la sequence i+1
+	backup  *replConn  // Backup abonne (nil : primaire seul)
+	down    bool
+}
+
+// replConn est la connexion d'un backup abonne.
+type replConn struct {
+	nc       net.Conn
+	notify   chan struct{} // cap 1 : journal a envoyer
+	done     chan struct{}
+	killOnce sync.Once
+	acked    uint64 // sous replicator.mu
+}
+
+// kill ferme la connexion immediatement.
+func (c *replConn) kill() {
+	c.killOnce.Do(func() {
+		close(c.done)
+		c.nc.Close()
+	})
+}
+
+// wake reveille la goroutine d'ecriture sans bloquer.
+func (c *replConn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// seq retourne la derniere sequence journalisee. Appele avec mu tenu.
+func (r *replicator) seq() uint64 { return uint64(len(r.journal)) }
+
+// Append implemente RingJournal : date la commande (heure strictement
+// croissante), la numerote dans le journal et l'y ajoute.
+func (r *replicator) Append(e *RingEvent) {
+	r.last = max(SystemClock{}.Now(), r.last+1)
+	e.Time = r.last
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Seq = r.seq() + 1
+	r.journal = append(r.journal, encodeEntry(nil, e.Seq, e))
+}
+
+// Commit implemente RingJournal : envoie le lot au backup et attend son
+// Ack. Un backup muet pendant AckTimeout est coupe, le primaire continue
+// seul.
+func (r *replicator) Commit() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, want := r.backup, r.seq()
+	if b != nil {
+		b.wake()
+		timer := time.AfterFunc(r.opts.AckTimeout, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.backup == b && b.acked < want {
+				r.opts.logf("PRIMARY", "%s: pas d'Ack en %v, primaire seul", b.nc.RemoteAddr(), r.opts.AckTimeout)
+				r.drop(b)
+			}
+		})
+		defer timer.Stop()
+	}
+	for !r.down && r.backup != nil && r.backup.acked < want {
+		r.cond.Wait()
+	}
+	if r.down {
+		return ErrPrimaryDown
+	}
+	return nil
+}
+
+// drop coupe le backup c. Appele avec mu tenu.
+func (r *replicator) drop(c *replConn) {
+	c.kill()
+	if r.backup == c {
+		r.backup = nil
+	}
+	r.cond.Broadcast()
+}
+
+// shutdown arrete l'ecoute et coupe le backup ; Commit echoue ensuite.
+func (r *replicator) shutdown() {
+	r.mu.Lock()
+	r.down = true
+	if r.backup != nil {
+		r.drop(r.backup)
+	}
+	r.cond.Broadcast()
+	r.mu.Unlock()
+	if r.l != nil {
+		r.l.Close()
+	}
+	r.wg.Wait()
+}
+
+// serve accepte les backups jusqu'a shutdown.
+func (r *replicator) serve() {
+	defer r.wg.Done()
+	for {
+		nc, err := r.l.Accept()
+		if err != nil {
+			return
+		}
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.handle(nc)
+		}()
+	}
+}
+
+// handle abonne un backup : attend son Subscribe, le substitue au backup
+// precedent, puis lit ses Acks jusqu'a la coupure.
+func (r *replicator) handle(nc net.Conn) {
+	br := bufio.NewReader(nc)
+	nc.SetReadDeadline(time.Now().Add(r.opts.FailoverTimeout))
+	f, err := DecodeFrame(br)
+	if err != nil || f.Type != replSubscribe || len(f.Payload) != 8 {
+		r.opts.logf("PRIMARY", "%s: abonnement invalide (%v)", nc.RemoteAddr(), err)
+		nc.Close()
+		return
+	}
+	from := binary.BigEndian.Uint64(f.Payload)
+
+	c := &replConn{nc: nc, notify: make(chan struct{}, 1), done: make(chan struct{}), acked: from}
+	r.mu.Lock()
+	switch {
+	case r.down:
+		r.mu.Unlock()
+		nc.Close()
+		return
+	case from > r.seq():
+		r.mu.Unlock()
+		r.opts.logf("PRIMARY", "%s: backup a #%d, journal a #%d : refuse", nc.RemoteAddr(), from, r.seq())
+		nc.Close()
+		return
+	}
+	if r.backup != nil {
+		r.drop(r.backup)
+	}
+	r.backup = c
+	r.mu.Unlock()
+	r.opts.logf("PRIMARY", "%s: backup abonne depuis #%d", nc.RemoteAddr(), from)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.write(c, from)
+	}()
+	c.wake() // Rattrapage
+	err = r.readAcks(c, br)
+
+	r.mu.Lock()
+	if r.backup == c {
+		r.opts.logf("PRIMARY", "%s: backup perdu (%v), primaire seul", nc.RemoteAddr(), err)
+		r.drop(c)
+	}
+	r.mu.Unlock()
+	c.kill()
+}
+
+// readAcks lit les Acks du backup ; un backup muet pendant FailoverTimeout
+// est considere mort.
+func (r *replicator) readAcks(c *replConn, br *bufio.Reader) error {
+	for {
+		c.nc.SetReadDeadline(time.Now().Add(r.opts.FailoverTimeout))
+		f, err := DecodeFrame(br)
+		if err != nil {
+			return err
+		}
+		if f.Type != replAck || len(f.Payload) != 8 {
+			return fmt.Errorf("replication: frame %q inattendue", f.Type)
+		}
+		seq := binary.BigEndian.Uint64(f.Payload)
+		r.mu.Lock()
+		c.acked = max(c.acked, seq)
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	}
+}
+
+// write envoie au backup le journal a partir de sent, par rafales, et un
+// heartbeat apres Heartbeat de silence.
+func (r *replicator) write(c *replConn, sent uint64) {
+	hb := r.opts.Heartbeat
+	bw := bufio.NewWriter(c.nc)
+	tick := time.NewTicker(max(hb/4, time.Millisecond))
+	defer tick.Stop()
+	lastSent := time.Now()
+	var frame []byte
+	for {
+		select {
+		case <-c.notify:
+		case <-tick.C:
+		case <-c.done:
+			return
+		}
+		r.mu.Lock()
+		entries := r.journal[sent:] // Les payloads ne changent plus
+		r.mu.Unlock()
+		if len(entries) == 0 && time.Since(lastSent) < hb {
+			continue
+		}
+		for _, p := range entries {
+			frame = appendFrame(frame[:0], replEntry, p)
+			bw.Write(frame)
+		}
+		if len(entries) == 0 {
+			bw.Write(appendFrame(frame[:0], replHeartbeat, nil))
+		}
+		c.nc.SetWriteDeadline(time.Now().Add(r.opts.AckTimeout))
+		if err := bw.Flush(); err != nil {
+			c.kill()
+			return
+		}
+		sent += uint64(len(entries))
+		lastSent = time.Now()
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Backup
+// ---------------------------------------------------------------------------
+
+// Backup suit un primaire et applique son journal a son propre Gateway.
+type Backup struct {
+	gw       *Gateway
+	clock    *SimClock
+	addr     string
+	l        net.Listener
+	opts     ReplicationOptions
+	handlers []RingHandler
+
+	mu      sync.Mutex
+	journal [][]byte // Payloads appliques ; journal[i] est la sequence i+1
+	last    int64    // Heure de la derniere commande appliquee
+	conn    net.Conn
+	primary *Primary
+	closed  bool
+
+	promoted chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewBackup cree un moteur pour symbols qui suit le primaire de addr. A la
+// bascule, il devient primaire, accepte les backups sur l (nil : aucun) et
+// demarre handlers sur son ring.
+func NewBackup(symbols []string, log *TradeLog, addr string, l net.Listener, opts ReplicationOptions, handlers ...RingHandler) *Backup {
+	gw, clock := newReplicaGateway(symbols, log)
+	b := &Backup{
+		gw:       gw,
+		clock:    clock,
+		addr:     addr,
+		l:        l,
+		opts:     opts.withDefaults(),
+		handlers: handlers,
+		promoted: make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Gateway retourne le moteur du backup, pour les lectures.
+func (b *Backup) Gateway() *Gateway { return b.gw }
+
+// Applied retourne la derniere sequence appliquee.
+func (b *Backup) Applied() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return uint64(len(b.journal))
+}
+
+// Promoted est ferme quand le backup devient primaire.
+func (b *Backup) Promoted() <-chan struct{} { return b.promoted }
+
+// Primary retourne le primaire issu de la bascule (nil avant).
+func (b *Backup) Primary() *Primary {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.primary
+}
+
+// Close arrete le suivi, ou le primaire issu de la bascule.
+func (b *Backup) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.mu.Unlock()
+	close(b.stop)
+	<-b.done
+
+	if p := b.Primary(); p != nil {
+		p.Close()
+	} else if b.l != nil {
+		b.l.Close()
+	}
+}
+
+// run suit le primaire, en se reconnectant, jusqu'a FailoverTimeout sans
+// nouvelles : il bascule alors.
+func (b *Backup) run() {
+	defer close(b.done)
+	heard := time.Now() // Dernier signe de vie du primaire
+	for {
+		nc, err := net.DialTimeout("tcp", b.addr, b.opts.Heartbeat)
+		if err == nil {
+			err = b.follow(nc, &heard)
+		}
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		wait := b.opts.FailoverTimeout - time.Since(heard)
+		if wait <= 0 {
+			b.promote()
+			return
+		}
+		b.opts.logf("BACKUP", "primaire %s: %v, bascule dans %v", b.addr, err, wait.Round(time.Millisecond))
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(min(wait, b.opts.Heartbeat)):
+		}
+	}
+}
+
+// follow s'abonne depuis la derniere sequence appliquee et applique le
+// journal recu jusqu'a la coupure ou FailoverTimeout de silence. Chaque
+// rafale est acquittee des sa reception, puis appliquee : le primaire
+// n'attend pas le matching du backup.
+func (b *Backup) follow(nc net.Conn, heard *time.Time) error {
+	defer nc.Close()
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return net.ErrClosed
+	}
+	b.conn = nc
+	received := uint64(len(b.journal))
+	b.mu.Unlock()
+
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], received)
+	nc.SetWriteDeadline(time.Now().Add(b.opts.Heartbeat))
+	if _, err := nc.Write(appendFrame(nil, replSubscribe, seq[:])); err != nil {
+		return err
+	}
+	br := bufio.NewReader(nc)
+	var burst []RingEvent
+	var payloads [][]byte
+	for {
+		nc.SetReadDeadline(heard.Add(b.opts.FailoverTimeout))
+		f, err := DecodeFrame(br)
+		if err != nil {
+			return err
+		}
+		*heard = time.Now()
+		switch f.Type {
+		case replEntry:
+			e, err := decodeEntry(f.Payload)
+			switch {
+			case err != nil:
+				return err
+			case e.Seq <= received:
+				continue // Deja recue (rattrapage)
+			case e.Seq != received+1:
+				return fmt.Errorf("replication: entry #%d apres #%d", e.Seq, received)
+			}
+			burst, payloads = append(burst, e), append(payloads, f.Payload)
+			received++
+		case replHeartbeat:
+		default:
+			return fmt.Errorf("replication: frame %q inattendue", f.Type)
+		}
+		if br.Buffered() > 0 {
+			continue // Ack a la fin de la rafale
+		}
+		binary.BigEndian.PutUint64(seq[:], received)
+		nc.SetWriteDeadline(time.Now().Add(b.opts.Heartbeat))
+		_, err = nc.Write(appendFrame(nil, replAck, seq[:]))
+		for i := range burst { // Acquittee : appliquee meme si l'Ack echoue
+			b.apply(&burst[i], payloads[i])
+		}
+		burst, payloads = burst[:0], payloads[:0]
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// apply applique une Entry recue et l'ajoute au journal.
+func (b *Backup) apply(e *RingEvent, payload []byte) {
+	applyCommand(b.gw, b.clock, e)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.journal = append(b.journal, payload)
+	b.last = e.Time
+}
+
+// promote fait du backup le primaire, a la suite de son journal.
+func (b *Backup) promote() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.opts.logf("BACKUP", "primaire %s muet depuis %v : bascule apres #%d", b.addr, b.opts.FailoverTimeout, len(b.journal))
+	b.primary = startPrimary(b.gw, b.clock, b.journal, b.last, b.l, b.opts, b.handlers)
+	close(b.promoted)
+}
@@ -0,0 +1,253 @@
+// replication_test.go — Tests de la replication primaire/backup : codec du
+// journal, suivi et rattrapage, bascule apres crash du primaire.
+// Lancer avec : go test ./phase2-order-engine/ -run 'Entry|Replication' -v
+
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReplOptions : timeouts courts pour des bascules rapides, Ack genereux
+// pour que le primaire ne coupe pas un backup ralenti par -race.
+var testReplOptions = ReplicationOptions{
+	Heartbeat:       10 * time.Millisecond,
+	FailoverTimeout: 150 * time.Millisecond,
+	AckTimeout:      5 * time.Second,
+	Ring:            RingOptions{Size: 256},
+}
+
+func listenLocal(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// waitFor attend cond au plus 5 s.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("attente depassee: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// engineState resume l'etat d'un moteur : carnets, ordres et trades.
+func engineState(gw *Gateway, log *TradeLog) string {
+	var sb strings.Builder
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		ob, _ := gw.Book(symbol)
+		bids, asks := ob.Levels(0)
+		fmt.Fprintf(&sb, "%s %v %v\n", symbol, bids, asks)
+	}
+	gw.mu.Lock()
+	for id := uint64(1); id <= uint64(len(gw.orders)); id++ {
+		o := gw.orders[id]
+		fmt.Fprintf(&sb, "#%d %s %s %s %d/%d @%d\n", id, o.ClOrdID, o.Side, o.Status, o.Filled, o.Quantity, o.Timestamp)
+	}
+	gw.mu.Unlock()
+	for _, t := range log.Trades() {
+		fmt.Fprintf(&sb, "%+v\n", t)
+	}
+	return sb.String()
+}
+
+// replay applique les n premieres entries d'un journal a un moteur neuf.
+func replay(t *testing.T, journal [][]byte, n uint64) string {
+	t.Helper()
+	log := NewTradeLog()
+	gw, clock := newReplicaGateway([]string{"AAPL", "MSFT"}, log)
+	for _, p := range journal[:n] {
+		e, err := decodeEntry(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyCommand(gw, clock, &e)
+	}
+	return engineState(gw, log)
+}
+
+// orderFlow publie un flux d'ordres qui se croisent jusqu'a n commandes ou
+// la premiere erreur (primaire arrete).
+func orderFlow(p *Primary, producer, n int) {
+	for i := 0; i < n; i++ {
+		side := Buy
+		if (producer+i)%2 == 1 {
+			side = Sell
+		}
+		cmd := Command{Kind: CmdSubmit, Order: NewLimitOrder("AAPL", side, float64(99+i%3), int64(1+i%7))}
+		cmd.Order.Account, cmd.Order.ClOrdID = fmt.Sprintf("ACC%d", producer), fmt.Sprintf("P%d-%d", producer, i)
+		if i%9 == 8 {
+			cmd = Command{Kind: CmdCancel, Symbol: "AAPL", OrderID: uint64(i)}
+		}
+		if _, err := p.Publish(cmd); err != nil {
+			return
+		}
+	}
+}
+
+func TestEntryCodec(t *testing.T) {
+	o := NewStopOrder("AAPL", Sell, 99.125, 30)
+	o.Account, o.ClOrdID, o.Price = "ACC-é", strings.Repeat("x", 300), 0.1+0.2
+	for _, e := range []RingEvent{
+		{Time: 42, Command: Command{Kind: CmdSubmit, Order: o}},
+		{Time: 43, Command: Command{Kind: CmdAmend, Symbol: "MSFT", OrderID: 7, Price: 101.5, Quantity: 20}},
+	} {
+		p := encodeEntry(nil, 9, &e)
+		got, err := decodeEntry(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Seq != 9 || got.Time != e.Time || got.Command.Kind != e.Command.Kind || got.Command.Symbol != e.Command.Symbol ||
+			got.Command.OrderID != e.Command.OrderID || got.Command.Price != e.Command.Price || got.Command.Quantity != e.Command.Quantity {
+			t.Errorf("decode: %+v, attendu %+v", got, e)
+		}
+		if e.Command.Order != nil && *got.Command.Order != *e.Command.Order {
+			t.Errorf("ordre: %+v, attendu %+v", *got.Command.Order, *e.Command.Order)
+		}
+		for _, bad := range [][]byte{p[:len(p)-1], append(slices.Clone(p), 0)} {
+			if _, err := decodeEntry(bad); err == nil {
+				t.Errorf("payload de %d octets accepte", len(bad))
+			}
+		}
+	}
+}
+
+// TestReplicationFollow verifie le rattrapage d'un backup abonne en retard,
+// le suivi en continu et la reprise apres un arret propre du primaire.
+func TestReplicationFollow(t *testing.T) {
+	symbols := []string{"AAPL", "MSFT"}
+	plog, blog := NewTradeLog(), NewTradeLog()
+	l := listenLocal(t)
+	p := NewPrimary(symbols, plog, l, testReplOptions)
+	orderFlow(p, 0, 100) // Primaire seul : journal rattrape a l'abonnement
+
+	var seqs []uint64
+	b := NewBackup(symbols, blog, l.Addr().String(), listenLocal(t), testReplOptions, func(e *RingEvent) {
+		seqs = append(seqs, e.Seq)
+	})
+	defer b.Close()
+	waitFor(t, "abonnement du backup", func() bool { return p.Status().Backup != "" })
+	var wg sync.WaitGroup
+	for producer := 1; producer <= 3; producer++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			orderFlow(p, producer, 300)
+		}()
+	}
+	wg.Wait()
+	waitFor(t, "application de 1000 commandes", func() bool { return p.ring.Matched() == 1000 })
+	if st := p.Status(); st.Seq != 1000 || st.Acked != st.Seq || st.Backup == "" {
+		t.Errorf("status: %+v", st)
+	}
+	waitFor(t, "application par le backup", func() bool { return b.Applied() == 1000 })
+	if got, want := engineState(b.Gateway(), blog), engineState(p.Gateway(), plog); got != want || len(plog.Trades()) == 0 {
+		t.Fatalf("etats differents:\nbackup:\n%s\nprimaire:\n%s", got, want)
+	}
+
+	p.Close()
+	select {
+	case <-b.Promoted():
+	case <-time.After(5 * time.Second):
+		t.Fatal("pas de bascule")
+	}
+	np := b.Primary()
+	mustPublish := func(cmd Command) {
+		if _, err := np.Publish(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustPublish(Command{Kind: CmdSubmit, Order: NewMarketOrder("AAPL", Buy, 1)})
+	np.ring.Close()
+	if !slices.Equal(seqs, []uint64{1001}) {
+		t.Errorf("sequences apres bascule: %v", seqs)
+	}
+	if err := b.Gateway().CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestReplicationFailover tue le primaire a des instants aleatoires pendant
+// un flux continu : toute commande vue par un handler du primaire (donc
+// acquittee) est sur le backup a la meme sequence, le backup a eu l'etat
+// du primaire et reprend la main.
+func TestReplicationFailover(t *testing.T) {
+	symbols := []string{"AAPL", "MSFT"}
+	for round := 0; round < 5; round++ {
+		plog, blog := NewTradeLog(), NewTradeLog()
+		l := listenLocal(t)
+		type acked struct {
+			seq   uint64
+			clOrd string
+		}
+		var seen []acked
+		p := NewPrimary(symbols, plog, l, testReplOptions, func(e *RingEvent) {
+			if o := e.Command.Order; o != nil {
+				seen = append(seen, acked{e.Seq, o.ClOrdID})
+			}
+		})
+		b := NewBackup(symbols, blog, l.Addr().String(), nil, testReplOptions)
+		waitFor(t, "abonnement du backup", func() bool { return p.Status().Backup != "" })
+
+		var wg sync.WaitGroup
+		for producer := 0; producer < 3; producer++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				orderFlow(p, producer, 5000)
+			}()
+		}
+		time.Sleep(time.Duration(rand.IntN(100)) * time.Millisecond)
+		p.crash()
+		wg.Wait()
+		matched := p.ring.Matched()
+
+		select {
+		case <-b.Promoted():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("round %d: pas de bascule", round)
+		}
+		b.mu.Lock()
+		journal := slices.Clone(b.journal)
+		b.mu.Unlock()
+		if uint64(len(journal)) < matched {
+			t.Fatalf("round %d: backup a #%d, primaire a applique #%d", round, len(journal), matched)
+		}
+		for _, a := range seen {
+			e, _ := decodeEntry(journal[a.seq-1])
+			if e.Command.Order == nil || e.Command.Order.ClOrdID != a.clOrd {
+				t.Fatalf("round %d: #%d %s acquitte par le primaire, %+v sur le backup", round, a.seq, a.clOrd, e.Command)
+			}
+		}
+		if got, want := replay(t, journal, matched), engineState(p.Gateway(), plog); got != want {
+			t.Fatalf("round %d: le backup n'avait pas l'etat du primaire a #%d", round, matched)
+		}
+		if got, want := engineState(b.Gateway(), blog), replay(t, journal, uint64(len(journal))); got != want {
+			t.Fatalf("round %d: etat du backup different du rejeu de son journal", round)
+		}
+
+		np := b.Primary()
+		if seq, err := np.Publish(Command{Kind: CmdSubmit, Order: NewLimitOrder("AAPL", Buy, 50, 1)}); err != nil || seq != 1 {
+			t.Fatalf("round %d: publication apres bascule: %d, %v", round, seq, err)
+		}
+		b.Close()
+		if st := np.Status(); st.Seq != uint64(len(journal))+1 {
+			t.Errorf("round %d: journal a #%d apres bascule, attendu #%d", round, st.Seq, len(journal)+1)
+		}
+		t.Logf("round %d: crash a #%d applique, backup a #%d, %d acquittes", round, matched, len(journal), len(seen))
+	}
+}
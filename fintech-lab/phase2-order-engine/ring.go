@@ -0,0 +1,361 @@
+// ring.go — Sequenceur d'entree facon LMAX Disruptor devant le matching.
+//
+// Plusieurs producteurs publient des commandes (submit, cancel, amend) dans
+// un ring buffer pre-alloue. Un seul consommateur, le matcher, les applique
+// au Gateway dans l'ordre des sequences ; les consommateurs aval (journal,
+// market data, risque) relisent ensuite les memes slots, resultats compris.
+//
+//   producteurs --claim--> [ ring ] --> matcher --> handler 1 (journal)
+//                                              \--> handler 2 (risque) ...
+//
+// PAS DE VERROU SUR LE RING : chaque participant publie sa progression dans
+// un curseur atomique et n'attend que celui qui le precede :
+//
+//   - un producteur reserve une sequence (atomic add sur claim), attend que
+//     le slot ait ete relu par tous les handlers (un tour de ring plus tot),
+//     ecrit la commande, puis publie le slot (published = sequence) ;
+//   - le matcher attend published, applique la commande, ecrit les trades
+//     dans le slot et avance son curseur (par lot : tout ce qui est publie) ;
+//   - chaque handler attend le curseur du matcher, lit le slot, avance le sien.
+//
+// Les stores/loads atomiques des curseurs donnent le happens-before : le
+// slot est ecrit par un seul participant a la fois. Le matcher est seul a
+// appeler le Gateway : ses verrous (gw.mu, ob.mu) ne sont jamais contendus.
+//
+// Le buffer de trades de chaque slot est reutilise (SubmitAppend) : un
+// handler qui garde des trades au-dela de son appel doit les copier.
+//
+// JOURNAL (optionnel, RingOptions.Journal) : une etape entre producteurs et
+// matcher, qui voit chaque commande avant son application et peut la dater
+// (replication.go). Le matcher n'applique qu'apres Commit ; si Commit
+// echoue, le ring s'arrete sur la derniere sequence journalisee.
+//
+// Mesure : go test ./phase2-order-engine/ -run '^$' -bench Sequencer -benchmem -cpu 1,4,8
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRingClosed est retourne par Publish apres Close.
+var ErrRingClosed = errors.New("ring ferme")
+
+// CommandKind identifie l'operation d'une commande.
+type CommandKind uint8
+
+const (
+	CmdSubmit CommandKind = iota + 1
+	CmdCancel
+	CmdAmend
+)
+
+// String retourne le nom de l'operation.
+func (k CommandKind) String() string {
+	switch k {
+	case CmdSubmit:
+		return "SUBMIT"
+	case CmdCancel:
+		return "CANCEL"
+	case CmdAmend:
+		return "AMEND"
+	}
+	return fmt.Sprintf("CommandKind(%d)", uint8(k))
+}
+
+// Command est une operation publiee dans le ring. Submit utilise Order ;
+// Cancel et Amend utilisent Symbol et OrderID (Amend : Price et Quantity,
+// comme Gateway.Amend).
+//
+// Une fois publie, l'ordre appartient au matcher : l'ID lui est attribue a
+// l'application. Les handlers peuvent lire ses champs fixes (ID, Symbol,
+// Account...) ; Status et Filled bougent avec les commandes suivantes,
+// l'etat de la commande est dans Trades et Err.
+type Command struct {
+	Kind     CommandKind
+	Order    *Order
+	Symbol   string
+	OrderID  uint64
+	Price    float64
+	Quantity int64
+}
+
+// RingEvent est le contenu d'un slot vu par les handlers : la commande et
+// son resultat. Valide pendant l'appel au handler seulement.
+type RingEvent struct {
+	Seq     uint64
+	Time    int64 // Heure de la commande fixee par le journal (0 : horloge du Gateway)
+	Command Command
+	Trades  []Trade // Trades de la commande, cascade comprise
+	Err     error   // Refus du Gateway
+}
+
+// RingHandler est un consommateur aval. Chaque handler a sa goroutine et
+// voit toutes les sequences, dans l'ordre.
+type RingHandler func(e *RingEvent)
+
+// RingOptions regle le Ring. Les zeros prennent les defauts.
+type RingOptions struct {
+	Size    int         // Slots, arrondi a une puissance de 2 (defaut 4096)
+	Journal RingJournal // Etape avant le matcher (nil : aucune)
+	Clock   *SimClock   // Horloge du Gateway, placee a RingEvent.Time avant chaque commande
+}
+
+// RingJournal est l'etape de journalisation du ring, appelee sur sa propre
+// goroutine, dans l'ordre des sequences.
+type RingJournal interface {
+	// Append recoit une commande publiee ; il peut fixer e.Time et
+	// renumeroter e.Seq (sequence du journal, vue par les handlers).
+	Append(e *RingEvent)
+	// Commit rend durables les commandes ajoutees depuis le dernier Commit.
+	// Une erreur arrete le ring : rien apres ne sera applique.
+	Commit() error
+}
+
+// ---------------------------------------------------------------------------
+// Ring
+// ---------------------------------------------------------------------------
+
+// cursor est une sequence atomique seule sur sa ligne de cache : les
+// curseurs sont ecrits par des goroutines differentes (false sharing).
+type cursor struct {
+	v atomic.Uint64
+	_ [56]byte
+}
+
+// ringSlot est une case du ring. published vaut la sequence du slot une
+// fois la commande ecrite.
+type ringSlot struct {
+	published atomic.Uint64
+	ev        RingEvent
+}
+
+// Ring sequence les commandes de plusieurs producteurs vers un Gateway.
+// Les sequences commencent a 1 ; un curseur vaut la derniere traitee.
+type Ring struct {
+	gw    *Gateway
+	opts  RingOptions
+	slots []ringSlot
+	mask  uint64
+
+	claim     cursor   // Derniere sequence reservee par un producteur
+	journaled cursor   // Derniere sequence acceptee par Journal.Commit
+	matched   cursor   // Derniere sequence appliquee par le matcher
+	handlers  []cursor // Derniere sequence lue par chaque handler
+
+	closed atomic.Bool
+	failed atomic.Bool // Commit a echoue ; err est fixe avant
+	err    error
+	wg     sync.WaitGroup
+}
+
+// NewRing cree un ring devant gw et demarre le matcher et un consommateur
+// par handler. gw ne doit plus etre appele directement pour les ordres.
+func NewRing(gw *Gateway, opts RingOptions, handlers ...RingHandler) *Ring {
+	size := 4096
+	if opts.Size > 0 {
+		size = 1
+		for size < opts.Size {
+			size <<= 1
+		}
+	}
+	r := &Ring{
+		gw:       gw,
+		opts:     opts,
+		slots:    make([]ringSlot, size),
+		mask:     uint64(size - 1),
+		handlers: make([]cursor, len(handlers)),
+	}
+	r.wg.Add(1 + len(handlers))
+	if opts.Journal != nil {
+		r.wg.Add(1)
+		go r.journal()
+	}
+	go r.match()
+	for i, h := range handlers {
+		go r.consume(&r.handlers[i], h)
+	}
+	return r
+}
+
+// Publish met cmd en file et retourne sa sequence. Bloque (attente active
+// puis sommeil) tant que le ring est plein : backpressure sur le producteur.
+// Apres un echec du journal, retourne son erreur.
+func (r *Ring) Publish(cmd Command) (uint64, error) {
+	if r.closed.Load() {
+		return 0, ErrRingClosed
+	}
+	if r.failed.Load() {
+		return 0, r.err
+	}
+	seq := r.claim.v.Add(1)
+	wrap := seq - uint64(len(r.slots)) // Sequence precedente du slot
+	for n := 0; seq > uint64(len(r.slots)) && r.gating() < wrap; n++ {
+		if r.failed.Load() {
+			return 0, r.err // Le slot ne sera jamais libere
+		}
+		backoff(n)
+	}
+	s := &r.slots[seq&r.mask]
+	s.ev.Seq, s.ev.Time, s.ev.Command, s.ev.Err = seq, 0, cmd, nil
+	s.published.Store(seq)
+	return seq, nil
+}
+
+// gating retourne la sequence du consommateur le plus lent : les slots
+// jusqu'a elle sont libres.
+func (r *Ring) gating() uint64 {
+	seq := r.matched.v.Load()
+	for i := range r.handlers {
+		seq = min(seq, r.handlers[i].v.Load())
+	}
+	return seq
+}
+
+// Matched retourne la derniere sequence appliquee au Gateway.
+func (r *Ring) Matched() uint64 { return r.matched.v.Load() }
+
+// Err retourne l'erreur du journal qui a arrete le ring (nil sinon).
+func (r *Ring) Err() error {
+	if r.failed.Load() {
+		return r.err
+	}
+	return nil
+}
+
+// Close attend que toutes les commandes publiees soient appliquees et lues
+// par les handlers, puis arrete les goroutines. Comme close sur un channel :
+// a appeler une fois les producteurs arretes.
+func (r *Ring) Close() {
+	if r.closed.Swap(true) {
+		return
+	}
+	r.wg.Wait()
+}
+
+// done indique si le consommateur en est a next-1 et n'a plus rien a lire :
+// ring ferme et tout traite, jusqu'a la derniere sequence journalisee si le
+// journal a echoue.
+func (r *Ring) done(next uint64) bool {
+	if !r.closed.Load() {
+		return false
+	}
+	if r.failed.Load() {
+		return next > r.journaled.v.Load()
+	}
+	return next > r.claim.v.Load()
+}
+
+// published retourne la derniere sequence publiee sans trou a partir de
+// next (next-1 si next ne l'est pas encore).
+func (r *Ring) published(next uint64) uint64 {
+	for r.slots[next&r.mask].published.Load() == next {
+		next++
+	}
+	return next - 1
+}
+
+// journal est la goroutine de l'etape Journal : un Commit par lot.
+func (r *Ring) journal() {
+	defer r.wg.Done()
+	next := uint64(1)
+	for n := 0; !r.done(next) && !r.failed.Load(); {
+		last := r.published(next)
+		if last < next {
+			backoff(n)
+			n++
+			continue
+		}
+		for seq := next; seq <= last; seq++ {
+			r.opts.Journal.Append(&r.slots[seq&r.mask].ev)
+		}
+		if err := r.opts.Journal.Commit(); err != nil {
+			r.err = err
+			r.failed.Store(true)
+			return
+		}
+		r.journaled.v.Store(last)
+		next, n = last+1, 0
+	}
+}
+
+// match est la goroutine du matcher : seule a appeler le Gateway.
+func (r *Ring) match() {
+	defer r.wg.Done()
+	next := uint64(1)
+	for n := 0; !r.done(next); {
+		last := r.journaled.v.Load()
+		if r.opts.Journal == nil {
+			last = r.published(next)
+		}
+		if last < next {
+			backoff(n)
+			n++
+			continue
+		}
+		for ; next <= last; next++ {
+			applyCommand(r.gw, r.opts.Clock, &r.slots[next&r.mask].ev)
+		}
+		r.matched.v.Store(last) // Un seul store par lot
+		n = 0
+	}
+}
+
+// applyCommand execute une commande sur gw et range le resultat dans e.
+// Avec clock, le Gateway est date a e.Time : un moteur qui rejoue les memes
+// commandes aux memes heures obtient le meme etat (replication.go).
+func applyCommand(gw *Gateway, clock *SimClock, e *RingEvent) {
+	if clock != nil {
+		clock.Set(e.Time)
+	}
+	c := &e.Command
+	trades := e.Trades[:0]
+	switch c.Kind {
+	case CmdSubmit:
+		e.Trades, e.Err = gw.SubmitAppend(trades, c.Order)
+	case CmdCancel:
+		e.Trades, e.Err = trades, gw.Cancel(c.Symbol, c.OrderID)
+	case CmdAmend:
+		t, err := gw.Amend(c.Symbol, c.OrderID, c.Price, c.Quantity)
+		e.Trades, e.Err = append(trades, t...), err
+	default:
+		e.Trades, e.Err = trades, &ValidationError{Field: "kind", Message: fmt.Sprintf("commande inconnue: %s", c.Kind)}
+	}
+}
+
+// consume est la goroutine d'un handler : il suit le matcher.
+func (r *Ring) consume(c *cursor, h RingHandler) {
+	defer r.wg.Done()
+	next := uint64(1)
+	for n := 0; !r.done(next); {
+		avail := r.matched.v.Load()
+		if avail < next {
+			backoff(n)
+			n++
+			continue
+		}
+		for ; next <= avail; next++ {
+			h(&r.slots[next&r.mask].ev)
+		}
+		c.v.Store(avail)
+		n = 0
+	}
+}
+
+// backoff est la strategie d'attente (PhasedBackoff du Disruptor) : attente
+// active d'abord pour la latence, puis on cede le processeur, puis on dort
+// pour ne pas bruler un coeur quand le ring est au repos.
+func backoff(n int) {
+	switch {
+	case n < 100:
+	case n < 1000:
+		runtime.Gosched()
+	default:
+		time.Sleep(50 * time.Microsecond)
+	}
+}
@@ -0,0 +1,216 @@
+// ring_test.go — Tests du sequenceur d'entree (ring buffer) et comparaison
+// avec les appels concurrents au Gateway sous mutex.
+// Lancer avec : go test ./phase2-order-engine/ -run Ring -v
+// Mesure     : go test ./phase2-order-engine/ -run '^$' -bench Sequencer -benchmem -cpu 1,4,8
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRingSequencing publie depuis plusieurs producteurs et verifie que
+// chaque handler voit toutes les sequences dans l'ordre, avec les memes
+// trades que le TradeLog.
+func TestRingSequencing(t *testing.T) {
+	gw, log := newTestGateway()
+	gw.SetDebug(true)
+	type seen struct {
+		seqs   []uint64
+		trades []Trade
+		errs   int
+	}
+	var journal, risk seen
+	record := func(s *seen) RingHandler {
+		return func(e *RingEvent) {
+			s.seqs = append(s.seqs, e.Seq)
+			s.trades = append(s.trades, e.Trades...) // Copie : le buffer du slot est reutilise
+			if e.Err != nil {
+				s.errs++
+			}
+		}
+	}
+	r := NewRing(gw, RingOptions{Size: 64}, record(&journal), record(&risk))
+
+	const producers, perProducer = 4, 500
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				cmd := Command{Kind: CmdSubmit, Order: NewLimitOrder("AAPL", Buy, float64(99+i%3), 10)}
+				switch {
+				case i%10 == 9:
+					cmd = Command{Kind: CmdCancel, Symbol: "AAPL", OrderID: 1 << 40} // Inconnu : refuse
+				case (p+i)%2 == 1:
+					cmd.Order.Side = Sell
+				}
+				if _, err := r.Publish(cmd); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	r.Close()
+
+	want := make([]uint64, producers*perProducer)
+	for i := range want {
+		want[i] = uint64(i + 1)
+	}
+	for name, s := range map[string]*seen{"journal": &journal, "risk": &risk} {
+		if !slices.Equal(s.seqs, want) {
+			t.Errorf("%s: %d sequences, attendu 1..%d dans l'ordre", name, len(s.seqs), len(want))
+		}
+		if !slices.Equal(s.trades, log.Trades()) {
+			t.Errorf("%s: %d trades, %d au TradeLog", name, len(s.trades), len(log.Trades()))
+		}
+		if s.errs != producers*perProducer/10 {
+			t.Errorf("%s: %d refus", name, s.errs)
+		}
+	}
+	if len(log.Trades()) == 0 || r.Matched() != uint64(len(want)) {
+		t.Errorf("%d trades, matched %d", len(log.Trades()), r.Matched())
+	}
+	if err := gw.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.Publish(Command{Kind: CmdCancel}); !errors.Is(err, ErrRingClosed) {
+		t.Errorf("Publish apres Close: %v", err)
+	}
+}
+
+// TestRingCommands verifie Amend, Cancel et une commande inconnue.
+func TestRingCommands(t *testing.T) {
+	gw, _ := newTestGateway()
+	sell := NewLimitOrder("AAPL", Sell, 101, 50)
+	mustSubmit(t, gw, sell)
+	var events []RingEvent
+	r := NewRing(gw, RingOptions{}, func(e *RingEvent) {
+		c := *e
+		c.Trades = slices.Clone(e.Trades)
+		events = append(events, c)
+	})
+	r.Publish(Command{Kind: CmdAmend, Symbol: "AAPL", OrderID: sell.ID, Price: 100, Quantity: 30})
+	r.Publish(Command{Kind: CmdSubmit, Order: NewLimitOrder("AAPL", Buy, 100, 10)})
+	r.Publish(Command{Kind: CmdCancel, Symbol: "AAPL", OrderID: sell.ID})
+	r.Publish(Command{Kind: 9})
+	r.Close()
+
+	var ve *ValidationError
+	switch {
+	case len(events) != 4:
+		t.Fatalf("%d evenements", len(events))
+	case events[0].Err != nil || events[2].Err != nil:
+		t.Errorf("amend/cancel: %v, %v", events[0].Err, events[2].Err)
+	case len(events[1].Trades) != 1 || events[1].Trades[0].Price != 100 || events[1].Trades[0].Quantity != 10:
+		t.Errorf("trades apres amend: %v", events[1].Trades)
+	case !errors.As(events[3].Err, &ve) || ve.Field != "kind":
+		t.Errorf("commande inconnue: %v", events[3].Err)
+	}
+	if sell.Status != StatusCancelled || sell.Filled != 10 {
+		t.Errorf("ordre amende: %s filled %d", sell.Status, sell.Filled)
+	}
+}
+
+// TestRingBackpressure verifie qu'un handler lent bloque les producteurs
+// quand le ring est plein, sans perdre de commande.
+func TestRingBackpressure(t *testing.T) {
+	gw, _ := newTestGateway()
+	release := make(chan struct{})
+	var seqs []uint64
+	r := NewRing(gw, RingOptions{Size: 3}, func(e *RingEvent) { // Arrondi a 4
+		<-release
+		seqs = append(seqs, e.Seq)
+	})
+	for i := 0; i < 4; i++ {
+		r.Publish(Command{Kind: CmdSubmit, Order: NewLimitOrder("AAPL", Buy, 100, 1)})
+	}
+
+	published := make(chan uint64)
+	go func() {
+		seq, _ := r.Publish(Command{Kind: CmdSubmit, Order: NewLimitOrder("AAPL", Buy, 100, 1)})
+		published <- seq
+	}()
+	select {
+	case seq := <-published:
+		t.Fatalf("sequence %d publiee dans un ring plein", seq)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if seq := <-published; seq != 5 {
+		t.Errorf("sequence %d, attendu 5", seq)
+	}
+	r.Close()
+	if !slices.Equal(seqs, []uint64{1, 2, 3, 4, 5}) {
+		t.Errorf("sequences lues: %v", seqs)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Benchmarks — ring contre mutex
+// ---------------------------------------------------------------------------
+//
+// Meme flux dans les trois cas : achats et ventes a 100 qui se croisent,
+// soumis par GOMAXPROCS goroutines (-cpu). Sous mutex, chaque producteur
+// execute le matching en tenant le verrou ; avec le ring, il ne fait que
+// publier et le matcher travaille seul, sans contention.
+//
+// Le debit reste borne par un seul thread de matching dans les deux cas :
+// le ring supprime la contention (le cout par ordre ne monte plus avec
+// -cpu) et rend la main aux producteurs, il ne parallelise pas le Gateway.
+
+// benchOrder alterne achat et vente pour que le carnet reste petit.
+func benchOrder(i int) *Order {
+	side := Buy
+	if i%2 == 1 {
+		side = Sell
+	}
+	return NewLimitOrder("AAPL", side, 100, 1)
+}
+
+// BenchmarkSequencerMutexBook soumet directement a un OrderBook (ob.mu).
+func BenchmarkSequencerMutexBook(b *testing.B) {
+	ob := NewOrderBook("AAPL")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			ob.Submit(benchOrder(i))
+		}
+	})
+}
+
+// BenchmarkSequencerMutexGateway soumet au Gateway (gw.mu puis ob.mu).
+func BenchmarkSequencerMutexGateway(b *testing.B) {
+	gw, _ := newTestGateway()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			gw.Submit(benchOrder(i))
+		}
+	})
+}
+
+// BenchmarkSequencerRing publie dans le ring, avec un handler aval ; le
+// chrono court jusqu'a ce que tout soit applique et lu.
+func BenchmarkSequencerRing(b *testing.B) {
+	gw, _ := newTestGateway()
+	var volume int64
+	r := NewRing(gw, RingOptions{}, func(e *RingEvent) {
+		for _, t := range e.Trades {
+			volume += t.Quantity
+		}
+	})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			r.Publish(Command{Kind: CmdSubmit, Order: benchOrder(i)})
+		}
+	})
+	r.Close()
+}
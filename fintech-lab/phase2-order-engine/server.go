@@ -0,0 +1,565 @@
+// server.go — API HTTP/JSON devant le Gateway : saisie d'ordres et market data.
+//
+//	POST   /orders                    soumettre un ordre
+//	GET    /orders/{id}               etat, fills et historique
+//	PATCH  /orders/{id}               modifier prix et/ou quantite (Amend)
+//	DELETE /orders/{id}               annuler
+//	GET    /books/{symbol}?depth=N    niveaux agreges du carnet
+//	GET    /trades?symbol=S&limit=N   derniers trades
+//	GET    /stream                    streaming WebSocket (voir stream.go)
+//	GET    /healthz                   sans authentification
+//	GET    /metrics                   metriques Prometheus, sans authentification (metrics.go)
+//
+// Authentification Bearer (ou ?token= sur un upgrade WebSocket, les
+// navigateurs ne pouvant pas poser d'en-tete) : chaque token designe un compte. Le compte d'un
+// ordre vient du token, jamais du corps de la requete, et un client ne voit
+// et ne touche que ses propres ordres.
+//
+// writeJSON, loggingMiddleware et authMiddleware reprennent les patterns de
+// netlab (phase1, lesson04).
+//
+// Lancer : go run ./phase2-order-engine/ serve -addr :8080 -tokens secret1=ACC1,secret2=ACC2
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPOptions configure le serveur HTTP.
+type HTTPOptions struct {
+	Tokens    map[string]string // token Bearer -> compte
+	AccessLog io.Writer         // journal des requetes (nil : aucun)
+	Stream    StreamOptions     // options du streaming /stream
+}
+
+// HTTPServer expose un Gateway en HTTP/JSON.
+type HTTPServer struct {
+	gw     *Gateway
+	opts   HTTPOptions
+	stream *StreamHub
+}
+
+// NewHTTPServer cree le serveur HTTP d'un Gateway. Close libere le hub de
+// streaming.
+func NewHTTPServer(gw *Gateway, opts HTTPOptions) *HTTPServer {
+	return &HTTPServer{gw: gw, opts: opts, stream: NewStreamHub(gw, opts.Stream)}
+}
+
+// Close arrete le hub de streaming.
+func (s *HTTPServer) Close() {
+	s.stream.Close()
+}
+
+// Handler retourne le routeur complet, middlewares compris.
+func (s *HTTPServer) Handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("POST /orders", s.handleSubmit)
+	api.HandleFunc("GET /orders/{id}", s.handleGetOrder)
+	api.HandleFunc("PATCH /orders/{id}", s.handleAmend)
+	api.HandleFunc("DELETE /orders/{id}", s.handleCancel)
+	api.HandleFunc("GET /books/{symbol}", s.handleBook)
+	api.HandleFunc("GET /trades", s.handleTrades)
+	api.Handle("GET /stream", s.stream)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"status": "ok",
+			"time":   time.Unix(0, s.gw.Clock().Now()).UTC().Format(time.RFC3339Nano),
+		})
+	})
+	mux.Handle("GET /metrics", s.gw.Metrics().Registry)
+	mux.Handle("/", authMiddleware(s.opts.Tokens, api))
+
+	if s.opts.AccessLog == nil {
+		return mux
+	}
+	return loggingMiddleware(s.opts.AccessLog, mux)
+}
+
+// ---------------------------------------------------------------------------
+// Format JSON
+// ---------------------------------------------------------------------------
+
+// orderRequest est le corps de POST /orders.
+type orderRequest struct {
+	ClOrdID   string  `json:"cl_ord_id"`
+	Symbol    string  `json:"symbol"`
+	Side      Side    `json:"side"`
+	Type      string  `json:"type"` // LIMIT par defaut
+	Price     float64 `json:"price"`
+	StopPrice float64 `json:"stop_price"`
+	Quantity  int64   `json:"quantity"`
+}
+
+// amendRequest est le corps de PATCH /orders/{id}. Un champ absent (0)
+// reste inchange.
+type amendRequest struct {
+	Price    float64 `json:"price"`
+	Quantity int64   `json:"quantity"`
+}
+
+type orderJSON struct {
+	ID        uint64      `json:"id"`
+	ClOrdID   string      `json:"cl_ord_id,omitempty"`
+	Account   string      `json:"account"`
+	Symbol    string      `json:"symbol"`
+	Side      Side        `json:"side"`
+	Type      OrderType   `json:"type"`
+	Status    OrderStatus `json:"status"`
+	Price     float64     `json:"price,omitempty"`
+	StopPrice float64     `json:"stop_price,omitempty"`
+	Quantity  int64       `json:"quantity"`
+	Filled    int64       `json:"filled"`
+	Remaining int64       `json:"remaining"`
+	Timestamp int64       `json:"ts"`
+}
+
+func toOrderJSON(o Order) orderJSON {
+	return orderJSON{
+		ID:        o.ID,
+		ClOrdID:   o.ClOrdID,
+		Account:   o.Account,
+		Symbol:    o.Symbol,
+		Side:      o.Side,
+		Type:      o.Type,
+		Status:    o.Status,
+		Price:     o.Price,
+		StopPrice: o.StopPrice,
+		Quantity:  o.Quantity,
+		Filled:    o.Filled,
+		Remaining: o.Remaining(),
+		Timestamp: o.Timestamp,
+	}
+}
+
+type tradeJSON struct {
+	ID          uint64      `json:"id"`
+	Symbol      string      `json:"symbol"`
+	BuyOrderID  uint64      `json:"buy_order_id"`
+	SellOrderID uint64      `json:"sell_order_id"`
+	Price       float64     `json:"price"`
+	Quantity    int64       `json:"quantity"`
+	Currency    string      `json:"currency"`
+	Timestamp   int64       `json:"ts"`
+	Status      TradeStatus `json:"status"`
+}
+
+func toTradesJSON(trades []Trade) []tradeJSON {
+	out := make([]tradeJSON, len(trades))
+	for i, t := range trades {
+		out[i] = tradeJSON{
+			ID:          t.ID,
+			Symbol:      t.Symbol,
+			BuyOrderID:  t.BuyOrderID,
+			SellOrderID: t.SellOrderID,
+			Price:       t.Price,
+			Quantity:    t.Quantity,
+			Currency:    t.Currency,
+			Timestamp:   t.Timestamp,
+			Status:      t.Status,
+		}
+	}
+	return out
+}
+
+type levelJSON struct {
+	Price    float64 `json:"price"`
+	Quantity int64   `json:"quantity"`
+	Orders   int     `json:"orders"`
+}
+
+func toLevelsJSON(levels []Level) []levelJSON {
+	out := make([]levelJSON, len(levels))
+	for i, l := range levels {
+		out[i] = levelJSON{Price: l.Price, Quantity: l.Quantity, Orders: l.Orders}
+	}
+	return out
+}
+
+// apiError est le corps de toute reponse d'erreur : {"error": {...}}.
+type apiError struct {
+	Code    string `json:"code"`            // validation_error, not_found, kill_switch, ...
+	Field   string `json:"field,omitempty"` // Champ fautif (ValidationError)
+	Message string `json:"message"`
+	OrderID uint64 `json:"order_id,omitempty"` // Ordre rejete (trace dans l'historique)
+}
+
+// ---------------------------------------------------------------------------
+// Handlers
+// ---------------------------------------------------------------------------
+
+func (s *HTTPServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req orderRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	o := &Order{
+		Account:   accountFrom(r),
+		ClOrdID:   req.ClOrdID,
+		Symbol:    req.Symbol,
+		Side:      req.Side,
+		Type:      OrderType(req.Type),
+		Status:    StatusOpen,
+		Price:     req.Price,
+		StopPrice: req.StopPrice,
+		Quantity:  req.Quantity,
+	}
+	if o.Type == "" {
+		o.Type = Limit
+	}
+	trades, err := s.gw.Submit(o)
+	if err != nil {
+		writeGatewayError(w, err, o.ID)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"order":  s.snapshot(o.ID),
+		"trades": toTradesJSON(trades),
+	})
+}
+
+func (s *HTTPServer) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.ownOrder(w, r)
+	if !ok {
+		return
+	}
+	fills := st.Fills
+	if fills == nil {
+		fills = []Fill{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"order":   toOrderJSON(st.Order),
+		"fills":   fills,
+		"history": st.History,
+	})
+}
+
+func (s *HTTPServer) handleAmend(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.ownOrder(w, r)
+	if !ok {
+		return
+	}
+	var req amendRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	trades, err := s.gw.Amend(st.Order.Symbol, st.Order.ID, req.Price, req.Quantity)
+	if err != nil {
+		writeGatewayError(w, err, 0)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"order":  s.snapshot(st.Order.ID),
+		"trades": toTradesJSON(trades),
+	})
+}
+
+func (s *HTTPServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.ownOrder(w, r)
+	if !ok {
+		return
+	}
+	if err := s.gw.Cancel(st.Order.Symbol, st.Order.ID); err != nil {
+		writeGatewayError(w, err, 0)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"order": s.snapshot(st.Order.ID)})
+}
+
+func (s *HTTPServer) handleBook(w http.ResponseWriter, r *http.Request) {
+	symbol := r.PathValue("symbol")
+	book, ok := s.gw.Book(symbol)
+	if !ok {
+		writeError(w, http.StatusNotFound, apiError{Code: "not_found", Message: fmt.Sprintf("symbole %q non supporte", symbol)})
+		return
+	}
+	depth, ok := queryInt(w, r, "depth", 10, 1000)
+	if !ok {
+		return
+	}
+	bids, asks := book.Levels(depth)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"symbol": symbol,
+		"bids":   toLevelsJSON(bids),
+		"asks":   toLevelsJSON(asks),
+	})
+}
+
+func (s *HTTPServer) handleTrades(w http.ResponseWriter, r *http.Request) {
+	if s.gw.log == nil {
+		writeError(w, http.StatusServiceUnavailable, apiError{Code: "unavailable", Message: "Gateway sans TradeLog"})
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", 100, 1000)
+	if !ok {
+		return
+	}
+	symbol := r.URL.Query().Get("symbol")
+
+	var trades []Trade
+	for _, t := range s.gw.log.Trades() {
+		if symbol == "" || t.Symbol == symbol {
+			trades = append(trades, t)
+		}
+	}
+	if len(trades) > limit {
+		trades = trades[len(trades)-limit:]
+	}
+	writeJSON(w, http.StatusOK, toTradesJSON(trades))
+}
+
+// ownOrder charge l'ordre {id} s'il appartient au compte du token. Un ordre
+// d'un autre compte repond 404, comme un ordre inexistant.
+func (s *HTTPServer) ownOrder(w http.ResponseWriter, r *http.Request) (OrderState, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, apiError{Code: "validation_error", Field: "id", Message: "ID d'ordre invalide"})
+		return OrderState{}, false
+	}
+	st, ok := s.gw.Order(id)
+	if !ok || st.Order.Account != accountFrom(r) {
+		writeError(w, http.StatusNotFound, apiError{Code: "not_found", Message: fmt.Sprintf("ordre #%d inconnu", id)})
+		return OrderState{}, false
+	}
+	return st, true
+}
+
+// snapshot retourne l'etat courant d'un ordre au format JSON.
+func (s *HTTPServer) snapshot(id uint64) orderJSON {
+	st, _ := s.gw.Order(id)
+	return toOrderJSON(st.Order)
+}
+
+// ---------------------------------------------------------------------------
+// Helpers
+// ---------------------------------------------------------------------------
+
+// writeJSON positionne Content-Type, ecrit le statut puis encode v.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("writeJSON encode error:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, e apiError) {
+	writeJSON(w, status, map[string]apiError{"error": e})
+}
+
+// writeGatewayError traduit une erreur du Gateway en reponse HTTP :
+// ValidationError -> 400, kill switch -> 403, ordre introuvable -> 404,
+// tout autre refus -> 409.
+func writeGatewayError(w http.ResponseWriter, err error, orderID uint64) {
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeError(w, http.StatusBadRequest, apiError{Code: "validation_error", Field: verr.Field, Message: verr.Message, OrderID: orderID})
+	case errors.Is(err, ErrKillSwitch):
+		writeError(w, http.StatusForbidden, apiError{Code: "kill_switch", Message: err.Error(), OrderID: orderID})
+	case errors.Is(err, ErrOrderNotFound):
+		writeError(w, http.StatusNotFound, apiError{Code: "not_found", Message: err.Error()})
+	default:
+		writeError(w, http.StatusConflict, apiError{Code: "rejected", Message: err.Error(), OrderID: orderID})
+	}
+}
+
+// decodeJSON lit le corps (1 MiB max, champs inconnus refuses).
+// Repond 400 et retourne false si le corps est invalide.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, apiError{Code: "invalid_json", Message: err.Error()})
+		return false
+	}
+	return true
+}
+
+// queryInt lit un parametre entier dans [1, max], def s'il est absent.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def, max int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > max {
+		writeError(w, http.StatusBadRequest, apiError{Code: "validation_error", Field: name, Message: fmt.Sprintf("entier entre 1 et %d attendu, recu %q", max, raw)})
+		return 0, false
+	}
+	return n, true
+}
+
+// ---------------------------------------------------------------------------
+// Middlewares
+// ---------------------------------------------------------------------------
+
+// statusRecorder capture le statut ecrit par le handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap expose le ResponseWriter d'origine (http.ResponseController,
+// upgrade WebSocket).
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// loggingMiddleware journalise methode, chemin, statut et duree.
+func loggingMiddleware(out io.Writer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		fmt.Fprintf(out, "[%s] %-6s %-30s %d %v\n",
+			time.Now().Format("15:04:05"), r.Method, r.URL.Path, rec.status, time.Since(start))
+	})
+}
+
+type ctxKey int
+
+const accountKey ctxKey = 0
+
+// authMiddleware refuse les requetes sans token Bearer valide et place le
+// compte du token dans le contexte de la requete.
+func authMiddleware(tokens map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token, bearer := strings.CutPrefix(auth, "Bearer ")
+		if !bearer && isWebSocketUpgrade(r) {
+			token, bearer = r.URL.Query().Get("token"), r.URL.Query().Has("token")
+		}
+		if !bearer {
+			writeError(w, http.StatusUnauthorized, apiError{Code: "unauthorized", Message: "missing Authorization header"})
+			return
+		}
+		account, ok := tokens[token]
+		if !ok {
+			writeError(w, http.StatusUnauthorized, apiError{Code: "unauthorized", Message: "invalid token"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accountKey, account)))
+	})
+}
+
+// accountFrom retourne le compte authentifie de la requete.
+func accountFrom(r *http.Request) string {
+	account, _ := r.Context().Value(accountKey).(string)
+	return account
+}
+
+// ---------------------------------------------------------------------------
+// Sous-commande serve
+// ---------------------------------------------------------------------------
+
+// runServe demarre le serveur HTTP sur un Gateway neuf, jusqu'a Ctrl+C.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "adresse d'ecoute")
+	symbols := fs.String("symbols", "AAPL,MSFT,TSLA", "symboles routes, separes par des virgules (SYM:DEVISE, defaut USD)")
+	tokens := fs.String("tokens", "", "token=compte, separes par des virgules")
+	auditPath := fs.String("audit", "", "journal d'audit JSONL (optionnel)")
+	itchFeed := fs.String("itch", "", "adresse UDP du flux ITCH (multicast ou unicast, vide : pas de flux)")
+	itchRetrans := fs.String("itch-retrans", "", "adresse TCP de retransmission ITCH")
+	fxPath := fs.String("fx", "", "fichier de taux de change, recharge a chaud (optionnel)")
+	currency := fs.String("currency", DefaultCurrency, "devise du resume de fin de session")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	accounts, err := parseTokens(*tokens)
+	if err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	var fx *FXRates
+	if *fxPath != "" {
+		if fx, err = LoadFXRates(*fxPath); err != nil {
+			return fmt.Errorf("serve: %w", err)
+		}
+	}
+
+	instruments, err := ParseInstruments(*symbols)
+	if err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	gw := NewGatewayWithInstruments(instruments, NewTradeLog(), SystemClock{})
+	if pub, err := startITCH(gw, *itchFeed, *itchRetrans, os.Stdout); err != nil {
+		return err
+	} else if pub != nil {
+		defer pub.Close()
+	}
+	if *auditPath != "" {
+		f, err := os.OpenFile(*auditPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gw.Subscribe(NewAuditTrail(f).OnEvent)
+	}
+
+	api := NewHTTPServer(gw, HTTPOptions{Tokens: accounts, AccessLog: os.Stdout})
+	defer api.Close()
+	srv := &http.Server{
+		Addr:         *addr,
+		Handler:      api.Handler(),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	if fx != nil {
+		go fx.Watch(ctx, time.Second, os.Stderr)
+	}
+
+	fmt.Printf("GME HTTP sur %s (%d comptes, Ctrl+C pour arreter)\n", *addr, len(accounts))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	gw.log.PrintSummaryIn(fx, *currency)
+	return nil
+}
+
+// parseTokens lit "token=compte,token=compte".
+func parseTokens(spec string) (map[string]string, error) {
+	accounts := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if pair == "" {
+			continue
+		}
+		token, account, ok := strings.Cut(pair, "=")
+		if !ok || token == "" || account == "" {
+			return nil, fmt.Errorf("token invalide %q (attendu token=compte)", pair)
+		}
+		accounts[token] = account
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("au moins un token requis (-tokens secret=ACC1)")
+	}
+	return accounts, nil
+}
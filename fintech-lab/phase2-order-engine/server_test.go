@@ -0,0 +1,175 @@
+// server_test.go — Tests de bout en bout de l'API HTTP (httptest).
+// Lancer avec : go test ./phase2-order-engine/ -run HTTP -v
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// apiClient envoie des requetes authentifiees a un serveur de test.
+type apiClient struct {
+	t     *testing.T
+	base  string
+	token string
+}
+
+func newTestHTTPServer(t *testing.T) (*httptest.Server, *Gateway) {
+	return newTestHTTPServerWith(t, StreamOptions{})
+}
+
+// newTestHTTPServerWith cree un serveur de test avec des options de streaming.
+func newTestHTTPServerWith(t *testing.T, stream StreamOptions) (*httptest.Server, *Gateway) {
+	t.Helper()
+	gw, _ := newTestGateway()
+	api := NewHTTPServer(gw, HTTPOptions{
+		Tokens: map[string]string{"tok-1": "ACC1", "tok-2": "ACC2"},
+		Stream: stream,
+	})
+	srv := httptest.NewServer(api.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		api.Close()
+	})
+	return srv, gw
+}
+
+// do envoie la requete et decode la reponse JSON dans out (si non nil).
+func (c apiClient) do(method, path string, body any, out any) int {
+	c.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, c.base+path, &buf)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s %s: reponse illisible: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type orderReply struct {
+	Order  orderJSON   `json:"order"`
+	Trades []tradeJSON `json:"trades"`
+	Fills  []Fill      `json:"fills"`
+	Error  *apiError   `json:"error"`
+}
+
+// TestHTTPAuth verifie l'authentification Bearer.
+func TestHTTPAuth(t *testing.T) {
+	srv, _ := newTestHTTPServer(t)
+
+	if code := (apiClient{t, srv.URL, ""}).do("GET", "/healthz", nil, nil); code != http.StatusOK {
+		t.Errorf("/healthz: attendu 200, obtenu %d", code)
+	}
+	var reply orderReply
+	if code := (apiClient{t, srv.URL, ""}).do("GET", "/trades", nil, &reply); code != http.StatusUnauthorized || reply.Error.Code != "unauthorized" {
+		t.Errorf("sans token: attendu 401/unauthorized, obtenu %d %+v", code, reply.Error)
+	}
+	if code := (apiClient{t, srv.URL, "faux"}).do("GET", "/trades", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("token invalide: attendu 401, obtenu %d", code)
+	}
+}
+
+// TestHTTPOrderLifecycle couvre soumission, matching, consultation, amend et annulation.
+func TestHTTPOrderLifecycle(t *testing.T) {
+	srv, _ := newTestHTTPServer(t)
+	acc1 := apiClient{t, srv.URL, "tok-1"}
+	acc2 := apiClient{t, srv.URL, "tok-2"}
+
+	var sell orderReply
+	code := acc1.do("POST", "/orders", map[string]any{
+		"cl_ord_id": "s-1", "symbol": "AAPL", "side": "SELL", "price": 190.00, "quantity": 100,
+	}, &sell)
+	if code != http.StatusCreated || sell.Order.Account != "ACC1" || sell.Order.Status != StatusOpen {
+		t.Fatalf("POST: attendu 201 OPEN pour ACC1, obtenu %d %+v", code, sell.Order)
+	}
+
+	var buy orderReply
+	acc2.do("POST", "/orders", map[string]any{"symbol": "AAPL", "side": "BUY", "price": 190.00, "quantity": 40}, &buy)
+	if len(buy.Trades) != 1 || buy.Trades[0].Quantity != 40 || buy.Order.Status != StatusFilled {
+		t.Fatalf("achat: attendu 1 trade x40 et FILLED, obtenu %+v", buy)
+	}
+
+	path := fmt.Sprintf("/orders/%d", sell.Order.ID)
+	var got orderReply
+	if code := acc1.do("GET", path, nil, &got); code != http.StatusOK || got.Order.Filled != 40 || len(got.Fills) != 1 {
+		t.Errorf("GET: attendu 40 executes et 1 fill, obtenu %d %+v", code, got)
+	}
+	if code := acc2.do("GET", path, nil, nil); code != http.StatusNotFound {
+		t.Errorf("GET par un autre compte: attendu 404, obtenu %d", code)
+	}
+
+	var book struct {
+		Asks []levelJSON `json:"asks"`
+	}
+	acc2.do("GET", "/books/AAPL?depth=5", nil, &book)
+	if len(book.Asks) != 1 || book.Asks[0].Quantity != 60 {
+		t.Errorf("book: attendu 1 ask x60, obtenu %+v", book.Asks)
+	}
+
+	var amended orderReply
+	if code := acc1.do("PATCH", path, map[string]any{"price": 191.00}, &amended); code != http.StatusOK || amended.Order.Price != 191.00 {
+		t.Errorf("PATCH: attendu 200 @191, obtenu %d %+v", code, amended)
+	}
+
+	var cancelled orderReply
+	if code := acc1.do("DELETE", path, nil, &cancelled); code != http.StatusOK || cancelled.Order.Status != StatusCancelled {
+		t.Errorf("DELETE: attendu 200 CANCELLED, obtenu %d %+v", code, cancelled.Order)
+	}
+	var again orderReply
+	if code := acc1.do("DELETE", path, nil, &again); code != http.StatusNotFound || again.Error.Code != "not_found" {
+		t.Errorf("second DELETE: attendu 404/not_found, obtenu %d %+v", code, again.Error)
+	}
+
+	var trades []tradeJSON
+	acc1.do("GET", "/trades?symbol=AAPL", nil, &trades)
+	if len(trades) != 1 || trades[0].Price != 190.00 {
+		t.Errorf("trades: attendu 1 trade @190, obtenu %+v", trades)
+	}
+}
+
+// TestHTTPErrors verifie la traduction des erreurs du Gateway.
+func TestHTTPErrors(t *testing.T) {
+	srv, gw := newTestHTTPServer(t)
+	acc1 := apiClient{t, srv.URL, "tok-1"}
+
+	var reply orderReply
+	code := acc1.do("POST", "/orders", map[string]any{"symbol": "AAPL", "side": "BUY", "price": -5, "quantity": 10}, &reply)
+	if code != http.StatusBadRequest || reply.Error.Code != "validation_error" || reply.Error.Field != "price" || reply.Error.OrderID == 0 {
+		t.Errorf("prix invalide: attendu 400 validation_error/price avec order_id, obtenu %d %+v", code, reply.Error)
+	}
+
+	code = acc1.do("POST", "/orders", map[string]any{"symbol": "AAPL", "side": "BUY", "qty": 10}, &reply)
+	if code != http.StatusBadRequest || reply.Error.Code != "invalid_json" {
+		t.Errorf("champ inconnu: attendu 400 invalid_json, obtenu %d %+v", code, reply.Error)
+	}
+
+	gw.Kill("ACC1", "test")
+	code = acc1.do("POST", "/orders", map[string]any{"symbol": "AAPL", "side": "BUY", "price": 189, "quantity": 10}, &reply)
+	if code != http.StatusForbidden || reply.Error.Code != "kill_switch" {
+		t.Errorf("kill switch: attendu 403, obtenu %d %+v", code, reply.Error)
+	}
+
+	if code := acc1.do("GET", "/books/XXX", nil, nil); code != http.StatusNotFound {
+		t.Errorf("symbole inconnu: attendu 404, obtenu %d", code)
+	}
+	if code := acc1.do("GET", "/books/AAPL?depth=0", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("depth=0: attendu 400, obtenu %d", code)
+	}
+}
@@ -0,0 +1,369 @@
+// stats.go — Statistiques de marche par symbole construites depuis les trades :
+// ticker de session (open/high/low/last/volume/VWAP) et bougies OHLCV.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Ticker — statistiques de session d'un symbole
+// ---------------------------------------------------------------------------
+
+// Ticker resume la session d'un seul symbole.
+// Le VWAP n'a de sens que par instrument : c'est ici qu'il faut le lire.
+type Ticker struct {
+	Symbol   string
+	Currency string  // Devise des prix et du notionnel
+	Open     float64 // Prix du premier trade de la session
+	High     float64
+	Low      float64
+	Last     float64 // Prix du dernier trade
+	Volume   int64
+	Notional float64
+	Trades   int
+}
+
+// add integre un trade du symbole.
+func (tk *Ticker) add(t Trade) {
+	if tk.Trades == 0 {
+		tk.Open, tk.High, tk.Low = t.Price, t.Price, t.Price
+	}
+	if t.Price > tk.High {
+		tk.High = t.Price
+	}
+	if t.Price < tk.Low {
+		tk.Low = t.Price
+	}
+	tk.Last = t.Price
+	tk.Volume += t.Quantity
+	tk.Notional += t.Notional()
+	tk.Trades++
+}
+
+// VWAP retourne le prix moyen pondere par le volume du symbole.
+func (tk Ticker) VWAP() float64 {
+	if tk.Volume == 0 {
+		return 0
+	}
+	return tk.Notional / float64(tk.Volume)
+}
+
+// String implemente fmt.Stringer.
+func (tk Ticker) String() string {
+	return fmt.Sprintf("%-5s %s O=%.2f H=%.2f L=%.2f C=%.2f | vol=%d trades=%d VWAP=%.4f",
+		tk.Symbol, tk.Currency, tk.Open, tk.High, tk.Low, tk.Last, tk.Volume, tk.Trades, tk.VWAP())
+}
+
+// ---------------------------------------------------------------------------
+// Bar — bougie OHLCV sur un intervalle de temps
+// ---------------------------------------------------------------------------
+
+// Bar agrege les trades d'un symbole sur [Start, Start+Interval).
+type Bar struct {
+	Symbol   string
+	Start    int64 // Unix nanoseconds, aligne sur un multiple de Interval
+	Interval time.Duration
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   int64
+	Notional float64
+	Trades   int
+
+	last int64 // Timestamp du dernier trade integre (celui de Close)
+}
+
+// VWAP retourne le prix moyen pondere par le volume de la bougie.
+func (b Bar) VWAP() float64 {
+	if b.Volume == 0 {
+		return 0
+	}
+	return b.Notional / float64(b.Volume)
+}
+
+// String implemente fmt.Stringer.
+func (b Bar) String() string {
+	return fmt.Sprintf("BAR %s %s/%v O=%.2f H=%.2f L=%.2f C=%.2f V=%d",
+		b.Symbol, time.Unix(0, b.Start).UTC().Format("15:04:05"), b.Interval,
+		b.Open, b.High, b.Low, b.Close, b.Volume)
+}
+
+// BarHandler recoit chaque bougie cloturee. Une bougie deja publiee peut
+// revenir, meme Symbol et Start, apres un trade en retard, un bust ou une
+// correction : elle remplace la precedente (Trades == 0 : bougie supprimee).
+type BarHandler func(Bar)
+
+// barKey identifie une bougie : symbole et debut de bucket.
+type barKey struct {
+	symbol string
+	start  int64
+}
+
+// BarBuilder construit des bougies OHLCV a intervalle fixe depuis le flux de
+// trades, pour tous les symboles. Une bougie est cloturee (et publiee) quand
+// arrive le premier trade du bucket suivant, ou par Flush en fin de session.
+// Les intervalles sans trade ne produisent pas de bougie.
+//
+// Un trade plus ancien que la bougie en cours va dans la bougie de son
+// bucket, deja cloturee ou creee pour lui, republiee. Un trade BUSTED ou
+// CORRECTED (notifie a nouveau par le TradeLog, voir correction.go) sort
+// de sa bougie, recalculee depuis les trades vivants qui restent. Un trade
+// anterieur au dernier de la bougie en cours (correction d'un trade plus
+// ancien) la fait recalculer dans l'ordre des timestamps.
+//
+// Recalculer une bougie demande ses trades : ils sont gardes pendant la
+// retention (DefaultBarRetention, voir SetRetention) derriere la bougie la
+// plus recente du symbole, puis oublies. Au-dela, la bougie est figee : un
+// trade en retard, un bust ou une correction ne la changent plus.
+//
+// Branchement typique : log.OnTrade(builder.OnTrade)
+type BarBuilder struct {
+	mu       sync.Mutex
+	interval time.Duration
+	current  map[string]*Bar    // symbol -> bougie en cours
+	closed   map[string][]Bar   // symbol -> bougies cloturees, par Start croissant
+	trades   map[barKey][]Trade // trades vivants des bougies encore revisables
+	keep     time.Duration      // retention des trades derriere la bougie la plus recente
+	subs     []BarHandler
+}
+
+// DefaultBarRetention est la retention par defaut des trades d'un BarBuilder.
+const DefaultBarRetention = time.Hour
+
+// NewBarBuilder cree un constructeur de bougies a l'intervalle donne (ex: time.Minute).
+func NewBarBuilder(interval time.Duration) *BarBuilder {
+	if interval <= 0 {
+		panic("BarBuilder: intervalle doit etre > 0")
+	}
+	return &BarBuilder{
+		interval: interval,
+		current:  make(map[string]*Bar),
+		closed:   make(map[string][]Bar),
+		trades:   make(map[barKey][]Trade),
+		keep:     DefaultBarRetention,
+	}
+}
+
+// SetRetention fixe combien de temps, derriere la bougie la plus recente
+// d'un symbole, une bougie reste revisable (voir BarBuilder). 0 : seule la
+// bougie en cours l'est.
+func (bb *BarBuilder) SetRetention(d time.Duration) {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	bb.keep = d
+}
+
+// Subscribe enregistre un abonne au flux de bougies cloturees.
+func (bb *BarBuilder) Subscribe(h BarHandler) {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	bb.subs = append(bb.subs, h)
+}
+
+// OnTrade integre un trade. Signature compatible TradeHandler.
+func (bb *BarBuilder) OnTrade(t Trade) {
+	bb.mu.Lock()
+	key := barKey{t.Symbol, t.Timestamp - t.Timestamp%int64(bb.interval)}
+
+	var done []Bar
+	cur, ok := bb.current[t.Symbol]
+	switch {
+	case bb.expired(key):
+		// Bougie figee : ses trades sont oublies, elle ne peut plus changer.
+	case !t.IsLive():
+		trades := bb.trades[key]
+		for i := range trades {
+			if trades[i].ID == t.ID {
+				bb.trades[key] = append(trades[:i], trades[i+1:]...)
+				done = bb.revise(key)
+				break
+			}
+		}
+	case ok && key.start < cur.Start, !ok && bb.isClosed(key):
+		// En retard : la bougie de son bucket est deja partie.
+		bb.trades[key] = append(bb.trades[key], t)
+		done = bb.revise(key)
+	case ok && key.start == cur.Start && t.Timestamp < cur.last:
+		// Anterieur au Close de la bougie en cours (correction d'un trade
+		// plus ancien) : elle est recalculee dans l'ordre des timestamps.
+		bb.trades[key] = append(bb.trades[key], t)
+		done = bb.revise(key)
+	default:
+		if ok && key.start > cur.Start {
+			done = append(done, bb.close(t.Symbol))
+			ok = false
+		}
+		if !ok {
+			cur = &Bar{
+				Symbol:   t.Symbol,
+				Start:    key.start,
+				Interval: bb.interval,
+				Open:     t.Price,
+				High:     t.Price,
+				Low:      t.Price,
+			}
+			bb.current[t.Symbol] = cur
+			bb.evict(t.Symbol)
+		}
+		cur.add(t)
+		bb.trades[key] = append(bb.trades[key], t)
+	}
+	subs := bb.subs
+	bb.mu.Unlock()
+
+	publishBars(subs, done)
+}
+
+// add integre un trade en fin de bougie.
+func (b *Bar) add(t Trade) {
+	if t.Price > b.High {
+		b.High = t.Price
+	}
+	if t.Price < b.Low {
+		b.Low = t.Price
+	}
+	b.Close = t.Price
+	b.Volume += t.Quantity
+	b.Notional += t.Notional()
+	b.Trades++
+	b.last = t.Timestamp
+}
+
+// isClosed indique si le bucket de key est au plus celui de la derniere
+// bougie cloturee du symbole. Appele avec bb.mu tenu.
+func (bb *BarBuilder) isClosed(key barKey) bool {
+	closed := bb.closed[key.symbol]
+	return len(closed) > 0 && key.start <= closed[len(closed)-1].Start
+}
+
+// expired indique si le bucket de key est sorti de la retention : ses
+// trades sont oublies. Appele avec bb.mu tenu.
+func (bb *BarBuilder) expired(key barKey) bool {
+	latest, ok := bb.latest(key.symbol)
+	return ok && key.start < latest-int64(bb.keep)
+}
+
+// latest retourne le debut de la bougie la plus recente d'un symbole, en
+// cours ou cloturee. Appele avec bb.mu tenu.
+func (bb *BarBuilder) latest(symbol string) (int64, bool) {
+	if cur, ok := bb.current[symbol]; ok {
+		return cur.Start, true
+	}
+	if closed := bb.closed[symbol]; len(closed) > 0 {
+		return closed[len(closed)-1].Start, true
+	}
+	return 0, false
+}
+
+// revise recalcule la bougie de key depuis ses trades vivants, dans l'ordre
+// des timestamps. Une bougie cloturee modifiee est retournee pour etre
+// republiee ; une bougie en cours est mise a jour sur place. Appele avec
+// bb.mu tenu.
+func (bb *BarBuilder) revise(key barKey) []Bar {
+	trades := bb.trades[key]
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].Timestamp < trades[j].Timestamp })
+	b := Bar{Symbol: key.symbol, Start: key.start, Interval: bb.interval}
+	for i, t := range trades {
+		if i == 0 {
+			b.Open, b.High, b.Low = t.Price, t.Price, t.Price
+		}
+		b.add(t)
+	}
+	if len(trades) == 0 {
+		delete(bb.trades, key)
+	}
+
+	if cur, ok := bb.current[key.symbol]; ok && cur.Start == key.start {
+		if len(trades) == 0 {
+			delete(bb.current, key.symbol)
+		} else {
+			*cur = b
+		}
+		return nil
+	}
+	closed := bb.closed[key.symbol]
+	i := sort.Search(len(closed), func(i int) bool { return closed[i].Start >= key.start })
+	switch {
+	case i < len(closed) && closed[i].Start == key.start && len(trades) == 0:
+		bb.closed[key.symbol] = append(closed[:i], closed[i+1:]...)
+	case i < len(closed) && closed[i].Start == key.start:
+		closed[i] = b
+	case len(trades) > 0:
+		bb.closed[key.symbol] = slices.Insert(closed, i, b)
+	default:
+		return nil // Aucune bougie a retirer
+	}
+	return []Bar{b}
+}
+
+// Flush cloture toutes les bougies en cours (fin de session) et les publie.
+func (bb *BarBuilder) Flush() {
+	bb.mu.Lock()
+	symbols := make([]string, 0, len(bb.current))
+	for symbol := range bb.current {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	var done []Bar
+	for _, symbol := range symbols {
+		done = append(done, bb.close(symbol))
+	}
+	subs := bb.subs
+	bb.mu.Unlock()
+
+	publishBars(subs, done)
+}
+
+// close deplace la bougie en cours d'un symbole vers l'historique.
+// Appele avec bb.mu tenu.
+func (bb *BarBuilder) close(symbol string) Bar {
+	b := *bb.current[symbol]
+	delete(bb.current, symbol)
+	bb.closed[symbol] = append(bb.closed[symbol], b)
+	return b
+}
+
+// evict oublie les trades des bougies d'un symbole sorties de la retention.
+// Appele avec bb.mu tenu, apres chaque nouvelle bougie.
+func (bb *BarBuilder) evict(symbol string) {
+	for key := range bb.trades {
+		if key.symbol == symbol && bb.expired(key) {
+			delete(bb.trades, key)
+		}
+	}
+}
+
+// publishBars notifie les abonnes hors verrou : un abonne lent ne bloque pas
+// OnTrade sur les autres symboles.
+func publishBars(subs []BarHandler, bars []Bar) {
+	for _, b := range bars {
+		for _, h := range subs {
+			h(b)
+		}
+	}
+}
+
+// Bars retourne les bougies cloturees d'un symbole, de la plus ancienne a la plus recente.
+func (bb *BarBuilder) Bars(symbol string) []Bar {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	return append([]Bar(nil), bb.closed[symbol]...)
+}
+
+// Current retourne la bougie en cours (non cloturee) d'un symbole.
+func (bb *BarBuilder) Current(symbol string) (Bar, bool) {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	cur, ok := bb.current[symbol]
+	if !ok {
+		return Bar{}, false
+	}
+	return *cur, true
+}
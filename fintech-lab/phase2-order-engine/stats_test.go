@@ -0,0 +1,202 @@
+// stats_test.go — Tests des tickers par symbole et des bougies OHLCV.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTickerPerSymbol verifie que le VWAP ne melange pas les symboles.
+func TestTickerPerSymbol(t *testing.T) {
+	gw, log := newTestGateway()
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 192.00, 100))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 192.00, 200))
+	mustSubmit(t, gw, NewLimitOrder("MSFT", Sell, 400.00, 10))
+	mustSubmit(t, gw, NewLimitOrder("MSFT", Buy, 400.00, 10))
+
+	aapl, ok := log.Ticker("AAPL")
+	if !ok {
+		t.Fatal("ticker AAPL absent")
+	}
+	if aapl.Open != 190.00 || aapl.High != 192.00 || aapl.Low != 190.00 || aapl.Last != 192.00 {
+		t.Errorf("OHLC AAPL inattendu: %v", aapl)
+	}
+	if aapl.Volume != 200 || aapl.Trades != 2 {
+		t.Errorf("volume/trades AAPL: attendu 200/2, obtenu %d/%d", aapl.Volume, aapl.Trades)
+	}
+	if aapl.VWAP() != 191.00 {
+		t.Errorf("VWAP AAPL: attendu 191.00, obtenu %.4f", aapl.VWAP())
+	}
+
+	msft, _ := log.Ticker("MSFT")
+	if msft.VWAP() != 400.00 {
+		t.Errorf("VWAP MSFT: attendu 400.00, obtenu %.4f", msft.VWAP())
+	}
+	if _, ok := log.Ticker("TSLA"); ok {
+		t.Error("ticker TSLA inattendu: aucun trade")
+	}
+}
+
+// TestBarBuilder verifie le decoupage en bougies et leur publication.
+func TestBarBuilder(t *testing.T) {
+	log := NewTradeLog()
+	bb := NewBarBuilder(time.Minute)
+	log.OnTrade(bb.OnTrade)
+
+	var published []Bar
+	bb.Subscribe(func(b Bar) { published = append(published, b) })
+
+	base := time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC).UnixNano()
+	sec := int64(time.Second)
+	log.AddAll([]Trade{
+		{Symbol: "AAPL", Price: 190.00, Quantity: 100, Timestamp: base + 1*sec},
+		{Symbol: "AAPL", Price: 191.50, Quantity: 50, Timestamp: base + 20*sec},
+		{Symbol: "AAPL", Price: 189.50, Quantity: 50, Timestamp: base + 59*sec},
+		{Symbol: "MSFT", Price: 400.00, Quantity: 10, Timestamp: base + 30*sec},
+		{Symbol: "AAPL", Price: 190.25, Quantity: 10, Timestamp: base + 61*sec},
+	})
+
+	if len(published) != 1 {
+		t.Fatalf("attendu 1 bougie cloturee, obtenu %d", len(published))
+	}
+	b := published[0]
+	if b.Symbol != "AAPL" || b.Start != base {
+		t.Errorf("bougie mal placee: %v", b)
+	}
+	if b.Open != 190.00 || b.High != 191.50 || b.Low != 189.50 || b.Close != 189.50 || b.Volume != 200 {
+		t.Errorf("OHLCV inattendu: %v", b)
+	}
+
+	if cur, ok := bb.Current("AAPL"); !ok || cur.Start != base+60*sec || cur.Volume != 10 {
+		t.Errorf("bougie en cours inattendue: %v", cur)
+	}
+
+	bb.Flush()
+	if len(published) != 3 {
+		t.Fatalf("apres Flush: attendu 3 bougies, obtenu %d", len(published))
+	}
+	if got := len(bb.Bars("AAPL")); got != 2 {
+		t.Errorf("historique AAPL: attendu 2 bougies, obtenu %d", got)
+	}
+}
+
+// TestBarBuilderRevisions verifie qu'un trade en retard rejoint la bougie
+// de son bucket et qu'un bust ou une correction la recalcule.
+func TestBarBuilderRevisions(t *testing.T) {
+	log := NewTradeLog()
+	bb := NewBarBuilder(time.Minute)
+	log.OnTrade(bb.OnTrade)
+	var published []Bar
+	bb.Subscribe(func(b Bar) { published = append(published, b) })
+
+	base := time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC).UnixNano()
+	minute := int64(time.Minute)
+	log.AddAll([]Trade{
+		{ID: 1, Symbol: "AAPL", Price: 190, Quantity: 100, Timestamp: base + 10},
+		{ID: 2, Symbol: "AAPL", Price: 192, Quantity: 50, Timestamp: base + 20},
+		{ID: 3, Symbol: "AAPL", Price: 195, Quantity: 10, Timestamp: base + 2*minute},
+	})
+	if len(published) != 1 {
+		t.Fatalf("attendu 1 bougie cloturee, obtenu %d", len(published))
+	}
+
+	// En retard : l'un dans la bougie cloturee (en tete par son timestamp),
+	// l'autre dans le bucket sans bougie entre les deux.
+	log.Add(Trade{ID: 4, Symbol: "AAPL", Price: 185, Quantity: 5, Timestamp: base + 5})
+	log.Add(Trade{ID: 5, Symbol: "AAPL", Price: 188, Quantity: 1, Timestamp: base + minute + 1})
+	if cur, _ := bb.Current("AAPL"); cur.Start != base+2*minute || cur.Volume != 10 {
+		t.Errorf("bougie en cours touchee par un trade en retard: %v", cur)
+	}
+	bars := bb.Bars("AAPL")
+	if len(bars) != 2 || bars[0].Open != 185 || bars[0].Low != 185 || bars[0].Close != 192 || bars[0].Volume != 155 ||
+		bars[1].Start != base+minute || bars[1].Volume != 1 {
+		t.Fatalf("bougies apres trades en retard: %v", bars)
+	}
+	if len(published) != 3 || published[1].Volume != 155 || published[2].Start != base+minute {
+		t.Errorf("republications: %v", published)
+	}
+
+	// Bust du plus haut, correction du dernier trade de la bougie en cours.
+	if _, err := log.bust(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := log.correct(3, 6, 196, 20); err != nil {
+		t.Fatal(err)
+	}
+	if b := bb.Bars("AAPL")[0]; b.High != 190 || b.Close != 190 || b.Volume != 105 || b.Trades != 2 {
+		t.Errorf("bougie apres bust: %v", b)
+	}
+	if last := published[len(published)-1]; last.Start != base || last.Volume != 105 {
+		t.Errorf("bougie bustee non republiee: %v", last)
+	}
+	if cur, _ := bb.Current("AAPL"); cur.Close != 196 || cur.Volume != 20 || cur.Trades != 1 {
+		t.Errorf("bougie en cours apres correction: %v", cur)
+	}
+
+	// Bust du seul trade d'une bougie cloturee : elle disparait.
+	log.bust(5)
+	if bars := bb.Bars("AAPL"); len(bars) != 1 || published[len(published)-1].Trades != 0 {
+		t.Errorf("bougie vide: %v, publiee %v", bars, published[len(published)-1])
+	}
+}
+
+// TestBarBuilderCorrectEarlierTrade corrige le premier trade de la bougie en
+// cours : la correction y reprend sa place, pas celle du Close.
+func TestBarBuilderCorrectEarlierTrade(t *testing.T) {
+	log := NewTradeLog()
+	bb := NewBarBuilder(time.Minute)
+	log.OnTrade(bb.OnTrade)
+
+	base := time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC).UnixNano()
+	log.AddAll([]Trade{
+		{ID: 1, Symbol: "AAPL", Price: 100, Quantity: 10, Timestamp: base + 1},
+		{ID: 2, Symbol: "AAPL", Price: 101, Quantity: 10, Timestamp: base + 2},
+		{ID: 3, Symbol: "AAPL", Price: 102, Quantity: 10, Timestamp: base + 3},
+	})
+	if _, _, err := log.correct(1, 4, 99, 10); err != nil {
+		t.Fatal(err)
+	}
+	if cur, _ := bb.Current("AAPL"); cur.Open != 99 || cur.Close != 102 || cur.Low != 99 || cur.Volume != 30 || cur.Trades != 3 {
+		t.Errorf("bougie en cours apres correction du premier trade: %v", cur)
+	}
+
+	// Un trade qui arrive ensuite reste le Close.
+	log.Add(Trade{ID: 5, Symbol: "AAPL", Price: 103, Quantity: 10, Timestamp: base + 4})
+	if cur, _ := bb.Current("AAPL"); cur.Open != 99 || cur.Close != 103 || cur.Trades != 4 {
+		t.Errorf("bougie en cours apres un nouveau trade: %v", cur)
+	}
+}
+
+// TestBarBuilderRetention verifie que les trades des bougies sorties de la
+// retention sont oublies et que ces bougies ne changent plus.
+func TestBarBuilderRetention(t *testing.T) {
+	log := NewTradeLog()
+	bb := NewBarBuilder(time.Minute)
+	bb.SetRetention(time.Minute)
+	log.OnTrade(bb.OnTrade)
+
+	base := time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC).UnixNano()
+	minute := int64(time.Minute)
+	for i := int64(0); i < 10; i++ {
+		log.Add(Trade{ID: uint64(i + 1), Symbol: "AAPL", Price: 100, Quantity: 10, Timestamp: base + i*minute})
+	}
+	if len(bb.trades) != 2 {
+		t.Errorf("trades retenus pour %d bougies, attendu 2 (en cours + retention)", len(bb.trades))
+	}
+
+	// Hors retention : bust et trade en retard ignores.
+	log.bust(1)
+	log.Add(Trade{ID: 11, Symbol: "AAPL", Price: 50, Quantity: 10, Timestamp: base + 1})
+	if b := bb.Bars("AAPL")[0]; b.Low != 100 || b.Volume != 10 || b.Trades != 1 {
+		t.Errorf("bougie figee modifiee: %v", b)
+	}
+
+	// Dans la retention : la bougie precedente est encore revisee.
+	log.bust(9)
+	if bars := bb.Bars("AAPL"); len(bars) != 8 {
+		t.Errorf("bougie bustee dans la retention: %d bougies, attendu 8", len(bars))
+	}
+}
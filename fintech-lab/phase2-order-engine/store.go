@@ -0,0 +1,626 @@
+// store.go — Persistance des trades : interface TradeSink et premier
+// backend, un journal append-only segmente sur disque, indexe par symbole,
+// par temps et par ordre.
+//
+// FORMAT D'UN RECORD (little endian) :
+//
+//   [len u32][crc32 u32][payload]
+//   payload = ID u64 | BuyOrderID u64 | SellOrderID u64 | Price f64 |
+//             Quantity i64 | Timestamp i64 | len(Symbol) u8 | Symbol |
+//             len(Currency) u8 | Currency
+//
+// La devise est optionnelle a la lecture : un record ecrit avant les
+// instruments (payload qui s'arrete apres Symbol) est en devise par defaut.
+//
+// RECORD DE STATUT (bust, correction), plus court que tout record de trade :
+//
+//   payload = ID u64 | Status u8 | Corrects u64 | CorrectedBy u64
+//
+// Il s'applique au trade ID deja ecrit ; a la reouverture, les statuts sont
+// rejoues sur les trades relus. Une correction ecrit le record de statut de
+// l'original (CORRECTED, CorrectedBy), puis le nouveau trade suivi d'un
+// record de statut qui porte son lien Corrects.
+//
+// Le CRC detecte un record tronque par un crash : a la reouverture, le
+// dernier segment est coupe juste apres le dernier record valide.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// TradeSink — point d'extension du TradeLog
+// ---------------------------------------------------------------------------
+
+// TradeSink recoit les trades du TradeLog pour les publier ou les persister.
+// Un trade deja transmis revient apres un bust ou une correction, meme ID,
+// avec son nouveau Status (IsLive() faux) ; le trade de correction arrive
+// ensuite avec Corrects renseigne.
+//
+// Append est appele sur le thread de matching : il doit seulement mettre le
+// lot en file (copie), jamais faire d'I/O. Un trade est ACQUITTE quand un
+// Sync() posterieur retourne nil : il survit alors a un crash du process.
+type TradeSink interface {
+	Append(trades []Trade) error
+	Sync() error
+	Close() error // Vide la file, rend tout durable, libere les ressources
+}
+
+// ErrStoreClosed est retourne par Append apres Close.
+var ErrStoreClosed = errors.New("trade store ferme")
+
+// ---------------------------------------------------------------------------
+// FileTradeStore
+// ---------------------------------------------------------------------------
+
+// StoreOptions regle le FileTradeStore. Les zeros prennent les defauts.
+type StoreOptions struct {
+	SegmentSize int64 // Rotation quand un segment depasserait cette taille (defaut 64 MiB)
+	MaxBatch    int   // Trades max par ecriture + fsync (defaut 4096)
+	QueueSize   int   // Lots en attente avant backpressure sur Append (defaut 1024)
+}
+
+// tradeLoc localise un record sur disque.
+type tradeLoc struct {
+	ts  int64
+	seg int
+	off int64
+}
+
+// FileTradeStore ecrit les trades dans des segments "00000001.seg", ...
+// Une seule goroutine ecrit : elle regroupe les lots en attente, ecrit,
+// fsync, puis indexe. Les index sont en memoire et reconstruits a l'ouverture.
+type FileTradeStore struct {
+	dir  string
+	opts StoreOptions
+	in   chan []Trade
+	done chan struct{}
+
+	sendMu sync.Mutex // serialise Append/Close (pas d'envoi sur channel ferme)
+	closed bool
+
+	// Etat de la goroutine d'ecriture (pas de verrou : elle seule y touche).
+	active     *os.File
+	activeID   int
+	activeSize int64
+
+	mu       sync.RWMutex
+	cond     *sync.Cond            // signale l'avancement de durable ; Locker = mu
+	segs     map[int]*os.File      // handles de lecture par segment
+	bySymbol map[string][]tradeLoc // tries par timestamp
+	byOrder  map[uint64][]tradeLoc
+	status   map[uint64]tradeStatus // tradeID -> dernier statut (bust, correction)
+	queued   uint64                 // trades recus par Append
+	durable  uint64                 // trades ecrits + fsync
+	err      error                  // premiere erreur d'ecriture (collante)
+}
+
+// OpenFileTradeStore ouvre (ou cree) un store dans dir et reconstruit ses index.
+func OpenFileTradeStore(dir string, opts StoreOptions) (*FileTradeStore, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 64 << 20
+	}
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 4096
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("trade store: %w", err)
+	}
+
+	s := &FileTradeStore{
+		dir:      dir,
+		opts:     opts,
+		in:       make(chan []Trade, opts.QueueSize),
+		done:     make(chan struct{}),
+		segs:     make(map[int]*os.File),
+		bySymbol: make(map[string][]tradeLoc),
+		byOrder:  make(map[uint64][]tradeLoc),
+		status:   make(map[uint64]tradeStatus),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		size, err := s.recover(id, i == len(ids)-1)
+		if err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+		s.activeID, s.activeSize = id, size
+	}
+	if len(ids) == 0 {
+		s.activeID = 1
+	}
+	if err := s.openActive(); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Append met un lot en file d'ecriture. Bloque seulement si la file est pleine.
+func (s *FileTradeStore) Append(trades []Trade) error {
+	if len(trades) == 0 {
+		return nil
+	}
+	batch := append([]Trade(nil), trades...) // l'appelant peut reutiliser son slice
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.mu.Lock()
+	s.queued += uint64(len(batch))
+	s.mu.Unlock()
+	s.in <- batch
+	return nil
+}
+
+// Sync attend que tous les trades recus avant l'appel soient durables.
+func (s *FileTradeStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := s.queued
+	for s.durable < target && s.err == nil {
+		s.cond.Wait()
+	}
+	return s.err
+}
+
+// Close vide la file d'ecriture, fsync, et ferme les fichiers.
+// Les requetes ne sont plus possibles ensuite.
+func (s *FileTradeStore) Close() error {
+	s.sendMu.Lock()
+	if s.closed {
+		s.sendMu.Unlock()
+		return ErrStoreClosed
+	}
+	s.closed = true
+	close(s.in)
+	s.sendMu.Unlock()
+
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	if cerr := s.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ---------------------------------------------------------------------------
+// Requetes
+// ---------------------------------------------------------------------------
+
+// BySymbol retourne les trades durables d'un symbole avec from <= Timestamp < to,
+// tries par timestamp.
+func (s *FileTradeStore) BySymbol(symbol string, from, to int64) ([]Trade, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	locs := s.bySymbol[symbol]
+	i := sort.Search(len(locs), func(i int) bool { return locs[i].ts >= from })
+	var out []Trade
+	for ; i < len(locs) && locs[i].ts < to; i++ {
+		t, err := s.read(locs[i])
+		if err != nil {
+			return out, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// ByOrder retourne les trades durables ou l'ordre apparait, d'un cote ou de l'autre.
+func (s *FileTradeStore) ByOrder(orderID uint64) ([]Trade, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []Trade
+	for _, loc := range s.byOrder[orderID] {
+		t, err := s.read(loc)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// read relit un record. Appele avec s.mu tenu (au moins en lecture).
+func (s *FileTradeStore) read(loc tradeLoc) (Trade, error) {
+	f, ok := s.segs[loc.seg]
+	if !ok {
+		return Trade{}, fmt.Errorf("trade store: segment %d inconnu", loc.seg)
+	}
+	var hdr [8]byte
+	if _, err := f.ReadAt(hdr[:], loc.off); err != nil {
+		return Trade{}, fmt.Errorf("trade store: lecture %d@%d: %w", loc.seg, loc.off, err)
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(hdr[0:4]))
+	if _, err := f.ReadAt(payload, loc.off+8); err != nil {
+		return Trade{}, fmt.Errorf("trade store: lecture %d@%d: %w", loc.seg, loc.off, err)
+	}
+	t, err := decodeTrade(payload)
+	if st, ok := s.status[t.ID]; ok {
+		st.apply(&t)
+	}
+	return t, err
+}
+
+// ---------------------------------------------------------------------------
+// Goroutine d'ecriture
+// ---------------------------------------------------------------------------
+
+// run regroupe les lots en attente (jusqu'a MaxBatch trades) et les ecrit
+// en une seule operation + fsync. Se termine quand Close ferme la file.
+func (s *FileTradeStore) run() {
+	defer close(s.done)
+	for batch := range s.in {
+	drain:
+		for len(batch) < s.opts.MaxBatch {
+			select {
+			case more, ok := <-s.in:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, more...)
+			default:
+				break drain
+			}
+		}
+		s.write(batch)
+	}
+}
+
+// write ecrit un lot, en changeant de segment si necessaire, puis l'indexe.
+func (s *FileTradeStore) write(batch []Trade) {
+	var err error
+	buf := make([]byte, 0, len(batch)*64)
+	locs := make([]tradeLoc, 0, len(batch))
+	for _, t := range batch {
+		var rec []byte
+		if t.IsLive() {
+			rec = encodeTrade(t)
+		}
+		if st := statusOf(t); st != (tradeStatus{}) {
+			rec = append(rec, encodeStatus(t.ID, st)...)
+		}
+		pending := s.activeSize + int64(len(buf))
+		if pending > 0 && pending+int64(len(rec)) > s.opts.SegmentSize {
+			if err = s.flush(buf); err != nil {
+				break
+			}
+			buf = buf[:0]
+			if err = s.rotate(); err != nil {
+				break
+			}
+		}
+		locs = append(locs, tradeLoc{ts: t.Timestamp, seg: s.activeID, off: s.activeSize + int64(len(buf))})
+		buf = append(buf, rec...)
+	}
+	if err == nil {
+		err = s.flush(buf)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+	} else {
+		for i, t := range batch {
+			if t.IsLive() {
+				s.index(t, locs[i])
+			}
+			if st := statusOf(t); st != (tradeStatus{}) {
+				s.status[t.ID] = st
+			}
+		}
+		s.durable += uint64(len(batch))
+	}
+	s.cond.Broadcast()
+}
+
+// flush ecrit buf a la fin du segment actif et fsync.
+func (s *FileTradeStore) flush(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	if _, err := s.active.Write(buf); err != nil {
+		return fmt.Errorf("trade store: ecriture segment %d: %w", s.activeID, err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("trade store: fsync segment %d: %w", s.activeID, err)
+	}
+	s.activeSize += int64(len(buf))
+	return nil
+}
+
+// rotate ferme le segment actif et en ouvre un nouveau.
+func (s *FileTradeStore) rotate() error {
+	if err := s.active.Close(); err != nil {
+		return fmt.Errorf("trade store: fermeture segment %d: %w", s.activeID, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activeID++
+	s.activeSize = 0
+	return s.openActive()
+}
+
+// ---------------------------------------------------------------------------
+// Ouverture et recuperation
+// ---------------------------------------------------------------------------
+
+func (s *FileTradeStore) segmentPath(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d.seg", id))
+}
+
+// segmentIDs liste les segments existants, tries.
+func (s *FileTradeStore) segmentIDs() ([]int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.seg"))
+	if err != nil {
+		return nil, fmt.Errorf("trade store: %w", err)
+	}
+	ids := make([]int, 0, len(paths))
+	for _, p := range paths {
+		var id int
+		if _, err := fmt.Sscanf(filepath.Base(p), "%08d.seg", &id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// recover relit un segment et l'indexe. Un record invalide en fin du
+// DERNIER segment est une ecriture interrompue par un crash (jamais
+// acquittee) : il est tronque. Ailleurs, c'est une corruption.
+func (s *FileTradeStore) recover(id int, last bool) (int64, error) {
+	path := s.segmentPath(id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("trade store: %w", err)
+	}
+
+	var off int64
+	for off < int64(len(data)) {
+		p, n, err := decodeRecord(data[off:])
+		var t Trade
+		if err == nil && len(p) != statusPayloadSize {
+			t, err = decodeTrade(p)
+		}
+		if err != nil {
+			if !last {
+				return 0, fmt.Errorf("trade store: segment %d corrompu a l'offset %d: %w", id, off, err)
+			}
+			if err := os.Truncate(path, off); err != nil {
+				return 0, fmt.Errorf("trade store: troncature segment %d: %w", id, err)
+			}
+			break
+		}
+		if len(p) == statusPayloadSize {
+			s.status[binary.LittleEndian.Uint64(p)] = decodeStatus(p)
+		} else {
+			s.index(t, tradeLoc{ts: t.Timestamp, seg: id, off: off})
+		}
+		off += int64(n)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("trade store: %w", err)
+	}
+	s.segs[id] = f
+	return off, nil
+}
+
+// openActive ouvre le segment actif en ecriture (et en lecture pour les requetes).
+// Appele avec s.mu tenu, ou avant le demarrage de la goroutine d'ecriture.
+func (s *FileTradeStore) openActive() error {
+	path := s.segmentPath(s.activeID)
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("trade store: %w", err)
+	}
+	if _, ok := s.segs[s.activeID]; !ok {
+		r, err := os.Open(path)
+		if err != nil {
+			w.Close()
+			return fmt.Errorf("trade store: %w", err)
+		}
+		s.segs[s.activeID] = r
+	}
+	s.active = w
+	return nil
+}
+
+// closeFiles ferme tous les handles. Appele avec s.mu tenu.
+func (s *FileTradeStore) closeFiles() error {
+	var err error
+	if s.active != nil {
+		err = s.active.Close()
+		s.active = nil
+	}
+	for id, f := range s.segs {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		delete(s.segs, id)
+	}
+	return err
+}
+
+// index ajoute un record aux index. Appele avec s.mu tenu.
+// Les trades arrivent quasi tries par temps : insertion en fin dans le cas normal.
+func (s *FileTradeStore) index(t Trade, loc tradeLoc) {
+	locs := s.bySymbol[t.Symbol]
+	i := len(locs)
+	if i > 0 && locs[i-1].ts > loc.ts {
+		i = sort.Search(len(locs), func(j int) bool { return locs[j].ts > loc.ts })
+	}
+	locs = append(locs, tradeLoc{})
+	copy(locs[i+1:], locs[i:])
+	locs[i] = loc
+	s.bySymbol[t.Symbol] = locs
+
+	s.byOrder[t.BuyOrderID] = append(s.byOrder[t.BuyOrderID], loc)
+	if t.SellOrderID != t.BuyOrderID {
+		s.byOrder[t.SellOrderID] = append(s.byOrder[t.SellOrderID], loc)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Encodage
+// ---------------------------------------------------------------------------
+
+const tradePayloadFixed = 6*8 + 1
+
+func encodeTrade(t Trade) []byte {
+	symbol := t.Symbol
+	if len(symbol) > math.MaxUint8 {
+		symbol = symbol[:math.MaxUint8]
+	}
+	ccy := t.Currency
+	if len(ccy) > math.MaxUint8 {
+		ccy = ccy[:math.MaxUint8]
+	}
+	n := tradePayloadFixed + len(symbol) + 1 + len(ccy)
+	rec := make([]byte, 8+n)
+	p := rec[8:]
+	binary.LittleEndian.PutUint64(p[0:], t.ID)
+	binary.LittleEndian.PutUint64(p[8:], t.BuyOrderID)
+	binary.LittleEndian.PutUint64(p[16:], t.SellOrderID)
+	binary.LittleEndian.PutUint64(p[24:], math.Float64bits(t.Price))
+	binary.LittleEndian.PutUint64(p[32:], uint64(t.Quantity))
+	binary.LittleEndian.PutUint64(p[40:], uint64(t.Timestamp))
+	p[48] = byte(len(symbol))
+	copy(p[49:], symbol)
+	p[49+len(symbol)] = byte(len(ccy))
+	copy(p[50+len(symbol):], ccy)
+
+	binary.LittleEndian.PutUint32(rec[0:], uint32(n))
+	binary.LittleEndian.PutUint32(rec[4:], crc32.ChecksumIEEE(p))
+	return rec
+}
+
+// decodeRecord lit un record complet en tete de data et retourne son
+// payload (trade ou statut, voir statusPayloadSize) et sa taille.
+func decodeRecord(data []byte) ([]byte, int, error) {
+	if len(data) < 8 {
+		return nil, 0, errors.New("en-tete tronque")
+	}
+	n := int(binary.LittleEndian.Uint32(data[0:4]))
+	if (n != statusPayloadSize && n < tradePayloadFixed) || len(data) < 8+n {
+		return nil, 0, errors.New("record tronque")
+	}
+	payload := data[8 : 8+n]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:8]) {
+		return nil, 0, errors.New("CRC invalide")
+	}
+	return payload, 8 + n, nil
+}
+
+func decodeTrade(p []byte) (Trade, error) {
+	if len(p) < tradePayloadFixed || len(p) < tradePayloadFixed+int(p[48]) {
+		return Trade{}, errors.New("payload de trade invalide")
+	}
+	end := tradePayloadFixed + int(p[48])
+	ccy := DefaultCurrency // Record sans devise
+	if len(p) > end {
+		if len(p) != end+1+int(p[end]) {
+			return Trade{}, errors.New("payload de trade invalide")
+		}
+		ccy = string(p[end+1:])
+	}
+	return Trade{
+		ID:          binary.LittleEndian.Uint64(p[0:]),
+		BuyOrderID:  binary.LittleEndian.Uint64(p[8:]),
+		SellOrderID: binary.LittleEndian.Uint64(p[16:]),
+		Price:       math.Float64frombits(binary.LittleEndian.Uint64(p[24:])),
+		Quantity:    int64(binary.LittleEndian.Uint64(p[32:])),
+		Timestamp:   int64(binary.LittleEndian.Uint64(p[40:])),
+		Symbol:      string(p[49:end]),
+		Currency:    ccy,
+		Status:      TradeActive,
+	}, nil
+}
+
+// statusPayloadSize est la taille d'un record de statut, inferieure a celle
+// de tout record de trade : la taille suffit a les distinguer.
+const statusPayloadSize = 8 + 1 + 8 + 8
+
+// tradeStatus est l'etat post-trade persiste d'un trade.
+type tradeStatus struct {
+	status      TradeStatus
+	corrects    uint64
+	correctedBy uint64
+}
+
+// statusOf retourne ce qu'un trade apporte en record de statut : son
+// Status s'il n'est plus vivant, son lien Corrects s'il est une correction.
+// Zero pour un trade ordinaire.
+func statusOf(t Trade) tradeStatus {
+	if t.IsLive() && t.Corrects == 0 {
+		return tradeStatus{}
+	}
+	st := tradeStatus{status: t.Status, corrects: t.Corrects, correctedBy: t.CorrectedBy}
+	if st.status == "" {
+		st.status = TradeActive
+	}
+	return st
+}
+
+// apply reporte le statut sur un trade relu.
+func (st tradeStatus) apply(t *Trade) {
+	t.Status, t.Corrects, t.CorrectedBy = st.status, st.corrects, st.correctedBy
+}
+
+// tradeStatusCodes numerote les statuts sur disque.
+var tradeStatusCodes = []TradeStatus{TradeActive, TradeBusted, TradeCorrected}
+
+func encodeStatus(id uint64, st tradeStatus) []byte {
+	rec := make([]byte, 8+statusPayloadSize)
+	p := rec[8:]
+	binary.LittleEndian.PutUint64(p[0:], id)
+	p[8] = byte(slices.Index(tradeStatusCodes, st.status))
+	binary.LittleEndian.PutUint64(p[9:], st.corrects)
+	binary.LittleEndian.PutUint64(p[17:], st.correctedBy)
+	binary.LittleEndian.PutUint32(rec[0:], statusPayloadSize)
+	binary.LittleEndian.PutUint32(rec[4:], crc32.ChecksumIEEE(p))
+	return rec
+}
+
+func decodeStatus(p []byte) tradeStatus {
+	st := tradeStatus{
+		corrects:    binary.LittleEndian.Uint64(p[9:]),
+		correctedBy: binary.LittleEndian.Uint64(p[17:]),
+	}
+	if int(p[8]) < len(tradeStatusCodes) {
+		st.status = tradeStatusCodes[p[8]]
+	}
+	return st
+}
@@ -0,0 +1,169 @@
+// store_test.go — Tests du FileTradeStore : persistance, requetes, crash.
+
+package main
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func storeTrade(id uint64, symbol string, buy, sell uint64, ts int64) Trade {
+	return Trade{ID: id, Symbol: symbol, BuyOrderID: buy, SellOrderID: sell, Price: 190.25, Quantity: 10, Timestamp: ts}
+}
+
+// TestFileTradeStoreQueries verifie les requetes par symbole/temps et par ordre,
+// a travers plusieurs segments et apres reouverture.
+func TestFileTradeStoreQueries(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileTradeStore(dir, StoreOptions{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	log := NewTradeLog()
+	log.SetSink(s)
+	for i := uint64(1); i <= 20; i++ {
+		symbol := "AAPL"
+		if i%2 == 0 {
+			symbol = "MSFT"
+		}
+		log.Add(storeTrade(i, symbol, 100+i, 42, int64(i)*1000))
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = OpenFileTradeStore(dir, StoreOptions{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("reouverture: %v", err)
+	}
+	defer s.Close()
+
+	if ids, _ := s.segmentIDs(); len(ids) < 2 {
+		t.Errorf("attendu plusieurs segments, obtenu %d", len(ids))
+	}
+
+	got, err := s.BySymbol("MSFT", 4000, 10000)
+	if err != nil {
+		t.Fatalf("BySymbol: %v", err)
+	}
+	if len(got) != 3 || got[0].ID != 4 || got[2].ID != 8 {
+		t.Errorf("MSFT [4000,10000): attendu trades 4,6,8, obtenu %v", got)
+	}
+
+	got, err = s.ByOrder(42)
+	if err != nil {
+		t.Fatalf("ByOrder: %v", err)
+	}
+	if len(got) != 20 {
+		t.Errorf("ordre #42: attendu 20 trades, obtenu %d", len(got))
+	}
+	if got, _ := s.ByOrder(107); len(got) != 1 || got[0].ID != 7 || got[0].Price != 190.25 {
+		t.Errorf("ordre #107: attendu le trade #7, obtenu %v", got)
+	}
+}
+
+// TestFileTradeStoreCrashRecovery verifie qu'un record tronque est ignore et
+// que les trades acquittes (Sync) survivent.
+func TestFileTradeStoreCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileTradeStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Append([]Trade{storeTrade(1, "AAPL", 1, 2, 10), storeTrade(2, "AAPL", 3, 4, 20)}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Simule un crash au milieu de l'ecriture du record suivant.
+	rec := encodeTrade(storeTrade(3, "AAPL", 5, 6, 30))
+	f, err := os.OpenFile(s.segmentPath(1), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(rec[:len(rec)/2])
+	f.Close()
+	s.Close()
+
+	s, err = OpenFileTradeStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatalf("reouverture: %v", err)
+	}
+	got, _ := s.BySymbol("AAPL", 0, 100)
+	if len(got) != 2 {
+		t.Fatalf("attendu 2 trades acquittes, obtenu %d", len(got))
+	}
+
+	// Le segment repare accepte de nouveaux records.
+	s.Append([]Trade{storeTrade(3, "AAPL", 5, 6, 30)})
+	s.Close()
+	s, _ = OpenFileTradeStore(dir, StoreOptions{})
+	defer s.Close()
+	if got, _ := s.BySymbol("AAPL", 0, 100); len(got) != 3 {
+		t.Errorf("apres reparation: attendu 3 trades, obtenu %d", len(got))
+	}
+}
+
+// TestFileTradeStoreBustAndCorrect verifie qu'un bust et une correction
+// survivent a la reouverture : le trade busted ne revient pas vivant, le
+// trade corrige et sa correction restent lies et comptes une seule fois.
+func TestFileTradeStoreBustAndCorrect(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileTradeStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, log := newTestGateway()
+	log.SetSink(s)
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190, 30))
+	trades := mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190, 30))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 191, 10))
+	trades = append(trades, mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 191, 10))...)
+	if err := gw.BustTrade(trades[0].ID, "ops", "prix aberrant"); err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := gw.CorrectTrade(trades[1].ID, 190.5, 10, "ops", "prix errone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFileTradeStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got, err := s.BySymbol("AAPL", 0, math.MaxInt64)
+	if err != nil || len(got) != 3 {
+		t.Fatalf("BySymbol: %v, %v", got, err)
+	}
+	byID := make(map[uint64]Trade)
+	var notional float64
+	for _, tr := range got {
+		byID[tr.ID] = tr
+		if tr.IsLive() {
+			notional += tr.Notional()
+		}
+	}
+	if st := byID[trades[0].ID].Status; st != TradeBusted {
+		t.Errorf("trade busted relu %s", st)
+	}
+	if orig := byID[trades[1].ID]; orig.Status != TradeCorrected || orig.CorrectedBy != fixed.ID {
+		t.Errorf("trade corrige relu: %+v", orig)
+	}
+	if c := byID[fixed.ID]; c.Status != TradeActive || c.Corrects != trades[1].ID || c.Price != 190.5 {
+		t.Errorf("correction relue: %+v", c)
+	}
+	if notional != log.TotalNotional() || notional != 1905 {
+		t.Errorf("notionnel vivant relu %v, TradeLog %v", notional, log.TotalNotional())
+	}
+	if got, _ := s.ByOrder(trades[1].BuyOrderID); len(got) != 2 || got[0].Status != TradeCorrected {
+		t.Errorf("ByOrder: %+v", got)
+	}
+}
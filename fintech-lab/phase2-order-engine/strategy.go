@@ -0,0 +1,175 @@
+// strategy.go — Strategies d'exemple pour le backtest (voir backtest.go).
+//
+//   MarketMaker : cote des deux cotes autour du mid, decale ses prix contre
+//                 son inventaire et cesse d'acheter (de vendre) au-dela de
+//                 MaxPosition. Gagne le spread, perd sur les tendances.
+//   Momentum    : croisement de moyennes mobiles des prix de trades ;
+//                 achete (vend) au marche quand la moyenne courte passe
+//                 au-dessus (au-dessous) de la longue.
+//
+// Les zeros des champs de reglage prennent les defauts au premier callback.
+
+package main
+
+// ---------------------------------------------------------------------------
+// MarketMaker
+// ---------------------------------------------------------------------------
+
+// MarketMaker maintient un bid et un ask sur Symbol.
+type MarketMaker struct {
+	Symbol      string
+	Quantity    int64   // Taille de chaque cote (defaut : 100)
+	Tick        float64 // defaut : 0.01
+	HalfSpread  float64 // Ecart entre le prix juste et chaque cote (defaut : 2 ticks)
+	Skew        float64 // Decalage du prix juste par action en position (defaut : 1 tick par Quantity)
+	MaxPosition int64   // Position au-dela de laquelle un seul cote est cote (defaut : 10 x Quantity)
+
+	bid, ask *Order
+}
+
+func (s *MarketMaker) defaults() {
+	if s.Quantity <= 0 {
+		s.Quantity = 100
+	}
+	if s.Tick <= 0 {
+		s.Tick = 0.01
+	}
+	if s.HalfSpread <= 0 {
+		s.HalfSpread = 2 * s.Tick
+	}
+	if s.Skew <= 0 {
+		s.Skew = s.Tick / float64(s.Quantity)
+	}
+	if s.MaxPosition <= 0 {
+		s.MaxPosition = 10 * s.Quantity
+	}
+}
+
+// OnBook recote quand le marche bouge.
+func (s *MarketMaker) OnBook(c *StrategyContext, symbol string, bid, ask Level) {
+	if symbol == s.Symbol {
+		s.requote(c, bid, ask)
+	}
+}
+
+// OnTrade n'est pas utilise : le prix juste vient du carnet.
+func (s *MarketMaker) OnTrade(c *StrategyContext, t Trade) {}
+
+// OnFill recote : l'inventaire a change, donc le decalage aussi.
+func (s *MarketMaker) OnFill(c *StrategyContext, o *Order, f Fill) {
+	if o.Symbol == s.Symbol {
+		bid, ask := c.Top(s.Symbol)
+		s.requote(c, bid, ask)
+	}
+}
+
+// requote place les deux cotes autour de mid - Skew x position, sans jamais
+// traverser le marche. Un cote vide retire les cotations.
+func (s *MarketMaker) requote(c *StrategyContext, bid, ask Level) {
+	s.defaults()
+	if bid.Quantity == 0 || ask.Quantity == 0 {
+		s.bid = s.quote(c, s.bid, Buy, 0, false)
+		s.ask = s.quote(c, s.ask, Sell, 0, false)
+		return
+	}
+	pos := c.Position(s.Symbol)
+	fair := (bid.Price+ask.Price)/2 - float64(pos)*s.Skew
+	bidPx := min(roundTick(fair-s.HalfSpread, s.Tick), roundTick(ask.Price-s.Tick, s.Tick))
+	askPx := max(roundTick(fair+s.HalfSpread, s.Tick), roundTick(bid.Price+s.Tick, s.Tick))
+	if askPx <= bidPx {
+		askPx = roundTick(bidPx+s.Tick, s.Tick)
+	}
+	s.bid = s.quote(c, s.bid, Buy, bidPx, pos < s.MaxPosition)
+	s.ask = s.quote(c, s.ask, Sell, askPx, pos > -s.MaxPosition)
+}
+
+// quote garde cur s'il est deja au bon prix, sinon l'annule et le remplace
+// (si want). Retourne la cotation en place, nil si aucune.
+func (s *MarketMaker) quote(c *StrategyContext, cur *Order, side Side, price float64, want bool) *Order {
+	if cur != nil && cur.IsActive() {
+		if want && cur.Price == price {
+			return cur
+		}
+		c.Cancel(cur)
+	}
+	if !want || price <= 0 {
+		return nil
+	}
+	o := NewLimitOrder(s.Symbol, side, price, s.Quantity)
+	if err := c.Submit(o); err != nil {
+		return nil
+	}
+	return o
+}
+
+// ---------------------------------------------------------------------------
+// Momentum
+// ---------------------------------------------------------------------------
+
+// Momentum suit la tendance des trades de Symbol, hors les siens.
+type Momentum struct {
+	Symbol      string
+	Quantity    int64   // Taille d'un ordre (defaut : 100)
+	MaxPosition int64   // Position maximale en valeur absolue (defaut : 5 x Quantity)
+	Fast        int     // Fenetre de la moyenne courte, en trades (defaut : 10)
+	Slow        int     // Fenetre de la moyenne longue, en trades (defaut : 50)
+	Threshold   float64 // Ecart relatif minimal entre les moyennes (defaut : 0.0002, 2 bps)
+
+	prices []float64 // Derniers Slow prix
+}
+
+func (s *Momentum) defaults() {
+	if s.Quantity <= 0 {
+		s.Quantity = 100
+	}
+	if s.MaxPosition <= 0 {
+		s.MaxPosition = 5 * s.Quantity
+	}
+	if s.Fast <= 0 {
+		s.Fast = 10
+	}
+	if s.Slow <= s.Fast {
+		s.Slow = max(50, 5*s.Fast)
+	}
+	if s.Threshold <= 0 {
+		s.Threshold = 0.0002
+	}
+}
+
+// OnBook n'est pas utilise : le signal vient des trades.
+func (s *Momentum) OnBook(c *StrategyContext, symbol string, bid, ask Level) {}
+
+// OnTrade met a jour les moyennes et prend position dans le sens du signal.
+func (s *Momentum) OnTrade(c *StrategyContext, t Trade) {
+	if t.Symbol != s.Symbol || c.Own(t.BuyOrderID) || c.Own(t.SellOrderID) {
+		return
+	}
+	s.defaults()
+	s.prices = append(s.prices, t.Price)
+	if len(s.prices) > s.Slow {
+		s.prices = s.prices[1:]
+	}
+	if len(s.prices) < s.Slow {
+		return
+	}
+	signal := mean(s.prices[len(s.prices)-s.Fast:])/mean(s.prices) - 1
+	pos := c.Position(s.Symbol)
+	switch {
+	case signal > s.Threshold && pos < s.MaxPosition:
+		c.Submit(NewMarketOrder(s.Symbol, Buy, min(s.Quantity, s.MaxPosition-pos)))
+	case signal < -s.Threshold && pos > -s.MaxPosition:
+		c.Submit(NewMarketOrder(s.Symbol, Sell, min(s.Quantity, s.MaxPosition+pos)))
+	}
+}
+
+// OnFill n'est pas utilise : la position suffit.
+func (s *Momentum) OnFill(c *StrategyContext, o *Order, f Fill) {}
+
+// mean retourne la moyenne de xs (non vide).
+func mean(xs []float64) float64 {
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
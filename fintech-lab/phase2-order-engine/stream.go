@@ -0,0 +1,561 @@
+// stream.go — Streaming WebSocket : carnet L2, trades et execution reports.
+//
+// Endpoint : GET /stream (upgrade WebSocket, Bearer ou ?token= pour les
+// navigateurs). Le client s'abonne par messages JSON :
+//
+//	{"op":"subscribe","channels":["book:AAPL","trades:AAPL","executions"]}
+//	{"op":"unsubscribe","channels":["trades:AAPL"]}
+//
+// Chaque canal commence par un snapshot puis envoie des updates numerotes :
+// snapshot.seq = N, updates N+1, N+2... Un trou dans seq = message perdu :
+// le client doit se reabonner pour repartir d'un snapshot.
+//
+//	book:SYM    snapshot : niveaux L2 complets (profondeur BookDepth).
+//	            update   : niveaux modifies, quantity 0 = niveau supprime.
+//	            Updates conflates : un burst d'ordres peut produire un seul update.
+//	trades:SYM  snapshot : derniers trades. update : un trade.
+//	executions  snapshot : ordres ouverts du compte. update : un Event du compte.
+//
+// Un client dont la file d'envoi deborde est deconnecte (close 1008,
+// raison "slow consumer") : le moteur ne ralentit jamais pour un client.
+// Heartbeat : {"type":"heartbeat"} + ping WebSocket toutes les Heartbeat.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Valeurs par defaut des options de streaming.
+const (
+	defaultHeartbeat = 5 * time.Second
+	defaultSendQueue = 256
+	defaultBookDepth = 10
+	recentTrades     = 50 // Trades gardes pour le snapshot trades:SYM
+)
+
+// StreamOptions configure le streaming. Champs a zero : valeurs par defaut.
+type StreamOptions struct {
+	Heartbeat    time.Duration // Intervalle des heartbeats
+	SendQueue    int           // Messages en attente par client avant deconnexion
+	BookDepth    int           // Niveaux par cote dans book:SYM
+	WriteTimeout time.Duration // Delai max d'ecriture d'un message (defaut : Heartbeat)
+}
+
+// streamMsg est l'enveloppe de tout message serveur.
+type streamMsg struct {
+	Type    string `json:"type"` // snapshot, update, heartbeat, error
+	Channel string `json:"channel,omitempty"`
+	Seq     uint64 `json:"seq,omitempty"`
+	TS      int64  `json:"ts,omitempty"`
+	Data    any    `json:"data,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// streamRequest est un message client.
+type streamRequest struct {
+	Op       string   `json:"op"`
+	Channels []string `json:"channels"`
+}
+
+// ---------------------------------------------------------------------------
+// Canaux
+// ---------------------------------------------------------------------------
+
+// streamChannel est un flux numerote et ses abonnes.
+type streamChannel struct {
+	name string
+	seq  uint64
+	subs map[*streamClient]struct{}
+}
+
+func newStreamChannel(name string) *streamChannel {
+	return &streamChannel{name: name, subs: make(map[*streamClient]struct{})}
+}
+
+// bookChannel garde le dernier L2 publie d'un symbole.
+type bookChannel struct {
+	*streamChannel
+	bids, asks []Level
+	dirty      bool
+	ts         int64 // Horodatage du dernier evenement du carnet
+}
+
+// tradeChannel garde les derniers trades d'un symbole.
+type tradeChannel struct {
+	*streamChannel
+	recent []tradeJSON
+}
+
+// execChannel suit les ordres ouverts d'un compte.
+type execChannel struct {
+	*streamChannel
+	open map[uint64]*orderJSON
+}
+
+type bookData struct {
+	Bids []levelJSON `json:"bids"`
+	Asks []levelJSON `json:"asks"`
+}
+
+// ---------------------------------------------------------------------------
+// StreamHub
+// ---------------------------------------------------------------------------
+
+// StreamHub diffuse l'activite d'un Gateway aux clients WebSocket.
+// Il s'abonne aux Events du Gateway et aux trades du TradeLog : ses
+// handlers ne font que mettre a jour l'etat et remplir des files, sans
+// jamais bloquer ni rappeler le Gateway.
+type StreamHub struct {
+	mu     sync.Mutex
+	gw     *Gateway
+	opts   StreamOptions
+	books  map[string]*bookChannel
+	trades map[string]*tradeChannel
+	execs  map[string]*execChannel
+	wake   chan struct{} // Reveille le publieur L2
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewStreamHub branche un hub sur gw. Close arrete son publieur L2.
+func NewStreamHub(gw *Gateway, opts StreamOptions) *StreamHub {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = defaultHeartbeat
+	}
+	if opts.SendQueue <= 0 {
+		opts.SendQueue = defaultSendQueue
+	}
+	if opts.BookDepth <= 0 {
+		opts.BookDepth = defaultBookDepth
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = opts.Heartbeat
+	}
+	h := &StreamHub{
+		gw:     gw,
+		opts:   opts,
+		books:  make(map[string]*bookChannel),
+		trades: make(map[string]*tradeChannel),
+		execs:  make(map[string]*execChannel),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	for symbol := range gw.books {
+		h.books[symbol] = &bookChannel{streamChannel: newStreamChannel("book:" + symbol)}
+		h.trades[symbol] = &tradeChannel{streamChannel: newStreamChannel("trades:" + symbol)}
+	}
+	gw.Subscribe(h.onEvent)
+	if gw.log != nil {
+		gw.log.OnTrade(h.onTrade)
+	}
+	h.wg.Add(1)
+	go h.publishBooks()
+	return h
+}
+
+// Close arrete le publieur L2. Les clients connectes restent ouverts
+// jusqu'a la fermeture du serveur HTTP.
+func (h *StreamHub) Close() {
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+	h.wg.Wait()
+}
+
+// onEvent suit les ordres des comptes et signale les carnets modifies.
+// Appele avec gw.mu tenu.
+func (h *StreamHub) onEvent(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch e.Type {
+	case EventRested, EventFilled, EventCancelled, EventAmended:
+		if b, ok := h.books[e.Symbol]; ok && !b.dirty {
+			b.dirty, b.ts = true, e.Timestamp
+			select {
+			case h.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+	if e.Account == "" || e.OrderID == 0 {
+		return
+	}
+	x := h.exec(e.Account)
+	x.track(e)
+	h.publish(x.streamChannel, e.Timestamp, e)
+}
+
+// onTrade publie un trade. Appele avec le verrou du TradeLog tenu.
+func (h *StreamHub) onTrade(t Trade) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tc, ok := h.trades[t.Symbol]
+	if !ok {
+		return
+	}
+	tj := toTradesJSON([]Trade{t})[0]
+	tc.recent = append(tc.recent, tj)
+	if len(tc.recent) > recentTrades {
+		tc.recent = tc.recent[len(tc.recent)-recentTrades:]
+	}
+	h.publish(tc.streamChannel, t.Timestamp, tj)
+}
+
+// publishBooks recalcule, a chaque reveil, le L2 des carnets modifies et
+// publie la difference avec le dernier etat diffuse.
+func (h *StreamHub) publishBooks() {
+	defer h.wg.Done()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-h.wake:
+		}
+
+		h.mu.Lock()
+		var dirty []string
+		for symbol, b := range h.books {
+			if b.dirty {
+				b.dirty = false
+				dirty = append(dirty, symbol)
+			}
+		}
+		h.mu.Unlock()
+		sort.Strings(dirty)
+
+		for _, symbol := range dirty {
+			book, _ := h.gw.Book(symbol)
+			bids, asks := book.Levels(h.opts.BookDepth)
+
+			h.mu.Lock()
+			b := h.books[symbol]
+			diff := bookData{Bids: diffLevels(b.bids, bids), Asks: diffLevels(b.asks, asks)}
+			b.bids, b.asks = bids, asks
+			if len(diff.Bids) > 0 || len(diff.Asks) > 0 {
+				h.publish(b.streamChannel, b.ts, diff)
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// diffLevels retourne les niveaux de next qui different de prev, plus les
+// niveaux de prev disparus (quantity 0).
+func diffLevels(prev, next []Level) []levelJSON {
+	old := make(map[float64]Level, len(prev))
+	for _, l := range prev {
+		old[l.Price] = l
+	}
+	var out []levelJSON
+	for _, l := range next {
+		if o, ok := old[l.Price]; !ok || o != l {
+			out = append(out, levelJSON{Price: l.Price, Quantity: l.Quantity, Orders: l.Orders})
+		}
+		delete(old, l.Price)
+	}
+	gone := make([]float64, 0, len(old))
+	for p := range old {
+		gone = append(gone, p)
+	}
+	sort.Float64s(gone)
+	for _, p := range gone {
+		out = append(out, levelJSON{Price: p})
+	}
+	return out
+}
+
+// publish numerote et diffuse un update. Appele avec h.mu tenu.
+func (h *StreamHub) publish(ch *streamChannel, ts int64, data any) {
+	ch.seq++
+	if len(ch.subs) == 0 {
+		return
+	}
+	b, err := json.Marshal(streamMsg{Type: "update", Channel: ch.name, Seq: ch.seq, TS: ts, Data: data})
+	if err != nil {
+		return
+	}
+	for c := range ch.subs {
+		if !c.enqueue(b) {
+			h.drop(c, "slow consumer")
+		}
+	}
+}
+
+// exec retourne (en le creant) le canal d'execution d'un compte.
+// Appele avec h.mu tenu.
+func (h *StreamHub) exec(account string) *execChannel {
+	x, ok := h.execs[account]
+	if !ok {
+		x = &execChannel{streamChannel: newStreamChannel("executions"), open: make(map[uint64]*orderJSON)}
+		h.execs[account] = x
+	}
+	return x
+}
+
+// track met a jour les ordres ouverts du compte.
+func (x *execChannel) track(e Event) {
+	switch e.Type {
+	case EventAccepted:
+		x.open[e.OrderID] = &orderJSON{
+			ID: e.OrderID, ClOrdID: e.ClOrdID, Account: e.Account, Symbol: e.Symbol,
+			Side: e.Side, Type: e.OrderType, Status: StatusOpen, Price: e.Price,
+			Quantity: e.Quantity, Remaining: e.Quantity, Timestamp: e.Timestamp,
+		}
+		return
+	case EventCancelled, EventRejected:
+		delete(x.open, e.OrderID)
+		return
+	}
+	o, ok := x.open[e.OrderID]
+	if !ok {
+		return
+	}
+	switch e.Type {
+	case EventFilled:
+		o.Filled += e.Quantity
+		o.Status = StatusPartial
+	case EventAmended:
+		o.Price, o.Quantity = e.Price, e.Quantity
+	case EventTriggered:
+		o.Type = e.OrderType
+	case EventRested:
+		if o.Status == "" {
+			o.Status = StatusOpen
+		}
+	}
+	o.Remaining = o.Quantity - o.Filled
+	if o.Remaining <= 0 {
+		delete(x.open, e.OrderID)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Abonnements
+// ---------------------------------------------------------------------------
+
+// subscribe abonne c a un canal et lui envoie le snapshot.
+func (h *StreamHub) subscribe(c *streamClient, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c.closed() {
+		return nil
+	}
+	ch, data, err := h.lookup(c, name)
+	if err != nil {
+		return err
+	}
+	if _, already := ch.subs[c]; already {
+		return nil
+	}
+	ch.subs[c] = struct{}{}
+	c.chans[ch] = struct{}{}
+
+	b, _ := json.Marshal(streamMsg{Type: "snapshot", Channel: name, Seq: ch.seq, TS: h.gw.Clock().Now(), Data: data})
+	if !c.enqueue(b) {
+		h.drop(c, "slow consumer")
+	}
+	return nil
+}
+
+// lookup resout un nom de canal et construit son snapshot.
+// Appele avec h.mu tenu.
+func (h *StreamHub) lookup(c *streamClient, name string) (*streamChannel, any, error) {
+	if name == "executions" {
+		x := h.exec(c.account)
+		ids := make([]uint64, 0, len(x.open))
+		for id := range x.open {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		orders := make([]orderJSON, len(ids))
+		for i, id := range ids {
+			orders[i] = *x.open[id]
+		}
+		return x.streamChannel, orders, nil
+	}
+	kind, symbol, _ := strings.Cut(name, ":")
+	switch kind {
+	case "book":
+		if b, ok := h.books[symbol]; ok {
+			return b.streamChannel, bookData{Bids: toLevelsJSON(b.bids), Asks: toLevelsJSON(b.asks)}, nil
+		}
+	case "trades":
+		if tc, ok := h.trades[symbol]; ok {
+			return tc.streamChannel, append([]tradeJSON{}, tc.recent...), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("canal %q inconnu", name)
+}
+
+// unsubscribe retire c d'un canal.
+func (h *StreamHub) unsubscribe(c *streamClient, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range c.chans {
+		if ch.name == name {
+			delete(ch.subs, c)
+			delete(c.chans, ch)
+		}
+	}
+}
+
+// drop retire c de tous ses canaux et le deconnecte avec reason.
+// Appele avec h.mu tenu.
+func (h *StreamHub) drop(c *streamClient, reason string) {
+	for ch := range c.chans {
+		delete(ch.subs, c)
+	}
+	c.chans = make(map[*streamChannel]struct{})
+	c.close(reason)
+}
+
+// remove retire un client deconnecte.
+func (h *StreamHub) remove(c *streamClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(c, "")
+}
+
+// ---------------------------------------------------------------------------
+// streamClient — une connexion
+// ---------------------------------------------------------------------------
+
+type streamClient struct {
+	account string
+	out     chan []byte
+	done    chan struct{}
+	once    sync.Once
+	reason  string                      // Raison de la deconnexion (ecrit avant close(done))
+	chans   map[*streamChannel]struct{} // Protege par StreamHub.mu
+}
+
+func newStreamClient(account string, queue int) *streamClient {
+	return &streamClient{
+		account: account,
+		out:     make(chan []byte, queue),
+		done:    make(chan struct{}),
+		chans:   make(map[*streamChannel]struct{}),
+	}
+}
+
+// enqueue ajoute un message sans jamais bloquer. false : file pleine.
+func (c *streamClient) enqueue(b []byte) bool {
+	select {
+	case c.out <- b:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *streamClient) close(reason string) {
+	c.once.Do(func() {
+		c.reason = reason
+		close(c.done)
+	})
+}
+
+func (c *streamClient) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Handler
+// ---------------------------------------------------------------------------
+
+// ServeHTTP gere une connexion GET /stream. Le compte vient du contexte
+// (authMiddleware).
+func (h *StreamHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := AcceptWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	c := newStreamClient(accountFrom(r), h.opts.SendQueue)
+	go h.writeLoop(ws, c)
+
+	// Sans trame du client (pong compris) pendant 3 heartbeats : deconnexion.
+	ws.ReadTimeout = 3 * h.opts.Heartbeat
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		var req streamRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			h.reply(c, streamMsg{Type: "error", Error: "message JSON invalide"})
+			continue
+		}
+		for _, name := range req.Channels {
+			switch req.Op {
+			case "subscribe":
+				if err := h.subscribe(c, name); err != nil {
+					h.reply(c, streamMsg{Type: "error", Channel: name, Error: err.Error()})
+				}
+			case "unsubscribe":
+				h.unsubscribe(c, name)
+			default:
+				h.reply(c, streamMsg{Type: "error", Error: fmt.Sprintf("op %q inconnue", req.Op)})
+			}
+		}
+	}
+	h.remove(c)
+}
+
+// reply envoie un message hors canal a un client.
+func (h *StreamHub) reply(c *streamClient, m streamMsg) {
+	b, _ := json.Marshal(m)
+	if !c.enqueue(b) {
+		h.mu.Lock()
+		h.drop(c, "slow consumer")
+		h.mu.Unlock()
+	}
+}
+
+// writeLoop vide la file du client et envoie les heartbeats. A la
+// deconnexion, envoie la raison dans la trame close.
+func (h *StreamHub) writeLoop(ws *WSConn, c *streamClient) {
+	tick := time.NewTicker(h.opts.Heartbeat)
+	defer tick.Stop()
+	for {
+		select {
+		case b := <-c.out:
+			if err := ws.WriteTextTimeout(b, h.opts.WriteTimeout); err != nil {
+				h.remove(c)
+				ws.Close(WSCloseGoingAway, "write timeout")
+				return
+			}
+		case <-tick.C:
+			b, _ := json.Marshal(streamMsg{Type: "heartbeat", TS: h.gw.Clock().Now()})
+			if err := ws.WriteTextTimeout(b, h.opts.WriteTimeout); err != nil {
+				h.remove(c)
+				ws.Close(WSCloseGoingAway, "write timeout")
+				return
+			}
+			ws.Ping(nil)
+		case <-c.done:
+			if c.reason == "" {
+				ws.Close(WSCloseNormal, "")
+			} else {
+				ws.Close(WSClosePolicyViolated, c.reason)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// stream_test.go — Tests du streaming WebSocket contre un client local.
+// Lancer avec : go test ./phase2-order-engine/ -run Stream -v
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamReply decode un message serveur ; Data reste brut.
+type streamReply struct {
+	Type    string          `json:"type"`
+	Channel string          `json:"channel"`
+	Seq     uint64          `json:"seq"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+// dialStream ouvre /stream avec le token en query string et s'abonne.
+func dialStream(t *testing.T, base, token string, channels ...string) *WSConn {
+	t.Helper()
+	ws, err := DialWebSocket("ws"+strings.TrimPrefix(base, "http")+"/stream?token="+token, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close(WSCloseNormal, "") })
+	ws.ReadTimeout = 2 * time.Second
+	req, _ := json.Marshal(streamRequest{Op: "subscribe", Channels: channels})
+	if err := ws.WriteText(req); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	return ws
+}
+
+// readStream lit le prochain message hors heartbeat.
+func readStream(t *testing.T, ws *WSConn) streamReply {
+	t.Helper()
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("lecture: %v", err)
+		}
+		var r streamReply
+		if err := json.Unmarshal(msg, &r); err != nil {
+			t.Fatalf("message illisible %s: %v", msg, err)
+		}
+		if r.Type != "heartbeat" {
+			return r
+		}
+	}
+}
+
+// TestStreamBook verifie snapshot puis updates L2 numerotes.
+func TestStreamBook(t *testing.T) {
+	srv, gw := newTestHTTPServer(t)
+	ws := dialStream(t, srv.URL, "tok-1", "book:AAPL", "book:XXX")
+
+	snap := readStream(t, ws)
+	if snap.Type != "snapshot" || snap.Channel != "book:AAPL" || snap.Seq != 0 {
+		t.Fatalf("attendu snapshot book:AAPL seq 0, obtenu %+v", snap)
+	}
+	if e := readStream(t, ws); e.Type != "error" || e.Channel != "book:XXX" {
+		t.Errorf("canal inconnu: attendu une erreur, obtenu %+v", e)
+	}
+
+	var book bookData
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	up := readStream(t, ws)
+	json.Unmarshal(up.Data, &book)
+	if up.Type != "update" || up.Seq != 1 || len(book.Asks) != 1 || book.Asks[0].Quantity != 100 {
+		t.Fatalf("attendu update seq 1 avec ask x100, obtenu %+v %s", up, up.Data)
+	}
+
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190.00, 100))
+	up = readStream(t, ws)
+	book = bookData{}
+	json.Unmarshal(up.Data, &book)
+	if up.Seq != 2 || len(book.Asks) != 1 || book.Asks[0].Price != 190.00 || book.Asks[0].Quantity != 0 {
+		t.Errorf("attendu update seq 2 supprimant le niveau 190, obtenu %+v %s", up, up.Data)
+	}
+}
+
+// TestStreamTradesAndExecutions verifie le canal trades et le filtrage
+// des execution reports par compte.
+func TestStreamTradesAndExecutions(t *testing.T) {
+	srv, gw := newTestHTTPServer(t)
+	resting := newAccountOrder("ACC1", "AAPL", Sell, 190.00, 100)
+	mustSubmit(t, gw, resting)
+
+	acc1 := dialStream(t, srv.URL, "tok-1", "executions")
+	acc2 := dialStream(t, srv.URL, "tok-2", "executions", "trades:AAPL")
+
+	var open []orderJSON
+	snap := readStream(t, acc1)
+	json.Unmarshal(snap.Data, &open)
+	if snap.Type != "snapshot" || len(open) != 1 || open[0].ID != resting.ID {
+		t.Fatalf("ACC1: attendu snapshot avec l'ordre #%d, obtenu %+v %s", resting.ID, snap, snap.Data)
+	}
+	open = nil
+	snap = readStream(t, acc2)
+	json.Unmarshal(snap.Data, &open)
+	if snap.Channel != "executions" || len(open) != 0 {
+		t.Fatalf("ACC2: attendu snapshot executions vide, obtenu %+v %s", snap, snap.Data)
+	}
+	readStream(t, acc2) // snapshot trades:AAPL
+
+	mustSubmit(t, gw, newAccountOrder("ACC2", "AAPL", Buy, 190.00, 40))
+
+	// ACC2 voit ses propres evenements et le trade, jamais ceux d'ACC1.
+	var sawTrade, sawFill bool
+	for !(sawTrade && sawFill) {
+		m := readStream(t, acc2)
+		switch m.Channel {
+		case "trades:AAPL":
+			var tr tradeJSON
+			json.Unmarshal(m.Data, &tr)
+			sawTrade = tr.Quantity == 40 && tr.Price == 190.00
+		case "executions":
+			var e Event
+			json.Unmarshal(m.Data, &e)
+			if e.Account != "ACC2" {
+				t.Fatalf("ACC2 a recu un evenement de %q: %+v", e.Account, e)
+			}
+			sawFill = sawFill || e.Type == EventFilled
+		}
+	}
+
+	// ACC1 recoit le fill de son ordre au repos.
+	for {
+		var e Event
+		m := readStream(t, acc1)
+		json.Unmarshal(m.Data, &e)
+		if e.Account != "ACC1" {
+			t.Fatalf("ACC1 a recu un evenement de %q: %+v", e.Account, e)
+		}
+		if e.Type == EventFilled {
+			if e.OrderID != resting.ID || e.Quantity != 40 {
+				t.Errorf("fill ACC1: attendu #%d x40, obtenu %+v", resting.ID, e)
+			}
+			break
+		}
+	}
+}
+
+// TestStreamHeartbeat verifie l'envoi periodique des heartbeats.
+func TestStreamHeartbeat(t *testing.T) {
+	srv, _ := newTestHTTPServerWith(t, StreamOptions{Heartbeat: 20 * time.Millisecond})
+	ws := dialStream(t, srv.URL, "tok-1")
+
+	for i := 0; i < 2; i++ {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("lecture: %v", err)
+		}
+		var r streamReply
+		json.Unmarshal(msg, &r)
+		if r.Type != "heartbeat" {
+			t.Errorf("attendu heartbeat, obtenu %s", msg)
+		}
+	}
+}
+
+// TestStreamAuth verifie le refus d'un upgrade sans token valide.
+func TestStreamAuth(t *testing.T) {
+	srv, _ := newTestHTTPServer(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/stream"
+	if _, err := DialWebSocket(url+"?token=faux", nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("token invalide: attendu un refus 401, obtenu %v", err)
+	}
+	ws, err := DialWebSocket(url, http.Header{"Authorization": {"Bearer tok-2"}})
+	if err != nil {
+		t.Fatalf("Bearer: %v", err)
+	}
+	ws.Close(WSCloseNormal, "")
+}
+
+// TestStreamSlowConsumer verifie qu'un client dont la file deborde est
+// deconnecte avec une raison, sans bloquer le Gateway.
+func TestStreamSlowConsumer(t *testing.T) {
+	gw, _ := newTestGateway()
+	hub := NewStreamHub(gw, StreamOptions{SendQueue: 1})
+	defer hub.Close()
+
+	c := newStreamClient("ACC1", 1)
+	if err := hub.subscribe(c, "trades:AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	// La file (1 message) est occupee par le snapshot, personne ne la vide.
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Sell, 190.00, 100))
+	mustSubmit(t, gw, NewLimitOrder("AAPL", Buy, 190.00, 100))
+
+	if !c.closed() || c.reason != "slow consumer" {
+		t.Fatalf("attendu deconnexion slow consumer, obtenu closed=%v reason=%q", c.closed(), c.reason)
+	}
+	hub.mu.Lock()
+	subs := len(hub.trades["AAPL"].subs)
+	hub.mu.Unlock()
+	if subs != 0 {
+		t.Errorf("client deconnecte encore abonne (%d abonnes)", subs)
+	}
+}
@@ -0,0 +1,430 @@
+// surveillance.go — Detection d'abus de marche sur le flux d'evenements du Gateway.
+//
+// Detecteurs :
+//   - WASH TRADE        : meme beneficiaire effectif des deux cotes d'un trade.
+//   - SPOOFING          : gros ordre annule peu apres un petit fill du cote oppose.
+//   - LAYERING          : plusieurs niveaux de prix d'un meme cote poses puis
+//                         annules autour d'un fill du cote oppose.
+//   - MOMENTUM IGNITION : rafale de fills dans un sens qui deplace le prix,
+//                         suivie d'un fill en sens inverse (prise de profit).
+//
+// Le meme code sert en streaming (gw.Subscribe(s.OnEvent)) et hors ligne
+// (RunSurveillance sur un fichier d'evenements JSONL). Seuls les timestamps
+// des evenements comptent : aucune horloge murale.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlertType identifie le schema d'abus detecte.
+type AlertType string
+
+const (
+	AlertWashTrade        AlertType = "WASH_TRADE"
+	AlertSpoofing         AlertType = "SPOOFING"
+	AlertLayering         AlertType = "LAYERING"
+	AlertMomentumIgnition AlertType = "MOMENTUM_IGNITION"
+)
+
+// Alert est une alerte de surveillance, avec les ordres qui la justifient.
+type Alert struct {
+	Type      AlertType `json:"type"`
+	Account   string    `json:"account"`
+	Symbol    string    `json:"symbol"`
+	Timestamp int64     `json:"ts"`
+	OrderIDs  []uint64  `json:"evidence_order_ids"`
+	TradeIDs  []uint64  `json:"evidence_trade_ids,omitempty"`
+	Detail    string    `json:"detail"`
+}
+
+// SurveillanceConfig regle les seuils des detecteurs.
+type SurveillanceConfig struct {
+	Owners map[string]string // account -> beneficiaire effectif (absent = le compte lui-meme)
+
+	SpoofMinQty int64         // Quantite restante minimale de l'ordre annule
+	SpoofRatio  float64       // Ordre annule >= SpoofRatio x fill oppose
+	SpoofWindow time.Duration // Delai max entre le fill oppose et l'annulation
+
+	LayerMinLevels int           // Niveaux de prix distincts annules du meme cote
+	LayerWindow    time.Duration // Fenetre de pose + annulation
+
+	MomentumMinFills int           // Fills dans le meme sens dans la fenetre
+	MomentumMinMove  float64       // Deplacement relatif du prix (0.005 = 0.5%)
+	MomentumWindow   time.Duration // Fenetre rafale + retournement
+}
+
+// DefaultSurveillanceConfig retourne des seuils raisonnables pour le labo.
+func DefaultSurveillanceConfig() SurveillanceConfig {
+	return SurveillanceConfig{
+		SpoofMinQty:      1_000,
+		SpoofRatio:       10,
+		SpoofWindow:      2 * time.Second,
+		LayerMinLevels:   3,
+		LayerWindow:      5 * time.Second,
+		MomentumMinFills: 3,
+		MomentumMinMove:  0.005,
+		MomentumWindow:   10 * time.Second,
+	}
+}
+
+type survOrder struct {
+	id       uint64
+	side     Side
+	price    float64
+	quantity int64
+	filled   int64
+	placed   int64
+}
+
+type survFill struct {
+	ts      int64
+	orderID uint64
+	tradeID uint64
+	account string
+	side    Side
+	price   float64
+	qty     int64
+}
+
+type survCancel struct {
+	ts      int64
+	orderID uint64
+	side    Side
+	price   float64
+	placed  int64
+}
+
+// survKey regroupe l'activite d'un compte sur un symbole.
+type survKey struct{ account, symbol string }
+
+// Surveillance consomme des Events et produit des Alerts.
+type Surveillance struct {
+	mu      sync.Mutex
+	cfg     SurveillanceConfig
+	orders  map[uint64]*survOrder
+	fills   map[survKey][]survFill
+	cancels map[survKey][]survCancel
+	trades  map[uint64]survFill // tradeID -> premier cote recu (wash)
+	alerts  []Alert
+	subs    []func(Alert)
+}
+
+// NewSurveillance cree un module de surveillance.
+func NewSurveillance(cfg SurveillanceConfig) *Surveillance {
+	return &Surveillance{
+		cfg:     cfg,
+		orders:  make(map[uint64]*survOrder),
+		fills:   make(map[survKey][]survFill),
+		cancels: make(map[survKey][]survCancel),
+		trades:  make(map[uint64]survFill),
+	}
+}
+
+// OnAlert enregistre un abonne aux alertes.
+func (s *Surveillance) OnAlert(h func(Alert)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, h)
+}
+
+// Alerts retourne une copie des alertes levees.
+func (s *Surveillance) Alerts() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Alert(nil), s.alerts...)
+}
+
+// OnEvent traite un evenement. Signature compatible EventHandler.
+func (s *Surveillance) OnEvent(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch e.Type {
+	case EventAccepted:
+		s.orders[e.OrderID] = &survOrder{
+			id:       e.OrderID,
+			side:     e.Side,
+			price:    e.Price,
+			quantity: e.Quantity,
+			placed:   e.Timestamp,
+		}
+	case EventAmended:
+		if o, ok := s.orders[e.OrderID]; ok {
+			o.price, o.quantity = e.Price, e.Quantity
+		}
+	case EventFilled:
+		s.onFill(e)
+	case EventCancelled:
+		if e.OrderType == IOC || e.OrderType == Market {
+			delete(s.orders, e.OrderID) // jamais pose au carnet : ni spoof ni layering
+			return
+		}
+		s.onCancel(e)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Detecteurs (appeles avec s.mu tenu)
+// ---------------------------------------------------------------------------
+
+func (s *Surveillance) onFill(e Event) {
+	f := survFill{
+		ts:      e.Timestamp,
+		orderID: e.OrderID,
+		tradeID: e.TradeID,
+		account: e.Account,
+		side:    e.Side,
+		price:   e.Price,
+		qty:     e.Quantity,
+	}
+	if o, ok := s.orders[e.OrderID]; ok {
+		o.filled += e.Quantity
+		if o.filled >= o.quantity {
+			delete(s.orders, e.OrderID)
+		}
+	}
+
+	// WASH : les deux cotes d'un trade arrivent l'un apres l'autre.
+	if other, ok := s.trades[e.TradeID]; ok {
+		delete(s.trades, e.TradeID)
+		if e.Account != "" && s.owner(e.Account) == s.owner(other.account) {
+			buy, sell := other.orderID, f.orderID
+			if f.side == Buy {
+				buy, sell = sell, buy
+			}
+			s.raise(Alert{
+				Type:      AlertWashTrade,
+				Account:   e.Account,
+				Symbol:    e.Symbol,
+				Timestamp: e.Timestamp,
+				OrderIDs:  []uint64{buy, sell},
+				TradeIDs:  []uint64{e.TradeID},
+				Detail: fmt.Sprintf("beneficiaire %q des deux cotes (%s / %s), x%d @ %.2f",
+					s.owner(e.Account), other.account, e.Account, e.Quantity, e.Price),
+			})
+		}
+	} else {
+		s.trades[e.TradeID] = f
+	}
+
+	k := survKey{e.Account, e.Symbol}
+	s.fills[k] = append(prune(s.fills[k], e.Timestamp-int64(s.maxWindow())), f)
+	s.checkMomentum(k, f)
+}
+
+func (s *Surveillance) onCancel(e Event) {
+	o, ok := s.orders[e.OrderID]
+	if !ok {
+		return
+	}
+	delete(s.orders, e.OrderID)
+	k := survKey{e.Account, e.Symbol}
+	remaining := o.quantity - o.filled
+
+	// SPOOFING : gros ordre retire juste apres un petit fill de l'autre cote.
+	if remaining >= s.cfg.SpoofMinQty {
+		var small []survFill
+		for _, f := range s.fills[k] {
+			if f.side != o.side && e.Timestamp-f.ts <= int64(s.cfg.SpoofWindow) &&
+				float64(remaining) >= s.cfg.SpoofRatio*float64(f.qty) {
+				small = append(small, f)
+			}
+		}
+		if len(small) > 0 {
+			s.raise(Alert{
+				Type:      AlertSpoofing,
+				Account:   e.Account,
+				Symbol:    e.Symbol,
+				Timestamp: e.Timestamp,
+				OrderIDs:  append([]uint64{o.id}, fillOrders(small)...),
+				TradeIDs:  fillTrades(small),
+				Detail: fmt.Sprintf("%s x%d @ %.2f annule %v apres %d fill(s) %s",
+					o.side, remaining, o.price, time.Duration(e.Timestamp-small[len(small)-1].ts), len(small), small[0].side),
+			})
+		}
+	}
+
+	// LAYERING : niveaux multiples poses et annules dans la fenetre.
+	window := int64(s.cfg.LayerWindow)
+	var kept []survCancel
+	for _, c := range s.cancels[k] {
+		if e.Timestamp-c.placed <= window {
+			kept = append(kept, c)
+		}
+	}
+	if e.Timestamp-o.placed <= window {
+		kept = append(kept, survCancel{ts: e.Timestamp, orderID: o.id, side: o.side, price: o.price, placed: o.placed})
+	}
+	s.cancels[k] = kept
+
+	var layer []survCancel
+	levels := make(map[float64]bool)
+	for _, c := range kept {
+		if c.side == o.side {
+			layer = append(layer, c)
+			levels[c.price] = true
+		}
+	}
+	if len(levels) < s.cfg.LayerMinLevels {
+		return
+	}
+	var opposite []survFill
+	for _, f := range s.fills[k] {
+		if f.side != o.side && e.Timestamp-f.ts <= window {
+			opposite = append(opposite, f)
+		}
+	}
+	if len(opposite) == 0 {
+		return
+	}
+	ids := make([]uint64, 0, len(layer)+len(opposite))
+	for _, c := range layer {
+		ids = append(ids, c.orderID)
+	}
+	s.raise(Alert{
+		Type:      AlertLayering,
+		Account:   e.Account,
+		Symbol:    e.Symbol,
+		Timestamp: e.Timestamp,
+		OrderIDs:  append(ids, fillOrders(opposite)...),
+		TradeIDs:  fillTrades(opposite),
+		Detail:    fmt.Sprintf("%d niveaux %s annules autour de %d fill(s) oppose(s)", len(levels), o.side, len(opposite)),
+	})
+	// Les annulations deja signalees ne relancent pas d'alerte.
+	s.cancels[k] = kept[:0]
+}
+
+// checkMomentum : f est-il le retournement d'une rafale dans l'autre sens ?
+func (s *Surveillance) checkMomentum(k survKey, f survFill) {
+	var burst []survFill
+	for _, x := range s.fills[k] {
+		if x.side != f.side && f.ts-x.ts <= int64(s.cfg.MomentumWindow) {
+			burst = append(burst, x)
+		}
+	}
+	if len(burst) < s.cfg.MomentumMinFills || s.cfg.MomentumMinFills == 0 {
+		return
+	}
+	first, last := burst[0].price, burst[len(burst)-1].price
+	move := (last - first) / first
+	if burst[0].side == Sell {
+		move = -move // une rafale de ventes doit faire baisser le prix
+	}
+	if move < s.cfg.MomentumMinMove {
+		return
+	}
+	s.raise(Alert{
+		Type:      AlertMomentumIgnition,
+		Account:   k.account,
+		Symbol:    k.symbol,
+		Timestamp: f.ts,
+		OrderIDs:  append(fillOrders(burst), f.orderID),
+		TradeIDs:  append(fillTrades(burst), f.tradeID),
+		Detail: fmt.Sprintf("%d fills %s de %.2f a %.2f (%.2f%%) puis %s @ %.2f",
+			len(burst), burst[0].side, first, last, move*100, f.side, f.price),
+	})
+	// La rafale signalee sort de l'historique pour ne pas alerter a chaque fill.
+	kept := s.fills[k][:0]
+	for _, x := range s.fills[k] {
+		if x.side == f.side {
+			kept = append(kept, x)
+		}
+	}
+	s.fills[k] = kept
+}
+
+func (s *Surveillance) raise(a Alert) {
+	s.alerts = append(s.alerts, a)
+	for _, h := range s.subs {
+		h(a)
+	}
+}
+
+func (s *Surveillance) owner(account string) string {
+	if o, ok := s.cfg.Owners[account]; ok {
+		return o
+	}
+	return account
+}
+
+func (s *Surveillance) maxWindow() time.Duration {
+	w := s.cfg.SpoofWindow
+	if s.cfg.LayerWindow > w {
+		w = s.cfg.LayerWindow
+	}
+	if s.cfg.MomentumWindow > w {
+		w = s.cfg.MomentumWindow
+	}
+	return w
+}
+
+// prune retire les fills anterieurs a since (les fills arrivent dans l'ordre).
+func prune(fills []survFill, since int64) []survFill {
+	i := sort.Search(len(fills), func(i int) bool { return fills[i].ts >= since })
+	return append(fills[:0], fills[i:]...)
+}
+
+func fillOrders(fills []survFill) []uint64 {
+	ids := make([]uint64, len(fills))
+	for i, f := range fills {
+		ids[i] = f.orderID
+	}
+	return ids
+}
+
+func fillTrades(fills []survFill) []uint64 {
+	ids := make([]uint64, len(fills))
+	for i, f := range fills {
+		ids[i] = f.tradeID
+	}
+	return ids
+}
+
+// ---------------------------------------------------------------------------
+// Mode hors ligne et sorties JSON
+// ---------------------------------------------------------------------------
+
+// RunSurveillance rejoue une sequence d'evenements enregistree et retourne les alertes.
+func RunSurveillance(cfg SurveillanceConfig, events []Event) []Alert {
+	s := NewSurveillance(cfg)
+	for _, e := range events {
+		s.OnEvent(e)
+	}
+	return s.Alerts()
+}
+
+// ReadEventsJSONL lit des evenements, un objet JSON par ligne.
+func ReadEventsJSONL(r io.Reader) ([]Event, error) {
+	var events []Event
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return events, fmt.Errorf("ligne %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, sc.Err()
+}
+
+// WriteAlertsJSONL ecrit les alertes, un objet JSON par ligne.
+func WriteAlertsJSONL(w io.Writer, alerts []Alert) error {
+	enc := json.NewEncoder(w)
+	for _, a := range alerts {
+		if err := enc.Encode(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// surveillance_test.go — Tests des detecteurs d'abus de marche.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSurveillanceWashTradeStreaming verifie la detection en direct sur le Gateway,
+// y compris via deux comptes d'un meme beneficiaire.
+func TestSurveillanceWashTradeStreaming(t *testing.T) {
+	gw, _ := newTestGateway()
+	cfg := DefaultSurveillanceConfig()
+	cfg.Owners = map[string]string{"ACC-A1": "FUND-A", "ACC-A2": "FUND-A"}
+	surv := NewSurveillance(cfg)
+	gw.Subscribe(surv.OnEvent)
+
+	sell := newAccountOrder("ACC-A1", "AAPL", Sell, 190.00, 100)
+	buy := newAccountOrder("ACC-A2", "AAPL", Buy, 190.00, 100)
+	mustSubmit(t, gw, sell)
+	mustSubmit(t, gw, buy)
+	mustSubmit(t, gw, newAccountOrder("ACC-B", "AAPL", Sell, 191.00, 10))
+	mustSubmit(t, gw, newAccountOrder("ACC-A1", "AAPL", Buy, 191.00, 10))
+
+	alerts := surv.Alerts()
+	if len(alerts) != 1 || alerts[0].Type != AlertWashTrade {
+		t.Fatalf("attendu 1 alerte WASH_TRADE, obtenu %+v", alerts)
+	}
+	if ids := alerts[0].OrderIDs; len(ids) != 2 || ids[0] != buy.ID || ids[1] != sell.ID {
+		t.Errorf("preuves attendues [#%d #%d], obtenu %v", buy.ID, sell.ID, ids)
+	}
+}
+
+// scenario construit un flux d'evenements synthetique pour un compte.
+type scenario struct {
+	t0     int64
+	events []Event
+	nextID uint64
+}
+
+func (sc *scenario) at(d time.Duration) int64 { return sc.t0 + int64(d) }
+
+func (sc *scenario) place(d time.Duration, side Side, price float64, qty int64) uint64 {
+	sc.nextID++
+	sc.events = append(sc.events, Event{Type: EventAccepted, OrderID: sc.nextID, Account: "SPOOF",
+		Symbol: "AAPL", Side: side, Price: price, Quantity: qty, Timestamp: sc.at(d)})
+	return sc.nextID
+}
+
+func (sc *scenario) fill(d time.Duration, id uint64, side Side, price float64, qty int64) {
+	sc.events = append(sc.events, Event{Type: EventFilled, OrderID: id, TradeID: 1000 + id, Account: "SPOOF",
+		Symbol: "AAPL", Side: side, Price: price, Quantity: qty, Timestamp: sc.at(d)})
+}
+
+func (sc *scenario) cancel(d time.Duration, id uint64) {
+	sc.events = append(sc.events, Event{Type: EventCancelled, OrderID: id, Account: "SPOOF",
+		Symbol: "AAPL", Timestamp: sc.at(d)})
+}
+
+// TestSurveillanceSpoofingAndLayering verifie les deux schemas d'annulation.
+func TestSurveillanceSpoofingAndLayering(t *testing.T) {
+	sc := &scenario{t0: time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC).UnixNano()}
+	ms := time.Millisecond
+
+	big := sc.place(0, Buy, 189.90, 5_000)
+	small := sc.place(10*ms, Sell, 190.00, 100)
+	sc.fill(200*ms, small, Sell, 190.00, 100)
+	sc.cancel(300*ms, big)
+
+	l1 := sc.place(1*time.Second, Sell, 190.50, 200)
+	l2 := sc.place(1*time.Second, Sell, 190.60, 200)
+	l3 := sc.place(1*time.Second, Sell, 190.70, 200)
+	buy := sc.place(1100*ms, Buy, 190.10, 50)
+	sc.fill(1200*ms, buy, Buy, 190.10, 50)
+	sc.cancel(1300*ms, l1)
+	sc.cancel(1300*ms, l2)
+	sc.cancel(1300*ms, l3)
+
+	alerts := RunSurveillance(DefaultSurveillanceConfig(), sc.events)
+	if len(alerts) != 2 {
+		t.Fatalf("attendu 2 alertes, obtenu %+v", alerts)
+	}
+	if a := alerts[0]; a.Type != AlertSpoofing || a.OrderIDs[0] != big || a.OrderIDs[1] != small {
+		t.Errorf("spoofing inattendu: %+v", a)
+	}
+	if a := alerts[1]; a.Type != AlertLayering || len(a.OrderIDs) != 4 || a.OrderIDs[3] != buy {
+		t.Errorf("layering inattendu: %+v", a)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAlertsJSONL(&buf, alerts); err != nil {
+		t.Fatalf("WriteAlertsJSONL: %v", err)
+	}
+	var decoded Alert
+	if err := json.Unmarshal([]byte(strings.Split(buf.String(), "\n")[0]), &decoded); err != nil {
+		t.Fatalf("JSON invalide: %v", err)
+	}
+	if decoded.Type != AlertSpoofing || len(decoded.OrderIDs) != 2 {
+		t.Errorf("JSON inattendu: %s", buf.String())
+	}
+}
+
+// TestSurveillanceMomentumIgnition verifie la rafale suivie du retournement,
+// en passant par le format JSONL hors ligne.
+func TestSurveillanceMomentumIgnition(t *testing.T) {
+	sc := &scenario{t0: time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC).UnixNano()}
+	for i, price := range []float64{190.00, 190.50, 191.20} {
+		d := time.Duration(i) * time.Second
+		id := sc.place(d, Buy, price, 100)
+		sc.fill(d, id, Buy, price, 100)
+	}
+	exit := sc.place(4*time.Second, Sell, 191.10, 300)
+	sc.fill(4*time.Second, exit, Sell, 191.10, 300)
+
+	var jsonl bytes.Buffer
+	enc := json.NewEncoder(&jsonl)
+	for _, e := range sc.events {
+		enc.Encode(e)
+	}
+	events, err := ReadEventsJSONL(&jsonl)
+	if err != nil {
+		t.Fatalf("ReadEventsJSONL: %v", err)
+	}
+
+	alerts := RunSurveillance(DefaultSurveillanceConfig(), events)
+	if len(alerts) != 1 || alerts[0].Type != AlertMomentumIgnition {
+		t.Fatalf("attendu 1 alerte MOMENTUM_IGNITION, obtenu %+v", alerts)
+	}
+	if ids := alerts[0].OrderIDs; len(ids) != 4 || ids[3] != exit {
+		t.Errorf("preuves inattendues: %v", ids)
+	}
+}
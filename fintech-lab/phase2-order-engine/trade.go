@@ -5,33 +5,37 @@ package main
 
 import (
 	"fmt"
-	"sync/atomic"
+	"sort"
+	"sync"
 )
 
-// ---------------------------------------------------------------------------
-// Sequence d'IDs pour les trades
-// ---------------------------------------------------------------------------
-
-var globalTradeSeq uint64
-
-func nextTradeID() uint64 {
-	return atomic.AddUint64(&globalTradeSeq, 1)
-}
-
 // ---------------------------------------------------------------------------
 // Trade — Enregistrement d'une execution.
 // ---------------------------------------------------------------------------
 //
-// Quand BUY #12 (x100 @ $189.50) rencontre SELL #7 (x100 @ $189.00) :
+// Quand BUY #12 (x100 @ 189.50) rencontre SELL #7 (x100 @ 189.00) :
 //   => Trade {
 //        BuyOrderID  : 12,
 //        SellOrderID : 7,
 //        Price       : 189.00,  <- Prix du vendeur (ordre passif)
 //        Quantity    : 100,
+//        Currency    : "USD",   <- Devise de l'instrument
 //      }
 //
 // REGLE PRIX : c'est l'ordre "passif" (deja dans le book) qui fixe le prix.
 //              L'ordre "agressif" (nouveau) accepte ce prix.
+//
+// DEVISE : prix et notionnel sont dans la devise de l'instrument. Additionner
+//          des trades de devises differentes passe par FXRates (fx.go).
+
+// TradeStatus suit les corrections post-trade (voir correction.go).
+type TradeStatus string
+
+const (
+	TradeActive    TradeStatus = "ACTIVE"
+	TradeBusted    TradeStatus = "BUSTED"    // Annule apres coup par les operations
+	TradeCorrected TradeStatus = "CORRECTED" // Remplace par le trade CorrectedBy
+)
 
 type Trade struct {
 	ID          uint64
@@ -40,24 +44,37 @@ type Trade struct {
 	SellOrderID uint64
 	Price       float64 // Prix d'execution = prix de l'ordre passif
 	Quantity    int64   // Quantite executee (peut etre partielle)
+	Currency    string  // Devise du prix (celle de l'instrument)
 	Timestamp   int64   // Unix nanoseconds
+	Status      TradeStatus
+	Corrects    uint64 // ID du trade que celui-ci corrige (0 sinon)
+	CorrectedBy uint64 // ID du trade de correction (0 sinon)
 }
 
 // newTrade cree un Trade entre un ordre d'achat et un ordre de vente.
-// Cette fonction est appelee uniquement par le matching engine.
-func newTrade(symbol string, buyID, sellID uint64, price float64, qty int64, ts int64) Trade {
+// Cette fonction est appelee uniquement par le matching engine, qui fournit
+// l'ID (son Sequencer) et l'heure du match (sa Clock).
+func newTrade(id uint64, symbol, currency string, buyID, sellID uint64, price float64, qty int64, ts int64) Trade {
 	return Trade{
-		ID:          nextTradeID(),
+		ID:          id,
 		Symbol:      symbol,
 		BuyOrderID:  buyID,
 		SellOrderID: sellID,
 		Price:       price,
 		Quantity:    qty,
+		Currency:    currency,
 		Timestamp:   ts,
+		Status:      TradeActive,
 	}
 }
 
-// Notional retourne la valeur notionnelle du trade (price * quantity).
+// IsLive indique si le trade compte dans les totaux (ni busted, ni corrige).
+func (t Trade) IsLive() bool {
+	return t.Status != TradeBusted && t.Status != TradeCorrected
+}
+
+// Notional retourne la valeur notionnelle du trade (price * quantity), dans
+// la devise de l'instrument (Currency).
 // Critique pour le calcul du P&L et des commissions.
 func (t Trade) Notional() float64 {
 	return t.Price * float64(t.Quantity)
@@ -65,64 +82,207 @@ func (t Trade) Notional() float64 {
 
 // String implemente fmt.Stringer.
 func (t Trade) String() string {
-	return fmt.Sprintf("TRADE[#%d] %s: BUY#%d vs SELL#%d | x%d @ $%.2f (notional: $%.2f)",
+	s := fmt.Sprintf("TRADE[#%d] %s: BUY#%d vs SELL#%d | x%d @ %.2f %s (notional: %.2f %s)",
 		t.ID, t.Symbol,
 		t.BuyOrderID, t.SellOrderID,
-		t.Quantity, t.Price,
-		t.Notional())
+		t.Quantity, t.Price, t.Currency,
+		t.Notional(), t.Currency)
+	if !t.IsLive() {
+		s += " " + string(t.Status)
+	}
+	return s
 }
 
 // ---------------------------------------------------------------------------
 // TradeLog — Historique des trades executes.
 // ---------------------------------------------------------------------------
 
+// TradeHandler recoit chaque trade ajoute au TradeLog (bougies, sinks...).
+// Un trade busted ou corrige est notifie une seconde fois, meme ID, avec son
+// nouveau Status (IsLive() faux) : l'abonne doit retirer sa contribution.
+// Appele avec le verrou du TradeLog tenu : ne pas rappeler le TradeLog.
+type TradeHandler func(Trade)
+
 // TradeLog accumule les trades pour analyse post-session.
-// En production, cela serait remplace par un publisher vers Kafka/Solace.
+// La persistance (ou la publication vers Kafka/Solace) passe par un TradeSink
+// branche avec SetSink ; le TradeLog garde la vue memoire de la session.
+// Thread-safe : le Gateway ecrit, le market data et le monitoring lisent.
 type TradeLog struct {
-	trades []Trade
+	mu      sync.RWMutex
+	trades  []Trade
+	byID    map[uint64]int     // tradeID -> index dans trades
+	tickers map[string]*Ticker // symbol -> statistiques de session (trades vivants)
+	subs    []TradeHandler
+	sink    TradeSink
+	sinkErr error // premiere erreur d'Append, remontee par Sync
 }
 
 func NewTradeLog() *TradeLog {
 	return &TradeLog{
-		trades: make([]Trade, 0, 1024), // pre-alloue 1024 slots
+		trades:  make([]Trade, 0, 1024), // pre-alloue 1024 slots
+		byID:    make(map[uint64]int),
+		tickers: make(map[string]*Ticker),
 	}
 }
 
+// OnTrade enregistre un abonne au flux de trades.
+func (tl *TradeLog) OnTrade(h TradeHandler) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.subs = append(tl.subs, h)
+}
+
+// SetSink branche un TradeSink : chaque lot ajoute lui est transmis.
+func (tl *TradeLog) SetSink(s TradeSink) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.sink = s
+}
+
 // Add ajoute un trade au log.
 func (tl *TradeLog) Add(t Trade) {
-	tl.trades = append(tl.trades, t)
+	tl.AddAll([]Trade{t})
 }
 
 // AddAll ajoute plusieurs trades.
 func (tl *TradeLog) AddAll(trades []Trade) {
-	tl.trades = append(tl.trades, trades...)
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	for _, t := range trades {
+		tl.add(t)
+	}
+	if tl.sink != nil && len(trades) > 0 {
+		if err := tl.sink.Append(trades); err != nil && tl.sinkErr == nil {
+			tl.sinkErr = err
+		}
+	}
+}
+
+// Sync attend que tous les trades ajoutes soient acquittes par le sink.
+func (tl *TradeLog) Sync() error {
+	tl.mu.RLock()
+	sink, err := tl.sink, tl.sinkErr
+	tl.mu.RUnlock()
+	if err != nil || sink == nil {
+		return err
+	}
+	return sink.Sync()
+}
+
+// Close vide et ferme le sink (fin de session).
+func (tl *TradeLog) Close() error {
+	tl.mu.Lock()
+	sink, err := tl.sink, tl.sinkErr
+	tl.sink = nil
+	tl.mu.Unlock()
+	if sink == nil {
+		return err
+	}
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// add met a jour l'historique, le ticker du symbole et notifie les abonnes.
+// Un trade sans devise (records anterieurs aux instruments) est en devise
+// par defaut. Appele avec tl.mu tenu.
+func (tl *TradeLog) add(t Trade) {
+	if t.Currency == "" {
+		t.Currency = DefaultCurrency
+	}
+	tl.byID[t.ID] = len(tl.trades)
+	tl.trades = append(tl.trades, t)
+	tk, ok := tl.tickers[t.Symbol]
+	if !ok {
+		tk = &Ticker{Symbol: t.Symbol, Currency: t.Currency}
+		tl.tickers[t.Symbol] = tk
+	}
+	tk.add(t)
+	tl.notify(t)
+}
+
+// notify transmet un trade (ajoute ou change de statut) aux abonnes.
+// Appele avec tl.mu tenu.
+func (tl *TradeLog) notify(t Trade) {
+	for _, h := range tl.subs {
+		h(t)
+	}
 }
 
-// Count retourne le nombre de trades.
+// Count retourne le nombre de trades vivants (hors busted et corriges).
 func (tl *TradeLog) Count() int {
-	return len(tl.trades)
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	n := 0
+	for _, t := range tl.trades {
+		if t.IsLive() {
+			n++
+		}
+	}
+	return n
 }
 
 // TotalVolume retourne le volume total execute (somme des quantites).
 func (tl *TradeLog) TotalVolume() int64 {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
 	var total int64
 	for _, t := range tl.trades {
-		total += t.Quantity
+		if t.IsLive() {
+			total += t.Quantity
+		}
 	}
 	return total
 }
 
 // TotalNotional retourne la valeur totale executee.
+// ATTENTION : les notionnels sont additionnes chacun dans sa devise ; avec
+// des instruments de devises differentes, utiliser NotionalByCurrency ou
+// TotalNotionalIn.
 func (tl *TradeLog) TotalNotional() float64 {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
 	var total float64
 	for _, t := range tl.trades {
-		total += t.Notional()
+		if t.IsLive() {
+			total += t.Notional()
+		}
 	}
 	return total
 }
 
+// NotionalByCurrency retourne la valeur executee par devise.
+func (tl *TradeLog) NotionalByCurrency() map[string]float64 {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	totals := make(map[string]float64)
+	for _, t := range tl.trades {
+		if t.IsLive() {
+			totals[t.Currency] += t.Notional()
+		}
+	}
+	return totals
+}
+
+// TotalNotionalIn retourne la valeur executee convertie en ccy aux taux
+// courants de fx. Erreur (ErrNoRate) si une devise n'a pas de taux.
+func (tl *TradeLog) TotalNotionalIn(fx *FXRates, ccy string) (float64, error) {
+	var total float64
+	for from, notional := range tl.NotionalByCurrency() {
+		v, err := fx.Convert(notional, from, ccy)
+		if err != nil {
+			return 0, err
+		}
+		total += v
+	}
+	return total, nil
+}
+
 // VWAP retourne le prix moyen pondere par le volume (Volume Weighted Average Price).
 // Metrique cle en trading pour evaluer la qualite d'execution.
+// ATTENTION : tous symboles confondus, ce chiffre melange AAPL et TSLA et
+// n'a de sens que pour un seul instrument. Utiliser Ticker(symbol).VWAP.
 func (tl *TradeLog) VWAP() float64 {
 	vol := tl.TotalVolume()
 	if vol == 0 {
@@ -131,11 +291,67 @@ func (tl *TradeLog) VWAP() float64 {
 	return tl.TotalNotional() / float64(vol)
 }
 
-// PrintSummary affiche un resume de la session de trading.
+// Trades retourne une copie de tous les records, dans l'ordre d'ajout
+// (busted et corriges inclus : filtrer avec IsLive).
+func (tl *TradeLog) Trades() []Trade {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	return append([]Trade(nil), tl.trades...)
+}
+
+// Ticker retourne les statistiques de session d'un symbole.
+func (tl *TradeLog) Ticker(symbol string) (Ticker, bool) {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	tk, ok := tl.tickers[symbol]
+	if !ok {
+		return Ticker{}, false
+	}
+	return *tk, true
+}
+
+// Symbols retourne, tries, les symboles ayant au moins un trade.
+func (tl *TradeLog) Symbols() []string {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	symbols := make([]string, 0, len(tl.tickers))
+	for s := range tl.tickers {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// PrintSummary affiche un resume de la session de trading, notionnel par
+// devise.
 func (tl *TradeLog) PrintSummary() {
+	tl.PrintSummaryIn(nil, "")
+}
+
+// PrintSummaryIn affiche le resume avec, si ccy n'est pas vide, le
+// notionnel total converti en ccy aux taux de fx.
+func (tl *TradeLog) PrintSummaryIn(fx *FXRates, ccy string) {
 	fmt.Println("=== TRADE LOG SUMMARY ===")
 	fmt.Printf("  Trades executes : %d\n", tl.Count())
 	fmt.Printf("  Volume total    : %d actions\n", tl.TotalVolume())
-	fmt.Printf("  Notional total  : $%.2f\n", tl.TotalNotional())
-	fmt.Printf("  VWAP            : $%.4f\n", tl.VWAP())
+	byCcy := tl.NotionalByCurrency()
+	currencies := make([]string, 0, len(byCcy))
+	for c := range byCcy {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+	for _, c := range currencies {
+		fmt.Printf("  Notional %s    : %.2f\n", c, byCcy[c])
+	}
+	if ccy != "" {
+		if total, err := tl.TotalNotionalIn(fx, ccy); err != nil {
+			fmt.Printf("  Notional total  : n/d (%v)\n", err)
+		} else {
+			fmt.Printf("  Notional total  : %.2f %s\n", total, ccy)
+		}
+	}
+	for _, s := range tl.Symbols() {
+		tk, _ := tl.Ticker(s)
+		fmt.Printf("  %v\n", tk)
+	}
 }
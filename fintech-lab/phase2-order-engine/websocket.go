@@ -0,0 +1,387 @@
+// websocket.go — WebSocket minimal (RFC 6455), sans dependance externe.
+//
+// Couvre ce dont le streaming a besoin : handshake serveur (hijack) et
+// client, trames texte/binaire, fragmentation en lecture, ping/pong et
+// close avec code et raison. Pas d'extensions (permessage-deflate), pas de
+// sous-protocoles, pas de wss:// cote client.
+//
+// Format d'une trame :
+//
+//	+-+-+-+-+-------+-+-------------+-------------------------------+
+//	|F|R|R|R| opcode|M| Payload len |    Extended payload length    |
+//	|I|S|S|S|  (4)  |A|     (7)     |            (16/64)            |
+//	|N|V|V|V|       |S|             |                               |
+//	+-+-+-+-+-------+-+-------------+-------------------------------+
+//	|  Masking-key (0 ou 4 octets)  |          Payload ...          |
+//
+// Le client masque toutes ses trames, le serveur jamais.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Codes de fermeture utilises par le moteur.
+const (
+	WSCloseNormal         = 1000
+	WSCloseGoingAway      = 1001
+	WSCloseProtocolError  = 1002
+	WSClosePolicyViolated = 1008 // Client lent, abonnement refuse...
+	WSCloseTooBig         = 1009
+)
+
+// WSCloseError est retourne par ReadMessage quand le pair ferme la connexion.
+type WSCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("websocket ferme (%d): %s", e.Code, e.Reason)
+}
+
+var errWSProtocol = errors.New("websocket: violation du protocole")
+
+// WSConn est une connexion WebSocket etablie. Les ecritures sont
+// serialisees ; ReadMessage ne doit etre appele que par une goroutine.
+type WSConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	client      bool // Cote client : trames sortantes masquees
+	maxMessage  int
+	wmu         sync.Mutex
+	closeOnce   sync.Once
+	ReadTimeout time.Duration // > 0 : chaque trame recue doit arriver dans ce delai
+}
+
+// wsAcceptKey calcule Sec-WebSocket-Accept pour une cle client.
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHas indique si un header a liste (Connection: keep-alive, Upgrade)
+// contient token, sans tenir compte de la casse.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWebSocketUpgrade indique si la requete demande un upgrade WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHas(r.Header, "Connection", "upgrade") && headerHas(r.Header, "Upgrade", "websocket")
+}
+
+// AcceptWebSocket termine le handshake cote serveur et prend la main sur la
+// connexion. En cas d'erreur, une reponse HTTP 400 a deja ete ecrite.
+func AcceptWebSocket(w http.ResponseWriter, r *http.Request) (*WSConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet || !isWebSocketUpgrade(r):
+		http.Error(w, "upgrade websocket attendu", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: pas une requete d'upgrade")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "version websocket non supportee", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: version %q", r.Header.Get("Sec-WebSocket-Version"))
+	case key == "":
+		http.Error(w, "Sec-WebSocket-Key manquant", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: cle manquante")
+	}
+	// ResponseController traverse les middlewares qui exposent Unwrap.
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "hijack impossible", http.StatusInternalServerError)
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	// Le http.Server a pu poser ses ReadTimeout/WriteTimeout sur la connexion.
+	conn.SetDeadline(time.Time{})
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WSConn{conn: conn, br: rw.Reader, maxMessage: 1 << 20}, nil
+}
+
+// DialWebSocket ouvre une connexion client vers ws://host/path.
+// header permet d'ajouter Authorization, par exemple.
+func DialWebSocket(rawURL string, header http.Header) (*WSConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("websocket: schema %q non supporte (ws:// uniquement)", u.Scheme)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: make(http.Header),
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("websocket: handshake refuse: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: Sec-WebSocket-Accept invalide")
+	}
+	conn.SetDeadline(time.Time{})
+	return &WSConn{conn: conn, br: br, client: true, maxMessage: 16 << 20}, nil
+}
+
+// ---------------------------------------------------------------------------
+// Ecriture
+// ---------------------------------------------------------------------------
+
+// WriteText envoie un message texte en une trame.
+func (c *WSConn) WriteText(p []byte) error { return c.writeFrame(wsText, p, 0) }
+
+// Ping envoie un ping ; le pair repond par un pong.
+func (c *WSConn) Ping(p []byte) error { return c.writeFrame(wsPing, p, 0) }
+
+// WriteTextTimeout envoie un message texte qui doit partir avant timeout.
+func (c *WSConn) WriteTextTimeout(p []byte, timeout time.Duration) error {
+	return c.writeFrame(wsText, p, timeout)
+}
+
+func (c *WSConn) writeFrame(op byte, payload []byte, timeout time.Duration) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(timeout))
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+
+	var hdr [14]byte
+	hdr[0] = 0x80 | op // FIN
+	n := 2
+	switch l := len(payload); {
+	case l <= 125:
+		hdr[1] = byte(l)
+	case l <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n += 2
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n += 8
+	}
+	if c.client {
+		hdr[1] |= 0x80
+		var mask [4]byte
+		rand.Read(mask[:])
+		copy(hdr[n:], mask[:])
+		n += 4
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+	if _, err := c.conn.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// Close envoie une trame de fermeture (code, reason) puis ferme la connexion.
+// Idempotent ; l'envoi de la trame est borne a une seconde.
+func (c *WSConn) Close(code int, reason string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		if len(reason) > 123 {
+			reason = reason[:123] // Payload d'une trame de controle <= 125
+		}
+		p := make([]byte, 2+len(reason))
+		binary.BigEndian.PutUint16(p, uint16(code))
+		copy(p[2:], reason)
+		c.writeFrame(wsClose, p, time.Second)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// ---------------------------------------------------------------------------
+// Lecture
+// ---------------------------------------------------------------------------
+
+// ReadMessage retourne le prochain message texte ou binaire, reassemble.
+// Les pings recoivent un pong automatique ; une trame close du pair est
+// acquittee puis retournee en *WSCloseError.
+func (c *WSConn) ReadMessage() (op byte, msg []byte, err error) {
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload, time.Second); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			ce := &WSCloseError{Code: 1005} // Pas de code recu
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			c.Close(ce.Code, "")
+			return 0, nil, ce
+		case wsText, wsBinary:
+			if op != 0 {
+				return 0, nil, c.fail(WSCloseProtocolError, "nouveau message avant la fin du precedent")
+			}
+			op = fop
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, c.fail(WSCloseProtocolError, "continuation sans message")
+			}
+		default:
+			return 0, nil, c.fail(WSCloseProtocolError, fmt.Sprintf("opcode %#x inconnu", fop))
+		}
+		if len(msg)+len(payload) > c.maxMessage {
+			return 0, nil, c.fail(WSCloseTooBig, "message trop gros")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+// readFrame lit une trame complete et la demasque.
+func (c *WSConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	if c.ReadTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	}
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "bits RSV sans extension")
+	}
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "masquage incorrect")
+	}
+
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsClose && (!fin || length > 125) {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "trame de controle invalide")
+	}
+	if length > uint64(c.maxMessage) {
+		return false, 0, nil, c.fail(WSCloseTooBig, "trame trop grosse")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// fail ferme la connexion sur une erreur de protocole.
+func (c *WSConn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return fmt.Errorf("%w: %s", errWSProtocol, reason)
+}